@@ -0,0 +1,160 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"sync"
+)
+
+// AckAnomalyReason tells why an acknowledgement was reported by
+// Config.AckDiagnostics.
+type AckAnomalyReason int
+
+const (
+	// AckUnknownTag is reported for a delivery tag that was never delivered
+	// on the channel, typically a tag of another channel or of the channel
+	// that was open before a recovery.
+	AckUnknownTag AckAnomalyReason = iota
+
+	// AckAlreadySettled is reported for a delivery tag that was delivered on
+	// the channel but already acknowledged, nacked or rejected, or that was
+	// delivered with automatic acknowledgement.
+	AckAlreadySettled
+)
+
+func (r AckAnomalyReason) String() string {
+	switch r {
+	case AckUnknownTag:
+		return "unknown delivery tag"
+	case AckAlreadySettled:
+		return "delivery tag already settled"
+	}
+	return fmt.Sprintf("AckAnomalyReason(%d)", int(r))
+}
+
+// AckAnomaly describes an acknowledgement the server will refuse with a
+// PRECONDITION_FAILED channel exception.  It is reported by
+// Config.AckDiagnostics right before the acknowledgement is sent.
+type AckAnomaly struct {
+	Channel     uint16 // channel id
+	Method      string // "ack", "nack" or "reject"
+	DeliveryTag uint64
+	Multiple    bool
+	Reason      AckAnomalyReason
+}
+
+func (a AckAnomaly) String() string {
+	return fmt.Sprintf("channel %d: %s of delivery tag %d (multiple: %t): %s", a.Channel, a.Method, a.DeliveryTag, a.Multiple, a.Reason)
+}
+
+// ackTracker records the deliveries of a channel awaiting acknowledgement, to
+// diagnose invalid acknowledgements.
+type ackTracker struct {
+	m           sync.Mutex
+	autoAck     map[string]bool // by consumer tag
+	outstanding map[uint64]struct{}
+	highest     uint64 // highest delivery tag seen
+}
+
+func newAckTracker() *ackTracker {
+	return &ackTracker{
+		autoAck:     make(map[string]bool),
+		outstanding: make(map[uint64]struct{}),
+	}
+}
+
+// consume records whether the deliveries of a consumer are automatically
+// acknowledged.
+func (t *ackTracker) consume(consumer string, autoAck bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.autoAck[consumer] = autoAck
+}
+
+// deliver records a delivery to a consumer.
+func (t *ackTracker) deliver(consumer string, tag uint64) {
+	t.m.Lock()
+	autoAck := t.autoAck[consumer]
+	t.m.Unlock()
+
+	t.delivered(tag, autoAck)
+}
+
+// delivered records a delivery, which awaits an acknowledgement unless it was
+// automatically acknowledged.
+func (t *ackTracker) delivered(tag uint64, autoAck bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if tag > t.highest {
+		t.highest = tag
+	}
+	if !autoAck {
+		t.outstanding[tag] = struct{}{}
+	}
+}
+
+// settle records an acknowledgement and reports whether the server will
+// refuse it.
+func (t *ackTracker) settle(tag uint64, multiple bool) (AckAnomalyReason, bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	// Multiple with the tag 0 settles all outstanding deliveries.
+	if multiple && tag == 0 {
+		t.outstanding = make(map[uint64]struct{})
+		return 0, false
+	}
+
+	if tag > t.highest || tag == 0 {
+		return AckUnknownTag, true
+	}
+	if _, found := t.outstanding[tag]; !found {
+		return AckAlreadySettled, true
+	}
+
+	if multiple {
+		for outstanding := range t.outstanding {
+			if outstanding <= tag {
+				delete(t.outstanding, outstanding)
+			}
+		}
+	} else {
+		delete(t.outstanding, tag)
+	}
+
+	return 0, false
+}
+
+// reset forgets the outstanding deliveries, whose delivery tags are no longer
+// valid after a basic.recover, and returns their number.
+func (t *ackTracker) reset() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	n := len(t.outstanding)
+	t.outstanding = make(map[uint64]struct{})
+	return n
+}
+
+// checkSettle reports an acknowledgement the server will refuse when ack
+// diagnostics are enabled.
+func (ch *Channel) checkSettle(method string, tag uint64, multiple bool) {
+	if ch.acks == nil {
+		return
+	}
+
+	if reason, anomaly := ch.acks.settle(tag, multiple); anomaly && ch.connection.ackReport != nil {
+		ch.connection.ackReport(AckAnomaly{
+			Channel:     ch.id,
+			Method:      method,
+			DeliveryTag: tag,
+			Multiple:    multiple,
+			Reason:      reason,
+		})
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestAckTracker(t *testing.T) {
+	tr := newAckTracker()
+	tr.consume("manual", false)
+	tr.consume("auto", true)
+
+	tr.deliver("manual", 1)
+	tr.deliver("auto", 2)
+	tr.deliver("manual", 3)
+	tr.deliver("manual", 4)
+	tr.delivered(5, false)
+
+	tests := []struct {
+		tag      uint64
+		multiple bool
+		anomaly  bool
+		reason   AckAnomalyReason
+	}{
+		{1, false, false, 0},
+		{1, false, true, AckAlreadySettled},
+		{2, false, true, AckAlreadySettled},
+		{6, false, true, AckUnknownTag},
+		{0, false, true, AckUnknownTag},
+		{4, true, false, 0},
+		{3, false, true, AckAlreadySettled},
+		{0, true, false, 0},
+		{5, false, true, AckAlreadySettled},
+	}
+
+	for i, test := range tests {
+		reason, anomaly := tr.settle(test.tag, test.multiple)
+		if anomaly != test.anomaly || (anomaly && reason != test.reason) {
+			t.Errorf("%d: settle(%d, %t) = %v %t, expected %v %t", i, test.tag, test.multiple, reason, anomaly, test.reason, test.anomaly)
+		}
+	}
+}
+
+func TestAckDiagnosticsReportsInvalidAcks(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 1})
+
+		srv.recv(1, &basicAck{})
+		srv.recv(1, &basicAck{})
+		srv.recv(1, &basicNack{})
+		srv.connectionClose()
+	}()
+
+	var m sync.Mutex
+	var anomalies []AckAnomaly
+
+	config := defaultConfig()
+	config.AckDiagnostics = func(a AckAnomaly) {
+		m.Lock()
+		defer m.Unlock()
+		anomalies = append(anomalies, a)
+	}
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	deliveries, err := ch.ConsumeWithContext(context.Background(), "jobs", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	d := <-deliveries
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if err := d.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if err := ch.Nack(7, true, true); err != nil {
+		t.Fatalf("could not nack: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	expected := []AckAnomaly{
+		{Channel: 1, Method: "ack", DeliveryTag: 1, Reason: AckAlreadySettled},
+		{Channel: 1, Method: "nack", DeliveryTag: 7, Multiple: true, Reason: AckUnknownTag},
+	}
+	if len(anomalies) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, anomalies)
+	}
+	for i := range expected {
+		if anomalies[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected[i], anomalies[i])
+		}
+	}
+}
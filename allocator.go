@@ -22,6 +22,10 @@ type allocator struct {
 	follow int
 	low    int
 	high   int
+
+	// lowest makes next return the lowest free number instead of rolling
+	// through the range.
+	lowest bool
 }
 
 // NewAllocator reserves and frees integers out of a range between low and
@@ -67,8 +71,13 @@ func (a allocator) String() string {
 // low and high.  If no number is available, false is returned.
 //
 // O(N) worst case runtime where N is allocated, but usually O(1) due to a
-// rolling index into the oldest allocation.
+// rolling index into the oldest allocation.  When allocating the lowest free
+// number, the runtime is O(N) where N is the lowest free number.
 func (a *allocator) next() (int, bool) {
+	if a.lowest {
+		a.follow = a.low
+	}
+
 	wrapped := a.follow
 	defer func() {
 		// make a.follow point to next value
@@ -118,3 +118,27 @@ func TestAllocatorReleasesKeepUpWithAllocationsForAllSizes(t *testing.T) {
 		}
 	}
 }
+
+func TestAllocatorLowestShouldReuseLowestReleased(t *testing.T) {
+	a := newAllocator(1, 5)
+	a.lowest = true
+
+	for want := 1; want <= 3; want++ {
+		if got, ok := a.next(); !ok || got != want {
+			t.Fatalf("expected allocation to be %d, got: %d", want, got)
+		}
+	}
+
+	a.release(2)
+	a.release(1)
+
+	if got, _ := a.next(); got != 1 {
+		t.Fatalf("expected the lowest released number 1, got: %d", got)
+	}
+	if got, _ := a.next(); got != 2 {
+		t.Fatalf("expected the lowest released number 2, got: %d", got)
+	}
+	if got, _ := a.next(); got != 4 {
+		t.Fatalf("expected the lowest free number 4, got: %d", got)
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "errors"
+
+var errAlternateExchangeSet = errors.New(`the "alternate-exchange" argument is already set`)
+
+// AlternateExchangeName returns the name of the alternate exchange and of the
+// capture queue of exchange declared by Channel.ExchangeDeclareWithCapture,
+// that is "exchange.unroutable".
+func AlternateExchangeName(exchange string) string {
+	return exchange + ".unroutable"
+}
+
+/*
+ExchangeDeclareWithCapture declares an exchange whose unroutable messages are
+kept in a capture queue instead of being dropped, and consumes that queue.
+This observes the messages that match no binding even when they are not
+published as mandatory, and allows reprocessing them once the missing binding
+is added.
+
+It declares a fanout exchange and a queue both named after
+AlternateExchangeName, binds them, declares the exchange with that fanout
+exchange as its "alternate-exchange" argument, and finally consumes the
+capture queue with a generated consumer tag and without automatic
+acknowledgement.  The routing key and headers of the captured messages are
+the ones they were published with.
+
+	Delivery       Exchange                        Queue
+	-------------------------------------------------------------------
+	key: bound --> orders -----------------------> orders.created
+	key: other --> orders --> orders.unroutable --> orders.unroutable
+
+The alternate exchange and the capture queue are declared with the same
+durable and autoDelete parameters as the exchange.  The other parameters have
+the same semantics as in Channel.ExchangeDeclare; args must not already hold
+an "alternate-exchange".  The first error stops the declaration and is
+returned, in which case the channel will be closed for server errors.
+*/
+func (ch *Channel) ExchangeDeclareWithCapture(name string, kind ExchangeType, durable, autoDelete, internal bool, args Table) (<-chan Delivery, error) {
+	if _, ok := args["alternate-exchange"]; ok {
+		return nil, errAlternateExchangeSet
+	}
+
+	alternate := AlternateExchangeName(name)
+	if err := ch.ExchangeDeclare(alternate, Fanout, durable, autoDelete, false, false, nil); err != nil {
+		return nil, err
+	}
+	if _, err := ch.QueueDeclare(alternate, durable, autoDelete, false, false, nil); err != nil {
+		return nil, err
+	}
+	if err := ch.QueueBind(alternate, "", alternate, false, nil); err != nil {
+		return nil, err
+	}
+
+	withAlternate := make(Table, len(args)+1)
+	for k, v := range args {
+		withAlternate[k] = v
+	}
+	withAlternate["alternate-exchange"] = alternate
+
+	if err := ch.ExchangeDeclare(name, kind, durable, autoDelete, internal, false, withAlternate); err != nil {
+		return nil, err
+	}
+
+	return ch.Consume(alternate, "", false, false, false, false, nil)
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestExchangeDeclareWithCapture(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		alternate := &exchangeDeclare{}
+		srv.recv(1, alternate)
+		if alternate.Exchange != "orders.unroutable" || alternate.Type != string(Fanout) {
+			t.Errorf("expected the fanout alternate exchange to be declared, got %q of type %q", alternate.Exchange, alternate.Type)
+		}
+		srv.send(1, &exchangeDeclareOk{})
+
+		queue := &queueDeclare{}
+		srv.recv(1, queue)
+		srv.send(1, &queueDeclareOk{Queue: queue.Queue})
+
+		bind := &queueBind{}
+		srv.recv(1, bind)
+		if bind.Queue != "orders.unroutable" || bind.Exchange != "orders.unroutable" {
+			t.Errorf("expected the capture queue to be bound to the alternate exchange, got %q to %q", bind.Queue, bind.Exchange)
+		}
+		srv.send(1, &queueBindOk{})
+
+		exchange := &exchangeDeclare{}
+		srv.recv(1, exchange)
+		if want, got := "orders.unroutable", exchange.Arguments["alternate-exchange"]; want != got {
+			t.Errorf("expected the alternate-exchange argument %q, got %v", want, got)
+		}
+		if want, got := int32(1), exchange.Arguments["x-custom"]; want != got {
+			t.Errorf("expected the arguments to be kept, got %v", exchange.Arguments)
+		}
+		srv.send(1, &exchangeDeclareOk{})
+
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		if consume.Queue != "orders.unroutable" {
+			t.Errorf("expected the capture queue to be consumed, got %q", consume.Queue)
+		}
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1, Exchange: "orders", RoutingKey: "other"})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	args := Table{"x-custom": int32(1)}
+	unroutable, err := ch.ExchangeDeclareWithCapture("orders", Topic, true, false, false, args)
+	if err != nil {
+		t.Fatalf("could not declare the exchange: %v", err)
+	}
+	if _, ok := args["alternate-exchange"]; ok {
+		t.Fatalf("expected the arguments of the caller to be left alone")
+	}
+
+	if d := <-unroutable; d.RoutingKey != "other" {
+		t.Fatalf("expected the unroutable message to be captured, got %q", d.RoutingKey)
+	}
+	<-served
+}
+
+func TestExchangeDeclareWithCaptureRefusesAlternateExchange(t *testing.T) {
+	ch := &Channel{}
+	if _, err := ch.ExchangeDeclareWithCapture("orders", Topic, true, false, false, Table{"alternate-exchange": "other"}); !errors.Is(err, errAlternateExchangeSet) {
+		t.Fatalf("expected an existing alternate-exchange to be refused, got: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package amqptest provides helpers for testing applications built on
+// github.com/rabbitmq/amqp091-go.
+package amqptest
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// libraryPrefix matches the frames of functions of the amqp091 package, but
+// not of its subpackages.
+const libraryPrefix = "github.com/rabbitmq/amqp091-go."
+
+// LeakTimeout is how long VerifyNoLeaks waits for library goroutines to
+// terminate before failing the test.
+var LeakTimeout = 5 * time.Second
+
+/*
+VerifyNoLeaks fails the test when goroutines of the amqp091 package started
+during the test are still running once the test and its cleanups are done.
+
+Every open Connection owns a reader goroutine, and consumers, heartbeats and
+other helpers own goroutines too, so a missing Close shows up as a leak.
+Goroutines running when VerifyNoLeaks is called are ignored, so call it at the
+start of the test:
+
+	func TestPublish(t *testing.T) {
+		amqptest.VerifyNoLeaks(t)
+
+		conn, err := amqp.Dial(url)
+		...
+	}
+
+Goroutines are given LeakTimeout to terminate, since closing a Connection does
+not wait for all of them to return.
+*/
+func VerifyNoLeaks(t testing.TB) {
+	t.Helper()
+
+	before := make(map[string]bool)
+	for _, g := range libraryGoroutines() {
+		before[g.id] = true
+	}
+
+	t.Cleanup(func() {
+		t.Helper()
+
+		var leaked []goroutine
+		deadline := time.Now().Add(LeakTimeout)
+		for {
+			leaked = leaked[:0]
+			for _, g := range libraryGoroutines() {
+				if !before[g.id] {
+					leaked = append(leaked, g)
+				}
+			}
+
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if len(leaked) > 0 {
+			stacks := make([]string, len(leaked))
+			for i, g := range leaked {
+				stacks[i] = g.stack
+			}
+			t.Errorf("amqptest: %d goroutine(s) leaked, missing Close?\n\n%s", len(leaked), strings.Join(stacks, "\n\n"))
+		}
+	})
+}
+
+type goroutine struct {
+	id    string
+	stack string
+}
+
+// libraryGoroutines returns the goroutines, other than the calling one, with
+// a frame of the amqp091 package in their stack.
+func libraryGoroutines() []goroutine {
+	buf := make([]byte, 64<<10)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	// The calling goroutine always comes first.
+	return parseGoroutines(string(buf))[1:]
+}
+
+// parseGoroutines splits the output of runtime.Stack into goroutines, keeping
+// the calling one and the ones running amqp091 code.
+func parseGoroutines(stacks string) []goroutine {
+	var found []goroutine
+	for i, stack := range strings.Split(stacks, "\n\n") {
+		header, _, _ := strings.Cut(stack, "\n")
+		id, _, _ := strings.Cut(strings.TrimPrefix(header, "goroutine "), " ")
+
+		if i == 0 || strings.Contains(stack, "\n"+libraryPrefix) {
+			found = append(found, goroutine{id: id, stack: stack})
+		}
+	}
+	return found
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqptest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// recorder is a testing.TB collecting cleanups and failures.
+type recorder struct {
+	testing.TB
+	cleanups []func()
+	errors   []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func TestVerifyNoLeaksPassesAfterClose(t *testing.T) {
+	r := &recorder{}
+	VerifyNoLeaks(r)
+
+	m, err := amqp.NewVhostManager("amqp://localhost", amqp.Config{}, time.Minute)
+	if err != nil {
+		t.Fatalf("could not create vhost manager: %v", err)
+	}
+	m.Close()
+
+	r.finish()
+	if len(r.errors) != 0 {
+		t.Fatalf("expected no leak, got: %v", r.errors)
+	}
+}
+
+func TestVerifyNoLeaksReportsRunningGoroutines(t *testing.T) {
+	defer func(timeout time.Duration) { LeakTimeout = timeout }(LeakTimeout)
+	LeakTimeout = 50 * time.Millisecond
+
+	r := &recorder{}
+	VerifyNoLeaks(r)
+
+	m, err := amqp.NewVhostManager("amqp://localhost", amqp.Config{}, time.Minute)
+	if err != nil {
+		t.Fatalf("could not create vhost manager: %v", err)
+	}
+	defer m.Close()
+
+	r.finish()
+	if len(r.errors) != 1 {
+		t.Fatalf("expected the vhost manager goroutine to be reported, got: %v", r.errors)
+	}
+}
+
+func TestParseGoroutines(t *testing.T) {
+	stacks := `goroutine 7 [running]:
+main.TestSomething()
+	/src/main_test.go:10 +0x1
+
+goroutine 1 [chan receive]:
+testing.(*T).Run()
+	/go/src/testing/testing.go:1 +0x1
+
+goroutine 12 [select]:
+github.com/rabbitmq/amqp091-go.(*Connection).heartbeater()
+	/src/connection.go:1 +0x1
+
+goroutine 13 [select]:
+github.com/rabbitmq/amqp091-go/amqptest.helper()
+	/src/amqptest/amqptest.go:1 +0x1`
+
+	found := parseGoroutines(stacks)
+	if len(found) != 2 || found[0].id != "7" || found[1].id != "12" {
+		t.Fatalf("expected the calling and library goroutines, got: %+v", found)
+	}
+}
@@ -8,6 +8,8 @@ package amqp091
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"sync"
 )
 
 // Authentication interface provides a means for different SASL authentication
@@ -17,6 +19,47 @@ type Authentication interface {
 	Response() string
 }
 
+// ChallengeAuthentication is implemented by the SASL mechanisms taking more
+// than one step, like GSSAPI or SCRAM.  Response is sent first, then each
+// challenge of the server is answered with the response returned by
+// Challenge, until the server accepts or refuses the credentials.  An error
+// aborts the connection handshake.
+type ChallengeAuthentication interface {
+	Authentication
+	Challenge(challenge string) (response string, err error)
+}
+
+var (
+	saslMechanismsM sync.RWMutex
+	saslMechanisms  = map[string]func(URI) Authentication{
+		"PLAIN":    func(uri URI) Authentication { return uri.PlainAuth() },
+		"AMQPLAIN": func(uri URI) Authentication { return uri.AMQPlainAuth() },
+		"EXTERNAL": func(URI) Authentication { return &ExternalAuth{} },
+	}
+)
+
+// RegisterSASLMechanism makes the auth_mechanism parameter of AMQP URIs
+// accept the mechanism name, case-insensitively, using newAuth to build the
+// Authentication from the parsed URI.  Registering a name again replaces the
+// previous registration, including the built-in PLAIN, AMQPLAIN and EXTERNAL.
+//
+// Mechanisms set in Config.SASL do not need to be registered.
+func RegisterSASLMechanism(name string, newAuth func(URI) Authentication) {
+	saslMechanismsM.Lock()
+	defer saslMechanismsM.Unlock()
+
+	saslMechanisms[strings.ToUpper(name)] = newAuth
+}
+
+// saslMechanism returns the constructor registered for the mechanism name.
+func saslMechanism(name string) (func(URI) Authentication, bool) {
+	saslMechanismsM.RLock()
+	defer saslMechanismsM.RUnlock()
+
+	newAuth, ok := saslMechanisms[strings.ToUpper(name)]
+	return newAuth, ok
+}
+
 // PlainAuth is a similar to Basic Auth in HTTP.
 type PlainAuth struct {
 	Username string
@@ -33,6 +76,12 @@ func (auth *PlainAuth) Response() string {
 	return fmt.Sprintf("\000%s\000%s", auth.Username, auth.Password)
 }
 
+// String returns the mechanism and username, without the password, so that
+// a Config can be logged.
+func (auth *PlainAuth) String() string {
+	return fmt.Sprintf("PLAIN %s:xxxxx", auth.Username)
+}
+
 // AMQPlainAuth is similar to PlainAuth
 type AMQPlainAuth struct {
 	Username string
@@ -54,6 +103,12 @@ func (auth *AMQPlainAuth) Response() string {
 	return buf.String()[4:]
 }
 
+// String returns the mechanism and username, without the password, so that
+// a Config can be logged.
+func (auth *AMQPlainAuth) String() string {
+	return fmt.Sprintf("AMQPLAIN %s:xxxxx", auth.Username)
+}
+
 // ExternalAuth for RabbitMQ-auth-mechanism-ssl.
 type ExternalAuth struct{}
 
@@ -0,0 +1,182 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// AutoscaleOptions configures the scaling of the handler workers of a
+// Consumer with the depth of its queue.
+type AutoscaleOptions struct {
+	// MinWorkers and MaxWorkers bound the number of workers, 1 and
+	// MinWorkers when zero.
+	MinWorkers int
+	MaxWorkers int
+
+	// Interval between samples of the queue depth, 10s when zero.
+	Interval time.Duration
+
+	// TargetDrain is the time in which the workers should be able to handle
+	// the ready messages of the queue at the observed processing rate, 10s
+	// when zero.  Workers are added when the backlog would take longer.
+	TargetDrain time.Duration
+
+	// ScaleDownDelay is how long fewer workers must have been enough before
+	// one is removed, 1m when zero.  Workers are added right away but removed
+	// one at a time, so that a short lull does not shrink the consumer.
+	ScaleDownDelay time.Duration
+
+	// PrefetchPerWorker, when positive, makes the prefetch of the channel
+	// follow the number of workers.  The prefetch is then set with global
+	// true, which RabbitMQ applies to the running consumer.
+	PrefetchPerWorker int
+
+	// OnScale is called when the number of workers changes, with the sample
+	// that triggered the change.
+	OnScale func(from, to int, sample QueueSample)
+}
+
+// autoscaler decides the number of workers of a Consumer from samples of its
+// queue.  It is only used by the goroutine of Consumer.autoscale.
+type autoscaler struct {
+	opts AutoscaleOptions
+
+	lastAt        time.Time // time of the previous sample
+	lastCompleted uint64    // deliveries handled at the previous sample
+	lowSince      time.Time // since when fewer workers have been enough, zero when not
+}
+
+func newAutoscaler(opts AutoscaleOptions) *autoscaler {
+	if opts.MinWorkers <= 0 {
+		opts.MinWorkers = 1
+	}
+	if opts.MaxWorkers < opts.MinWorkers {
+		opts.MaxWorkers = opts.MinWorkers
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.TargetDrain <= 0 {
+		opts.TargetDrain = 10 * time.Second
+	}
+	if opts.ScaleDownDelay <= 0 {
+		opts.ScaleDownDelay = time.Minute
+	}
+	return &autoscaler{opts: opts}
+}
+
+// clamp bounds n to the configured number of workers.
+func (a *autoscaler) clamp(n int) int {
+	if n < a.opts.MinWorkers {
+		return a.opts.MinWorkers
+	}
+	if n > a.opts.MaxWorkers {
+		return a.opts.MaxWorkers
+	}
+	return n
+}
+
+// scale returns the number of workers for the sample, given the current
+// number of workers and the number of deliveries handled so far.
+func (a *autoscaler) scale(workers int, sample QueueSample, completed uint64) int {
+	elapsed := sample.At.Sub(a.lastAt)
+	handled := completed - a.lastCompleted
+	first := a.lastAt.IsZero()
+	a.lastAt, a.lastCompleted = sample.At, completed
+
+	if first || elapsed <= 0 {
+		return workers
+	}
+
+	desired := a.opts.MinWorkers
+	if sample.Messages > 0 {
+		if handled == 0 {
+			// Nothing was handled: the workers are busy with slow
+			// deliveries, or there are too few of them to tell.
+			desired = workers + 1
+		} else {
+			perWorker := float64(handled) / elapsed.Seconds() / float64(workers)
+			desired = int(math.Ceil(float64(sample.Messages) / (perWorker * a.opts.TargetDrain.Seconds())))
+		}
+	}
+	desired = a.clamp(desired)
+
+	switch {
+	case desired > workers:
+		a.lowSince = time.Time{}
+		return desired
+
+	case desired < workers:
+		if a.lowSince.IsZero() {
+			a.lowSince = sample.At
+		}
+		if sample.At.Sub(a.lowSince) >= a.opts.ScaleDownDelay {
+			// Remove one worker at a time, waiting for the delay again.
+			a.lowSince = sample.At
+			return workers - 1
+		}
+
+	default:
+		a.lowSince = time.Time{}
+	}
+
+	return workers
+}
+
+// autoscale samples the queue of the consumer and scales its workers until
+// ctx is done.
+func (c *Consumer) autoscale(ctx context.Context) {
+	a := newAutoscaler(*c.opts.Autoscale)
+	m := &QueueMonitor{source: c.source, opts: QueueMonitorOptions{Queues: []string{c.opts.Queue}}}
+	defer m.close()
+
+	ticker := time.NewTicker(a.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, sample := range m.sample() {
+			if sample.Err != nil {
+				continue
+			}
+
+			stats := c.Stats()
+			from := stats.Workers
+			to := a.scale(from, sample, stats.Succeeded+stats.Failed+stats.Panicked)
+			if to != from {
+				c.resize(to)
+				if a.opts.OnScale != nil {
+					a.opts.OnScale(from, to, sample)
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resize sets the number of workers of the consumer, and its prefetch when
+// it follows the workers.
+func (c *Consumer) resize(workers int) {
+	c.m.Lock()
+	c.workers = workers
+	if c.pool != nil {
+		c.pool.resize(workers)
+	}
+	ch := c.ch
+	c.m.Unlock()
+
+	if prefetch := workers * c.opts.Autoscale.PrefetchPerWorker; ch != nil && prefetch > 0 {
+		if err := ch.Qos(prefetch, 0, true); err != nil {
+			Logger.Printf("consumer %q could not set the prefetch of queue %q to %d: %v", c.opts.Tag, c.opts.Queue, prefetch, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAutoscalerScale(t *testing.T) {
+	a := newAutoscaler(AutoscaleOptions{
+		MinWorkers:     1,
+		MaxWorkers:     8,
+		TargetDrain:    10 * time.Second,
+		ScaleDownDelay: 30 * time.Second,
+	})
+
+	start := time.Now()
+	at := func(s int) time.Time { return start.Add(time.Duration(s) * time.Second) }
+
+	steps := []struct {
+		workers   int
+		messages  int
+		completed uint64
+		at        int
+		expected  int
+	}{
+		// First sample, no rate yet.
+		{1, 500, 0, 0, 1},
+		// Nothing handled with a backlog: one more worker.
+		{1, 500, 0, 10, 2},
+		// 2 workers handled 40 messages in 10s: 2/s each, 400 messages take
+		// 20 worker-seconds per 10s of drain, so 20 workers, capped to 8.
+		{2, 400, 40, 20, 8},
+		// 8 workers at 2/s each drain 50 messages in 3s: 3 workers would do,
+		// but they are only removed after the delay.
+		{8, 50, 200, 30, 8},
+		{8, 50, 360, 40, 8},
+		{8, 50, 520, 60, 7},
+		// Removed one at a time, waiting for the delay again.
+		{7, 0, 660, 70, 7},
+		{7, 0, 800, 90, 6},
+		// Backlog again: added right away.
+		{6, 1000, 920, 100, 8},
+	}
+
+	for i, step := range steps {
+		got := a.scale(step.workers, QueueSample{Messages: step.messages, At: at(step.at)}, step.completed)
+		if got != step.expected {
+			t.Errorf("step %d: expected %d workers, got %d", i, step.expected, got)
+		}
+	}
+}
+
+func TestAutoscalerBounds(t *testing.T) {
+	a := newAutoscaler(AutoscaleOptions{MinWorkers: 2, MaxWorkers: 1})
+
+	if got := a.clamp(0); got != 2 {
+		t.Errorf("expected the minimum of 2 workers, got %d", got)
+	}
+	if got := a.clamp(5); got != 2 {
+		t.Errorf("expected the maximum to be raised to the minimum, got %d", got)
+	}
+}
+
+func TestWorkerPoolResize(t *testing.T) {
+	deliveries := make(chan Delivery)
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+
+	var running int32
+	pool := newWorkerPool(deliveries, func(Delivery) error {
+		atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		started <- struct{}{}
+		<-release
+		return nil
+	}, HandlerOptions{})
+
+	pool.resize(3)
+	if got := pool.size(); got != 3 {
+		t.Fatalf("expected 3 workers, got %d", got)
+	}
+
+	// All three workers hold a delivery at the same time.
+	for i := 0; i < 3; i++ {
+		deliveries <- Delivery{}
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatalf("expected delivery %d to be handled concurrently", i+1)
+		}
+	}
+
+	pool.resize(0)
+	if got := pool.size(); got != 1 {
+		t.Fatalf("expected at least one worker, got %d", got)
+	}
+	close(release)
+
+	deliveries <- Delivery{}
+	close(deliveries)
+
+	select {
+	case <-pool.done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the pool to be done once the deliveries are closed")
+	}
+
+	// Resizing a finished pool does not start workers.
+	pool.resize(2)
+	if got := atomic.LoadInt32(&running); got != 0 {
+		t.Fatalf("expected no running handler, got %d", got)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// backlog tracks the deliveries buffered for a consumer that have not been
+// received from its chan Delivery yet.
+type backlog struct {
+	m       sync.Mutex
+	pending int
+	oldest  time.Time // arrival of the oldest pending delivery
+}
+
+func (b *backlog) set(pending int, oldest time.Time) {
+	b.m.Lock()
+	b.pending = pending
+	b.oldest = oldest
+	b.m.Unlock()
+}
+
+func (b *backlog) snapshot(now time.Time) (int, time.Duration) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.pending == 0 {
+		return 0, 0
+	}
+	return b.pending, now.Sub(b.oldest)
+}
+
+// ConsumerBacklog describes the deliveries received from the server for a
+// consumer that the application has not read from the chan Delivery yet.
+type ConsumerBacklog struct {
+	Consumer  string        // consumer tag
+	Pending   int           // number of unread deliveries
+	OldestAge time.Duration // time since the oldest unread delivery arrived, zero when none
+}
+
+/*
+ConsumerBacklogs returns the backlog of every active consumer of the channel,
+ordered by consumer tag.
+
+Deliveries pushed by the server are buffered by the library until they are read
+from the chan Delivery returned by Channel.Consume.  A growing backlog, or an
+old oldest delivery, means that the consumer does not keep up: the server will
+stop delivering once the prefetch count is reached, and without a prefetch
+count the buffered deliveries grow memory usage without bound.  Export these
+values as metrics to detect slow consumers before this happens.
+*/
+func (ch *Channel) ConsumerBacklogs() []ConsumerBacklog {
+	return ch.consumers.backlogSnapshot(time.Now())
+}
+
+func (subs *consumers) backlogSnapshot(now time.Time) []ConsumerBacklog {
+	subs.Lock()
+	defer subs.Unlock()
+
+	backlogs := make([]ConsumerBacklog, 0, len(subs.backlogs))
+	for tag, b := range subs.backlogs {
+		pending, age := b.snapshot(now)
+		backlogs = append(backlogs, ConsumerBacklog{
+			Consumer:  tag,
+			Pending:   pending,
+			OldestAge: age,
+		})
+	}
+
+	sort.Slice(backlogs, func(i, j int) bool {
+		return backlogs[i].Consumer < backlogs[j].Consumer
+	})
+
+	return backlogs
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func waitForBacklog(t *testing.T, ch *Channel, pending int) ConsumerBacklog {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		backlogs := ch.ConsumerBacklogs()
+		if len(backlogs) == 1 && backlogs[0].Pending == pending {
+			return backlogs[0]
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected a backlog of %d deliveries, got %+v", pending, backlogs)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestConsumerBacklogs(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &basicConsumeOk{ConsumerTag: "slow"})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: "slow", DeliveryTag: tag})
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.Consume("queue", "slow", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	backlog := waitForBacklog(t, ch, 3)
+	if want, got := "slow", backlog.Consumer; want != got {
+		t.Errorf("expected backlog of consumer %q, got %q", want, got)
+	}
+	if backlog.OldestAge <= 0 {
+		t.Errorf("expected a positive age of the oldest delivery, got %v", backlog.OldestAge)
+	}
+
+	<-deliveries
+	waitForBacklog(t, ch, 2)
+
+	<-deliveries
+	<-deliveries
+	if backlog := waitForBacklog(t, ch, 0); backlog.OldestAge != 0 {
+		t.Errorf("expected no age without pending deliveries, got %v", backlog.OldestAge)
+	}
+}
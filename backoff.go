@@ -0,0 +1,59 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// backoff computes exponentially growing delays between retries.  Each delay
+// doubles the previous one, starting at base and capped at max, with up to 20%
+// of random jitter so that many clients retrying at once spread out.
+type backoff struct {
+	base    time.Duration
+	max     time.Duration
+	attempt int
+}
+
+func newBackoff(base, max time.Duration) *backoff {
+	return &backoff{base: base, max: max}
+}
+
+// next returns the delay to wait before the next attempt.
+func (b *backoff) next() time.Duration {
+	d := b.base
+	for i := 0; i < b.attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	b.attempt++
+
+	if jitter := int64(d) / 5; jitter > 0 {
+		d -= time.Duration(rand.Int63n(jitter))
+	}
+	return d
+}
+
+// reset starts the sequence of delays over from base.
+func (b *backoff) reset() {
+	b.attempt = 0
+}
+
+// sleepContext waits for d or until ctx is done, whichever happens first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsUntilMax(t *testing.T) {
+	b := newBackoff(100*time.Millisecond, time.Second)
+
+	prev := time.Duration(0)
+	for i := 0; i < 4; i++ {
+		d := b.next()
+		if d < prev {
+			t.Fatalf("expected delays to grow, got %s after %s", d, prev)
+		}
+		prev = d
+	}
+
+	for i := 0; i < 10; i++ {
+		if d := b.next(); d > time.Second || d < 800*time.Millisecond {
+			t.Fatalf("expected delay to be capped near the max, got %s", d)
+		}
+	}
+
+	b.reset()
+	if d := b.next(); d > 100*time.Millisecond {
+		t.Fatalf("expected delay to start over from base after reset, got %s", d)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+)
+
+// Binding describes a binding from an exchange to a queue or to another
+// exchange, identified by its routing key and arguments.
+type Binding struct {
+	Exchange string // source exchange
+	Key      string // routing key
+	Args     Table  // optional binding arguments
+}
+
+// BindingError is returned by the batch binding methods when one of the
+// bindings could not be applied.  Bindings before Index have been applied,
+// bindings from Index onwards have not.
+type BindingError struct {
+	Index   int     // position of the failing binding in the batch
+	Binding Binding // the failing binding
+	Err     error   // the error returned by the server or the library
+}
+
+func (e *BindingError) Error() string {
+	return fmt.Sprintf("binding %d (exchange %q, key %q): %v", e.Index, e.Binding.Exchange, e.Binding.Key, e.Err)
+}
+
+func (e *BindingError) Unwrap() error {
+	return e.Err
+}
+
+/*
+QueueBindAll binds queue to every binding in bindings, as many calls to
+Channel.QueueBind would, but without waiting for a round trip per binding.
+
+All queue.bind requests are written to the connection at once and their replies
+are collected afterwards, which makes binding hundreds of routing keys take
+about as long as binding a single one.  Unlike issuing the requests with noWait
+set, the replies are still awaited, so when a binding fails the returned
+*BindingError identifies exactly which one.
+
+The server processes the requests in order and closes the channel on the first
+failure, so all bindings before BindingError.Index are in place, and none after
+it are.  As with Channel.QueueBind, the channel must be discarded after an
+error.
+
+The context is checked before each request is written.  Once written, the
+replies of the requests that were sent are always awaited.
+*/
+func (ch *Channel) QueueBindAll(ctx context.Context, queue string, bindings []Binding) error {
+	reqs := make([]message, len(bindings))
+	for i, b := range bindings {
+		if err := b.Args.Validate(); err != nil {
+			return &BindingError{Index: i, Binding: b, Err: err}
+		}
+		reqs[i] = &queueBind{
+			Queue:      queue,
+			Exchange:   b.Exchange,
+			RoutingKey: b.Key,
+			Arguments:  b.Args,
+		}
+	}
+
+	if i, err := ch.pipeline(ctx, reqs, &queueBindOk{}); err != nil {
+		return &BindingError{Index: i, Binding: bindings[i], Err: err}
+	}
+	return nil
+}
+
+/*
+QueueUnbindAll removes every binding in bindings from queue, as many calls to
+Channel.QueueUnbind would.  It pipelines the requests and reports failures in
+the same way as Channel.QueueBindAll.
+*/
+func (ch *Channel) QueueUnbindAll(ctx context.Context, queue string, bindings []Binding) error {
+	reqs := make([]message, len(bindings))
+	for i, b := range bindings {
+		if err := b.Args.Validate(); err != nil {
+			return &BindingError{Index: i, Binding: b, Err: err}
+		}
+		reqs[i] = &queueUnbind{
+			Queue:      queue,
+			Exchange:   b.Exchange,
+			RoutingKey: b.Key,
+			Arguments:  b.Args,
+		}
+	}
+
+	if i, err := ch.pipeline(ctx, reqs, &queueUnbindOk{}); err != nil {
+		return &BindingError{Index: i, Binding: bindings[i], Err: err}
+	}
+	return nil
+}
+
+// pipeline writes all synchronous requests before reading any reply, then
+// waits for one reply of the type of res per request that was written, and
+// records the requests that succeeded like Channel.call.  On failure, it
+// returns the index of the first request that did not succeed, which is
+// within reqs.
+func (ch *Channel) pipeline(ctx context.Context, reqs []message, res message) (int, error) {
+	if len(reqs) == 0 {
+		return 0, nil
+	}
+
+	var sendErr error
+	sent := 0
+
+	// Hold the channel mutex so no content frames of a concurrent publishing
+	// on this channel are interleaved with the unflushed method frames.
+	ch.m.Lock()
+	for _, req := range reqs {
+		if sendErr = ctx.Err(); sendErr != nil {
+			break
+		}
+		if ch.IsClosed() {
+			sendErr = ErrClosed
+			break
+		}
+		if sendErr = ch.connection.sendUnflushed(&methodFrame{
+			ChannelId: ch.id,
+			Method:    req,
+		}); sendErr != nil {
+			break
+		}
+		sent++
+	}
+	if err := ch.connection.endSendUnflushed(); err != nil && sendErr == nil {
+		sendErr = err
+	}
+	ch.m.Unlock()
+
+	if sent > 0 {
+		atomic.AddInt32(&ch.calls, 1)
+		defer atomic.AddInt32(&ch.calls, -1)
+	}
+
+	for i := 0; i < sent; i++ {
+		select {
+		case e, ok := <-ch.errors:
+			if ok {
+				return i, withOp(e, reqs[i])
+			}
+			return i, ErrClosed
+
+		case msg := <-ch.rpc:
+			if msg == nil {
+				return i, ErrClosed
+			}
+			if !setReply(msg, res) {
+				return i, ErrCommandInvalid
+			}
+			ch.recordTopology(reqs[i], res)
+		}
+	}
+
+	if sendErr != nil {
+		// All requests were buffered when only the final flush failed.
+		if sent == len(reqs) {
+			sent--
+		}
+		return sent, sendErr
+	}
+	return 0, nil
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"testing"
+)
+
+func TestQueueBindAllReportsFailingBinding(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueBind{})
+		srv.recv(1, &queueBind{})
+		srv.recv(1, &queueBind{})
+
+		srv.send(1, &queueBindOk{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no exchange 'missing'"})
+		srv.recv(1, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	bindings := []Binding{
+		{Exchange: "logs", Key: "info"},
+		{Exchange: "missing", Key: "warn"},
+		{Exchange: "logs", Key: "error"},
+	}
+
+	err = ch.QueueBindAll(context.Background(), "q", bindings)
+
+	var bindErr *BindingError
+	if !errors.As(err, &bindErr) {
+		t.Fatalf("expected a *BindingError, got %v", err)
+	}
+	if want, got := 1, bindErr.Index; want != got {
+		t.Errorf("expected failing binding index %d, got %d", want, got)
+	}
+	if want, got := "missing", bindErr.Binding.Exchange; want != got {
+		t.Errorf("expected failing exchange %q, got %q", want, got)
+	}
+
+	var amqpErr *Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != NotFound {
+		t.Errorf("expected the server error to be wrapped, got %v", err)
+	}
+
+	var op *OpError
+	if !errors.As(err, &op) || op.Op != "queue.bind" || op.Exchange != "missing" || op.RoutingKey != "warn" {
+		t.Errorf("expected the failing queue.bind to be reported, got %+v", op)
+	}
+}
+
+func TestQueueBindAllRecordsTopology(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueBind{})
+		srv.recv(1, &queueBind{})
+		srv.send(1, &queueBindOk{})
+		srv.send(1, &queueBindOk{})
+
+		srv.recv(1, &queueUnbind{})
+		srv.send(1, &queueUnbindOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	c.topology = newTopologyRecorder(TopologyRecovery{})
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	bindings := []Binding{
+		{Exchange: "logs", Key: "info"},
+		{Exchange: "logs", Key: "error"},
+	}
+	if err := ch.QueueBindAll(context.Background(), "q", bindings); err != nil {
+		t.Fatalf("could not bind: %v", err)
+	}
+	if got := c.topology.bindings; len(got) != 2 || got[0].Key != "info" || got[1].Key != "error" || got[1].Destination != "q" {
+		t.Fatalf("expected both bindings to be recorded, got %+v", got)
+	}
+
+	if err := ch.QueueUnbindAll(context.Background(), "q", bindings[:1]); err != nil {
+		t.Fatalf("could not unbind: %v", err)
+	}
+	if got := c.topology.bindings; len(got) != 1 || got[0].Key != "error" {
+		t.Fatalf("expected the removed binding to be forgotten, got %+v", got)
+	}
+}
+
+// failingFlush delivers the writes to the server but reports them as failed
+// once fail is set and the server replied, like a connection lost while
+// flushing.
+type failingFlush struct {
+	io.ReadWriteCloser
+	fail    int32
+	replied chan struct{}
+}
+
+func (f *failingFlush) Write(b []byte) (int, error) {
+	n, err := f.ReadWriteCloser.Write(b)
+	if err == nil && atomic.LoadInt32(&f.fail) == 1 {
+		<-f.replied
+		err = errors.New("connection reset by peer")
+	}
+	return n, err
+}
+
+func TestQueueBindAllFlushFailure(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+	transport := &failingFlush{ReadWriteCloser: rwc, replied: make(chan struct{})}
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueBind{})
+		srv.recv(1, &queueBind{})
+		// The client may be gone already, so the replies can fail.
+		_ = srv.w.WriteFrame(&methodFrame{ChannelId: 1, Method: &queueBindOk{}})
+		close(transport.replied)
+		_ = srv.w.WriteFrame(&methodFrame{ChannelId: 1, Method: &queueBindOk{}})
+	}()
+
+	c, err := Open(transport, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	bindings := []Binding{
+		{Exchange: "logs", Key: "info"},
+		{Exchange: "logs", Key: "error"},
+	}
+	atomic.StoreInt32(&transport.fail, 1)
+	err = ch.QueueBindAll(context.Background(), "q", bindings)
+
+	var bindErr *BindingError
+	if !errors.As(err, &bindErr) {
+		t.Fatalf("expected a *BindingError, got %v", err)
+	}
+	if bindErr.Index < 0 || bindErr.Index >= len(bindings) || bindErr.Binding.Key != bindings[bindErr.Index].Key {
+		t.Fatalf("expected the index of a binding of the batch, got %+v", bindErr)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Publisher.Publish without publishing while
+// the circuit breaker of the publisher is open.
+var ErrCircuitOpen = errors.New("publisher circuit breaker is open")
+
+// BreakerState is the state of the circuit breaker of a Publisher.
+type BreakerState int
+
+const (
+	// BreakerClosed lets every publishing through.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails publishings right away with ErrCircuitOpen.
+	BreakerOpen
+	// BreakerHalfOpen lets a few probe publishings through to find out
+	// whether the broker recovered.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// BreakerOptions configures the circuit breaker of a Publisher.
+type BreakerOptions struct {
+	// FailureThreshold is the number of consecutive failed publishings that
+	// opens the breaker, 5 when zero.  Nacks, confirmations not received in
+	// time and channel or connection errors are failures, returned
+	// publishings and cancelled contexts are not.
+	FailureThreshold int
+
+	// OpenTimeout is how long the breaker stays open before letting probes
+	// through, 30s when zero.
+	OpenTimeout time.Duration
+
+	// HalfOpenProbes is the number of publishings let through at the same
+	// time while half-open, 1 when zero.  The breaker closes on the first
+	// successful probe and opens again on the first failed one.
+	HalfOpenProbes int
+
+	// OnStateChange is called on every change of state, with the breaker
+	// locked: it must not publish with the Publisher.
+	OnStateChange func(from, to BreakerState)
+}
+
+// BreakerStats are the counters of the circuit breaker of a Publisher.
+type BreakerStats struct {
+	State       BreakerState
+	Failures    uint64    // failed publishings
+	Consecutive int       // consecutive failed publishings
+	Rejected    uint64    // publishings failed with ErrCircuitOpen
+	Opened      uint64    // times the breaker opened
+	LastOpened  time.Time // last time the breaker opened
+}
+
+type circuitBreaker struct {
+	opts BreakerOptions
+	now  func() time.Time
+
+	m        sync.Mutex
+	state    BreakerState
+	probes   int // probes in flight while half-open
+	openedAt time.Time
+	stats    BreakerStats
+}
+
+func newCircuitBreaker(opts BreakerOptions) *circuitBreaker {
+	if opts.FailureThreshold <= 0 {
+		opts.FailureThreshold = 5
+	}
+	if opts.OpenTimeout <= 0 {
+		opts.OpenTimeout = 30 * time.Second
+	}
+	if opts.HalfOpenProbes <= 0 {
+		opts.HalfOpenProbes = 1
+	}
+	return &circuitBreaker{opts: opts, now: time.Now}
+}
+
+// allow returns ErrCircuitOpen when a publishing must fail right away, and
+// whether the publishing is a probe of the half-open breaker.
+func (b *circuitBreaker) allow() (probe bool, err error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.state == BreakerOpen && b.now().Sub(b.openedAt) >= b.opts.OpenTimeout {
+		b.transition(BreakerHalfOpen)
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		b.stats.Rejected++
+		return false, ErrCircuitOpen
+
+	case BreakerHalfOpen:
+		if b.probes >= b.opts.HalfOpenProbes {
+			b.stats.Rejected++
+			return false, ErrCircuitOpen
+		}
+		b.probes++
+		return true, nil
+	}
+
+	return false, nil
+}
+
+// record updates the breaker with the result of a publishing let through by
+// allow.
+func (b *circuitBreaker) record(err error, probe bool) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if probe {
+		b.probes--
+	}
+
+	var retErr *ReturnedError
+	switch {
+	case errors.Is(err, context.Canceled):
+		// Says nothing about the broker.
+		return
+
+	case err == nil || errors.As(err, &retErr):
+		b.stats.Consecutive = 0
+		if probe && b.state == BreakerHalfOpen {
+			b.transition(BreakerClosed)
+		}
+
+	default:
+		b.stats.Failures++
+		b.stats.Consecutive++
+		if probe && b.state == BreakerHalfOpen || b.state == BreakerClosed && b.stats.Consecutive >= b.opts.FailureThreshold {
+			b.transition(BreakerOpen)
+		}
+	}
+}
+
+// transition changes the state, b.m must be held.
+func (b *circuitBreaker) transition(to BreakerState) {
+	from := b.state
+	b.state = to
+
+	if to == BreakerOpen {
+		b.openedAt = b.now()
+		b.stats.Opened++
+		b.stats.LastOpened = b.openedAt
+	}
+
+	if b.opts.OnStateChange != nil {
+		b.opts.OnStateChange(from, to)
+	}
+}
+
+func (b *circuitBreaker) snapshot() BreakerStats {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	stats := b.stats
+	stats.State = b.state
+	return stats
+}
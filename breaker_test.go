@@ -0,0 +1,122 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerStates(t *testing.T) {
+	now := time.Now()
+
+	var changes []BreakerState
+	b := newCircuitBreaker(BreakerOptions{
+		FailureThreshold: 2,
+		OpenTimeout:      time.Minute,
+		OnStateChange:    func(_, to BreakerState) { changes = append(changes, to) },
+	})
+	b.now = func() time.Time { return now }
+
+	publish := func(err error) error {
+		probe, allowErr := b.allow()
+		if allowErr != nil {
+			return allowErr
+		}
+		b.record(err, probe)
+		return err
+	}
+
+	// Returned publishings and cancellations are not failures.
+	publish(ErrNacked)
+	publish(&ReturnedError{})
+	publish(ErrNacked)
+	publish(context.Canceled)
+	if state := b.snapshot().State; state != BreakerClosed {
+		t.Fatalf("expected the breaker to stay closed, got %v", state)
+	}
+
+	publish(ErrNacked)
+	if err := publish(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+
+	// A failed probe opens the breaker again.
+	now = now.Add(time.Minute)
+	if err := publish(ErrClosed); err != ErrClosed {
+		t.Fatalf("expected a probe to be let through, got %v", err)
+	}
+	if err := publish(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen after a failed probe, got %v", err)
+	}
+
+	// Only one probe at a time, a successful probe closes the breaker.
+	now = now.Add(time.Minute)
+	probe, err := b.allow()
+	if err != nil || !probe {
+		t.Fatalf("expected a probe, got %t %v", probe, err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a second probe to be rejected, got %v", err)
+	}
+	b.record(nil, probe)
+	if err := publish(nil); err != nil {
+		t.Fatalf("expected the breaker to be closed, got %v", err)
+	}
+
+	expected := []BreakerState{BreakerOpen, BreakerHalfOpen, BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected state changes %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("expected state changes %v, got %v", expected, changes)
+		}
+	}
+
+	stats := b.snapshot()
+	if stats.Failures != 4 || stats.Rejected != 3 || stats.Opened != 2 || stats.Consecutive != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestPublisherCircuitBreakerFailsFast(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 1})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 2})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{
+		MaxAttempts: 1,
+		Breaker:     &BreakerOptions{FailureThreshold: 2, OpenTimeout: time.Hour},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i := 0; i < 2; i++ {
+		if err := p.Publish(ctx, "", "q", Publishing{Body: []byte("hello")}); !errors.Is(err, ErrNacked) {
+			t.Fatalf("expected the publishing to be nacked, got: %v", err)
+		}
+	}
+
+	if err := p.Publish(ctx, "", "q", Publishing{Body: []byte("hello")}); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got: %v", err)
+	}
+
+	if stats := p.BreakerStats(); stats.State != BreakerOpen || stats.Rejected != 1 {
+		t.Fatalf("unexpected breaker stats: %+v", stats)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrBulkheadFull is returned by Bulkhead.Channel when the bulkhead already
+// has MaxChannels open channels.
+var ErrBulkheadFull = errors.New("bulkhead has no channel available")
+
+// BulkheadOptions limits the resources of the connection a Bulkhead uses.
+type BulkheadOptions struct {
+	// MaxChannels caps the open channels of the bulkhead, in use or idle.
+	// Unlimited when zero.
+	MaxChannels int
+
+	// MaxInFlight caps the publishings of Bulkhead.Publish waiting for their
+	// confirmation, across the channels of the bulkhead.  When set, the
+	// channels of the bulkhead are put in confirm mode.  Unlimited when zero.
+	MaxInFlight int
+
+	// Pool configures the idle channels kept by the bulkhead.
+	Pool PoolOptions
+}
+
+// BulkheadStats are the counters of a Bulkhead.
+type BulkheadStats struct {
+	Name     string
+	Channels int // open channels, in use or idle
+	Idle     int // idle channels
+	InFlight int // publishings waiting for their confirmation
+}
+
+/*
+Bulkhead is a group of channels of a connection with its own limits, so that
+a misbehaving workload, like a bulk import, cannot take all the channels or
+confirmation slots that latency-critical work sharing the connection needs.
+
+Take channels with Channel or ChannelContext and give them back with Release,
+as with a ChannelPool.  A Bulkhead is a ChannelSource, so that a Publisher or
+a Consumer can draw their channels from it; their channels count against
+MaxChannels until they are closed.  Publish publishes on a channel of the
+bulkhead within MaxInFlight.
+*/
+type Bulkhead struct {
+	name string
+	pool *ChannelPool
+
+	channels chan struct{} // a slot per open channel, nil when unlimited
+	inflight chan struct{} // a slot per unconfirmed publishing, nil when unlimited
+}
+
+// NewBulkhead returns a bulkhead of channels of conn.  Call Close to close its
+// idle channels.
+func NewBulkhead(conn *Connection, name string, opts BulkheadOptions) *Bulkhead {
+	h := &Bulkhead{
+		name: name,
+		pool: NewChannelPool(conn, opts.Pool),
+	}
+	if opts.MaxChannels > 0 {
+		h.channels = make(chan struct{}, opts.MaxChannels)
+	}
+	if opts.MaxInFlight > 0 {
+		h.inflight = make(chan struct{}, opts.MaxInFlight)
+	}
+
+	h.pool.open = func() (*Channel, error) {
+		ch, err := conn.Channel()
+		if err != nil {
+			return nil, err
+		}
+		if h.inflight != nil {
+			if err := ch.Confirm(false); err != nil {
+				_ = ch.Close()
+				return nil, err
+			}
+		}
+		return ch, nil
+	}
+
+	return h
+}
+
+// Name returns the name of the bulkhead.
+func (h *Bulkhead) Name() string {
+	return h.name
+}
+
+// Channel returns an idle channel of the bulkhead, or opens a new one.  It
+// returns an error wrapping ErrBulkheadFull right away when MaxChannels channels are open.
+func (h *Bulkhead) Channel() (*Channel, error) {
+	return h.channel(nil)
+}
+
+// ChannelContext is like Channel, but waits for a channel of the bulkhead to
+// be closed when MaxChannels channels are open, until ctx is done.
+func (h *Bulkhead) ChannelContext(ctx context.Context) (*Channel, error) {
+	return h.channel(ctx)
+}
+
+// channel waits for a slot until ctx is done, or fails right away when ctx is
+// nil.
+func (h *Bulkhead) channel(ctx context.Context) (*Channel, error) {
+	if h.channels == nil {
+		return h.pool.Get()
+	}
+
+	res, err := h.pool.pool.get()
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		return res.(*Channel), nil
+	}
+
+	if ctx == nil {
+		select {
+		case h.channels <- struct{}{}:
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrBulkheadFull, h.name)
+		}
+	} else {
+		select {
+		case h.channels <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	ch, err := h.pool.open()
+	if err != nil {
+		<-h.channels
+		return nil, err
+	}
+	// The slot is held until the channel closes, for whatever reason.
+	ch.OnClose(func(*Error) { <-h.channels })
+
+	return ch, nil
+}
+
+// Release gives back a channel taken with Channel or ChannelContext.  The
+// channel must not be used by the caller afterwards.
+func (h *Bulkhead) Release(ch *Channel) {
+	h.pool.Put(ch)
+}
+
+/*
+Publish publishes msg on a channel of the bulkhead and returns its
+DeferredConfirmation.  When MaxInFlight publishings are waiting for their
+confirmation, Publish waits for one of them to be confirmed until ctx is done.
+
+The DeferredConfirmation is nil when MaxInFlight is zero, as the channels of
+the bulkhead are then not in confirm mode.
+*/
+func (h *Bulkhead) Publish(ctx context.Context, exchange, key string, mandatory bool, msg Publishing) (*DeferredConfirmation, error) {
+	if h.inflight != nil {
+		select {
+		case h.inflight <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	dc, err := h.publish(ctx, exchange, key, mandatory, msg)
+	if h.inflight != nil {
+		if err != nil || dc == nil {
+			<-h.inflight
+		} else {
+			// Confirmations are also done when the channel closes.
+			go func() {
+				<-dc.Done()
+				<-h.inflight
+			}()
+		}
+	}
+
+	return dc, err
+}
+
+func (h *Bulkhead) publish(ctx context.Context, exchange, key string, mandatory bool, msg Publishing) (*DeferredConfirmation, error) {
+	ch, err := h.ChannelContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer h.Release(ch)
+
+	return ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, false, msg)
+}
+
+// Stats returns the counters of the bulkhead.  Channels is only counted when
+// MaxChannels is set, and InFlight when MaxInFlight is set.
+func (h *Bulkhead) Stats() BulkheadStats {
+	return BulkheadStats{
+		Name:     h.name,
+		Channels: len(h.channels),
+		Idle:     h.pool.Idle(),
+		InFlight: len(h.inflight),
+	}
+}
+
+// Close closes the idle channels of the bulkhead.  Channels in use are closed
+// by Release.
+func (h *Bulkhead) Close() error {
+	return h.pool.Close()
+}
+
+// Bulkheads is a set of named bulkheads sharing a connection.
+type Bulkheads struct {
+	heads map[string]*Bulkhead
+}
+
+// NewBulkheads returns a bulkhead of conn for each of the named options.
+func NewBulkheads(conn *Connection, opts map[string]BulkheadOptions) *Bulkheads {
+	b := &Bulkheads{heads: make(map[string]*Bulkhead, len(opts))}
+	for name, o := range opts {
+		b.heads[name] = NewBulkhead(conn, name, o)
+	}
+	return b
+}
+
+// Get returns the named bulkhead, or nil when there is none.
+func (b *Bulkheads) Get(name string) *Bulkhead {
+	return b.heads[name]
+}
+
+// Stats returns the counters of all bulkheads, sorted by name.
+func (b *Bulkheads) Stats() []BulkheadStats {
+	stats := make([]BulkheadStats, 0, len(b.heads))
+	for _, h := range b.heads {
+		stats = append(stats, h.Stats())
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Close closes all bulkheads.
+func (b *Bulkheads) Close() error {
+	var errs []error
+	for _, h := range b.heads {
+		if err := h.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBulkheadLimitsChannels(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.channelOpen(1)
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	heads := NewBulkheads(c, map[string]BulkheadOptions{
+		"import": {MaxChannels: 1},
+	})
+	h := heads.Get("import")
+	if h == nil || h.Name() != "import" {
+		t.Fatalf("expected the import bulkhead, got %v", h)
+	}
+	if heads.Get("api") != nil {
+		t.Fatalf("expected no api bulkhead")
+	}
+
+	ch, err := h.Channel()
+	if err != nil {
+		t.Fatalf("could not get channel: %v", err)
+	}
+
+	if _, err := h.Channel(); !errors.Is(err, ErrBulkheadFull) {
+		t.Fatalf("expected ErrBulkheadFull, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.ChannelContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the wait for a channel to time out, got %v", err)
+	}
+
+	h.Release(ch)
+	if again, err := h.Channel(); err != nil || again != ch {
+		t.Fatalf("expected the idle channel to be reused, got %v %v", again, err)
+	}
+	h.Release(ch)
+
+	if stats := heads.Stats(); len(stats) != 1 || stats[0].Channels != 1 || stats[0].Idle != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if err := heads.Close(); err != nil {
+		t.Fatalf("could not close bulkheads: %v", err)
+	}
+
+	// The slot of the closed channel is given back.
+	deadline := time.Now().Add(time.Second)
+	for h.Stats().Channels != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the channel slot to be released, got %+v", h.Stats())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestBulkheadLimitsInFlightPublishings(t *testing.T) {
+	confirm := make(chan struct{})
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		<-confirm
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 2})
+		srv.connectionClose()
+	})
+
+	h := NewBulkhead(c, "import", BulkheadOptions{MaxInFlight: 1})
+
+	dc, err := h.Publish(context.Background(), "", "q", false, Publishing{Body: []byte("1")})
+	if err != nil || dc == nil {
+		t.Fatalf("could not publish: %v %v", dc, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.Publish(ctx, "", "q", false, Publishing{Body: []byte("2")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the publishing to wait for a confirmation slot, got %v", err)
+	}
+	if got := h.Stats().InFlight; got != 1 {
+		t.Fatalf("expected 1 publishing in flight, got %d", got)
+	}
+
+	close(confirm)
+	if !dc.Wait() {
+		t.Fatalf("expected the first publishing to be acked")
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	dc, err = h.Publish(ctx, "", "q", false, Publishing{Body: []byte("3")})
+	if err != nil {
+		t.Fatalf("expected the confirmation slot to be released, got %v", err)
+	}
+	if !dc.Wait() {
+		t.Fatalf("expected the second publishing to be acked")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
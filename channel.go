@@ -7,9 +7,10 @@ package amqp091
 
 import (
 	"context"
-	"reflect"
+	"errors"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // 0      1         3             7                  size+7 size+8
@@ -48,6 +49,24 @@ type Channel struct {
 	// Channel and Connection exceptions will be broadcast on these listeners.
 	closes []chan *Error
 
+	// Callbacks registered with OnClose.
+	onClose closeCallbacks
+
+	// Creation site and last use, only set when leak detection is enabled.
+	leak *leakTracker
+
+	// Deliveries awaiting acknowledgement, only set when ack diagnostics or
+	// delivery tracking are enabled.
+	acks *ackTracker
+
+	// State of PauseDeliveries.
+	flowM sync.Mutex
+	flow  deliveryFlow
+
+	// Pending Redelivery of the last RecoverWithContext.
+	redeliveryM sync.Mutex
+	redelivery  *Redelivery
+
 	// Listeners for active=true flow control.  When true is sent to a listener,
 	// publishing should pause until false is sent to listeners.
 	flows []chan bool
@@ -60,10 +79,44 @@ type Channel struct {
 	// a consumer has been cancelled.
 	cancels []chan string
 
+	// Called synchronously with returned publishings, before their
+	// confirmation is dispatched.  Used by Publisher to correlate returns.
+	returnHook func(Return)
+
 	// Allocated when in confirm mode in order to track publish counter and order confirms
 	confirms   *confirms
 	confirming bool
 
+	// Set on the channels of a topology recovery, whose declarations are not
+	// recorded, see RecoveryPolicy.Topology.
+	unrecorded bool
+
+	// Prefetch set on the channel, by global, replayed when the channel or
+	// its consumers are recovered.  Guarded by m.
+	recordedQos []basicQos
+
+	// State of SetAutoReopen.
+	autoReopen int32 // 1 when enabled, accessed atomically
+	reopening  int32 // 1 while channel.open is sent again, accessed atomically
+	reopenM    sync.Mutex
+	reopens    []chan *Error
+	calls      int32  // calls waiting for a reply, accessed atomically
+	tagOffset  uint64 // delivery tags received before the last reopen, accessed atomically
+	lastTag    uint64 // highest delivery tag received, accessed atomically
+
+	// Unix nanoseconds of the last nack, return or failed publishing, see
+	// LastPublishError.
+	lastPublishError int64
+
+	// Limiters throttling publishings, by message count and by body size.
+	limiterM     sync.RWMutex
+	msgLimiter   Limiter
+	bytesLimiter Limiter
+
+	// Hooks run on every publishing before it is sent.
+	hookM        sync.RWMutex
+	publishHooks []PublishHook
+
 	// Selects on any errors from shutdown during RPC
 	errors chan *Error
 
@@ -74,6 +127,10 @@ type Channel struct {
 	message messageWithContent
 	header  *headerFrame
 	body    []byte
+
+	// Time the last frame of the current message was read, only mutated from
+	// recv
+	receivedAt time.Time
 }
 
 // Constructs a new channel with the given framing rules
@@ -119,6 +176,13 @@ func (ch *Channel) shutdown(e *Error) {
 
 		ch.consumers.close()
 
+		ch.redeliveryM.Lock()
+		if ch.redelivery != nil {
+			ch.redelivery.abort(ErrClosed)
+			ch.redelivery = nil
+		}
+		ch.redeliveryM.Unlock()
+
 		for _, c := range ch.closes {
 			close(c)
 		}
@@ -135,12 +199,17 @@ func (ch *Channel) shutdown(e *Error) {
 			close(c)
 		}
 
+		for _, c := range ch.reopens {
+			close(c)
+		}
+
 		// Set the slices to nil to prevent the dispatch() range from sending on
 		// the now closed channels after we release the notifyM mutex
 		ch.flows = nil
 		ch.closes = nil
 		ch.returns = nil
 		ch.cancels = nil
+		ch.reopens = nil
 
 		if ch.confirms != nil {
 			ch.confirms.Close()
@@ -149,6 +218,8 @@ func (ch *Channel) shutdown(e *Error) {
 		close(ch.errors)
 		close(ch.close)
 		ch.noNotify = true
+
+		ch.onClose.fire(e)
 	})
 }
 
@@ -157,6 +228,8 @@ func (ch *Channel) shutdown(e *Error) {
 // After the channel has been closed, send calls Channel.sendClosed(), ensuring
 // only 'channel.close' is sent to the server.
 func (ch *Channel) send(msg message) (err error) {
+	ch.touch()
+
 	// If the channel is closed, use Channel.sendClosed()
 	if ch.IsClosed() {
 		return ch.sendClosed(msg)
@@ -177,23 +250,21 @@ func (ch *Channel) call(req message, res ...message) error {
 	}
 
 	if req.wait() {
+		atomic.AddInt32(&ch.calls, 1)
+		defer atomic.AddInt32(&ch.calls, -1)
+
 		select {
 		case e, ok := <-ch.errors:
 			if ok {
-				return e
+				return withOp(e, req)
 			}
 			return ErrClosed
 
 		case msg := <-ch.rpc:
 			if msg != nil {
-				for _, try := range res {
-					if reflect.TypeOf(msg) == reflect.TypeOf(try) {
-						// *res = *msg
-						vres := reflect.ValueOf(try).Elem()
-						vmsg := reflect.ValueOf(msg).Elem()
-						vres.Set(vmsg)
-						return nil
-					}
+				if setReply(msg, res...) {
+					ch.recordTopology(req, res...)
+					return nil
 				}
 				return ErrCommandInvalid
 			}
@@ -204,13 +275,23 @@ func (ch *Channel) call(req message, res ...message) error {
 		}
 	}
 
+	ch.recordTopology(req, res...)
 	return nil
 }
 
+// recordTopology records a declaration for the topology recovery of the
+// connection, if enabled.
+func (ch *Channel) recordTopology(req message, res ...message) {
+	if t := ch.connection.topology; t != nil {
+		t.record(ch, req, res...)
+	}
+}
+
 func (ch *Channel) sendClosed(msg message) (err error) {
 	// After a 'channel.close' is sent or received the only valid response is
-	// channel.close-ok
-	if _, ok := msg.(*channelCloseOk); ok {
+	// channel.close-ok, and channel.open when the channel reopens itself
+	_, reopen := msg.(*channelOpen)
+	if _, ok := msg.(*channelCloseOk); ok || (reopen && atomic.LoadInt32(&ch.reopening) == 1) {
 		return ch.connection.send(&methodFrame{
 			ChannelId: ch.id,
 			Method:    msg,
@@ -239,40 +320,25 @@ func (ch *Channel) sendOpen(msg message) (err error) {
 			return ch.sendClosed(msg)
 		}
 
-		// Flush the buffer only after all the Frames that comprise the Message
-		// have been written to maximise benefits of using a buffered writer.
-		defer func() {
-			if endError := ch.connection.endSendUnflushed(); endError != nil {
-				if err == nil {
-					err = endError
-				}
-			}
-		}()
-
-		// We use sendUnflushed() in this method as sending the message requires
-		// sending multiple Frames (methodFrame, headerFrame, N x bodyFrame).
-		// Flushing after each Frame is inefficient, as it negates much of the
-		// benefit of using a buffered writer and results in more syscalls than
-		// necessary. Flushing buffers after every frame can have a significant
-		// performance impact when sending (e.g. basicPublish) small messages,
-		// so sendUnflushed() performs an *Unflushed* write, but is otherwise
-		// equivalent to the send() method. We later use the separate flush
-		// method to explicitly flush the buffer after all Frames are written.
-		if err = ch.connection.sendUnflushed(&methodFrame{
+		// Sending the message requires sending multiple Frames (methodFrame,
+		// headerFrame, N x bodyFrame). They are written at once by sendFrames(),
+		// and flushed only after all of them were written, which results in
+		// fewer syscalls than flushing after every frame. Large bodies are
+		// written with vectored I/O, without being copied into the buffer.
+		count := 2
+		if size > 0 {
+			count += (len(body) + size - 1) / size
+		}
+		frames := make([]frame, 0, count)
+		frames = append(frames, &methodFrame{
 			ChannelId: ch.id,
 			Method:    content,
-		}); err != nil {
-			return
-		}
-
-		if err = ch.connection.sendUnflushed(&headerFrame{
+		}, &headerFrame{
 			ChannelId:  ch.id,
 			ClassId:    class,
 			Size:       uint64(len(body)),
 			Properties: props,
-		}); err != nil {
-			return
-		}
+		})
 
 		// chunk body into size (max frame size - frame header size)
 		for i, j := 0, size; i < len(body); i, j = j, j+size {
@@ -280,13 +346,13 @@ func (ch *Channel) sendOpen(msg message) (err error) {
 				j = len(body)
 			}
 
-			if err = ch.connection.sendUnflushed(&bodyFrame{
+			frames = append(frames, &bodyFrame{
 				ChannelId: ch.id,
 				Body:      body[i:j],
-			}); err != nil {
-				return
-			}
+			})
 		}
+
+		err = ch.connection.sendFrames(frames)
 	} else {
 		// If the channel is closed, use Channel.sendClosed()
 		if ch.IsClosed() {
@@ -318,7 +384,14 @@ func (ch *Channel) dispatch(msg message) {
 			Logger.Printf("error sending channelCloseOk, channel id: %d error: %+v", ch.id, err)
 		}
 		ch.m.Unlock()
-		ch.connection.closeChannel(ch, newError(m.ReplyCode, m.ReplyText))
+
+		e := newError(m.ReplyCode, m.ReplyText)
+		if ch.reopenable(e) {
+			ch.interrupt(e)
+			go ch.reopen(e)
+			return
+		}
+		ch.connection.closeChannel(ch, e)
 
 	case *channelFlow:
 		ch.notifyM.RLock()
@@ -340,7 +413,11 @@ func (ch *Channel) dispatch(msg message) {
 
 	case *basicReturn:
 		ret := newReturn(*m)
+		ch.publishFailed()
 		ch.notifyM.RLock()
+		if ch.returnHook != nil {
+			ch.returnHook(*ret)
+		}
 		for _, c := range ch.returns {
 			c <- *ret
 		}
@@ -356,6 +433,7 @@ func (ch *Channel) dispatch(msg message) {
 		}
 
 	case *basicNack:
+		ch.publishFailed()
 		if ch.confirming {
 			if m.Multiple {
 				ch.confirms.Multiple(Confirmation{m.DeliveryTag, false})
@@ -365,7 +443,14 @@ func (ch *Channel) dispatch(msg message) {
 		}
 
 	case *basicDeliver:
-		ch.consumers.send(m.ConsumerTag, newDelivery(ch, m))
+		if ch.acks != nil {
+			ch.acks.deliver(m.ConsumerTag, m.DeliveryTag)
+			if m.Redelivered {
+				ch.redelivered()
+			}
+		}
+		m.DeliveryTag = ch.deliveryTag(m.DeliveryTag)
+		ch.consumers.send(m.ConsumerTag, newDelivery(ch, m, ch.receivedAt))
 		// TODO log failed consumer and close channel, this can happen when
 		// deliveries are in flight and a no-wait cancel has happened
 
@@ -419,6 +504,7 @@ func (ch *Channel) recvHeader(f frame) {
 		ch.header = frame
 
 		if frame.Size == 0 {
+			ch.receivedAt = time.Now()
 			ch.message.setContent(ch.header.Properties, ch.body)
 			ch.dispatch(ch.message) // termination state
 			ch.transition((*Channel).recvMethod)
@@ -454,6 +540,7 @@ func (ch *Channel) recvContent(f frame) {
 		ch.body = append(ch.body, frame.Body...)
 
 		if uint64(len(ch.body)) >= ch.header.Size {
+			ch.receivedAt = time.Now()
 			ch.message.setContent(ch.header.Properties, ch.body)
 			ch.dispatch(ch.message) // termination state
 			ch.transition((*Channel).recvMethod)
@@ -479,6 +566,11 @@ func (ch *Channel) Close() error {
 	}
 
 	defer ch.connection.closeChannel(ch, nil)
+
+	// The deliveries are dropped once the channel is closed, do not let a
+	// consumer blocked by ConsumeBacklogLimit hold back the reply.
+	ch.consumers.stop()
+
 	return ch.call(
 		&channelClose{ReplyCode: replySuccess},
 		&channelCloseOk{},
@@ -703,14 +795,27 @@ greater as described by benchmarks on RabbitMQ.
 http://www.rabbitmq.com/blog/2012/04/25/rabbitmq-performance-measurements-part-2/
 */
 func (ch *Channel) Qos(prefetchCount, prefetchSize int, global bool) error {
-	return ch.call(
-		&basicQos{
-			PrefetchCount: uint16(prefetchCount),
-			PrefetchSize:  uint32(prefetchSize),
-			Global:        global,
-		},
-		&basicQosOk{},
-	)
+	if !global {
+		return ch.setQos(prefetchCount, prefetchSize, global)
+	}
+
+	ch.flowM.Lock()
+	defer ch.flowM.Unlock()
+
+	qos := &basicQos{
+		PrefetchCount: uint16(prefetchCount),
+		PrefetchSize:  uint32(prefetchSize),
+		Global:        global,
+	}
+
+	// While paused with the prefetch fallback, ResumeDeliveries applies it.
+	if !ch.flow.fallback {
+		if err := ch.setQos(prefetchCount, prefetchSize, global); err != nil {
+			return err
+		}
+	}
+	ch.flow.global = qos
+	return nil
 }
 
 /*
@@ -732,6 +837,13 @@ require an acknowledgment, otherwise they will arrive and be dropped in the
 client without an ack, and will not be redelivered to other consumers.
 */
 func (ch *Channel) Cancel(consumer string, noWait bool) error {
+	if t := ch.connection.topology; t != nil && ch.IsClosed() {
+		// The consumer may deliver from the channel of a recovery.
+		if current := t.channelOf(ch, consumer); current != nil && current != ch {
+			return current.Cancel(consumer, noWait)
+		}
+	}
+
 	req := &basicCancel{
 		ConsumerTag: consumer,
 		NoWait:      noWait,
@@ -1124,13 +1236,20 @@ func (ch *Channel) Consume(queue, consumer string, autoAck, exclusive, noLocal,
 
 	deliveries := make(chan Delivery)
 
-	ch.consumers.add(consumer, deliveries)
+	if ch.acks != nil {
+		ch.acks.consume(consumer, autoAck)
+	}
+	ch.consumers.add(consumer, deliveries, overflow{})
 
 	if err := ch.call(req, res); err != nil {
 		ch.consumers.cancel(consumer)
 		return nil, err
 	}
+	ch.consumers.remember(consumer, req)
 
+	if t := ch.connection.topology; t != nil {
+		return t.consume(context.Background(), ch, req, deliveries, overflow{}), nil
+	}
 	return deliveries, nil
 }
 
@@ -1197,6 +1316,10 @@ be dropped. RabbitMQ will requeue messages not acknowledged. In other words, dro
 messages in this way won't be lost.
 */
 func (ch *Channel) ConsumeWithContext(ctx context.Context, queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table) (<-chan Delivery, error) {
+	return ch.consume(ctx, queue, consumer, autoAck, exclusive, noLocal, noWait, args, overflow{})
+}
+
+func (ch *Channel) consume(ctx context.Context, queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table, limit overflow) (<-chan Delivery, error) {
 	// When we return from ch.call, there may be a delivery already for the
 	// consumer that hasn't been added to the consumer hash yet.  Because of
 	// this, we never rely on the server picking a consumer tag for us.
@@ -1228,12 +1351,16 @@ func (ch *Channel) ConsumeWithContext(ctx context.Context, queue, consumer strin
 
 	deliveries := make(chan Delivery)
 
-	ch.consumers.add(consumer, deliveries)
+	if ch.acks != nil {
+		ch.acks.consume(consumer, autoAck)
+	}
+	ch.consumers.add(consumer, deliveries, limit)
 
 	if err := ch.call(req, res); err != nil {
 		ch.consumers.cancel(consumer)
 		return nil, err
 	}
+	ch.consumers.remember(consumer, req)
 
 	go func() {
 		select {
@@ -1246,6 +1373,9 @@ func (ch *Channel) ConsumeWithContext(ctx context.Context, queue, consumer strin
 		}
 	}()
 
+	if t := ch.connection.topology; t != nil {
+		return t.consume(ctx, ch, req, deliveries, limit), nil
+	}
 	return deliveries, nil
 }
 
@@ -1472,6 +1602,13 @@ This can return an error when the channel, connection or socket is closed.  The
 error or lack of an error does not indicate whether the server has received this
 publishing.
 
+Publishings are never held back to be coalesced with later ones: the frames of
+a message are buffered only until the whole message has been written, and are
+flushed to the transport before Publish returns.  For RPC-style workloads where
+the latency of a single small message matters most, Config.LowLatencyWrites
+bypasses the buffer altogether.  Go enables TCP_NODELAY on TCP connections by
+default, a custom Config.Dial should preserve it.
+
 It is possible for publishing to not reach the broker if the underlying socket
 is shut down without pending publishing packets being flushed from the kernel
 buffers.  The easy way of making it probable that all publishings reach the
@@ -1485,14 +1622,16 @@ When Publish does not return an error and the channel is in confirm mode, the
 internal counter for DeliveryTags with the first confirmation starts at 1.
 */
 func (ch *Channel) Publish(exchange, key string, mandatory, immediate bool, msg Publishing) error {
-	_, err := ch.PublishWithDeferredConfirm(exchange, key, mandatory, immediate, msg)
+	_, err := ch.publish(context.Background(), exchange, key, mandatory, immediate, msg)
 	return err
 }
 
 /*
 PublishWithContext sends a Publishing from the client to an exchange on the server.
 
-NOTE: this function is equivalent to [Channel.Publish]. Context is not honoured.
+NOTE: this function is equivalent to [Channel.Publish]. Context is only honoured
+while waiting on the limiters set with [Channel.SetPublishLimiter] and on
+[Config.WriteBufferLimit].
 
 When you want a single message to be delivered to a single queue, you can
 publish to the default exchange with the routingKey of the queue name.  This is
@@ -1523,8 +1662,9 @@ confirmations start at 1.  Exit when all publishings are confirmed.
 When Publish does not return an error and the channel is in confirm mode, the
 internal counter for DeliveryTags with the first confirmation starts at 1.
 */
-func (ch *Channel) PublishWithContext(_ context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error {
-	return ch.Publish(exchange, key, mandatory, immediate, msg)
+func (ch *Channel) PublishWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error {
+	_, err := ch.publish(ctx, exchange, key, mandatory, immediate, msg)
+	return err
 }
 
 /*
@@ -1534,16 +1674,58 @@ confirmation for this message. If the channel has not been put into confirm
 mode, the DeferredConfirmation will be nil.
 */
 func (ch *Channel) PublishWithDeferredConfirm(exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	return ch.publish(context.Background(), exchange, key, mandatory, immediate, msg)
+}
+
+func (ch *Channel) publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	return ch.publishTracked(ctx, exchange, key, mandatory, immediate, msg, nil)
+}
+
+// publishTracked publishes like publish, calling sent, when not nil, with the
+// delivery tag and the publishing as modified by the publish hooks right before
+// it is sent, so before its return or confirmation is dispatched.  The delivery
+// tag is zero when the channel is not in confirm mode.
+func (ch *Channel) publishTracked(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing, sent func(tag uint64, msg Publishing)) (*DeferredConfirmation, error) {
+	if err := ch.runPublishHooks(exchange, key, &msg); err != nil {
+		return nil, err
+	}
+
 	if err := msg.Headers.Validate(); err != nil {
 		return nil, err
 	}
 
+	if err := ch.waitPublishLimiter(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	written, err := ch.connection.writeBudget.reserve(ctx, len(msg.Body), ch.connection.close)
+	if err != nil {
+		return nil, err
+	}
+	defer written()
+
 	ch.m.Lock()
 	defer ch.m.Unlock()
 
 	var dc *DeferredConfirmation
 	if ch.confirming {
 		dc = ch.confirms.publish()
+		ch.confirms.keep(UnconfirmedPublishing{
+			DeliveryTag: dc.DeliveryTag,
+			Exchange:    exchange,
+			RoutingKey:  key,
+			Mandatory:   mandatory,
+			Immediate:   immediate,
+			Publishing:  msg,
+		})
+	}
+
+	if sent != nil {
+		var tag uint64
+		if dc != nil {
+			tag = dc.DeliveryTag
+		}
+		sent(tag, msg)
 	}
 
 	if err := ch.send(&basicPublish{
@@ -1571,6 +1753,7 @@ func (ch *Channel) PublishWithDeferredConfirm(exchange, key string, mandatory, i
 		if ch.confirming {
 			ch.confirms.unpublish()
 		}
+		ch.publishFailed()
 		return nil, err
 	}
 
@@ -1584,10 +1767,11 @@ for this message. If the channel has not been put into confirm mode,
 the DeferredConfirmation will be nil.
 
 NOTE: PublishWithDeferredConfirmWithContext is equivalent to its non-context variant. The context passed
-to this function is not honoured.
+to this function is only honoured while waiting on the limiters set with Channel.SetPublishLimiter
+and on Config.WriteBufferLimit.
 */
-func (ch *Channel) PublishWithDeferredConfirmWithContext(_ context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
-	return ch.PublishWithDeferredConfirm(exchange, key, mandatory, immediate, msg)
+func (ch *Channel) PublishWithDeferredConfirmWithContext(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	return ch.publish(ctx, exchange, key, mandatory, immediate, msg)
 }
 
 /*
@@ -1617,12 +1801,53 @@ func (ch *Channel) Get(queue string, autoAck bool) (msg Delivery, ok bool, err e
 	}
 
 	if res.DeliveryTag > 0 {
-		return *(newDelivery(ch, res)), true, nil
+		res.DeliveryTag = ch.deliveryTag(res.DeliveryTag)
+		if ch.acks != nil {
+			ch.acks.delivered(res.DeliveryTag, autoAck)
+		}
+		// The response was read by the connection goroutine right before.
+		return *(newDelivery(ch, res, time.Now())), true, nil
 	}
 
 	return Delivery{}, false, nil
 }
 
+// ErrEmptyQueue is returned by Channel.GetWithContext when the queue has no
+// message to deliver.
+var ErrEmptyQueue = errors.New("no message available in the queue")
+
+/*
+GetWithContext behaves like Get, but returns ErrEmptyQueue along with a false
+ok bool when there was no delivery waiting on the queue, so that polling code
+can handle the empty queue like any other error:
+
+	for {
+		msg, _, err := ch.GetWithContext(ctx, "jobs", false)
+		if errors.Is(err, amqp.ErrEmptyQueue) {
+			time.Sleep(time.Second)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		handle(msg)
+	}
+
+The context is only checked before the basic.get is sent: once sent, the
+response is awaited so that the channel stays usable.
+*/
+func (ch *Channel) GetWithContext(ctx context.Context, queue string, autoAck bool) (msg Delivery, ok bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return Delivery{}, false, err
+	}
+
+	msg, ok, err = ch.Get(queue, autoAck)
+	if err == nil && !ok {
+		err = ErrEmptyQueue
+	}
+	return msg, ok, err
+}
+
 /*
 Tx puts the channel into transaction mode on the server.  All publishings and
 acknowledgments following this method will be atomically committed or rolled
@@ -1682,6 +1907,10 @@ until called again with active as `true`.
 
 Channel.Get methods will not be affected by flow control.
 
+RabbitMQ does not implement pausing deliveries and closes the connection with
+a NOT_IMPLEMENTED error when active is `false`.  Channel.PauseDeliveries falls
+back to a prefetch limit on such servers.
+
 This method is not intended to act as window control.  Use Channel.Qos to limit
 the number of unacknowledged messages or bytes in flight instead.
 
@@ -1736,6 +1965,10 @@ func (ch *Channel) Confirm(noWait bool) error {
 	ch.confirming = true
 	ch.confirmM.Unlock()
 
+	if ch.connection.republishesUnconfirmed() {
+		ch.confirms.retain()
+	}
+
 	return nil
 }
 
@@ -1774,13 +2007,24 @@ is true.
 See also Delivery.Ack
 */
 func (ch *Channel) Ack(tag uint64, multiple bool) error {
-	ch.m.Lock()
-	defer ch.m.Unlock()
+	ch.checkSettle("ack", tag, multiple)
 
-	return ch.send(&basicAck{
+	tag, ok := ch.wireTag(tag)
+	if !ok {
+		return ErrStaleDeliveryTag
+	}
+	settle := &basicAck{
 		DeliveryTag: tag,
 		Multiple:    multiple,
-	})
+	}
+	if ch.holdSettle(settle) {
+		return nil
+	}
+
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	return ch.send(settle)
 }
 
 /*
@@ -1791,14 +2035,25 @@ it must be redelivered or dropped.
 See also Delivery.Nack
 */
 func (ch *Channel) Nack(tag uint64, multiple, requeue bool) error {
-	ch.m.Lock()
-	defer ch.m.Unlock()
+	ch.checkSettle("nack", tag, multiple)
 
-	return ch.send(&basicNack{
+	tag, ok := ch.wireTag(tag)
+	if !ok {
+		return ErrStaleDeliveryTag
+	}
+	settle := &basicNack{
 		DeliveryTag: tag,
 		Multiple:    multiple,
 		Requeue:     requeue,
-	})
+	}
+	if ch.holdSettle(settle) {
+		return nil
+	}
+
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	return ch.send(settle)
 }
 
 /*
@@ -1809,13 +2064,24 @@ multiple messages, reducing the amount of protocol messages to exchange.
 See also Delivery.Reject
 */
 func (ch *Channel) Reject(tag uint64, requeue bool) error {
-	ch.m.Lock()
-	defer ch.m.Unlock()
+	ch.checkSettle("reject", tag, false)
 
-	return ch.send(&basicReject{
+	tag, ok := ch.wireTag(tag)
+	if !ok {
+		return ErrStaleDeliveryTag
+	}
+	settle := &basicReject{
 		DeliveryTag: tag,
 		Requeue:     requeue,
-	})
+	}
+	if ch.holdSettle(settle) {
+		return nil
+	}
+
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	return ch.send(settle)
 }
 
 // GetNextPublishSeqNo returns the sequence number of the next message to be
@@ -1826,3 +2092,23 @@ func (ch *Channel) GetNextPublishSeqNo() uint64 {
 
 	return ch.confirms.published + 1
 }
+
+// OutstandingConfirms returns the number of messages published in confirm mode
+// and not confirmed yet.
+func (ch *Channel) OutstandingConfirms() int {
+	return ch.confirms.deferredConfirmations.len()
+}
+
+// LastPublishError returns when a publishing on this channel last failed,
+// either because it was nacked, returned or could not be sent, or the zero
+// time when none did.
+func (ch *Channel) LastPublishError() time.Time {
+	if at := atomic.LoadInt64(&ch.lastPublishError); at != 0 {
+		return time.Unix(0, at)
+	}
+	return time.Time{}
+}
+
+func (ch *Channel) publishFailed() {
+	atomic.StoreInt64(&ch.lastPublishError, time.Now().UnixNano())
+}
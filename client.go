@@ -0,0 +1,254 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// Config is used to dial the connection, and to dial it again after it
+	// was lost.
+	Config Config
+
+	// Topology is declared on every new connection, before publishers and
+	// consumers use it.
+	Topology Topology
+
+	// BaseDelay and MaxDelay bound the exponential backoff between
+	// reconnection attempts, 100ms and 10s when zero.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+/*
+Client is a connection that recovers from failures, together with the
+publishers and consumers using it.  It is meant as a safe default for
+applications that do not need the control given by Connection and Channel.
+
+When the connection is closed by an error, the Client dials it again with an
+exponential backoff and declares its topology again.  Publishers and Consumers
+created by the Client open their channels from it, so they resume on the new
+connection.  Close shuts everything down in order.
+
+	client, err := amqp.NewClient(url, amqp.ClientOptions{Topology: topology})
+	if err != nil {
+		return err
+	}
+	defer client.Close(ctx)
+
+	publisher := client.NewPublisher(amqp.PublisherOptions{Mandatory: true})
+	err = publisher.Publish(ctx, "events", "user.created", msg)
+
+A Client is safe for concurrent use.
+*/
+type Client struct {
+	url  string
+	opts ClientOptions
+	dial func(url string, config Config) (*Connection, error)
+
+	m          sync.Mutex
+	conn       *Connection
+	topologies []Topology
+	publishers []*Publisher
+	consumers  []*Consumer
+	closed     bool
+	done       chan struct{}
+}
+
+// NewClient dials url and declares opts.Topology.
+func NewClient(url string, opts ClientOptions) (*Client, error) {
+	return newClient(url, opts, DialConfig)
+}
+
+func newClient(url string, opts ClientOptions, dial func(string, Config) (*Connection, error)) (*Client, error) {
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 10 * time.Second
+	}
+
+	c := &Client{
+		url:        url,
+		opts:       opts,
+		dial:       dial,
+		topologies: []Topology{opts.Topology},
+		done:       make(chan struct{}),
+	}
+
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+
+	c.conn = conn
+	c.watch(conn)
+	return c, nil
+}
+
+// connect dials a new connection and declares the topologies on it.
+func (c *Client) connect() (*Connection, error) {
+	conn, err := c.dial(c.url, c.opts.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	topologies := append([]Topology(nil), c.topologies...)
+	c.m.Unlock()
+
+	for _, t := range topologies {
+		if len(t.Exchanges) == 0 && len(t.Queues) == 0 {
+			continue
+		}
+		if err := t.Apply(context.Background(), conn, ApplyOptions{}); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// watch reconnects when conn is closed by an error.
+func (c *Client) watch(conn *Connection) {
+	conn.OnClose(func(err *Error) {
+		if err != nil {
+			c.reconnect(err)
+		}
+	})
+}
+
+func (c *Client) reconnect(cause *Error) {
+	delays := newBackoff(c.opts.BaseDelay, c.opts.MaxDelay)
+
+	for {
+		delay := delays.next()
+		Logger.Printf("client connection lost, reconnecting in %s: %v", delay, cause)
+
+		select {
+		case <-c.done:
+			return
+		case <-time.After(delay):
+		}
+
+		conn, err := c.connect()
+		if err != nil {
+			Logger.Printf("client reconnection failed: %v", err)
+			continue
+		}
+
+		c.m.Lock()
+		if c.closed {
+			c.m.Unlock()
+			_ = conn.Close()
+			return
+		}
+		c.conn = conn
+		c.m.Unlock()
+
+		c.watch(conn)
+		return
+	}
+}
+
+// Connection returns the current connection of the client, which is replaced
+// after a reconnection.
+func (c *Client) Connection() *Connection {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.conn
+}
+
+// Channel opens a channel on the current connection.  It fails while the
+// client is reconnecting.
+func (c *Client) Channel() (*Channel, error) {
+	c.m.Lock()
+	conn, closed := c.conn, c.closed
+	c.m.Unlock()
+
+	if closed {
+		return nil, ErrClosed
+	}
+	return conn.Channel()
+}
+
+// DeclareTopology declares t on the current connection, and again on every
+// new connection.
+func (c *Client) DeclareTopology(ctx context.Context, t Topology) error {
+	c.m.Lock()
+	c.topologies = append(c.topologies, t)
+	conn := c.conn
+	c.m.Unlock()
+
+	return t.Apply(ctx, conn, ApplyOptions{})
+}
+
+// NewPublisher returns a Publisher using the client.  It is closed by Close.
+func (c *Client) NewPublisher(opts PublisherOptions) *Publisher {
+	p := NewPublisher(c, opts)
+
+	c.m.Lock()
+	c.publishers = append(c.publishers, p)
+	c.m.Unlock()
+
+	return p
+}
+
+// NewConsumer starts a Consumer using the client.  It is stopped by Close.
+func (c *Client) NewConsumer(opts ConsumerOptions) (*Consumer, error) {
+	consumer := NewConsumer(c, opts)
+	if err := consumer.Start(); err != nil {
+		return nil, err
+	}
+
+	c.m.Lock()
+	c.consumers = append(c.consumers, consumer)
+	c.m.Unlock()
+
+	return consumer, nil
+}
+
+/*
+Close stops the consumers, letting their running handlers return, closes the
+publishers and finally the connection.  When ctx is done before the consumers
+have stopped, their channels are closed right away.  The errors met along the
+way are joined.
+*/
+func (c *Client) Close(ctx context.Context) error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	consumers, publishers, conn := c.consumers, c.publishers, c.conn
+	c.m.Unlock()
+
+	var errs []error
+	for _, consumer := range consumers {
+		if err := consumer.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, p := range publishers {
+		if err := p.Close(); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := conn.Close(); err != nil && !errors.Is(err, ErrClosed) {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
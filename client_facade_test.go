@@ -0,0 +1,79 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestClientReconnectsAfterConnectionError(t *testing.T) {
+	serve := []func(srv *server){
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.send(0, &connectionClose{ReplyCode: ConnectionForced, ReplyText: "restarting"})
+			srv.recv(0, &connectionCloseOk{})
+		},
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+			srv.connectionClose()
+		},
+	}
+
+	dialed := make(chan *Connection, len(serve))
+	dials := 0
+	dial := func(string, Config) (*Connection, error) {
+		rwc, srv := newSession(t)
+		t.Cleanup(func() { rwc.Close() })
+
+		go serve[dials](srv)
+		dials++
+
+		c, err := Open(rwc, defaultConfig())
+		if err == nil {
+			dialed <- c
+		}
+		return c, err
+	}
+
+	client, err := newClient("amqp://localhost", ClientOptions{BaseDelay: time.Millisecond}, dial)
+	if err != nil {
+		t.Fatalf("could not create client: %v", err)
+	}
+
+	first := <-dialed
+	var second *Connection
+	select {
+	case second = <-dialed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the client to reconnect")
+	}
+
+	if !first.IsClosed() {
+		t.Fatalf("expected the first connection to be closed")
+	}
+
+	// the connection is swapped right after dialing, wait for it
+	deadline := time.Now().Add(time.Second)
+	for client.Connection() != second && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := client.Channel(); err != nil {
+		t.Fatalf("could not open channel on the new connection: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := client.Close(ctx); err != nil {
+		t.Fatalf("could not close client: %v", err)
+	}
+	if _, err := client.Channel(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from a closed client, got: %v", err)
+	}
+}
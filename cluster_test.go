@@ -0,0 +1,92 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDialClusterFailsOverToNextEndpoint(t *testing.T) {
+	var m sync.Mutex
+	var dialed []string
+	served := make(chan struct{})
+
+	dial := func(network, addr string) (net.Conn, error) {
+		m.Lock()
+		dialed = append(dialed, addr)
+		m.Unlock()
+
+		if addr != "rabbit-2:5672" {
+			return nil, errors.New("connection refused")
+		}
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+		srv := newServer(t, server, client)
+		go func() {
+			defer close(served)
+			srv.connectionOpen()
+			srv.connectionClose()
+		}()
+		return client, nil
+	}
+
+	c, err := DialCluster([]string{"amqp://rabbit-1", "amqp://rabbit-2", "amqp://rabbit-3"}, Config{Dial: dial})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+
+	if want := []string{"rabbit-1:5672", "rabbit-2:5672"}; !reflect.DeepEqual(dialed, want) {
+		t.Fatalf("expected the endpoints to be tried in order up to the first success, got %v", dialed)
+	}
+}
+
+func TestDialClusterRetriesRoundsAndJoinsErrors(t *testing.T) {
+	var dialed int
+	dial := func(network, addr string) (net.Conn, error) {
+		dialed++
+		return nil, errors.New("connection refused by " + addr)
+	}
+
+	_, err := DialCluster([]string{"amqp://rabbit-1", "amqp://rabbit-2"}, Config{
+		Dial:      dial,
+		DialRetry: DialRetry{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	if err == nil || !strings.Contains(err.Error(), "rabbit-1") || !strings.Contains(err.Error(), "rabbit-2") {
+		t.Fatalf("expected the errors of both endpoints, got %v", err)
+	}
+	if dialed != 4 {
+		t.Fatalf("expected two rounds over both endpoints, got %d dials", dialed)
+	}
+
+	if _, err := DialCluster(nil, Config{}); err == nil {
+		t.Fatalf("expected an error without endpoints")
+	}
+}
+
+func TestEndpointStrategies(t *testing.T) {
+	endpoints := []string{"a", "b", "c"}
+
+	rr := RoundRobinEndpoints()
+	for _, want := range [][]string{{"a", "b", "c"}, {"b", "c", "a"}, {"c", "a", "b"}, {"a", "b", "c"}} {
+		if got := rr(endpoints); !reflect.DeepEqual(got, want) {
+			t.Fatalf("expected round-robin order %v, got %v", want, got)
+		}
+	}
+
+	shuffled := RandomEndpoints(endpoints)
+	if len(shuffled) != 3 || !reflect.DeepEqual(endpoints, []string{"a", "b", "c"}) {
+		t.Fatalf("expected a shuffled copy leaving the endpoints alone, got %v and %v", shuffled, endpoints)
+	}
+}
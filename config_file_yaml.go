@@ -0,0 +1,28 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build amqp_yaml && !amqp_lean
+// +build amqp_yaml,!amqp_lean
+
+package amqp091
+
+import (
+	"encoding/json"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	configFromYAML = yamlToJSON
+}
+
+// yamlToJSON converts a YAML document to JSON, so that YAML configuration
+// files go through the decoding of JSON ones, rejecting unknown fields.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
@@ -7,6 +7,7 @@ package amqp091
 
 import (
 	"context"
+	"sort"
 	"sync"
 )
 
@@ -19,6 +20,12 @@ type confirms struct {
 	published             uint64
 	publishedMut          sync.Mutex
 	expecting             uint64
+
+	// Copies of the publishings awaiting confirmation, only allocated when
+	// the channel retains unconfirmed publishings.
+	retainM  sync.Mutex
+	retained map[uint64]UnconfirmedPublishing
+	stranded []UnconfirmedPublishing // copies of a channel reopened that could not be published again
 }
 
 // newConfirms allocates a confirms
@@ -53,6 +60,7 @@ func (c *confirms) unpublish() {
 	c.publishedMut.Lock()
 	defer c.publishedMut.Unlock()
 	c.deferredConfirmations.remove(c.published)
+	c.release(c.published, false)
 	c.published--
 }
 
@@ -86,6 +94,7 @@ func (c *confirms) One(confirmed Confirmation) {
 	defer c.m.Unlock()
 
 	c.deferredConfirmations.Confirm(confirmed)
+	c.release(confirmed.DeliveryTag, false)
 
 	if c.expecting == confirmed.DeliveryTag {
 		c.confirm(confirmed)
@@ -101,6 +110,7 @@ func (c *confirms) Multiple(confirmed Confirmation) {
 	defer c.m.Unlock()
 
 	c.deferredConfirmations.ConfirmMultiple(confirmed)
+	c.release(confirmed.DeliveryTag, true)
 
 	for c.expecting <= confirmed.DeliveryTag {
 		c.confirm(Confirmation{c.expecting, confirmed.Ack})
@@ -108,6 +118,100 @@ func (c *confirms) Multiple(confirmed Confirmation) {
 	c.resequence()
 }
 
+// retain starts keeping copies of the publishings until they are confirmed.
+func (c *confirms) retain() {
+	c.retainM.Lock()
+	defer c.retainM.Unlock()
+
+	if c.retained == nil {
+		c.retained = map[uint64]UnconfirmedPublishing{}
+	}
+}
+
+// keep stores a copy of the publishing with the given delivery tag when
+// retaining.
+func (c *confirms) keep(p UnconfirmedPublishing) {
+	c.retainM.Lock()
+	defer c.retainM.Unlock()
+
+	if c.retained == nil {
+		return
+	}
+
+	p.Body = append([]byte(nil), p.Body...)
+	if p.Headers != nil {
+		headers := make(Table, len(p.Headers))
+		for k, v := range p.Headers {
+			headers[k] = v
+		}
+		p.Headers = headers
+	}
+
+	c.retained[p.DeliveryTag] = p
+}
+
+// release drops the retained copy of the publishing with the given delivery
+// tag, or of all publishings up until the delivery tag when multiple is true.
+func (c *confirms) release(tag uint64, multiple bool) {
+	c.retainM.Lock()
+	defer c.retainM.Unlock()
+
+	if !multiple {
+		delete(c.retained, tag)
+		return
+	}
+
+	for k := range c.retained {
+		if k <= tag {
+			delete(c.retained, k)
+		}
+	}
+}
+
+// unconfirmed returns the retained publishings, the stranded ones first, then
+// the others ordered by delivery tag.
+func (c *confirms) unconfirmed() []UnconfirmedPublishing {
+	c.retainM.Lock()
+	defer c.retainM.Unlock()
+
+	return c.pending()
+}
+
+// takeUnconfirmed returns the retained publishings like unconfirmed, and
+// stops retaining them.
+func (c *confirms) takeUnconfirmed() []UnconfirmedPublishing {
+	c.retainM.Lock()
+	defer c.retainM.Unlock()
+
+	pending := c.pending()
+	if c.retained != nil {
+		c.retained = map[uint64]UnconfirmedPublishing{}
+	}
+	c.stranded = nil
+	return pending
+}
+
+// strand keeps the copies of publishings that could not be published again
+// after the channel was reopened, until the next reopen.
+func (c *confirms) strand(pending []UnconfirmedPublishing) {
+	c.retainM.Lock()
+	defer c.retainM.Unlock()
+
+	c.stranded = append(c.stranded, pending...)
+}
+
+func (c *confirms) pending() []UnconfirmedPublishing {
+	retained := make([]UnconfirmedPublishing, 0, len(c.retained))
+	for _, p := range c.retained {
+		retained = append(retained, p)
+	}
+	sort.Slice(retained, func(i, j int) bool {
+		return retained[i].DeliveryTag < retained[j].DeliveryTag
+	})
+
+	return append(append([]UnconfirmedPublishing(nil), c.stranded...), retained...)
+}
+
 // Cleans up the confirms struct and its dependencies.
 // Closes all listeners, discarding any out of sequence confirmations
 func (c *confirms) Close() error {
@@ -144,6 +248,13 @@ func (d *deferredConfirmations) Add(tag uint64) *DeferredConfirmation {
 	return dc
 }
 
+func (d *deferredConfirmations) len() int {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	return len(d.confirmations)
+}
+
 // remove is only used to drop a tag whose publish failed
 func (d *deferredConfirmations) remove(tag uint64) {
 	d.m.Lock()
@@ -236,3 +347,18 @@ func (d *DeferredConfirmation) WaitContext(ctx context.Context) (bool, error) {
 	}
 	return d.ack, nil
 }
+
+// confirmed waits for the confirmation of a publishing.
+func confirmed(ctx context.Context, confirm *DeferredConfirmation, err error) error {
+	if err != nil {
+		return err
+	}
+	ack, err := confirm.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+	if !ack {
+		return ErrNacked
+	}
+	return nil
+}
@@ -7,14 +7,16 @@ package amqp091
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"os"
-	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,11 +27,13 @@ import (
 const (
 	maxChannelMax = (2 << 15) - 1
 
-	defaultHeartbeat         = 10 * time.Second
-	defaultConnectionTimeout = 30 * time.Second
-	defaultProduct           = "AMQP 0.9.1 Client"
-	buildVersion             = "1.10.0"
-	platform                 = "golang"
+	defaultHeartbeat          = 10 * time.Second
+	defaultConnectionTimeout  = 30 * time.Second
+	defaultDialRetryBaseDelay = 100 * time.Millisecond
+	defaultDialRetryMaxDelay  = 10 * time.Second
+	defaultProduct            = "AMQP 0.9.1 Client"
+	buildVersion              = "1.10.0"
+	platform                  = "golang"
 	// Safer default that makes channel leaks a lot easier to spot
 	// before they create operational headaches. See https://github.com/rabbitmq/rabbitmq-server/issues/1593.
 	defaultChannelMax = uint16((2 << 10) - 1)
@@ -42,7 +46,8 @@ const (
 type Config struct {
 	// The SASL mechanisms to try in the client request, and the successful
 	// mechanism used on the Connection object.
-	// If SASL is nil, PlainAuth from the URL is used.
+	// If SASL is nil, PlainAuth from the URL is used, or AMQPlainAuth when
+	// the server does not offer PLAIN.
 	SASL []Authentication
 
 	// Vhost specifies the namespace of permissions, exchanges, queues and
@@ -59,23 +64,229 @@ type Config struct {
 	// ServerName from the URL is used.
 	TLSClientConfig *tls.Config
 
+	// GetTLSConfig, when set, returns the client configuration of the TLS
+	// connection instead of TLSClientConfig.  It is called on every dial and
+	// every redial of RecoveryPolicy, so that rotated certificates and CA
+	// bundles are picked up by new connections without restarting the
+	// process.  The returned tls.Config is cloned before the ServerName from
+	// the URL is set on it; nil uses the TLS parameters of the URL.
+	GetTLSConfig func() (*tls.Config, error)
+
+	// TLSPolicy, when set, enforces a minimum TLS version and cipher suites,
+	// and optionally refuses to send credentials without TLS.  See
+	// TLSPolicy.
+	TLSPolicy *TLSPolicy
+
+	// VerifyPeer, when set, is called by DialConfig with the state of the TLS
+	// connection once the certificate chain of the server was verified, and
+	// fails the handshake when it returns an error.  It runs after the
+	// VerifyConnection of TLSClientConfig, if any.  See PinSPKI and
+	// AllowSANs.
+	VerifyPeer func(tls.ConnectionState) error
+
 	// Properties is table of properties that the client advertises to the server.
 	// This is an optional setting - if the application does not set this,
 	// the underlying library will use a generic set of client properties.
 	Properties Table
 
-	// Connection locale that we expect to always be en_US
-	// Even though servers must return it as per the AMQP 0-9-1 spec,
-	// we are not aware of it being used other than to satisfy the spec requirements
+	// Connection locale sent to the server in connection.start-ok.  When
+	// set, the locale must be one of the locales offered by the server,
+	// otherwise the connection fails with ErrLocale.  When empty, en_US is
+	// used if the server offers it, the first locale offered by the server
+	// otherwise.  RabbitMQ only offers en_US.
 	Locale string
 
+	// WriteTimeout bounds the time spent writing each frame to the transport.
+	// When the server stops reading and a write does not complete in time,
+	// the write fails and the connection is closed with a FrameError, instead
+	// of blocking publishers forever.  It sets the write deadline of
+	// transports with a SetWriteDeadline method, like net.Conn, and closes
+	// other transports, like an io.ReadWriteCloser given to Open, when a
+	// write does not complete in time.  Zero means no timeout.
+	WriteTimeout time.Duration
+
+	// LowLatencyWrites writes every frame and every publishing to the
+	// transport on its own, bypassing the buffer of the connection: the
+	// frames of a publishing are written at once, in a single system call
+	// whatever their size, and frames sent in a row, like the requests of
+	// Channel.QueueBindAll, are not coalesced.  It suits RPC-style workloads
+	// where the tail latency of a single small message matters more than
+	// throughput.  By default, frames go through a 4 KiB buffer flushed at
+	// the end of every publishing.
+	LowLatencyWrites bool
+
+	// WriteBufferLimit caps the bytes of the message bodies being published
+	// on the connection and not yet written to the transport.  When the
+	// server applies TCP backpressure, publishings beyond the limit wait for
+	// the earlier ones to be written, until the context of the publishing is
+	// done, instead of piling up in memory.  Zero means unlimited.
+	WriteBufferLimit int
+
+	// WriteBufferFailFast makes publishings fail with ErrWriteBufferFull
+	// instead of waiting when WriteBufferLimit is reached.
+	WriteBufferFailFast bool
+
+	// ReadTimeout closes the connection with a FrameError when no frame has
+	// been received from the server for that long.  When set, it replaces the
+	// read deadline derived from the negotiated heartbeat interval, which
+	// allows detecting dead connections with heartbeats disabled.  It only has
+	// an effect when the transport has a SetReadDeadline method, like
+	// net.Conn.  Zero means that the heartbeat interval is used.
+	ReadTimeout time.Duration
+
+	// DialRetry makes DialConfig retry failed connection attempts, so that
+	// the initial connection tolerates a broker that is restarting.  The zero
+	// value makes a single attempt.
+	DialRetry DialRetry
+
+	// EndpointStrategy picks the order in which DialCluster tries the
+	// endpoints of a cluster, see OrderedEndpoints, RandomEndpoints and
+	// RoundRobinEndpoints.  The endpoints are tried in the order they are
+	// listed when nil.
+	EndpointStrategy EndpointStrategy
+
+	// RecoveryPolicy, when not nil, makes a connection dialed with DialConfig
+	// recover from network failures and from closes initiated by the server,
+	// by dialing again and running the handshake on the same Connection.  See
+	// RecoveryPolicy and Connection.NotifyReconnect.
+	RecoveryPolicy *RecoveryPolicy
+
+	// CredentialsProvider, when set, supplies the username and password of a
+	// connection dialed with DialConfig, with the PLAIN mechanism, instead of
+	// the URL and SASL.  It is called on every dial and recovery, so that
+	// credentials rotated by a secret store, like Vault or AWS Secrets
+	// Manager, are fetched fresh rather than baked into the URL.  Before
+	// credentials with an Expiry expire, it is called again and their
+	// password is passed to UpdateSecret, so that OAuth 2 access tokens are
+	// renewed without manual refreshing.  A new username is only used by the
+	// next recovery.  See CredentialsRefresh.
+	CredentialsProvider CredentialsProvider
+
+	// CredentialsRefresh configures the refresh of the credentials of
+	// CredentialsProvider.
+	CredentialsRefresh CredentialsRefresh
+
+	// ChannelLeakTimeout enables channel leak detection when greater than
+	// zero.  The stack of every Connection.Channel call is recorded and
+	// channels that stay open without sending or receiving a frame for longer
+	// than ChannelLeakTimeout are reported, once until they are used again.
+	// A Connection references its channels until they are closed, so a
+	// channel dropped by the application is never garbage collected and
+	// idleness is the only reliable sign of a leak.  This has a cost on every
+	// channel open and is meant for debugging.
+	ChannelLeakTimeout time.Duration
+
+	// ChannelLeakReport is called with the channels found by leak detection.
+	// When nil, leaks are logged with Logger.
+	ChannelLeakReport func(ChannelLeak)
+
+	// AckDiagnostics enables the tracking of the deliveries awaiting
+	// acknowledgement on every channel when not nil.  It is called with the
+	// acknowledgements, nacks and rejects that the server will refuse with a
+	// PRECONDITION_FAILED channel exception, right before they are sent, to
+	// find the code settling a delivery twice or with the wrong channel.  It
+	// runs on the goroutine settling the delivery and must not block.  This
+	// has a cost on every delivery and is meant for debugging.
+	AckDiagnostics func(AckAnomaly)
+
+	// TrackDeliveries records the deliveries awaiting acknowledgement on every
+	// channel, so that Channel.RecoverWithContext counts the deliveries
+	// redelivered by the server.  It is implied by AckDiagnostics.  This has a
+	// cost on every delivery.
+	TrackDeliveries bool
+
+	// UnknownMethod is called with the method frames whose class and method
+	// ids are not implemented by this library, for experimenting with broker
+	// specific protocol extensions.  It runs on the goroutine reading from the
+	// connection, so it must not block nor call methods waiting for a
+	// response.  When it returns an error, the connection is closed with that
+	// error.  When nil, unknown methods close the connection with a
+	// FrameError.  Unknown methods are assumed to carry no content.
+	UnknownMethod func(UnknownMethod) error
+
+	// ChannelAllocation selects how the ids of new channels are picked.  The
+	// zero value hands ids out in increasing order, wrapping around at
+	// ChannelMax.
+	ChannelAllocation ChannelAllocation
+
+	// LocalAddr is the local address the TCP connection is bound to, for
+	// example to choose the interface of a multi-homed host.  The port is
+	// usually left to zero so that the system picks one.  It is ignored when
+	// Dial is set.
+	LocalAddr net.Addr
+
+	// TCPKeepAlive configures the keepalive probes of the TCP connection, so
+	// that a connection silently dropped by a NAT or a load balancer is
+	// detected by the kernel even while no heartbeat is due.  The interval
+	// and count of the probes are only applied on Linux.  It is ignored when
+	// Dial is set.
+	TCPKeepAlive TCPKeepAlive
+
+	// TCPUserTimeout, when greater than zero, sets TCP_USER_TIMEOUT on the TCP
+	// connection: the connection is dropped by the kernel when the data it
+	// sent stays unacknowledged for that long.  It is only applied on Linux,
+	// and ignored when Dial is set.
+	TCPUserTimeout time.Duration
+
+	// NoDelay, when not nil, sets TCP_NODELAY on the TCP connection.  Go
+	// disables Nagle's algorithm by default, which suits latency-sensitive
+	// workloads like RPC.  Setting it to false enables Nagle's algorithm to
+	// coalesce small writes, for the throughput of batch publishing.  It is
+	// ignored when Dial is set.
+	NoDelay *bool
+
+	// Resolver looks up the host of the URL, instead of the default resolver
+	// of the net package.  Hostnames are resolved again on every dial, so
+	// reconnections follow DNS changes.  It is ignored when Dial is set,
+	// except for the SRV records of amqp+srv:// URLs.
+	Resolver *net.Resolver
+
+	// Proxy returns the URL of the proxy to tunnel the connection to the
+	// broker at addr through, or nil to connect directly.  HTTP and HTTPS
+	// proxies are supported with CONNECT requests, and SOCKS5 proxies with
+	// the socks5:// or socks5h:// schemes, authenticated with the user info
+	// of the URL.  The TLS handshake of amqps connections happens
+	// with the broker, through the tunnel.  The proxy is reached with Dial
+	// when set.  Unix sockets are never proxied.  See ProxyFromEnvironment and
+	// ProxyURL.
+	Proxy func(addr string) (*url.URL, error)
+
 	// Dial returns a net.Conn prepared for a TLS handshake with TSLClientConfig,
 	// then an AMQP connection handshake.
 	// If Dial is nil, net.DialTimeout with a 30s connection and 30s deadline is
-	// used during TLS and AMQP handshaking.
+	// used during TLS and AMQP handshaking.  The network is "unix" and addr
+	// the path of the socket for amqp+unix URLs, "tcp" otherwise.
 	Dial func(network, addr string) (net.Conn, error)
 }
 
+// DialRetry configures how DialConfig retries failed connection attempts.
+// Failed attempts are retried after an exponentially growing delay, unless the
+// error cannot be fixed by retrying, like rejected credentials.
+type DialRetry struct {
+	// MaxAttempts is the total number of connection attempts, including the
+	// first one.  Values lower than 2 disable retries.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, 100ms when zero.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts, 10s when zero.
+	MaxDelay time.Duration
+}
+
+// ChannelAllocation selects how Connection.Channel picks the id of a new
+// channel, see Config.ChannelAllocation.
+type ChannelAllocation int
+
+const (
+	// AllocateRoundRobin hands out ids in increasing order and wraps around at
+	// the negotiated channel max, so a released id is reused as late as
+	// possible.  Server logs then rarely mix up two channels sharing an id.
+	AllocateRoundRobin ChannelAllocation = iota
+
+	// AllocateLowest hands out the lowest free id, so ids stay small and are
+	// reused right away when channels are opened and closed repeatedly.
+	AllocateLowest
+)
+
 // NewConnectionProperties creates an amqp.Table to be used as amqp.Config.Properties.
 //
 // Defaults to library-defined values. For empty properties, use make(amqp.Table) instead.
@@ -97,7 +308,15 @@ type Connection struct {
 	sendM      sync.Mutex // conn writer mutex
 	m          sync.Mutex // struct field mutex
 
-	conn io.ReadWriteCloser
+	conn          io.ReadWriteCloser
+	writeTimeout  time.Duration             // per frame write deadline, see Config.WriteTimeout
+	watchdog      writeWatchdog             // write timeout of transports without deadlines
+	readTimeout   time.Duration             // idle read deadline, see Config.ReadTimeout
+	leakTimeout   time.Duration             // see Config.ChannelLeakTimeout
+	leakReport    func(ChannelLeak)         // see Config.ChannelLeakReport
+	ackReport     func(AckAnomaly)          // see Config.AckDiagnostics
+	unknownMethod func(UnknownMethod) error // see Config.UnknownMethod
+	writeBudget   *writeBudget              // see Config.WriteBufferLimit
 
 	rpc       chan message
 	writer    *writer
@@ -107,9 +326,19 @@ type Connection struct {
 	allocator *allocator // id generator valid after openTune
 	channels  map[uint16]*Channel
 
-	noNotify bool // true when we will never notify again
-	closes   []chan *Error
-	blocks   []chan Blocking
+	noNotify   bool // true when we will never notify again
+	closes     []chan *Error
+	blocks     []chan Blocking
+	reconnects []chan struct{}
+	onClose    closeCallbacks
+
+	recovery    *recovery             // see Config.RecoveryPolicy
+	topology    *topologyRecorder     // see RecoveryPolicy.Topology
+	credentials *credentialsRefresher // see Config.CredentialsProvider
+	generation  uint64                // transports opened after the first one, accessed atomically
+	readerDone  chan struct{}         // closed when the reader of the transport exits
+	reopening   int32                 // 1 while a recovery runs the handshake, accessed atomically
+	closing     int32                 // 1 once closed by the application, accessed atomically
 
 	errors chan *Error
 	// if connection is closed should close this chan
@@ -129,10 +358,19 @@ type readDeadliner interface {
 	SetReadDeadline(time.Time) error
 }
 
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
 // DefaultDial establishes a connection when config.Dial is not provided
 func DefaultDial(connectionTimeout time.Duration) func(network, addr string) (net.Conn, error) {
+	return defaultDial(context.Background(), connectionTimeout, &net.Dialer{Timeout: connectionTimeout})
+}
+
+// defaultDial is DefaultDial connecting with dialer.
+func defaultDial(ctx context.Context, connectionTimeout time.Duration, dialer *net.Dialer) func(network, addr string) (net.Conn, error) {
 	return func(network, addr string) (net.Conn, error) {
-		conn, err := net.DialTimeout(network, addr, connectionTimeout)
+		conn, err := dialer.DialContext(ctx, network, addr)
 		if err != nil {
 			return nil, err
 		}
@@ -156,9 +394,7 @@ func DefaultDial(connectionTimeout time.Duration) func(network, addr string) (ne
 // Dial uses the zero value of tls.Config when it encounters an amqps://
 // scheme.  It is equivalent to calling DialTLS(amqp, nil).
 func Dial(url string) (*Connection, error) {
-	return DialConfig(url, Config{
-		Locale: defaultLocale,
-	})
+	return DialConfig(url, Config{})
 }
 
 // DialTLS accepts a string in the AMQP URI format and returns a new Connection
@@ -166,10 +402,11 @@ func Dial(url string) (*Connection, error) {
 // seconds and sets the initial read deadline to 30 seconds.
 //
 // DialTLS uses the provided tls.Config when encountering an amqps:// scheme.
+// To reach the broker through a proxy, use DialConfig with TLSClientConfig
+// and Proxy: the TLS handshake happens with the broker, through the tunnel.
 func DialTLS(url string, amqps *tls.Config) (*Connection, error) {
 	return DialConfig(url, Config{
 		TLSClientConfig: amqps,
-		Locale:          defaultLocale,
 	})
 }
 
@@ -196,30 +433,63 @@ func DialTLS_ExternalAuth(url string, amqps *tls.Config) (*Connection, error) {
 // over the value specified in the config. To disable heartbeats, you must use
 // the AMQP URI and set heartbeat=0 there.
 func DialConfig(url string, config Config) (*Connection, error) {
-	var err error
-	var conn net.Conn
+	return DialConfigWithContext(context.Background(), url, config)
+}
+
+// DialContext is like Dial, but the connection attempt, including the TLS and
+// AMQP handshakes, is abandoned when ctx is done, returning ctx.Err().
+func DialContext(ctx context.Context, url string) (*Connection, error) {
+	return DialConfigWithContext(ctx, url, Config{})
+}
+
+/*
+DialConfigWithContext is like DialConfig, but the whole connection attempt
+respects the cancellation and the deadline of ctx: the TCP connection, the TLS
+handshake, the AMQP handshake including the SASL authentication, and the
+delays between the attempts of Config.DialRetry.  When ctx is done first, the
+attempt is abandoned and ctx.Err() is returned.
+
+A Config.Dial function cannot be interrupted, the connection it returns is
+closed when ctx is done by then.  Once the connection is returned, ctx has no
+effect on it, nor on its recoveries with Config.RecoveryPolicy.
+
+The amqp+srv:// and amqps+srv:// schemes discover the brokers with the DNS SRV
+records of the host of the URL, which must not have a port: the brokers listed
+by the _amqp._tcp, respectively _amqps._tcp, records are tried in the order of
+their priority and weight, with the credentials, virtual host and query of the
+URL, like with DialClusterWithContext.
+*/
+func DialConfigWithContext(ctx context.Context, url string, config Config) (*Connection, error) {
+	if isSRVURI(url) {
+		return dialSRV(ctx, url, config)
+	}
 
 	uri, err := ParseURI(url)
 	if err != nil {
 		return nil, err
 	}
 
+	var credentials *credentialsRefresher
+	if config.CredentialsProvider != nil {
+		credentials = newCredentialsRefresher(config.CredentialsProvider, config.CredentialsRefresh)
+		if config.SASL, err = credentials.sasl(ctx); err != nil {
+			return nil, err
+		}
+	}
+
 	if config.SASL == nil {
 		if uri.AuthMechanism != nil {
 			for _, identifier := range uri.AuthMechanism {
-				switch strings.ToUpper(identifier) {
-				case "PLAIN":
-					config.SASL = append(config.SASL, uri.PlainAuth())
-				case "AMQPLAIN":
-					config.SASL = append(config.SASL, uri.AMQPlainAuth())
-				case "EXTERNAL":
-					config.SASL = append(config.SASL, &ExternalAuth{})
-				default:
+				newAuth, ok := saslMechanism(identifier)
+				if !ok {
 					return nil, fmt.Errorf("unsupported auth_mechanism: %v", identifier)
 				}
+				config.SASL = append(config.SASL, newAuth(uri))
 			}
 		} else {
-			config.SASL = []Authentication{uri.PlainAuth()}
+			// Brokers offering only AMQPLAIN, like Qpid and older
+			// RabbitMQ versions, get the same credentials.
+			config.SASL = []Authentication{uri.PlainAuth(), uri.AMQPlainAuth()}
 		}
 	}
 
@@ -239,48 +509,259 @@ func DialConfig(url string, config Config) (*Connection, error) {
 		config.ChannelMax = uri.ChannelMax
 	}
 
+	if config.FrameSize == 0 {
+		config.FrameSize = uri.FrameMax
+	}
+
+	if uri.ConnectionName != "" {
+		if _, found := config.Properties["connection_name"]; !found {
+			properties := NewConnectionProperties()
+			if len(config.Properties) > 0 {
+				properties = make(Table, len(config.Properties)+1)
+				for k, v := range config.Properties {
+					properties[k] = v
+				}
+			}
+			properties["connection_name"] = uri.ConnectionName
+			config.Properties = properties
+		}
+	}
+
 	connectionTimeout := defaultConnectionTimeout
 	if uri.ConnectionTimeout != 0 {
 		connectionTimeout = time.Duration(uri.ConnectionTimeout) * time.Millisecond
 	}
 
-	addr := net.JoinHostPort(uri.Host, strconv.FormatInt(int64(uri.Port), 10))
+	target := dialTarget{
+		network:       "tcp",
+		addr:          net.JoinHostPort(uri.Host, strconv.FormatInt(int64(uri.Port), 10)),
+		secure:        uri.Scheme == "amqps" || uri.Scheme == "wss",
+		webSocketPath: uri.WebSocketPath,
+	}
+	if uri.Socket != "" {
+		target.network, target.addr = "unix", uri.Socket
+	}
 
-	dialer := config.Dial
-	if dialer == nil {
-		dialer = DefaultDial(connectionTimeout)
+	dialerWithContext := func(ctx context.Context) func(network, addr string) (net.Conn, error) {
+		dialer := config.Dial
+		if dialer == nil {
+			dialer = tcpOptions(defaultDial(ctx, connectionTimeout, &net.Dialer{
+				Timeout:   connectionTimeout,
+				KeepAlive: config.TCPKeepAlive.Idle,
+				LocalAddr: config.LocalAddr,
+				Resolver:  config.Resolver,
+			}), config)
+		}
+		if config.Proxy != nil && target.network == "tcp" {
+			dialer = dialProxy(dialer, config.Proxy)
+		}
+		return dialer
+	}
+	dialer := dialerWithContext(ctx)
+
+	if target.secure && config.GetTLSConfig == nil {
+		if config.TLSClientConfig, err = clientTLSConfig(uri, config, config.TLSClientConfig); err != nil {
+			return nil, err
+		}
+	}
+	if target.secure && config.GetTLSConfig != nil {
+		target.tlsConfig = func() (*tls.Config, error) {
+			tlsConfig, err := config.GetTLSConfig()
+			if err != nil {
+				return nil, fmt.Errorf("get TLS config: %w", err)
+			}
+			if tlsConfig != nil {
+				tlsConfig = tlsConfig.Clone()
+			}
+			return clientTLSConfig(uri, config, tlsConfig)
+		}
 	}
 
-	conn, err = dialer("tcp", addr)
+	retry := config.DialRetry
+	if retry.BaseDelay <= 0 {
+		retry.BaseDelay = defaultDialRetryBaseDelay
+	}
+	if retry.MaxDelay <= 0 {
+		retry.MaxDelay = defaultDialRetryMaxDelay
+	}
+	delays := newBackoff(retry.BaseDelay, retry.MaxDelay)
+
+	for attempt := 1; ; attempt++ {
+		c, err := dialAndOpen(ctx, dialer, target, config)
+		if err == nil && config.RecoveryPolicy != nil {
+			// A connection lost before its recovery is armed failed to dial.
+			redial := dialerWithContext(context.Background())
+			err = c.enableRecovery(*config.RecoveryPolicy, config, func() (net.Conn, error) {
+				return dialTransport(context.Background(), redial, target, config)
+			})
+		}
+		if err == nil && credentials != nil {
+			c.refreshCredentials(credentials)
+		}
+		if err == nil || attempt >= retry.MaxAttempts || !isRetryableDialError(err) || ctx.Err() != nil {
+			return c, err
+		}
+
+		delay := delays.next()
+		Logger.Printf("dial attempt %d of %d to %s failed, retrying in %s: %v", attempt, retry.MaxAttempts, target.addr, delay, err)
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// clientTLSConfig completes the TLS configuration of a connection to uri,
+// made from the TLS parameters of uri when nil, with the server name of uri
+// and the TLS policy and peer verification of config.
+func clientTLSConfig(uri URI, config Config, tlsConfig *tls.Config) (*tls.Config, error) {
+	if tlsConfig == nil {
+		var err error
+		if tlsConfig, err = tlsConfigFromURI(uri); err != nil {
+			return nil, fmt.Errorf("create TLS config from URI: %w", err)
+		}
+	}
+
+	// If ServerName has not been specified in TLSClientConfig,
+	// set it to the URI host used for this connection.
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = uri.Host
+	}
+
+	tlsConfig, err := config.TLSPolicy.apply(tlsConfig)
 	if err != nil {
 		return nil, err
 	}
+	return withVerifyPeer(tlsConfig, config.VerifyPeer), nil
+}
 
-	if uri.Scheme == "amqps" {
-		if config.TLSClientConfig == nil {
-			tlsConfig, err := tlsConfigFromURI(uri)
-			if err != nil {
-				return nil, fmt.Errorf("create TLS config from URI: %w", err)
-			}
-			config.TLSClientConfig = tlsConfig
+// dialTarget is where the transport of a connection is dialed.
+type dialTarget struct {
+	network, addr string
+	secure        bool   // runs a TLS handshake
+	webSocketPath string // upgrades to a WebSocket connection when not empty
+
+	// tlsConfig returns the TLS configuration of each dial, instead of the
+	// TLSClientConfig of the Config, when not nil.
+	tlsConfig func() (*tls.Config, error)
+}
+
+// dialAndOpen performs a single attempt at connecting to target, running the
+// TLS and WebSocket handshakes it requires, and then the AMQP handshake.
+func dialAndOpen(ctx context.Context, dialer func(network, addr string) (net.Conn, error), target dialTarget, config Config) (*Connection, error) {
+	conn, err := dialTransport(ctx, dialer, target, config)
+	if err != nil {
+		return nil, err
+	}
+
+	stop := interruptOnDone(ctx, conn)
+	c, err := Open(conn, config)
+	if stop() {
+		// The reader of an open connection shuts it down after the
+		// interruption.
+		conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return c, err
+}
+
+// dialTransport connects to target, running the TLS and WebSocket handshakes
+// it requires.
+func dialTransport(ctx context.Context, dialer func(network, addr string) (net.Conn, error), target dialTarget, config Config) (net.Conn, error) {
+	tlsConfig := config.TLSClientConfig
+	if target.secure && target.tlsConfig != nil {
+		var err error
+		if tlsConfig, err = target.tlsConfig(); err != nil {
+			return nil, err
 		}
+	}
 
-		// If ServerName has not been specified in TLSClientConfig,
-		// set it to the URI host used for this connection.
-		if config.TLSClientConfig.ServerName == "" {
-			config.TLSClientConfig.ServerName = uri.Host
+	conn, err := dialer(target.network, target.addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
 		}
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-		client := tls.Client(conn, config.TLSClientConfig)
-		if err := client.Handshake(); err != nil {
+	if target.secure {
+		client := tls.Client(conn, tlsConfig)
+		if err := client.HandshakeContext(ctx); err != nil {
 			conn.Close()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
 			return nil, err
 		}
 
 		conn = client
 	}
 
-	return Open(conn, config)
+	if target.webSocketPath != "" {
+		stop := interruptOnDone(ctx, conn)
+		ws, err := webSocketHandshake(conn, target.addr, target.webSocketPath)
+		if stop() {
+			conn.Close()
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		conn = ws
+	}
+
+	return conn, nil
+}
+
+// interruptOnDone aborts the pending reads and writes of conn when ctx is
+// done, until the returned stop function is called.  stop returns true when
+// conn was interrupted.
+func interruptOnDone(ctx context.Context, conn net.Conn) (stop func() bool) {
+	if ctx.Done() == nil {
+		return func() bool { return false }
+	}
+
+	done := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.SetDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-done:
+			interrupted <- false
+		}
+	}()
+
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
+// isRetryableDialError returns false for the errors that another attempt
+// cannot fix: rejected credentials, vhost or locale, untrusted or rejected
+// certificates and violations of the TLS policy.
+func isRetryableDialError(err error) bool {
+	if errors.Is(err, ErrTLSPolicy) || errors.Is(err, ErrPeerVerification) {
+		return false
+	}
+
+	var amqpErr *Error
+	if errors.As(err, &amqpErr) {
+		return amqpErr.Code != AccessRefused && amqpErr.Code != NotImplemented
+	}
+
+	var certErr *tls.CertificateVerificationError
+	return !errors.As(err, &certErr)
 }
 
 /*
@@ -289,17 +770,42 @@ a transport.  Use this method if you have established a TLS connection or wish
 to use your own custom transport.
 */
 func Open(conn io.ReadWriteCloser, config Config) (*Connection, error) {
+	if err := config.TLSPolicy.enforce(conn, config.SASL); err != nil {
+		return nil, err
+	}
+
 	c := &Connection{
-		conn:      conn,
-		writer:    &writer{bufio.NewWriter(conn)},
-		channels:  make(map[uint16]*Channel),
-		rpc:       make(chan message),
-		sends:     make(chan time.Time),
-		errors:    make(chan *Error, 1),
-		close:     make(chan struct{}),
-		deadlines: make(chan readDeadliner, 1),
-	}
-	go c.reader(conn)
+		conn:          conn,
+		writeTimeout:  config.WriteTimeout,
+		readTimeout:   config.ReadTimeout,
+		writer:        &writer{w: bufio.NewWriter(conn), conn: conn, unbuffered: config.LowLatencyWrites},
+		channels:      make(map[uint16]*Channel),
+		rpc:           make(chan message),
+		sends:         make(chan time.Time),
+		errors:        make(chan *Error, 1),
+		close:         make(chan struct{}),
+		deadlines:     make(chan readDeadliner, 1),
+		leakTimeout:   config.ChannelLeakTimeout,
+		leakReport:    config.ChannelLeakReport,
+		ackReport:     config.AckDiagnostics,
+		unknownMethod: config.UnknownMethod,
+		writeBudget:   newWriteBudget(config.WriteBufferLimit, config.WriteBufferFailFast),
+	}
+	if c.leakReport == nil {
+		c.leakReport = logChannelLeak
+	}
+	c.Config.WriteTimeout = config.WriteTimeout
+	c.Config.LowLatencyWrites = config.LowLatencyWrites
+	c.Config.ReadTimeout = config.ReadTimeout
+	c.Config.ChannelLeakTimeout = config.ChannelLeakTimeout
+	c.Config.ChannelLeakReport = config.ChannelLeakReport
+	c.Config.AckDiagnostics = config.AckDiagnostics
+	c.Config.TrackDeliveries = config.TrackDeliveries
+	c.Config.UnknownMethod = config.UnknownMethod
+	c.Config.WriteBufferLimit = config.WriteBufferLimit
+	c.Config.WriteBufferFailFast = config.WriteBufferFailFast
+	c.readerDone = make(chan struct{})
+	go c.reader(conn, 0, c.readerDone)
 	return c, c.open(config)
 }
 
@@ -367,7 +873,8 @@ In case of a non graceful close the error will be notified synchronously by the
 so that it will be necessary to consume the Channel from the caller in order to avoid deadlocks
 
 To reconnect after a transport or protocol error, register a listener here and
-re-run your setup process.
+re-run your setup process, or set Config.RecoveryPolicy.  Connections that
+recover only notify the listeners once closed for good.
 */
 func (c *Connection) NotifyClose(receiver chan *Error) chan *Error {
 	c.m.Lock()
@@ -420,10 +927,15 @@ will also be closed.
 */
 func (c *Connection) Close() error {
 	if c.IsClosed() {
+		if c.stopRecovery() {
+			return nil
+		}
 		return ErrClosed
 	}
 
+	atomic.StoreInt32(&c.closing, 1)
 	defer c.shutdown(nil)
+	c.stopConsumers()
 	return c.call(
 		&connectionClose{
 			ReplyCode: replySuccess,
@@ -448,10 +960,15 @@ func (c *Connection) Close() error {
 // will also be closed.
 func (c *Connection) CloseDeadline(deadline time.Time) error {
 	if c.IsClosed() {
+		if c.stopRecovery() {
+			return nil
+		}
 		return ErrClosed
 	}
 
+	atomic.StoreInt32(&c.closing, 1)
 	defer c.shutdown(nil)
+	c.stopConsumers()
 
 	err := c.setDeadline(deadline)
 	if err != nil {
@@ -467,6 +984,17 @@ func (c *Connection) CloseDeadline(deadline time.Time) error {
 	)
 }
 
+// stopConsumers drops the deliveries of every channel before closing, so that
+// a consumer blocked by ConsumeBacklogLimit does not hold back the reply.
+func (c *Connection) stopConsumers() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, ch := range c.channels {
+		ch.consumers.stop()
+	}
+}
+
 func (c *Connection) closeWith(err *Error) error {
 	if c.IsClosed() {
 		return ErrClosed
@@ -501,18 +1029,110 @@ func (c *Connection) setDeadline(t time.Time) error {
 	return con.SetDeadline(t)
 }
 
+// setWriteDeadline bounds the next write to the transport by the write
+// timeout, if any.  It must be called with sendM held, and followed by
+// writeDone with the error of the write.
+//
+// A transport without SetWriteDeadline, like the io.ReadWriteCloser given to
+// Open, is closed by the write watchdog when the write does not complete in
+// time, and the write then fails with os.ErrDeadlineExceeded, like a net.Conn
+// past its deadline.
+func (c *Connection) setWriteDeadline() {
+	if c.writeTimeout <= 0 {
+		return
+	}
+	if conn, ok := c.conn.(writeDeadliner); ok {
+		_ = conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+		return
+	}
+	c.watchdog.arm(c.conn, c.writeTimeout)
+}
+
+// writeDone returns the error of a write bounded by setWriteDeadline.  It must
+// be called with sendM held.
+func (c *Connection) writeDone(err error) error {
+	if !c.watchdog.armed {
+		return err
+	}
+	return c.watchdog.disarm(err)
+}
+
+// writeWatchdog closes a transport without SetWriteDeadline when a write does
+// not complete in time.  Its timer is reset for every write rather than
+// allocated, so that it adds no garbage to publishing.
+type writeWatchdog struct {
+	armed bool // guarded by Connection.sendM
+
+	m     sync.Mutex
+	timer *time.Timer
+	conn  io.Closer // transport of the write in progress, nil between writes
+	fired bool
+}
+
+func (w *writeWatchdog) arm(conn io.Closer, timeout time.Duration) {
+	w.armed = true
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.conn = conn
+	w.fired = false
+	if w.timer == nil {
+		var timer *time.Timer
+		timer = time.AfterFunc(timeout, func() { w.expire(timer) })
+		w.timer = timer
+	} else {
+		w.timer.Reset(timeout)
+	}
+}
+
+func (w *writeWatchdog) disarm(err error) error {
+	w.armed = false
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if !w.timer.Stop() {
+		// The timer func may still be waiting for m, a new timer keeps it
+		// from closing the transport of the next write.
+		w.timer = nil
+	}
+	w.conn = nil
+	if w.fired {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
+
+func (w *writeWatchdog) expire(timer *time.Timer) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if w.timer == timer && w.conn != nil {
+		_ = w.conn.Close()
+		w.fired = true
+	}
+}
+
 func (c *Connection) send(f frame) error {
-	if c.IsClosed() {
+	if !c.writable(f) {
 		return ErrClosed
 	}
 
 	c.sendM.Lock()
-	err := c.writer.WriteFrame(f)
+	// The transport may have been replaced by a recovery in the meantime.
+	if !c.writable(f) {
+		c.sendM.Unlock()
+		return ErrClosed
+	}
+	gen := atomic.LoadUint64(&c.generation)
+	c.setWriteDeadline()
+	err := c.writeDone(c.writer.WriteFrame(f))
 	c.sendM.Unlock()
 
 	if err != nil {
 		// shutdown could be re-entrant from signaling notify chans
-		go c.shutdown(&Error{
+		go c.fail(gen, &Error{
 			Code:   FrameError,
 			Reason: err.Error(),
 		})
@@ -533,8 +1153,20 @@ func (c *Connection) send(f frame) error {
 // of sendUnflushed() calls and flush the connection
 func (c *Connection) endSendUnflushed() error {
 	c.sendM.Lock()
-	defer c.sendM.Unlock()
-	return c.flush()
+	gen := atomic.LoadUint64(&c.generation)
+	c.setWriteDeadline()
+	err := c.writeDone(c.flush())
+	c.sendM.Unlock()
+
+	if err != nil {
+		// shutdown could be re-entrant from signaling notify chans
+		go c.fail(gen, &Error{
+			Code:   FrameError,
+			Reason: err.Error(),
+		})
+	}
+
+	return err
 }
 
 // sendUnflushed performs an *Unflushed* write. It is otherwise equivalent to
@@ -544,26 +1176,31 @@ func (c *Connection) endSendUnflushed() error {
 // Why is this a thing?
 //
 // send() method uses writer.WriteFrame(), which will write the Frame then
-// flush the buffer. For cases like the sendOpen() method on Channel, which
-// sends multiple Frames (methodFrame, headerFrame, N x bodyFrame), flushing
-// after each Frame is inefficient as it negates much of the benefit of using a
-// buffered writer, and results in more syscalls than necessary. Flushing buffers
-// after every frame can have a significant performance impact when sending
-// (basicPublish) small messages, so this method performs an *Unflushed* write
-// but is otherwise equivalent to send() method, and we provide a separate
-// flush method to explicitly flush the buffer after all Frames are written.
+// flush the buffer. For cases like the batched bindings of Channel, which
+// send multiple Frames in a row, flushing after each Frame is inefficient as it
+// negates much of the benefit of using a buffered writer, and results in more
+// syscalls than necessary, so this method performs an *Unflushed* write but is
+// otherwise equivalent to send() method, and we provide a separate flush
+// method to explicitly flush the buffer after all Frames are written. The
+// frames of a message are written with sendFrames() instead.
 func (c *Connection) sendUnflushed(f frame) error {
-	if c.IsClosed() {
+	if !c.writable(f) {
 		return ErrClosed
 	}
 
 	c.sendM.Lock()
-	err := c.writer.WriteFrameNoFlush(f)
+	if !c.writable(f) {
+		c.sendM.Unlock()
+		return ErrClosed
+	}
+	gen := atomic.LoadUint64(&c.generation)
+	c.setWriteDeadline()
+	err := c.writeDone(c.writer.WriteFrameNoFlush(f))
 	c.sendM.Unlock()
 
 	if err != nil {
 		// shutdown could be re-entrant from signaling notify chans
-		go c.shutdown(&Error{
+		go c.fail(gen, &Error{
 			Code:   FrameError,
 			Reason: err.Error(),
 		})
@@ -572,6 +1209,44 @@ func (c *Connection) sendUnflushed(f frame) error {
 	return err
 }
 
+// sendFrames writes the frames of a message at once with
+// writer.WriteFrames, which avoids copying large bodies into the buffer of
+// the writer.  It is otherwise equivalent to sendUnflushed() for each frame
+// followed by endSendUnflushed().
+func (c *Connection) sendFrames(frames []frame) error {
+	if len(frames) == 0 {
+		return nil
+	}
+	if !c.writable(frames[0]) {
+		return ErrClosed
+	}
+
+	c.sendM.Lock()
+	if !c.writable(frames[0]) {
+		c.sendM.Unlock()
+		return ErrClosed
+	}
+	gen := atomic.LoadUint64(&c.generation)
+	c.setWriteDeadline()
+	err := c.writeDone(c.writer.WriteFrames(frames))
+	c.sendM.Unlock()
+
+	if err != nil {
+		// shutdown could be re-entrant from signaling notify chans
+		go c.fail(gen, &Error{
+			Code:   FrameError,
+			Reason: err.Error(),
+		})
+	} else {
+		select {
+		case c.sends <- time.Now():
+		default:
+		}
+	}
+
+	return err
+}
+
 // This method is intended to be used with sendUnflushed() to explicitly flush
 // the buffer after all required Frames have been written to the buffer.
 func (c *Connection) flush() (err error) {
@@ -598,6 +1273,11 @@ func (c *Connection) flush() (err error) {
 }
 
 func (c *Connection) shutdown(err *Error) {
+	if c.recoverable(err) {
+		c.interrupt(err)
+		return
+	}
+
 	atomic.StoreInt32(&c.closed, 1)
 
 	c.destructor.Do(func() {
@@ -621,6 +1301,10 @@ func (c *Connection) shutdown(err *Error) {
 			close(c)
 		}
 
+		for _, c := range c.reconnects {
+			close(c)
+		}
+
 		// Shutdown the channel, but do not use closeChannel() as it calls
 		// releaseChannel() which requires the connection lock.
 		//
@@ -637,12 +1321,21 @@ func (c *Connection) shutdown(err *Error) {
 		c.channels = nil
 		c.allocator = nil
 		c.noNotify = true
+
+		c.onClose.fire(err)
 	})
 }
 
 // All methods sent to the connection channel should be synchronous so we
 // can handle them directly without a framing component
 func (c *Connection) demux(f frame) {
+	if mf, ok := f.(*methodFrame); ok {
+		if m, ok := mf.Method.(*unknownMethod); ok {
+			c.dispatchUnknown(mf.ChannelId, m)
+			return
+		}
+	}
+
 	if f.channel() == 0 {
 		c.dispatch0(f)
 	} else {
@@ -694,6 +1387,7 @@ func (c *Connection) dispatchN(f frame) {
 	channel, ok := c.channels[f.channel()]
 	if ok {
 		updateChannel(f, channel)
+		channel.touch()
 	} else {
 		Logger.Printf("[debug] dropping frame, channel %d does not exist", f.channel())
 	}
@@ -745,17 +1439,20 @@ func (c *Connection) dispatchClosed(f frame) {
 // Reads each frame off the IO and hand off to the connection object that
 // will demux the streams and dispatch to one of the opened channels or
 // handle on channel 0 (the connection channel).
-func (c *Connection) reader(r io.Reader) {
+//
+// The reader exits, closing done, once the transport of generation gen fails.
+func (c *Connection) reader(r io.Reader, gen uint64, done chan struct{}) {
 	buf := bufio.NewReader(r)
 	frames := &reader{buf}
 	conn, haveDeadliner := r.(readDeadliner)
 
+	defer close(done)
 	defer close(c.rpc)
 
 	for {
 		frame, err := frames.ReadFrame()
 		if err != nil {
-			c.shutdown(&Error{Code: FrameError, Reason: err.Error()})
+			c.fail(gen, &Error{Code: FrameError, Reason: err.Error()})
 			return
 		}
 
@@ -774,7 +1471,7 @@ func (c *Connection) reader(r io.Reader) {
 
 // Ensures that at least one frame is being sent at the tuned interval with a
 // jitter tolerance of 1s
-func (c *Connection) heartbeater(interval time.Duration, done chan *Error) {
+func (c *Connection) heartbeater(interval time.Duration, done chan struct{}) {
 	const maxServerHeartbeatsInFlight = 3
 
 	var sendTicks <-chan time.Time
@@ -808,8 +1505,17 @@ func (c *Connection) heartbeater(interval time.Duration, done chan *Error) {
 
 		case conn := <-c.deadlines:
 			// When reading, reset our side of the deadline, if we've negotiated one with
-			// a deadline that covers at least 2 server heartbeats
-			if interval > 0 {
+			// a deadline that covers at least 2 server heartbeats, or if a read
+			// timeout has been configured
+			if c.readTimeout > 0 {
+				if err := conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+					var opErr *net.OpError
+					if !errors.As(err, &opErr) {
+						Logger.Printf("error setting read deadline in heartbeater: %+v", err)
+						return
+					}
+				}
+			} else if interval > 0 {
 				if err := conn.SetReadDeadline(time.Now().Add(maxServerHeartbeatsInFlight * interval)); err != nil {
 					var opErr *net.OpError
 					if !errors.As(err, &opErr) {
@@ -851,6 +1557,12 @@ func (c *Connection) allocateChannel() (*Channel, error) {
 	}
 
 	ch := newChannel(c, uint16(id))
+	if c.leakTimeout > 0 {
+		ch.leak = newLeakTracker()
+	}
+	if c.ackReport != nil || c.Config.TrackDeliveries {
+		ch.acks = newAckTracker()
+	}
 	c.channels[uint16(id)] = ch
 
 	return ch, nil
@@ -885,6 +1597,28 @@ func (c *Connection) openChannel() (*Channel, error) {
 	return ch, nil
 }
 
+// ChannelIDs returns the ids of the channels currently allocated on the
+// connection, in increasing order.
+func (c *Connection) ChannelIDs() []uint16 {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	ids := make([]uint16, 0, len(c.channels))
+	for id := range c.channels {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+// openChannels returns the number of channels currently open on the connection.
+func (c *Connection) openChannels() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.channels)
+}
+
 // closeChannel releases and initiates a shutdown of the channel.  All channel
 // closures should be initiated here for proper channel lifecycle management on
 // this connection.
@@ -902,36 +1636,85 @@ func (c *Connection) Channel() (*Channel, error) {
 	return c.openChannel()
 }
 
+/*
+Ping checks that the server is still responding on this connection by opening
+and closing a throwaway channel, and returns the time that took.  The measured
+latency covers two round trips to the server.
+
+Unlike IsClosed, which only reports what the client already knows, Ping detects
+half-open TCP connections where the server went away without the socket being
+closed.  Use it before publishing after a long idle period, or periodically
+from a liveness probe.
+
+When ctx is done before the server replied, ctx.Err() is returned.  The
+round trip keeps waiting in the background until the server replies or the
+connection is closed.
+*/
+func (c *Connection) Ping(ctx context.Context) (time.Duration, error) {
+	if c.IsClosed() {
+		return 0, ErrClosed
+	}
+
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	done := make(chan error, 1)
+
+	go func() {
+		ch, err := c.openChannel()
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- ch.Close()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return 0, err
+		}
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
 func (c *Connection) call(req message, res ...message) error {
+	msg, err := c.roundTrip(req)
+	if err != nil {
+		return err
+	}
+
+	// Try to match one of the result types
+	if setReply(msg, res...) {
+		return nil
+	}
+	return ErrCommandInvalid
+}
+
+// roundTrip sends req, if any, and returns the next method of the server on
+// channel 0.
+func (c *Connection) roundTrip(req message) (message, error) {
 	// Special case for when the protocol header frame is sent insted of a
 	// request method
 	if req != nil {
 		if err := c.send(&methodFrame{ChannelId: 0, Method: req}); err != nil {
-			return err
+			return nil, err
 		}
 	}
 
-	var msg message
 	select {
 	case e, ok := <-c.errors:
 		if ok {
-			return e
-		}
-		return ErrClosed
-	case msg = <-c.rpc:
-	}
-
-	// Try to match one of the result types
-	for _, try := range res {
-		if reflect.TypeOf(msg) == reflect.TypeOf(try) {
-			// *res = *msg
-			vres := reflect.ValueOf(try).Elem()
-			vmsg := reflect.ValueOf(msg).Elem()
-			vres.Set(vmsg)
-			return nil
+			return nil, e
 		}
+		return nil, ErrClosed
+	case msg := <-c.rpc:
+		return msg, nil
 	}
-	return ErrCommandInvalid
 }
 
 // Communication flow to open, use and close a connection. 'C:' are
@@ -971,8 +1754,6 @@ func (c *Connection) openStart(config Config) error {
 	c.Properties = start.ServerProperties
 	c.Locales = strings.Split(start.Locales, " ")
 
-	// eventually support challenge/response here by also responding to
-	// connectionSecure.
 	auth, ok := pickSASLMechanism(config.SASL, strings.Split(start.Mechanisms, " "))
 	if !ok {
 		return ErrSASL
@@ -981,12 +1762,48 @@ func (c *Connection) openStart(config Config) error {
 	// Save this mechanism off as the one we chose
 	c.Config.SASL = []Authentication{auth}
 
+	if config.Locale == "" {
+		config.Locale = pickLocale(c.Locales)
+	} else if !offersLocale(c.Locales, config.Locale) {
+		return ErrLocale
+	}
+
 	// Set the connection locale to client locale
 	c.Config.Locale = config.Locale
 
 	return c.openTune(config, auth)
 }
 
+// pickLocale returns en_US when the server offers it, or the first locale
+// offered by the server otherwise.
+func pickLocale(offered []string) string {
+	if offersLocale(offered, defaultLocale) {
+		return defaultLocale
+	}
+	for _, l := range offered {
+		if l != "" {
+			return l
+		}
+	}
+	return defaultLocale
+}
+
+// offersLocale returns true when locale is one of the locales offered by the
+// server.  A server that offers no locale at all accepts any.
+func offersLocale(offered []string, locale string) bool {
+	empty := true
+	for _, l := range offered {
+		if l == "" {
+			continue
+		}
+		empty = false
+		if strings.EqualFold(l, locale) {
+			return true
+		}
+	}
+	return empty
+}
+
 func (c *Connection) openTune(config Config, auth Authentication) error {
 	if len(config.Properties) == 0 {
 		config.Properties = NewConnectionProperties()
@@ -1005,9 +1822,24 @@ func (c *Connection) openTune(config Config, auth Authentication) error {
 		Response:         auth.Response(),
 		Locale:           config.Locale,
 	}
-	tune := &connectionTune{}
-
-	if err := c.call(ok, tune); err != nil {
+	reply, err := c.roundTrip(ok)
+	for err == nil {
+		secure, challenged := reply.(*connectionSecure)
+		if !challenged {
+			break
+		}
+		challenger, multiStep := auth.(ChallengeAuthentication)
+		if !multiStep {
+			return fmt.Errorf("%w: %s received a challenge", ErrCredentials, auth.Mechanism())
+		}
+		response, challengeErr := challenger.Challenge(secure.Challenge)
+		if challengeErr != nil {
+			return fmt.Errorf("%w: %s challenge: %v", ErrCredentials, auth.Mechanism(), challengeErr)
+		}
+		reply, err = c.roundTrip(&connectionSecureOk{Response: response})
+	}
+	tune, tuned := reply.(*connectionTune)
+	if err != nil || !tuned {
 		// per spec, a connection can only be closed when it has been opened
 		// so at this point, we know it's an auth error, but the socket
 		// was closed instead.  Return a meaningful error.
@@ -1027,6 +1859,8 @@ func (c *Connection) openTune(config Config, auth Authentication) error {
 	c.Config.ChannelMax = minUInt16(c.Config.ChannelMax, maxChannelMax)
 
 	c.allocator = newAllocator(1, int(c.Config.ChannelMax))
+	c.allocator.lowest = config.ChannelAllocation == AllocateLowest
+	c.Config.ChannelAllocation = config.ChannelAllocation
 
 	c.m.Unlock()
 
@@ -1042,7 +1876,7 @@ func (c *Connection) openTune(config Config, auth Authentication) error {
 
 	// "The client should start sending heartbeats after receiving a
 	// Connection.Tune method"
-	go c.heartbeater(c.Config.Heartbeat/2, c.NotifyClose(make(chan *Error, 1)))
+	go c.heartbeater(c.Config.Heartbeat/2, c.close)
 
 	if err := c.send(&methodFrame{
 		ChannelId: 0,
@@ -1084,6 +1918,18 @@ func (c *Connection) openComplete() error {
 		_ = deadliner.SetDeadline(time.Time{})
 	}
 
+	// Arm the read timeout now, the heartbeater only resets it after a frame
+	// has been read.
+	if c.readTimeout > 0 {
+		if deadliner, ok := c.conn.(readDeadliner); ok {
+			_ = deadliner.SetReadDeadline(time.Now().Add(c.readTimeout))
+		}
+	}
+
+	if c.leakTimeout > 0 {
+		go c.sweepLeaks(c.close)
+	}
+
 	return nil
 }
 
@@ -1099,31 +1945,33 @@ func tlsConfigFromURI(uri URI) (*tls.Config, error) {
 		}
 
 		certPool = x509.NewCertPool()
-		certPool.AppendCertsFromPEM(data)
+		if !certPool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("read CA certificate: no certificate in %s", uri.CACertFile)
+		}
 	} else if sysPool, err := x509.SystemCertPool(); err != nil {
 		return nil, fmt.Errorf("load system certificates: %w", err)
 	} else {
 		certPool = sysPool
 	}
 
+	config := &tls.Config{
+		RootCAs:            certPool,
+		ServerName:         uri.ServerName,
+		InsecureSkipVerify: uri.Verify == "verify_none",
+	}
+
 	if uri.CertFile == "" || uri.KeyFile == "" {
 		// no client auth (mTLS), just server auth
-		return &tls.Config{
-			RootCAs:    certPool,
-			ServerName: uri.ServerName,
-		}, nil
+		return config, nil
 	}
 
 	certificate, err := tls.LoadX509KeyPair(uri.CertFile, uri.KeyFile)
 	if err != nil {
 		return nil, fmt.Errorf("load client certificate: %w", err)
 	}
+	config.Certificates = []tls.Certificate{certificate}
 
-	return &tls.Config{
-		Certificates: []tls.Certificate{certificate},
-		RootCAs:      certPool,
-		ServerName:   uri.ServerName,
-	}, nil
+	return config, nil
 }
 
 func max(a, b int) int {
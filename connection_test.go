@@ -209,7 +209,7 @@ func TestPlaintextDialTLS(t *testing.T) {
 		t.Skip("requires server listening for plaintext connections")
 	}
 
-	conn, err := DialTLS(uri.String(), &tls.Config{MinVersion: tls.VersionTLS12})
+	conn, err := DialTLS(uri.Unredacted(), &tls.Config{MinVersion: tls.VersionTLS12})
 	if err != nil {
 		t.Fatalf("unexpected dial error, got %v", err)
 	}
@@ -0,0 +1,254 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+// ConsumerPriorityArg is the consumer argument used by RabbitMQ to assign a
+// priority to a consumer. Consumers with a higher priority receive deliveries
+// first, lower priority consumers only receive deliveries when the higher
+// priority ones are blocked. See https://www.rabbitmq.com/consumer-priority.html
+const ConsumerPriorityArg = "x-priority"
+
+// consumeOptions holds the settings collected from ConsumeOption values.
+type consumeOptions struct {
+	exclusive bool
+	noWait    bool
+	args      Table
+
+	// hooks build the delivery hooks of the consumer once it is known
+	// whether deliveries are automatically acknowledged.
+	hooks []func(autoAck bool) DeliveryHook
+
+	overflow overflow
+}
+
+// ConsumeOption configures a consumer started with Channel.ConsumeWithOptions.
+// Options are applied in order, so a later option overrides an earlier one
+// setting the same argument.
+type ConsumeOption func(*consumeOptions) error
+
+func newConsumeOptions(opts []ConsumeOption) (*consumeOptions, error) {
+	o := &consumeOptions{}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func (o *consumeOptions) setArg(key string, value interface{}) {
+	if o.args == nil {
+		o.args = Table{}
+	}
+	o.args[key] = value
+}
+
+// ConsumerPriority sets the x-priority consumer argument.  The default
+// priority of a consumer is 0, and both positive and negative priorities are
+// accepted by the server.  An error is returned when starting the consumer if
+// the priority does not fit in the signed 32-bit integer expected by the
+// server.
+func ConsumerPriority(priority int) ConsumeOption {
+	return func(o *consumeOptions) error {
+		if priority < math.MinInt32 || priority > math.MaxInt32 {
+			return fmt.Errorf("consumer priority %d out of range for %s", priority, ConsumerPriorityArg)
+		}
+		o.setArg(ConsumerPriorityArg, int32(priority))
+		return nil
+	}
+}
+
+// ConsumeExclusive asks the server to ensure that this is the sole consumer
+// from the queue.  See the exclusive parameter of Channel.Consume.
+func ConsumeExclusive() ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.exclusive = true
+		return nil
+	}
+}
+
+// ConsumeNoWait does not wait for the server to confirm the consumer.  See
+// the noWait parameter of Channel.Consume.
+func ConsumeNoWait() ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.noWait = true
+		return nil
+	}
+}
+
+// ConsumeArgs adds the fields of args to the consumer arguments.  The table
+// is copied, so args can be reused by the caller.
+func ConsumeArgs(args Table) ConsumeOption {
+	return func(o *consumeOptions) error {
+		if err := args.Validate(); err != nil {
+			return err
+		}
+		for k, v := range args {
+			o.setArg(k, v)
+		}
+		return nil
+	}
+}
+
+/*
+ConsumeBacklogLimit bounds the number of deliveries buffered by the library for
+a consumer that the application has not read yet.  By default the buffer grows
+without limit, so a stuck consumer grows memory usage until the prefetch count
+set with Channel.Qos is reached.
+
+When max deliveries are buffered and onOverflow is nil, the library stops
+reading from the connection until the consumer catches up, which stalls every
+channel of the connection, where the default would keep buffering.  Closing
+the channel or the connection, or cancelling the consumer with noWait, drops
+the delivery held back and resumes reading; a Cancel waiting for the reply of
+the server waits for the consumer to catch up.
+
+Otherwise onOverflow is called with each delivery arriving while the buffer is
+full, which is then dropped, so that one stuck consumer cannot stall the
+others.  A typical overflow callback rejects the delivery with requeue:
+
+	amqp.ConsumeBacklogLimit(1000, func(d amqp.Delivery) { _ = d.Nack(false, true) })
+
+The callback must not block, and deliveries dropped from a consumer that
+automatically acknowledges deliveries are lost.
+*/
+func ConsumeBacklogLimit(max int, onOverflow func(Delivery)) ConsumeOption {
+	return func(o *consumeOptions) error {
+		if max <= 0 {
+			return fmt.Errorf("consumer backlog limit %d must be greater than zero", max)
+		}
+		o.overflow = overflow{max: max, drop: onOverflow}
+		return nil
+	}
+}
+
+// ConsumeDeliveryHook adds a hook run on every delivery before it is handed
+// to the application.  Hooks run in the order they are given.
+func ConsumeDeliveryHook(hook DeliveryHook) ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.hooks = append(o.hooks, func(bool) DeliveryHook { return hook })
+		return nil
+	}
+}
+
+// filter returns deliveries unchanged when there are no hooks, otherwise a
+// chan of the deliveries kept by all hooks.
+func (o *consumeOptions) filter(deliveries <-chan Delivery, autoAck bool) <-chan Delivery {
+	if len(o.hooks) == 0 {
+		return deliveries
+	}
+
+	hooks := make([]DeliveryHook, len(o.hooks))
+	for i, build := range o.hooks {
+		hooks[i] = build(autoAck)
+	}
+
+	return filterDeliveries(deliveries, hooks)
+}
+
+/*
+ConsumeWithOptions immediately starts delivering queued messages.
+
+It is equivalent to Channel.ConsumeWithContext, except that the exclusive and
+noWait flags and the consumer arguments are given as ConsumeOption values
+instead of positional parameters.  This avoids assembling argument tables by
+hand for common settings such as the consumer priority:
+
+	deliveries, err := ch.ConsumeWithOptions(ctx, "jobs", "", false,
+		amqp.ConsumerPriority(10),
+		amqp.ConsumeExclusive(),
+	)
+
+An error is returned without contacting the server when one of the options is
+invalid.
+*/
+func (ch *Channel) ConsumeWithOptions(ctx context.Context, queue, consumer string, autoAck bool, opts ...ConsumeOption) (<-chan Delivery, error) {
+	o, err := newConsumeOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries, err := ch.consume(ctx, queue, consumer, autoAck, o.exclusive, false, o.noWait, o.args, o.overflow)
+	if err != nil {
+		return nil, err
+	}
+
+	return o.filter(deliveries, autoAck), nil
+}
+
+/*
+ConsumeOne waits for a single delivery from queue and returns it.  It registers
+a temporary consumer, waits until the first delivery arrives or ctx is done,
+and cancels the consumer before returning.  This is the "wait for the reply" or
+"wait for the next job" pattern done safely.
+
+The delivery is not automatically acknowledged: call Delivery.Ack once it has
+been processed.  Deliveries that the server pushed to the temporary consumer
+after the first one are negatively acknowledged with requeue set to true before
+this method returns.  Set Channel.Qos with a prefetch count of 1 to avoid the
+server delivering more than one message to the temporary consumer.
+
+When ctx is done before a delivery arrives, the consumer is cancelled and
+ctx.Err() is returned.  ErrClosed is returned when the channel or connection is
+closed while waiting.
+
+The exclusive and noWait flags, as well as the consumer arguments, can be set
+with ConsumeOption values as in Channel.ConsumeWithOptions.
+*/
+func (ch *Channel) ConsumeOne(ctx context.Context, queue string, opts ...ConsumeOption) (Delivery, error) {
+	o, err := newConsumeOptions(opts)
+	if err != nil {
+		return Delivery{}, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return Delivery{}, err
+	}
+
+	consumer := uniqueConsumerTag()
+	deliveries, err := ch.consume(context.Background(), queue, consumer, false, o.exclusive, false, o.noWait, o.args, o.overflow)
+	if err != nil {
+		return Delivery{}, err
+	}
+	deliveries = o.filter(deliveries, false)
+
+	var (
+		msg Delivery
+		got bool
+	)
+
+	select {
+	case msg, got = <-deliveries:
+		if !got {
+			return Delivery{}, ErrClosed
+		}
+	case <-ctx.Done():
+	}
+
+	if err := ch.Cancel(consumer, o.noWait); err != nil {
+		// The channel is unusable, deliveries will be requeued by the server.
+		ch.consumers.cancel(consumer)
+		for range deliveries {
+		}
+		return Delivery{}, err
+	}
+
+	// Requeue anything that was already on its way to the temporary consumer.
+	for extra := range deliveries {
+		_ = extra.Nack(false, true)
+	}
+
+	if !got {
+		return Delivery{}, ctx.Err()
+	}
+
+	return msg, nil
+}
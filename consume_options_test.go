@@ -0,0 +1,381 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestConsumerPriorityOption(t *testing.T) {
+	o, err := newConsumeOptions([]ConsumeOption{ConsumerPriority(-5)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want, got := int32(-5), o.args[ConsumerPriorityArg]; want != got {
+		t.Errorf("expected %s to be %d, got %v", ConsumerPriorityArg, want, got)
+	}
+}
+
+func TestConsumerPriorityOptionOutOfRange(t *testing.T) {
+	if _, err := newConsumeOptions([]ConsumeOption{ConsumerPriority(math.MaxInt32 + 1)}); err == nil {
+		t.Fatalf("expected an error for a priority that does not fit in int32")
+	}
+}
+
+func TestConsumeArgsOptionDoesNotAliasTable(t *testing.T) {
+	args := Table{"x-stream-offset": "first"}
+
+	o, err := newConsumeOptions([]ConsumeOption{ConsumeArgs(args), ConsumerPriority(1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, found := args[ConsumerPriorityArg]; found {
+		t.Errorf("expected the caller's table not to be modified")
+	}
+	if want, got := "first", o.args["x-stream-offset"]; want != got {
+		t.Errorf("expected x-stream-offset to be %q, got %v", want, got)
+	}
+}
+
+func TestConsumeWithOptionsSendsArguments(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	consume := make(chan *basicConsume, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+		consume <- req
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if _, err := ch.ConsumeWithOptions(context.Background(), "jobs", "tag", false, ConsumerPriority(10), ConsumeExclusive()); err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	req := <-consume
+	if !req.Exclusive {
+		t.Errorf("expected an exclusive consumer")
+	}
+	if want, got := int32(10), req.Arguments[ConsumerPriorityArg]; want != got {
+		t.Errorf("expected %s to be %d, got %v", ConsumerPriorityArg, want, got)
+	}
+}
+
+func TestConsumeOneRequeuesExtraDeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	nacked := make(chan *basicNack, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 1})
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 2})
+
+		cancel := &basicCancel{}
+		srv.recv(1, cancel)
+		srv.send(1, &basicCancelOk{ConsumerTag: cancel.ConsumerTag})
+
+		nack := &basicNack{}
+		srv.recv(1, nack)
+		nacked <- nack
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	msg, err := ch.ConsumeOne(context.Background(), "replies")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want, got := uint64(1), msg.DeliveryTag; want != got {
+		t.Errorf("expected delivery tag %d, got %d", want, got)
+	}
+
+	nack := <-nacked
+	if nack.DeliveryTag != 2 || !nack.Requeue {
+		t.Errorf("expected delivery 2 to be requeued, got %+v", nack)
+	}
+}
+
+func TestConsumeOneContextCancelled(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		cancel := &basicCancel{}
+		srv.recv(1, cancel)
+		srv.send(1, &basicCancelOk{ConsumerTag: cancel.ConsumerTag})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := ch.ConsumeOne(ctx, "replies"); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestConsumeBacklogLimitOverflow(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	sent := make(chan struct{})
+
+	go func() {
+		defer close(sent)
+
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: tag})
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	dropped := make(chan uint64, 3)
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "jobs", "", false,
+		ConsumeBacklogLimit(1, func(d Delivery) { dropped <- d.DeliveryTag }),
+	)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	for _, want := range []uint64{2, 3} {
+		if got := <-dropped; want != got {
+			t.Fatalf("expected delivery %d to overflow, got %d", want, got)
+		}
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 1 {
+		t.Fatalf("expected the buffered delivery 1, got %d", d.DeliveryTag)
+	}
+
+	<-sent
+}
+
+func TestConsumeBacklogLimitInvalid(t *testing.T) {
+	if _, err := newConsumeOptions([]ConsumeOption{ConsumeBacklogLimit(0, nil)}); err == nil {
+		t.Fatalf("expected an error for a backlog limit of zero")
+	}
+}
+
+// consumeBlocked opens a consumer buffering a single delivery and sends it
+// two, so that the connection reader blocks on the second one, then serves the
+// rest of the session with serve.
+func consumeBlocked(t *testing.T, serve func(srv *server, tag string)) (*Connection, *Channel, string, <-chan Delivery) {
+	t.Helper()
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	tags := make(chan string, 1)
+	blocked := make(chan struct{})
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+		tags <- req.ConsumerTag
+
+		for tag := uint64(1); tag <= 2; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: tag, Body: []byte("job")})
+		}
+		close(blocked)
+
+		serve(srv, req.ConsumerTag)
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "jobs", "", false, ConsumeBacklogLimit(1, nil))
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	<-blocked
+	return c, ch, <-tags, deliveries
+}
+
+// withinSecond fails the test when f does not return within a second.
+func withinSecond(t *testing.T, name string, f func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("%s blocked behind the consumer", name)
+	}
+}
+
+// drain reads the deliveries until the chan is closed and returns their tags.
+func drain(t *testing.T, deliveries <-chan Delivery) (tags []uint64) {
+	t.Helper()
+
+	withinSecond(t, "closing the deliveries", func() {
+		for d := range deliveries {
+			tags = append(tags, d.DeliveryTag)
+		}
+	})
+	return tags
+}
+
+func TestConsumeBacklogLimitBlockedBacklogs(t *testing.T) {
+	c, ch, tag, deliveries := consumeBlocked(t, func(srv *server, _ string) {
+		srv.connectionClose()
+	})
+
+	withinSecond(t, "ConsumerBacklogs", func() {
+		for {
+			backlogs := ch.ConsumerBacklogs()
+			if len(backlogs) == 1 && backlogs[0].Consumer == tag && backlogs[0].Pending == 1 {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	withinSecond(t, "Close", func() {
+		if err := c.Close(); err != nil {
+			t.Errorf("could not close connection: %v", err)
+		}
+	})
+	drain(t, deliveries)
+}
+
+func TestConsumeBacklogLimitBlockedCancel(t *testing.T) {
+	c, ch, tag, deliveries := consumeBlocked(t, func(srv *server, tag string) {
+		srv.recv(1, &basicCancel{})
+		srv.connectionClose()
+	})
+
+	withinSecond(t, "Cancel", func() {
+		if err := ch.Cancel(tag, true); err != nil {
+			t.Errorf("could not cancel: %v", err)
+		}
+	})
+	if tags := drain(t, deliveries); len(tags) != 1 || tags[0] != 1 {
+		t.Fatalf("expected the buffered delivery 1 only, got %v", tags)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestConsumeBacklogLimitBlockedClose(t *testing.T) {
+	t.Run("channel", func(t *testing.T) {
+		c, ch, _, deliveries := consumeBlocked(t, func(srv *server, _ string) {
+			srv.recv(1, &channelClose{})
+			srv.send(1, &channelCloseOk{})
+			srv.connectionClose()
+		})
+
+		withinSecond(t, "Channel.Close", func() {
+			if err := ch.Close(); err != nil {
+				t.Errorf("could not close channel: %v", err)
+			}
+		})
+		drain(t, deliveries)
+
+		if err := c.Close(); err != nil {
+			t.Fatalf("could not close connection: %v", err)
+		}
+	})
+
+	t.Run("connection", func(t *testing.T) {
+		c, _, _, deliveries := consumeBlocked(t, func(srv *server, _ string) {
+			srv.connectionClose()
+		})
+
+		withinSecond(t, "Connection.Close", func() {
+			if err := c.Close(); err != nil {
+				t.Errorf("could not close connection: %v", err)
+			}
+		})
+		drain(t, deliveries)
+	})
+}
@@ -0,0 +1,408 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConsumerOptions configures a Consumer.
+type ConsumerOptions struct {
+	// Queue to consume from.
+	Queue string
+
+	// Tag is the consumer tag, kept across restarts.  A unique tag is
+	// generated when empty.
+	Tag string
+
+	// Prefetch is the number of unacknowledged deliveries the server pushes
+	// to the consumer, see Channel.Qos.  It defaults to the handler
+	// concurrency.
+	Prefetch int
+
+	// Handler processes the deliveries, it is required.
+	Handler Handler
+
+	// HandlerOptions sets the handler concurrency and how failed deliveries
+	// are settled, see Channel.ConsumeHandler.
+	HandlerOptions HandlerOptions
+
+	// ConsumeOptions are passed to Channel.ConsumeWithOptions.
+	ConsumeOptions []ConsumeOption
+
+	// BaseDelay and MaxDelay bound the exponential backoff between restarts,
+	// 100ms and 5s when zero.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// Autoscale, when set, scales the number of handler workers, and
+	// optionally the prefetch, with the depth of the queue.
+	// HandlerOptions.Concurrency is then the initial number of workers.
+	Autoscale *AutoscaleOptions
+
+	// Redeclare is the topology declaring the queue.  When consuming fails
+	// with NOT_FOUND because the queue was deleted, the topology is applied
+	// again and the consumer restarted once right away, before falling back
+	// to the backoff.
+	Redeclare *Topology
+}
+
+// ConsumerStats are the counters of a Consumer since it was started.
+type ConsumerStats struct {
+	Delivered uint64 // deliveries passed to the handler
+	Succeeded uint64 // deliveries for which the handler returned nil
+	Failed    uint64 // deliveries for which the handler returned an error
+	Panicked  uint64 // deliveries for which the handler panicked
+	InFlight  int64  // deliveries being handled right now
+	Restarts  uint64 // times the consumer was started again after its channel closed
+	Workers   int    // handler workers, changed by Autoscale
+}
+
+/*
+Consumer is a long-running consumer that owns its channel.  It opens a channel
+from its ChannelSource, sets the prefetch and consumes the queue with a
+Handler, as with Channel.ConsumeHandler.
+
+When the channel or its connection is closed, the Consumer opens a new channel
+from the source after an exponential backoff and consumes again, so a source
+that reconnects, like a Session recovered on a new connection, is picked up
+transparently.  Stop ends the consumer gracefully.
+
+	c := amqp.NewConsumer(conn, amqp.ConsumerOptions{
+		Queue:          "jobs",
+		Handler:        process,
+		HandlerOptions: amqp.HandlerOptions{Concurrency: 8},
+	})
+	c.Start()
+	defer c.Stop(ctx)
+*/
+type Consumer struct {
+	source ChannelSource
+	opts   ConsumerOptions
+
+	stats ConsumerStats // only accessed as atomics
+
+	m        sync.Mutex
+	started  bool
+	ch       *Channel
+	pool     *workerPool        // workers of the running consumer
+	attached chan struct{}      // closed when the next consumer is started
+	restart  context.CancelFunc // restarts the running consumer on its channel
+	workers  int
+	stop     context.CancelFunc
+	done     chan struct{}
+}
+
+// NewConsumer returns a Consumer opening its channel from source.  Call Start
+// to begin consuming.
+func NewConsumer(source ChannelSource, opts ConsumerOptions) *Consumer {
+	if opts.Tag == "" {
+		opts.Tag = uniqueConsumerTag()
+	}
+	if opts.HandlerOptions.Concurrency <= 0 {
+		opts.HandlerOptions.Concurrency = 1
+	}
+	if opts.Autoscale != nil {
+		a := newAutoscaler(*opts.Autoscale)
+		opts.Autoscale = &a.opts
+		opts.HandlerOptions.Concurrency = a.clamp(opts.HandlerOptions.Concurrency)
+	}
+	if opts.Prefetch <= 0 {
+		opts.Prefetch = opts.HandlerOptions.Concurrency
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 5 * time.Second
+	}
+
+	return &Consumer{
+		source:   source,
+		opts:     opts,
+		workers:  opts.HandlerOptions.Concurrency,
+		attached: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// Start begins consuming in the background.  It returns an error when the
+// consumer has no handler or was already started.
+func (c *Consumer) Start() error {
+	if c.opts.Handler == nil {
+		return errors.New("consumer handler is required")
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.started {
+		return errors.New("consumer already started")
+	}
+	c.started = true
+
+	ctx, stop := context.WithCancel(context.Background())
+	c.stop = stop
+
+	go func() {
+		defer close(c.done)
+
+		if c.opts.Autoscale != nil {
+			scaled := make(chan struct{})
+			go func() {
+				defer close(scaled)
+				c.autoscale(ctx)
+			}()
+			defer func() { <-scaled }()
+		}
+
+		c.run(ctx)
+	}()
+	return nil
+}
+
+func (c *Consumer) run(ctx context.Context) {
+	delays := newBackoff(c.opts.BaseDelay, c.opts.MaxDelay)
+	redeclared := false
+	for first := true; ; first = false {
+		if !first {
+			atomic.AddUint64(&c.stats.Restarts, 1)
+		}
+
+		consumed, err := c.consume(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if consumed {
+			delays.reset()
+			if !isNotFound(err) {
+				redeclared = false
+			}
+		}
+
+		if !redeclared && c.redeclarable(err) {
+			redeclared = true
+			rerr := c.opts.Redeclare.apply(ctx, c.source, ApplyOptions{})
+			if rerr == nil {
+				continue
+			}
+			Logger.Printf("consumer %q could not redeclare the topology of queue %q: %v", c.opts.Tag, c.opts.Queue, rerr)
+		}
+
+		delay := delays.next()
+		Logger.Printf("consumer %q of queue %q stopped, restarting in %s: %v", c.opts.Tag, c.opts.Queue, delay, err)
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// redeclarable returns true when err may be fixed by redeclaring the topology
+// of the consumer.
+func (c *Consumer) redeclarable(err error) bool {
+	return c.opts.Redeclare != nil && c.opts.Redeclare.declaresQueue(c.opts.Queue) && isNotFound(err)
+}
+
+// consume runs the consumer on a new channel until ctx is done or the
+// channel is closed.  consumed is true when the consumer was started.  The
+// consumer is started again on the same channel when UpdateOptions restarts
+// it.
+func (c *Consumer) consume(ctx context.Context) (consumed bool, err error) {
+	ch, err := c.source.Channel()
+	if err != nil {
+		return false, err
+	}
+
+	c.m.Lock()
+	c.ch = ch
+	c.m.Unlock()
+
+	defer func() {
+		c.m.Lock()
+		c.pool = nil
+		c.restart = nil
+		c.m.Unlock()
+		_ = ch.Close()
+	}()
+
+	for {
+		c.m.Lock()
+		workers := c.workers
+		prefetch, consumeOpts := c.opts.Prefetch, c.opts.ConsumeOptions
+		run, restart := context.WithCancel(ctx)
+		c.restart = restart
+		c.m.Unlock()
+
+		global := false
+		if a := c.opts.Autoscale; a != nil && a.PrefetchPerWorker > 0 {
+			// Unlike the prefetch of a consumer, the prefetch of a channel can
+			// be changed while consuming.
+			prefetch, global = workers*a.PrefetchPerWorker, true
+		}
+		if err := ch.Qos(prefetch, 0, global); err != nil {
+			restart()
+			return consumed, err
+		}
+		consumed = true
+
+		opts := c.opts.HandlerOptions
+		opts.Concurrency = workers
+		err = ch.consumeHandler(run, c.opts.Queue, c.opts.Tag, c.handle, opts, c.attach, consumeOpts...)
+
+		restarted := run.Err() != nil && ctx.Err() == nil
+		restart()
+		if !restarted {
+			return consumed, err
+		}
+	}
+}
+
+// attach keeps the workers of the running consumer, so that they can be
+// scaled.
+func (c *Consumer) attach(pool *workerPool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.pool = pool
+	pool.resize(c.workers)
+
+	close(c.attached)
+	c.attached = make(chan struct{})
+}
+
+// handle wraps the handler to count deliveries.
+func (c *Consumer) handle(d Delivery) (err error) {
+	atomic.AddUint64(&c.stats.Delivered, 1)
+	atomic.AddInt64(&c.stats.InFlight, 1)
+	defer atomic.AddInt64(&c.stats.InFlight, -1)
+
+	panicking := true
+	defer func() {
+		if panicking {
+			atomic.AddUint64(&c.stats.Panicked, 1)
+		}
+	}()
+
+	err = c.opts.Handler(d)
+	panicking = false
+
+	if err != nil {
+		atomic.AddUint64(&c.stats.Failed, 1)
+	} else {
+		atomic.AddUint64(&c.stats.Succeeded, 1)
+	}
+	return err
+}
+
+// ConsumerUpdate holds the options of a running Consumer to change with
+// Consumer.UpdateOptions.
+type ConsumerUpdate struct {
+	// Prefetch, when greater than zero, replaces ConsumerOptions.Prefetch.
+	Prefetch int
+
+	// ConsumeOptions, when not nil, replace ConsumerOptions.ConsumeOptions,
+	// like the priority, exclusivity or arguments of the consumer.
+	ConsumeOptions []ConsumeOption
+}
+
+/*
+UpdateOptions changes the prefetch and the consume options of the consumer
+without stopping it.  The server only applies them to new consumers, so the
+consumer is cancelled and consumed again with the new options on the same
+channel.  The deliveries received before the cancellation are still handled
+and acknowledged, and the handler keeps being called, so the application sees
+an uninterrupted stream of deliveries.
+
+UpdateOptions returns once the consumer is started again, or ctx.Err() when
+ctx is done first, in which case the consumer keeps being restarted with the
+new options in the background, as after a failure.  A consumer that is not
+running starts with the new options.
+*/
+func (c *Consumer) UpdateOptions(ctx context.Context, update ConsumerUpdate) error {
+	if update.ConsumeOptions != nil {
+		if _, err := newConsumeOptions(update.ConsumeOptions); err != nil {
+			return err
+		}
+	}
+
+	c.m.Lock()
+	changed := false
+	if update.Prefetch > 0 && update.Prefetch != c.opts.Prefetch {
+		c.opts.Prefetch = update.Prefetch
+		changed = true
+	}
+	if update.ConsumeOptions != nil {
+		c.opts.ConsumeOptions = update.ConsumeOptions
+		changed = true
+	}
+	restart, attached := c.restart, c.attached
+	c.m.Unlock()
+
+	if !changed || restart == nil {
+		return nil
+	}
+	restart()
+
+	select {
+	case <-attached:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats returns the counters of the consumer.
+func (c *Consumer) Stats() ConsumerStats {
+	return ConsumerStats{
+		Delivered: atomic.LoadUint64(&c.stats.Delivered),
+		Succeeded: atomic.LoadUint64(&c.stats.Succeeded),
+		Failed:    atomic.LoadUint64(&c.stats.Failed),
+		Panicked:  atomic.LoadUint64(&c.stats.Panicked),
+		InFlight:  atomic.LoadInt64(&c.stats.InFlight),
+		Restarts:  atomic.LoadUint64(&c.stats.Restarts),
+		Workers:   c.workerCount(),
+	}
+}
+
+func (c *Consumer) workerCount() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.workers
+}
+
+/*
+Stop cancels the consumer, so the server stops pushing deliveries, waits for
+the running handlers to return and closes the channel of the consumer.
+
+When ctx is done first, the channel is closed right away, so the server
+requeues the deliveries that were not acknowledged yet, and ctx.Err() is
+returned.  Stop returns nil when the consumer was never started.
+*/
+func (c *Consumer) Stop(ctx context.Context) error {
+	c.m.Lock()
+	if !c.started {
+		c.m.Unlock()
+		return nil
+	}
+	c.stop()
+	c.m.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		c.m.Lock()
+		ch := c.ch
+		c.m.Unlock()
+		if ch != nil {
+			_ = ch.Close()
+		}
+		return ctx.Err()
+	}
+}
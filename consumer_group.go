@@ -0,0 +1,422 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+LeaseStore holds expiring leases, the coordination primitive of a
+ConsumerGroup.  A lease is held by a single holder until it expires or is
+released.  Implementations are usually backed by storage shared by the
+members of the group, like a database table or etcd, and must be safe for
+concurrent use.  MemoryLeaseStore coordinates the groups of a single process.
+*/
+type LeaseStore interface {
+	// Acquire takes the lease key for holder, or extends it when holder
+	// already holds it, until ttl elapses.  It returns false when another
+	// holder holds the lease.
+	Acquire(ctx context.Context, key, holder string, ttl time.Duration) (bool, error)
+
+	// Release gives up the lease key when holder holds it.
+	Release(ctx context.Context, key, holder string) error
+
+	// Leases returns the holders of the leases that have not expired and
+	// whose key starts with prefix, by key.
+	Leases(ctx context.Context, prefix string) (map[string]string, error)
+}
+
+// ConsumerGroupOptions configures a ConsumerGroup.
+type ConsumerGroupOptions struct {
+	// Group names the group, members of the same group share its queues.
+	Group string
+
+	// Member identifies this member of the group, it must be unique in the
+	// group.  A unique id is generated when empty.
+	Member string
+
+	// Queues are the queues shared by the group, like the shards of a
+	// sharded queue, see ShardQueueName.
+	Queues []string
+
+	// Store holds the leases of the members and of the queues, it is
+	// required.
+	Store LeaseStore
+
+	// Consumer configures the Consumer of each assigned queue.  Its Queue is
+	// set by the group.
+	Consumer ConsumerOptions
+
+	// Interval between heartbeats and rebalances, 5s when zero.
+	Interval time.Duration
+
+	// LeaseTTL is how long the leases of a member outlive its last
+	// heartbeat, three intervals when zero.  The queues of a member that
+	// crashed are assigned again once their leases expired.
+	LeaseTTL time.Duration
+
+	// OnAssign is called with the queues consumed by this member whenever
+	// they change.
+	OnAssign func(queues []string)
+}
+
+// groupConsumer is the part of a Consumer used by a ConsumerGroup.
+type groupConsumer interface {
+	Stop(ctx context.Context) error
+}
+
+/*
+ConsumerGroup shares a set of queues between the processes consuming them, so
+that each queue is consumed by a single member of the group at a time and the
+queues are spread evenly over the members.  Members join by starting a group
+with the same name and queues, and leave with Stop or by crashing.
+
+Every Interval, a member renews its membership lease, computes the queues it
+should consume from the live members with rendezvous hashing, stops consuming
+the queues that moved to other members and starts consuming the queues that
+moved to it.  A member only consumes a queue while it holds the lease of the
+queue, which is released once its Consumer stopped, so a queue moving between
+members is never consumed by both.
+
+	g := amqp.NewConsumerGroup(conn, amqp.ConsumerGroupOptions{
+		Group:    "billing",
+		Queues:   []string{"events.0", "events.1", "events.2", "events.3"},
+		Store:    store,
+		Consumer: amqp.ConsumerOptions{Handler: process},
+	})
+	g.Start()
+	defer g.Stop(ctx)
+*/
+type ConsumerGroup struct {
+	source ChannelSource
+	opts   ConsumerGroupOptions
+
+	// consume starts consuming a queue, replaced in tests.
+	consume func(queue string) (groupConsumer, error)
+
+	m         sync.Mutex
+	started   bool
+	consumers map[string]groupConsumer // by assigned queue
+	stop      context.CancelFunc
+	done      chan struct{}
+}
+
+// NewConsumerGroup returns a member of a consumer group opening its channels
+// from source.  Call Start to join the group.
+func NewConsumerGroup(source ChannelSource, opts ConsumerGroupOptions) *ConsumerGroup {
+	if opts.Member == "" {
+		opts.Member = uniqueConsumerTag()
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 5 * time.Second
+	}
+	if opts.LeaseTTL <= 0 {
+		opts.LeaseTTL = 3 * opts.Interval
+	}
+
+	g := &ConsumerGroup{
+		source:    source,
+		opts:      opts,
+		consumers: make(map[string]groupConsumer),
+		done:      make(chan struct{}),
+	}
+	g.consume = g.startConsumer
+	return g
+}
+
+func (g *ConsumerGroup) startConsumer(queue string) (groupConsumer, error) {
+	opts := g.opts.Consumer
+	opts.Queue = queue
+
+	c := NewConsumer(g.source, opts)
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Member returns the id of this member of the group.
+func (g *ConsumerGroup) Member() string {
+	return g.opts.Member
+}
+
+// Start joins the group and balances the queues in the background.  It
+// returns an error when the group has no lease store or was already started.
+func (g *ConsumerGroup) Start() error {
+	if g.opts.Store == nil {
+		return errors.New("consumer group lease store is required")
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	if g.started {
+		return errors.New("consumer group already started")
+	}
+	g.started = true
+
+	ctx, stop := context.WithCancel(context.Background())
+	g.stop = stop
+
+	go func() {
+		defer close(g.done)
+
+		ticker := time.NewTicker(g.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			g.balance(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// Assigned returns the queues consumed by this member, sorted.
+func (g *ConsumerGroup) Assigned() []string {
+	g.m.Lock()
+	defer g.m.Unlock()
+	return g.assigned()
+}
+
+// assigned returns the queues consumed by this member, g.m must be held.
+func (g *ConsumerGroup) assigned() []string {
+	queues := make([]string, 0, len(g.consumers))
+	for queue := range g.consumers {
+		queues = append(queues, queue)
+	}
+	sort.Strings(queues)
+	return queues
+}
+
+func (g *ConsumerGroup) memberKey(member string) string {
+	return g.opts.Group + "/members/" + member
+}
+
+func (g *ConsumerGroup) queueKey(queue string) string {
+	return g.opts.Group + "/queues/" + queue
+}
+
+// balance renews the leases of this member and moves the queues that should
+// be consumed by other members.
+func (g *ConsumerGroup) balance(ctx context.Context) {
+	store, member, ttl := g.opts.Store, g.opts.Member, g.opts.LeaseTTL
+
+	if _, err := store.Acquire(ctx, g.memberKey(member), member, ttl); err != nil {
+		Logger.Printf("consumer group %q member %q could not renew its membership: %v", g.opts.Group, member, err)
+		return
+	}
+
+	leases, err := store.Leases(ctx, g.opts.Group+"/members/")
+	if err != nil {
+		Logger.Printf("consumer group %q member %q could not list the members: %v", g.opts.Group, member, err)
+		return
+	}
+	members := []string{member}
+	for _, holder := range leases {
+		if holder != member {
+			members = append(members, holder)
+		}
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	before := strings.Join(g.assigned(), "\x00")
+
+	for queue, c := range g.consumers {
+		if owner(queue, members) != member {
+			g.release(ctx, queue, c)
+			continue
+		}
+		ok, err := store.Acquire(ctx, g.queueKey(queue), member, ttl)
+		if err != nil {
+			// The lease may expire and the queue be taken by another
+			// member: stop consuming it first.
+			Logger.Printf("consumer group %q member %q could not renew the lease of queue %q: %v", g.opts.Group, member, queue, err)
+		}
+		if err != nil || !ok {
+			g.release(ctx, queue, c)
+		}
+	}
+
+	for _, queue := range g.opts.Queues {
+		if _, ok := g.consumers[queue]; ok || owner(queue, members) != member {
+			continue
+		}
+
+		// The previous owner releases the lease once it stopped consuming.
+		ok, err := store.Acquire(ctx, g.queueKey(queue), member, ttl)
+		if err != nil || !ok {
+			continue
+		}
+
+		c, err := g.consume(queue)
+		if err != nil {
+			Logger.Printf("consumer group %q member %q could not consume queue %q: %v", g.opts.Group, member, queue, err)
+			_ = store.Release(ctx, g.queueKey(queue), member)
+			continue
+		}
+		g.consumers[queue] = c
+	}
+
+	if after := g.assigned(); strings.Join(after, "\x00") != before && g.opts.OnAssign != nil {
+		g.opts.OnAssign(after)
+	}
+}
+
+// release stops consuming a queue and releases its lease, g.m must be held.
+func (g *ConsumerGroup) release(ctx context.Context, queue string, c groupConsumer) {
+	stopCtx, cancel := context.WithTimeout(context.Background(), g.opts.LeaseTTL)
+	defer cancel()
+
+	if err := c.Stop(stopCtx); err != nil {
+		Logger.Printf("consumer group %q member %q could not stop consuming queue %q: %v", g.opts.Group, g.opts.Member, queue, err)
+	}
+	delete(g.consumers, queue)
+
+	if err := g.opts.Store.Release(ctx, g.queueKey(queue), g.opts.Member); err != nil {
+		Logger.Printf("consumer group %q member %q could not release queue %q: %v", g.opts.Group, g.opts.Member, queue, err)
+	}
+}
+
+// owner returns the member that should consume queue, the one with the
+// highest hash of the pair, so that only the queues of a member that joins
+// or leaves move.
+func owner(queue string, members []string) string {
+	var best string
+	var bestHash uint64
+	for _, member := range members {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(member))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(queue))
+		if sum := mix64(h.Sum64()); best == "" || sum > bestHash || sum == bestHash && member < best {
+			best, bestHash = member, sum
+		}
+	}
+	return best
+}
+
+// mix64 is the finalizer of SplitMix64, FNV-1a alone hardly spreads keys
+// differing in a few bytes.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// Stop leaves the group: it stops the consumers of this member as
+// Consumer.Stop, then releases its leases so that the other members take
+// over its queues right away.
+func (g *ConsumerGroup) Stop(ctx context.Context) error {
+	g.m.Lock()
+	if !g.started {
+		g.m.Unlock()
+		return nil
+	}
+	g.stop()
+	g.m.Unlock()
+
+	select {
+	case <-g.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	g.m.Lock()
+	defer g.m.Unlock()
+
+	var errs []error
+	for queue, c := range g.consumers {
+		if err := c.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+		delete(g.consumers, queue)
+		if err := g.opts.Store.Release(ctx, g.queueKey(queue), g.opts.Member); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := g.opts.Store.Release(ctx, g.memberKey(g.opts.Member), g.opts.Member); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// MemoryLeaseStore is a LeaseStore in memory, for the consumer groups of a
+// single process and for tests.
+type MemoryLeaseStore struct {
+	now func() time.Time
+
+	m      sync.Mutex
+	leases map[string]memoryLease
+}
+
+type memoryLease struct {
+	holder  string
+	expires time.Time
+}
+
+// NewMemoryLeaseStore returns an empty MemoryLeaseStore.
+func NewMemoryLeaseStore() *MemoryLeaseStore {
+	return &MemoryLeaseStore{now: time.Now, leases: make(map[string]memoryLease)}
+}
+
+// Acquire implements LeaseStore.
+func (s *MemoryLeaseStore) Acquire(_ context.Context, key, holder string, ttl time.Duration) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := s.now()
+	if l, ok := s.leases[key]; ok && l.holder != holder && now.Before(l.expires) {
+		return false, nil
+	}
+	s.leases[key] = memoryLease{holder: holder, expires: now.Add(ttl)}
+	return true, nil
+}
+
+// Release implements LeaseStore.
+func (s *MemoryLeaseStore) Release(_ context.Context, key, holder string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if l, ok := s.leases[key]; ok && l.holder == holder {
+		delete(s.leases, key)
+	}
+	return nil
+}
+
+// Leases implements LeaseStore.
+func (s *MemoryLeaseStore) Leases(_ context.Context, prefix string) (map[string]string, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := s.now()
+	holders := make(map[string]string)
+	for key, l := range s.leases {
+		if strings.HasPrefix(key, prefix) && now.Before(l.expires) {
+			holders[key] = l.holder
+		}
+	}
+	return holders, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGroupConsumers records the queues consumed by the members of a group,
+// failing the test when a queue is consumed by two members.
+type fakeGroupConsumers struct {
+	t      *testing.T
+	m      sync.Mutex
+	owners map[string]string
+}
+
+type fakeGroupConsumer struct {
+	consumers *fakeGroupConsumers
+	queue     string
+}
+
+func (f *fakeGroupConsumers) join(g *ConsumerGroup) {
+	g.consume = func(queue string) (groupConsumer, error) {
+		f.m.Lock()
+		defer f.m.Unlock()
+		if owner, ok := f.owners[queue]; ok {
+			f.t.Errorf("queue %q consumed by %q and %q", queue, owner, g.Member())
+		}
+		f.owners[queue] = g.Member()
+		return &fakeGroupConsumer{consumers: f, queue: queue}, nil
+	}
+}
+
+func (c *fakeGroupConsumer) Stop(context.Context) error {
+	c.consumers.m.Lock()
+	defer c.consumers.m.Unlock()
+	delete(c.consumers.owners, c.queue)
+	return nil
+}
+
+func TestConsumerGroupRebalancesOnMembershipChange(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryLeaseStore()
+	queues := []string{"events.0", "events.1", "events.2", "events.3", "events.4", "events.5"}
+	consumers := &fakeGroupConsumers{t: t, owners: make(map[string]string)}
+
+	member := func(name string) *ConsumerGroup {
+		g := NewConsumerGroup(nil, ConsumerGroupOptions{Group: "billing", Member: name, Queues: queues, Store: store, Interval: time.Hour})
+		consumers.join(g)
+		return g
+	}
+
+	a := member("a")
+	var assigned []string
+	a.opts.OnAssign = func(queues []string) { assigned = queues }
+
+	a.balance(ctx)
+	if !reflect.DeepEqual(a.Assigned(), queues) || !reflect.DeepEqual(assigned, queues) {
+		t.Fatalf("expected the single member to consume every queue, got %v", a.Assigned())
+	}
+
+	// b joins: a gives up the queues of b before b takes them.
+	b := member("b")
+	b.balance(ctx)
+	if len(b.Assigned()) != 0 {
+		t.Fatalf("expected b to wait for a to release its queues, got %v", b.Assigned())
+	}
+	a.balance(ctx)
+	b.balance(ctx)
+
+	all := append(a.Assigned(), b.Assigned()...)
+	sort.Strings(all)
+	if !reflect.DeepEqual(all, queues) || len(a.Assigned()) == 0 || len(b.Assigned()) == 0 {
+		t.Fatalf("expected the queues to be shared, got %v and %v", a.Assigned(), b.Assigned())
+	}
+
+	// b leaves: a takes its queues back.
+	b.m.Lock()
+	b.started = true
+	b.stop = func() {}
+	close(b.done)
+	b.m.Unlock()
+	if err := b.Stop(ctx); err != nil {
+		t.Fatalf("could not stop b: %v", err)
+	}
+	a.balance(ctx)
+	if !reflect.DeepEqual(a.Assigned(), queues) {
+		t.Fatalf("expected a to consume every queue after b left, got %v", a.Assigned())
+	}
+}
+
+func TestMemoryLeaseStoreExpires(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	s := NewMemoryLeaseStore()
+	s.now = func() time.Time { return now }
+
+	if ok, _ := s.Acquire(ctx, "q", "a", time.Minute); !ok {
+		t.Fatalf("expected a to acquire the free lease")
+	}
+	if ok, _ := s.Acquire(ctx, "q", "b", time.Minute); ok {
+		t.Fatalf("expected b not to acquire the lease of a")
+	}
+
+	now = now.Add(time.Minute)
+	if leases, _ := s.Leases(ctx, ""); len(leases) != 0 {
+		t.Fatalf("expected the expired lease not to be listed, got %v", leases)
+	}
+	if ok, _ := s.Acquire(ctx, "q", "b", time.Minute); !ok {
+		t.Fatalf("expected b to acquire the expired lease")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (t *server) consumerStart(id int, tag string, deliveryTag uint64) {
+	t.channelOpen(id)
+	t.recv(id, &basicQos{})
+	t.send(id, &basicQosOk{})
+
+	req := &basicConsume{}
+	t.recv(id, req)
+	if req.ConsumerTag != tag {
+		t.Errorf("expected consumer tag %q, got %q", tag, req.ConsumerTag)
+	}
+	t.send(id, &basicConsumeOk{ConsumerTag: tag})
+	t.send(id, &basicDeliver{ConsumerTag: tag, DeliveryTag: deliveryTag})
+}
+
+func TestConsumerRestartsAfterChannelClose(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		srv.connectionOpen()
+
+		srv.consumerStart(1, "worker", 1)
+		srv.recv(1, &basicAck{})
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "internal"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.consumerStart(2, "worker", 1)
+		srv.recv(2, &basicReject{})
+
+		srv.recv(2, &basicCancel{})
+		srv.send(2, &basicCancelOk{ConsumerTag: "worker"})
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	handled := make(chan struct{}, 2)
+	calls := 0
+	consumer := NewConsumer(c, ConsumerOptions{
+		Queue:     "jobs",
+		Tag:       "worker",
+		BaseDelay: time.Millisecond,
+		Handler: func(Delivery) error {
+			defer func() { handled <- struct{}{} }()
+			calls++
+			if calls == 2 {
+				return errors.New("failed")
+			}
+			return nil
+		},
+	})
+
+	if err := consumer.Start(); err != nil {
+		t.Fatalf("could not start consumer: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-handled:
+		case <-time.After(time.Second):
+			t.Fatalf("expected delivery %d to be handled", i+1)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := consumer.Stop(ctx); err != nil {
+		t.Fatalf("could not stop consumer: %v", err)
+	}
+
+	stats := consumer.Stats()
+	if stats.Delivered != 2 || stats.Succeeded != 1 || stats.Failed != 1 || stats.Restarts != 1 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-done
+}
+
+func TestConsumerRequiresHandler(t *testing.T) {
+	if err := NewConsumer(nil, ConsumerOptions{Queue: "jobs"}).Start(); err == nil {
+		t.Fatalf("expected an error without handler")
+	}
+}
+
+func TestConsumerRedeclaresDeletedQueue(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		srv.connectionOpen()
+
+		srv.channelOpen(1)
+		srv.recv(1, &basicQos{})
+		srv.send(1, &basicQosOk{})
+		srv.recv(1, &basicConsume{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'jobs'"})
+		srv.recv(1, &channelCloseOk{})
+
+		// Topology.Apply: declaring channel, then probe channel.
+		srv.channelOpen(2)
+		srv.channelOpen(3)
+		srv.recv(3, &queueDeclare{})
+		srv.send(3, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'jobs'"})
+		srv.recv(3, &channelCloseOk{})
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &queueDeclareOk{Queue: "jobs"})
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+
+		srv.consumerStart(4, "worker", 1)
+		srv.recv(4, &basicAck{})
+
+		srv.recv(4, &basicCancel{})
+		srv.send(4, &basicCancelOk{ConsumerTag: "worker"})
+		srv.recv(4, &channelClose{})
+		srv.send(4, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	handled := make(chan struct{}, 1)
+	consumer := NewConsumer(c, ConsumerOptions{
+		Queue: "jobs",
+		Tag:   "worker",
+		// The restart after redeclaring is not delayed.
+		BaseDelay: time.Hour,
+		Redeclare: &Topology{Queues: []QueueSpec{{Name: "jobs", Durable: true}}},
+		Handler: func(Delivery) error {
+			handled <- struct{}{}
+			return nil
+		},
+	})
+
+	if err := consumer.Start(); err != nil {
+		t.Fatalf("could not start consumer: %v", err)
+	}
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the delivery to be handled after redeclaring the queue")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := consumer.Stop(ctx); err != nil {
+		t.Fatalf("could not stop consumer: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-done
+}
+
+func TestConsumerUpdateOptions(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		srv.connectionOpen()
+
+		srv.consumerStart(1, "worker", 1)
+		srv.recv(1, &basicAck{})
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: "worker"})
+
+		qos := &basicQos{}
+		srv.recv(1, qos)
+		if qos.PrefetchCount != 4 {
+			t.Errorf("expected the new prefetch of 4, got %d", qos.PrefetchCount)
+		}
+		srv.send(1, &basicQosOk{})
+
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		if want, got := int32(5), consume.Arguments[ConsumerPriorityArg]; want != got {
+			t.Errorf("expected the new priority %d, got %v", want, got)
+		}
+		srv.send(1, &basicConsumeOk{ConsumerTag: "worker"})
+		srv.send(1, &basicDeliver{ConsumerTag: "worker", DeliveryTag: 2})
+
+		ack := &basicAck{}
+		srv.recv(1, ack)
+		if ack.DeliveryTag != 2 {
+			t.Errorf("expected the delivery of the new consumer to be acked, got %d", ack.DeliveryTag)
+		}
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: "worker"})
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	handled := make(chan struct{}, 2)
+	consumer := NewConsumer(c, ConsumerOptions{
+		Queue:   "jobs",
+		Tag:     "worker",
+		Handler: func(Delivery) error { handled <- struct{}{}; return nil },
+	})
+	if err := consumer.Start(); err != nil {
+		t.Fatalf("could not start consumer: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	<-handled
+	if err := consumer.UpdateOptions(ctx, ConsumerUpdate{Prefetch: 4, ConsumeOptions: []ConsumeOption{ConsumerPriority(5)}}); err != nil {
+		t.Fatalf("could not update the consumer: %v", err)
+	}
+
+	select {
+	case <-handled:
+	case <-ctx.Done():
+		t.Fatalf("expected the restarted consumer to handle deliveries")
+	}
+
+	if err := consumer.Stop(ctx); err != nil {
+		t.Fatalf("could not stop consumer: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-done
+
+	if restarts := consumer.Stats().Restarts; restarts != 0 {
+		t.Fatalf("expected the update not to count as a restart, got %d", restarts)
+	}
+}
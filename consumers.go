@@ -6,10 +6,12 @@
 package amqp091
 
 import (
+	"context"
 	"os"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var consumerSeq uint64
@@ -34,44 +36,97 @@ func commandNameBasedUniqueConsumerTag(commandName string) string {
 
 type consumerBuffers map[string]chan *Delivery
 
+// overflow limits the deliveries buffered for a consumer, see
+// ConsumeBacklogLimit.  The zero value buffers without limit.
+type overflow struct {
+	max  int            // maximum number of buffered deliveries, 0 for no limit
+	drop func(Delivery) // called with deliveries arriving when full, nil to block
+}
+
 // Concurrent type that manages the consumerTag ->
 // ingress consumerBuffer mapping
 type consumers struct {
 	sync.WaitGroup               // one for buffer
 	closed         chan struct{} // signal buffer
+	stopped        sync.Once     // closes closed
 
 	sync.Mutex // protects below
 	chans      consumerBuffers
+	backlogs   map[string]*backlog
+	lifetimes  map[string]consumerLifetime
+	requests   map[string]*basicConsume // to consume again, see Channel.SetAutoReopen
+}
+
+// consumerLifetime is the context of the deliveries of a consumer, cancelled
+// when the consumer goes away.
+type consumerLifetime struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 func makeConsumers() *consumers {
 	return &consumers{
-		closed: make(chan struct{}),
-		chans:  make(consumerBuffers),
+		closed:    make(chan struct{}),
+		chans:     make(consumerBuffers),
+		backlogs:  make(map[string]*backlog),
+		lifetimes: make(map[string]consumerLifetime),
+		requests:  make(map[string]*basicConsume),
 	}
 }
 
-func (subs *consumers) buffer(in chan *Delivery, out chan Delivery) {
+// buffer hands the deliveries received on in to out, buffering them until
+// the consumer reads them.  done is closed when the consumer is cancelled: the
+// buffered deliveries are still handed over, then out is closed.  in is never
+// closed, so that send can block on it without holding the lock.
+func (subs *consumers) buffer(in chan *Delivery, done <-chan struct{}, out chan Delivery, pending *backlog, limit overflow) {
 	defer close(out)
 	defer subs.Done()
 
 	inflight := in
 	var queue []*Delivery
+	var arrivals []time.Time
 
-	for delivery := range in {
-		queue = append(queue, delivery)
+	for {
+		select {
+		case <-subs.closed:
+			return
+
+		case <-done:
+			return
+
+		case delivery := <-in:
+			queue = append(queue, delivery)
+			arrivals = append(arrivals, time.Now())
+			pending.set(len(queue), arrivals[0])
+		}
 
 		for len(queue) > 0 {
+			full := limit.max > 0 && len(queue) >= limit.max
+
+			// Stop receiving when full and blocking, which blocks the
+			// connection reader until the consumer catches up.
+			recv := inflight
+			if full && limit.drop == nil {
+				recv = nil
+			}
+
 			select {
 			case <-subs.closed:
 				// closed before drained, drop in-flight
 				return
 
-			case delivery, consuming := <-inflight:
-				if consuming {
-					queue = append(queue, delivery)
+			case <-done:
+				// cancelled, hand over what is buffered
+				inflight = nil
+				done = nil
+
+			case delivery := <-recv:
+				if full {
+					limit.drop(*delivery)
 				} else {
-					inflight = nil
+					queue = append(queue, delivery)
+					arrivals = append(arrivals, time.Now())
+					pending.set(len(queue), arrivals[0])
 				}
 
 			case out <- *queue[0]:
@@ -103,50 +158,81 @@ func (subs *consumers) buffer(in chan *Delivery, out chan Delivery) {
 				 */
 				queue[0] = nil
 				queue = queue[1:]
+				arrivals = arrivals[1:]
+				if len(arrivals) > 0 {
+					pending.set(len(queue), arrivals[0])
+				} else {
+					pending.set(0, time.Time{})
+				}
 			}
 		}
+
+		if inflight == nil {
+			// cancelled and drained
+			return
+		}
 	}
 }
 
-// On key conflict, close the previous channel.
-func (subs *consumers) add(tag string, consumer chan Delivery) {
+// On key conflict, cancel the previous consumer.
+func (subs *consumers) add(tag string, consumer chan Delivery, limit overflow) {
 	subs.Lock()
 	defer subs.Unlock()
 
-	if prev, found := subs.chans[tag]; found {
-		close(prev)
+	if _, found := subs.chans[tag]; found {
+		subs.lifetimes[tag].cancel()
 	}
 
+	ctx, cancel := context.WithCancel(context.Background())
+	subs.lifetimes[tag] = consumerLifetime{ctx, cancel}
+
 	in := make(chan *Delivery)
 	subs.chans[tag] = in
 
+	pending := &backlog{}
+	subs.backlogs[tag] = pending
+
 	subs.Add(1)
-	go subs.buffer(in, consumer)
+	go subs.buffer(in, ctx.Done(), consumer, pending, limit)
 }
 
 func (subs *consumers) cancel(tag string) (found bool) {
 	subs.Lock()
 	defer subs.Unlock()
 
-	ch, found := subs.chans[tag]
+	_, found = subs.chans[tag]
 
 	if found {
 		delete(subs.chans, tag)
-		close(ch)
+		delete(subs.backlogs, tag)
+		delete(subs.requests, tag)
+		subs.lifetimes[tag].cancel()
+		delete(subs.lifetimes, tag)
 	}
 
 	return found
 }
 
+// stop drops the deliveries buffered and in flight, and closes the chan
+// Delivery of every consumer, without waiting for the lock.  A send blocked on
+// a full backlog returns, so that the connection reader can read the reply to
+// a closing channel or connection.
+func (subs *consumers) stop() {
+	subs.stopped.Do(func() { close(subs.closed) })
+}
+
 func (subs *consumers) close() {
 	subs.Lock()
 	defer subs.Unlock()
 
-	close(subs.closed)
+	subs.stop()
 
-	for tag, ch := range subs.chans {
+	for tag := range subs.chans {
 		delete(subs.chans, tag)
-		close(ch)
+		delete(subs.backlogs, tag)
+		delete(subs.requests, tag)
+		subs.lifetimes[tag].cancel()
+		delete(subs.lifetimes, tag)
 	}
 
 	subs.Wait()
@@ -155,15 +241,25 @@ func (subs *consumers) close() {
 // Sends a delivery to a the consumer identified by `tag`.
 // If unbuffered channels are used for Consume this method
 // could block all deliveries until the consumer
-// receives on the other end of the channel.
+// receives on the other end of the channel.  It blocks without holding the
+// lock, so that the consumer can be cancelled or closed meanwhile, which
+// drops the delivery.
 func (subs *consumers) send(tag string, msg *Delivery) bool {
 	subs.Lock()
-	defer subs.Unlock()
-
 	buffer, found := subs.chans[tag]
-	if found {
-		buffer <- msg
+	lifetime := subs.lifetimes[tag]
+	subs.Unlock()
+
+	if !found {
+		return false
 	}
 
-	return found
+	msg.ctx = lifetime.ctx
+	select {
+	case buffer <- msg:
+	case <-lifetime.ctx.Done():
+	case <-subs.closed:
+	}
+
+	return true
 }
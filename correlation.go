@@ -0,0 +1,172 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// DirectReplyTo is the pseudo-queue of the RabbitMQ direct reply-to feature.
+// Consuming from it in no-ack mode lets a channel receive the replies to the
+// requests it publishes with this ReplyTo, without declaring a reply queue.
+const DirectReplyTo = "amq.rabbitmq.reply-to"
+
+var correlatorSeq uint64
+
+/*
+Correlator matches replies to the requests waiting for them by CorrelationId,
+for request/reply over AMQP.
+
+Each call registered with Add gets a unique correlation id.  The replies,
+consumed either from DirectReplyTo or from an explicit callback queue, are
+passed to Dispatch, or consumed by ConsumeReplies, and handed to the call with
+the same correlation id.  Replies for which no call is waiting, because the call
+timed out or the reply is a duplicate, are orphans and passed to the orphan
+handler.
+
+	c := amqp.NewCorrelator(nil)
+	if err := c.ConsumeReplies(ctx, ch, amqp.DirectReplyTo); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	reply, err := c.Call(ctx, ch, "", "rpc_queue", amqp.DirectReplyTo, amqp.Publishing{Body: request})
+
+A Correlator is safe for concurrent use.
+*/
+type Correlator struct {
+	prefix   string
+	seq      uint64
+	onOrphan func(Delivery)
+
+	m       sync.Mutex
+	pending map[string]chan Delivery
+}
+
+// NewCorrelator returns a Correlator calling onOrphan with the replies no
+// call is waiting for.  When onOrphan is nil, orphans are logged with Logger.
+func NewCorrelator(onOrphan func(Delivery)) *Correlator {
+	if onOrphan == nil {
+		onOrphan = func(d Delivery) {
+			Logger.Printf("dropping orphaned reply with correlation id %q", d.CorrelationId)
+		}
+	}
+
+	return &Correlator{
+		prefix:   "corr-" + strconv.FormatUint(atomic.AddUint64(&correlatorSeq, 1), 10) + "-",
+		onOrphan: onOrphan,
+		pending:  make(map[string]chan Delivery),
+	}
+}
+
+// PendingCall is a call waiting for its reply, see Correlator.Add.
+type PendingCall struct {
+	id    string
+	c     *Correlator
+	reply chan Delivery
+}
+
+// Add registers a new call.  Set the CorrelationId of the request to the id
+// of the call, and Wait for the reply.
+func (c *Correlator) Add() *PendingCall {
+	call := &PendingCall{
+		id:    c.prefix + strconv.FormatUint(atomic.AddUint64(&c.seq, 1), 10),
+		c:     c,
+		reply: make(chan Delivery, 1),
+	}
+
+	c.m.Lock()
+	c.pending[call.id] = call.reply
+	c.m.Unlock()
+
+	return call
+}
+
+// CorrelationId returns the correlation id of the call.
+func (p *PendingCall) CorrelationId() string {
+	return p.id
+}
+
+// Wait returns the reply to the call, or the error of ctx when it is done
+// first.  The call is unregistered either way, so a late reply is an orphan.
+func (p *PendingCall) Wait(ctx context.Context) (Delivery, error) {
+	defer p.Cancel()
+
+	select {
+	case d := <-p.reply:
+		return d, nil
+	case <-ctx.Done():
+		return Delivery{}, ctx.Err()
+	}
+}
+
+// Cancel unregisters the call, its reply becomes an orphan.
+func (p *PendingCall) Cancel() {
+	p.c.m.Lock()
+	delete(p.c.pending, p.id)
+	p.c.m.Unlock()
+}
+
+// Dispatch hands a reply to the call with the same correlation id and returns
+// true, or passes it to the orphan handler and returns false.
+func (c *Correlator) Dispatch(d Delivery) bool {
+	c.m.Lock()
+	reply, found := c.pending[d.CorrelationId]
+	delete(c.pending, d.CorrelationId)
+	c.m.Unlock()
+
+	if !found {
+		c.onOrphan(d)
+		return false
+	}
+
+	reply <- d
+	return true
+}
+
+// Pending returns the number of calls waiting for their reply.
+func (c *Correlator) Pending() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.pending)
+}
+
+// ConsumeReplies consumes the replies from queue in no-ack mode and
+// dispatches them until ctx is done or the channel is closed.  Use
+// DirectReplyTo as queue for direct reply-to, in which case the requests must
+// be published on the same channel.
+func (c *Correlator) ConsumeReplies(ctx context.Context, ch *Channel, queue string) error {
+	replies, err := ch.ConsumeWithContext(ctx, queue, "", true, false, false, false, nil)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for d := range replies {
+			c.Dispatch(d)
+		}
+	}()
+
+	return nil
+}
+
+// Call publishes a request with a new correlation id and replyTo, and waits
+// for the reply until ctx is done.
+func (c *Correlator) Call(ctx context.Context, ch *Channel, exchange, key, replyTo string, msg Publishing) (Delivery, error) {
+	call := c.Add()
+	msg.CorrelationId = call.CorrelationId()
+	msg.ReplyTo = replyTo
+
+	if err := ch.PublishWithContext(ctx, exchange, key, false, false, msg); err != nil {
+		call.Cancel()
+		return Delivery{}, err
+	}
+
+	return call.Wait(ctx)
+}
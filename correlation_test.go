@@ -0,0 +1,107 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCorrelatorDispatch(t *testing.T) {
+	var orphans []Delivery
+	c := NewCorrelator(func(d Delivery) { orphans = append(orphans, d) })
+
+	first := c.Add()
+	second := c.Add()
+	if first.CorrelationId() == second.CorrelationId() {
+		t.Fatalf("expected unique correlation ids, got %q twice", first.CorrelationId())
+	}
+
+	if !c.Dispatch(Delivery{CorrelationId: second.CorrelationId(), Body: []byte("second")}) {
+		t.Fatalf("expected the reply to be dispatched")
+	}
+
+	reply, err := second.Wait(context.Background())
+	if err != nil || string(reply.Body) != "second" {
+		t.Fatalf("expected the second reply, got %q (%v)", reply.Body, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := first.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected the call to time out, got: %v", err)
+	}
+
+	if c.Dispatch(Delivery{CorrelationId: first.CorrelationId()}) {
+		t.Fatalf("expected a late reply to be an orphan")
+	}
+	if c.Dispatch(Delivery{CorrelationId: "unknown"}) {
+		t.Fatalf("expected an unknown reply to be an orphan")
+	}
+
+	if len(orphans) != 2 || c.Pending() != 0 {
+		t.Fatalf("expected 2 orphans and no pending call, got %d orphans and %d pending", len(orphans), c.Pending())
+	}
+}
+
+func TestCorrelatorCallWithDirectReplyTo(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		if consume.Queue != DirectReplyTo || !consume.NoAck {
+			t.Errorf("expected a no-ack consumer of %s, got %+v", DirectReplyTo, consume)
+		}
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+
+		request := srv.recv(1, &basicPublish{}).(*basicPublish)
+		if request.Properties.ReplyTo != DirectReplyTo {
+			t.Errorf("expected the request to be replied to %s, got %q", DirectReplyTo, request.Properties.ReplyTo)
+		}
+
+		srv.send(1, &basicDeliver{
+			ConsumerTag: consume.ConsumerTag,
+			DeliveryTag: 1,
+			Properties:  properties{CorrelationId: request.Properties.CorrelationId},
+			Body:        []byte("pong"),
+		})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	correlator := NewCorrelator(nil)
+	if err := correlator.ConsumeReplies(ctx, ch, DirectReplyTo); err != nil {
+		t.Fatalf("could not consume replies: %v", err)
+	}
+
+	reply, err := correlator.Call(ctx, ch, "", "rpc", DirectReplyTo, Publishing{Body: []byte("ping")})
+	if err != nil || string(reply.Body) != "pong" {
+		t.Fatalf("expected the pong reply, got %q (%v)", reply.Body, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCredentialsMargin     = time.Minute
+	defaultCredentialsRetryDelay = 5 * time.Second
+)
+
+// Credentials are a username and a password, or a secret like an OAuth 2
+// access token, valid until Expiry.  A zero Expiry never expires.
+type Credentials struct {
+	Username string
+	Password string
+	Expiry   time.Time
+}
+
+// CredentialsProvider returns the current credentials of a connection, see
+// Config.CredentialsProvider.  It typically fetches an OAuth 2 access token
+// from an identity provider.
+type CredentialsProvider func(ctx context.Context) (Credentials, error)
+
+// CredentialsRefresh configures how a connection refreshes the credentials of
+// its Config.CredentialsProvider.
+type CredentialsRefresh struct {
+	// Margin is how long before their expiry the credentials are refreshed,
+	// 1 minute when zero.  It is capped at half the lifetime of the
+	// credentials.
+	Margin time.Duration
+
+	// RetryDelay is the delay before retrying a failed refresh, 5 seconds
+	// when zero.  Failed refreshes are retried until they succeed or the
+	// connection is closed, by the server once the credentials expired.
+	RetryDelay time.Duration
+
+	// OnError, when set, is called with the error of each failed refresh,
+	// from the provider or from Connection.UpdateSecret.
+	OnError func(err error)
+}
+
+// credentialsRefresher keeps the secret of a connection up to date.
+type credentialsRefresher struct {
+	provider CredentialsProvider
+	refresh  CredentialsRefresh
+
+	m         sync.Mutex
+	refreshAt time.Time     // zero when the credentials never expire
+	renewed   chan struct{} // signalled when refreshAt changes
+}
+
+func newCredentialsRefresher(provider CredentialsProvider, refresh CredentialsRefresh) *credentialsRefresher {
+	if refresh.Margin <= 0 {
+		refresh.Margin = defaultCredentialsMargin
+	}
+	if refresh.RetryDelay <= 0 {
+		refresh.RetryDelay = defaultCredentialsRetryDelay
+	}
+	return &credentialsRefresher{
+		provider: provider,
+		refresh:  refresh,
+		renewed:  make(chan struct{}, 1),
+	}
+}
+
+// fetch returns the current credentials of the provider.
+func (r *credentialsRefresher) fetch(ctx context.Context) (Credentials, error) {
+	creds, err := r.provider(ctx)
+	if err != nil {
+		return creds, fmt.Errorf("get credentials: %w", err)
+	}
+	return creds, nil
+}
+
+// schedule sets the time of the next refresh for credentials expiring at
+// expiry.
+func (r *credentialsRefresher) schedule(expiry time.Time) {
+	var refreshAt time.Time
+	if !expiry.IsZero() {
+		margin := r.refresh.Margin
+		if half := time.Until(expiry) / 2; half < margin {
+			margin = half
+		}
+		refreshAt = expiry.Add(-margin)
+	}
+
+	r.m.Lock()
+	r.refreshAt = refreshAt
+	r.m.Unlock()
+}
+
+// sasl returns the mechanism authenticating a new connection with the current
+// credentials, and schedules their refresh.
+func (r *credentialsRefresher) sasl(ctx context.Context) ([]Authentication, error) {
+	creds, err := r.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.schedule(creds.Expiry)
+
+	select {
+	case r.renewed <- struct{}{}:
+	default:
+	}
+	return []Authentication{&PlainAuth{Username: creds.Username, Password: creds.Password}}, nil
+}
+
+// run refreshes the secret of c before it expires, until ctx is done.
+func (r *credentialsRefresher) run(ctx context.Context, c *Connection) {
+	var notBefore time.Time
+	for {
+		r.m.Lock()
+		refreshAt := r.refreshAt
+		r.m.Unlock()
+
+		var timer *time.Timer
+		var due <-chan time.Time
+		if !refreshAt.IsZero() {
+			if refreshAt.Before(notBefore) {
+				refreshAt = notBefore
+			}
+			timer = time.NewTimer(time.Until(refreshAt))
+			due = timer.C
+		}
+
+		refreshNow := false
+		select {
+		case <-ctx.Done():
+		case <-r.renewed:
+			// Authenticated again by a recovery of the connection.
+			notBefore = time.Time{}
+		case <-due:
+			refreshNow = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		if !refreshNow {
+			continue
+		}
+
+		creds, err := r.fetch(ctx)
+		if err == nil {
+			err = c.UpdateSecret(creds.Password, "credentials refreshed")
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			Logger.Printf("could not refresh the credentials of the connection: %v", err)
+			if r.refresh.OnError != nil {
+				r.refresh.OnError(err)
+			}
+			notBefore = time.Now().Add(r.refresh.RetryDelay)
+			continue
+		}
+
+		r.schedule(creds.Expiry)
+		notBefore = time.Time{}
+	}
+}
+
+// refreshCredentials starts refreshing the secret of c with r until c is
+// closed for good.
+func (c *Connection) refreshCredentials(r *credentialsRefresher) {
+	c.m.Lock()
+	c.credentials = r
+	c.m.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	closed := c.NotifyClose(make(chan *Error, 1))
+	go func() {
+		<-closed
+		cancel()
+	}()
+	go r.run(ctx, c)
+}
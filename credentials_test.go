@@ -0,0 +1,191 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// tokenProvider returns the tokens "token-1", "token-2"... valid for ttl, or
+// forever when zero, or the next of errs while there are some left after the
+// first token.
+type tokenProvider struct {
+	m     sync.Mutex
+	ttl   time.Duration
+	calls int
+	errs  []error
+}
+
+func (p *tokenProvider) credentials(context.Context) (Credentials, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.calls++
+	if p.calls > 1 && len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return Credentials{}, err
+	}
+	creds := Credentials{Username: "app", Password: fmt.Sprintf("token-%d", p.calls)}
+	if p.ttl > 0 {
+		creds.Expiry = time.Now().Add(p.ttl)
+	}
+	return creds, nil
+}
+
+func credentialsDialer(t *testing.T, serve func(srv *server)) (func(network, addr string) (net.Conn, error), <-chan struct{}) {
+	served := make(chan struct{})
+	return func(network, addr string) (net.Conn, error) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+		srv := newServer(t, server, client)
+		go func() {
+			defer close(served)
+			serve(srv)
+		}()
+		return client, nil
+	}, served
+}
+
+func TestCredentialsProviderRefreshesSecret(t *testing.T) {
+	update := &connectionUpdateSecret{}
+	updated := make(chan struct{})
+	dial, served := credentialsDialer(t, func(srv *server) {
+		srv.connectionOpen()
+		srv.recv(0, update)
+		srv.send(0, &connectionUpdateSecretOk{})
+		close(updated)
+		srv.connectionClose()
+	})
+
+	provider := &tokenProvider{ttl: 200 * time.Millisecond}
+	c, err := DialConfig("amqp://localhost", Config{
+		Dial:                dial,
+		CredentialsProvider: provider.credentials,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	if auth, ok := c.Config.SASL[0].(*PlainAuth); !ok || auth.Username != "app" || auth.Password != "token-1" {
+		t.Fatalf("expected to authenticate with the first token, got %v", c.Config.SASL)
+	}
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the token to be refreshed before its expiry")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+
+	if update.NewSecret != "token-2" {
+		t.Fatalf("expected the refreshed token to be sent, got %+v", update)
+	}
+}
+
+func TestCredentialsRefreshReportsErrors(t *testing.T) {
+	update := &connectionUpdateSecret{}
+	updated := make(chan struct{})
+	dial, served := credentialsDialer(t, func(srv *server) {
+		srv.connectionOpen()
+		srv.recv(0, update)
+		srv.send(0, &connectionUpdateSecretOk{})
+		close(updated)
+		srv.connectionClose()
+	})
+
+	failures := make(chan error, 2)
+	provider := &tokenProvider{ttl: 200 * time.Millisecond, errs: []error{errors.New("identity provider down")}}
+	c, err := DialConfig("amqp://localhost", Config{
+		Dial:                dial,
+		CredentialsProvider: provider.credentials,
+		CredentialsRefresh: CredentialsRefresh{
+			RetryDelay: time.Millisecond,
+			OnError:    func(err error) { failures <- err },
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+
+	select {
+	case err := <-failures:
+		if err.Error() != "get credentials: identity provider down" {
+			t.Fatalf("unexpected refresh error: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the failed refresh to be reported")
+	}
+
+	select {
+	case <-updated:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the refresh to be retried")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+
+	if update.NewSecret != "token-3" {
+		t.Fatalf("expected the token of the retried refresh, got %+v", update)
+	}
+}
+
+func TestCredentialsProviderCalledOnRecovery(t *testing.T) {
+	lose := make(chan struct{})
+	starts := make(chan connectionStartOk, 2)
+	dial, served := recoveryDialer(t,
+		func(srv *server) {
+			srv.connectionOpen()
+			starts <- srv.start
+			<-lose
+			srv.S.Close()
+		},
+		func(srv *server) {
+			srv.connectionOpen()
+			starts <- srv.start
+			srv.connectionClose()
+		},
+	)
+
+	provider := &tokenProvider{}
+	c, err := DialConfig("amqp://localhost", Config{
+		Dial:                dial,
+		RecoveryPolicy:      &RecoveryPolicy{BaseDelay: time.Millisecond},
+		CredentialsProvider: provider.credentials,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	reconnects := c.NotifyReconnect(make(chan struct{}, 1))
+
+	close(lose)
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to recover")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	served.Wait()
+
+	for _, want := range []string{"\x00app\x00token-1", "\x00app\x00token-2"} {
+		if start := <-starts; start.Response != want {
+			t.Fatalf("expected the credentials to be fetched for each dial, got %q instead of %q", start.Response, want)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DedupStore records the ids of the messages whose processing completed, so
+// that redelivered messages are not processed twice.  Implementations backed
+// by the database the handler writes to give exactly-once effects when Mark
+// runs in the same transaction as the side effects of the handler.
+type DedupStore interface {
+	// Seen returns true when the processing of the message id completed.
+	Seen(ctx context.Context, id string) (bool, error)
+	// Mark records that the processing of the message id completed.
+	Mark(ctx context.Context, id string) error
+}
+
+// ExpiringDedupStore is a DedupStore that can forget ids after a while, so
+// that it does not grow forever.  HandlerOptions.Dedup uses MarkProcessed
+// when the store implements it.
+type ExpiringDedupStore interface {
+	DedupStore
+	// MarkProcessed records that the processing of the message id completed,
+	// for ttl.
+	MarkProcessed(ctx context.Context, id string, ttl time.Duration) error
+}
+
+// MemoryDedupStore is an ExpiringDedupStore keeping ids in memory.  It
+// protects against redeliveries within a single process, but not across
+// restarts nor between processes consuming the same queue.
+//
+// When it has a capacity, the least recently marked id is forgotten first
+// once the store is full, so the capacity should cover the ids processed in
+// the window in which redeliveries are expected.
+type MemoryDedupStore struct {
+	capacity int // unlimited when zero
+	now      func() time.Time
+
+	m     sync.Mutex
+	order *list.List               // of *dedupEntry, most recently marked first
+	ids   map[string]*list.Element // by id
+}
+
+type dedupEntry struct {
+	id      string
+	expires time.Time // zero when the id never expires
+}
+
+// NewMemoryDedupStore returns an empty MemoryDedupStore without capacity.
+func NewMemoryDedupStore() *MemoryDedupStore {
+	return NewLRUDedupStore(0)
+}
+
+// NewLRUDedupStore returns an empty MemoryDedupStore holding at most
+// capacity ids, unlimited when zero.
+func NewLRUDedupStore(capacity int) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		capacity: capacity,
+		now:      time.Now,
+		order:    list.New(),
+		ids:      make(map[string]*list.Element),
+	}
+}
+
+// Seen implements DedupStore.
+func (s *MemoryDedupStore) Seen(_ context.Context, id string) (bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	e, found := s.ids[id]
+	if !found {
+		return false, nil
+	}
+
+	if expires := e.Value.(*dedupEntry).expires; !expires.IsZero() && !s.now().Before(expires) {
+		s.remove(e)
+		return false, nil
+	}
+	return true, nil
+}
+
+// Mark implements DedupStore, the id does not expire.
+func (s *MemoryDedupStore) Mark(ctx context.Context, id string) error {
+	return s.MarkProcessed(ctx, id, 0)
+}
+
+// MarkProcessed implements ExpiringDedupStore, the id does not expire when
+// ttl is zero.
+func (s *MemoryDedupStore) MarkProcessed(_ context.Context, id string, ttl time.Duration) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var expires time.Time
+	if ttl > 0 {
+		expires = s.now().Add(ttl)
+	}
+
+	if e, found := s.ids[id]; found {
+		e.Value.(*dedupEntry).expires = expires
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.ids[id] = s.order.PushFront(&dedupEntry{id: id, expires: expires})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return nil
+}
+
+// Len returns the number of ids in the store, expired ones included until
+// they are looked up or evicted.
+func (s *MemoryDedupStore) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.order.Len()
+}
+
+// remove forgets an id, s.m must be held.
+func (s *MemoryDedupStore) remove(e *list.Element) {
+	s.order.Remove(e)
+	delete(s.ids, e.Value.(*dedupEntry).id)
+}
+
+// dedupID returns the id under which a delivery is deduplicated, empty when
+// it is not.
+func (opts HandlerOptions) dedupID(d Delivery) string {
+	if opts.DedupKey != nil {
+		return opts.DedupKey(d)
+	}
+	return d.MessageId
+}
+
+// seen returns true when the delivery was already processed.  Errors of the
+// store are logged and the delivery handled, as duplicates are preferable to
+// losses.
+func (opts HandlerOptions) seen(d Delivery) bool {
+	if opts.Dedup == nil {
+		return false
+	}
+	id := opts.dedupID(d)
+	if id == "" {
+		return false
+	}
+
+	// Not the context of the delivery, which is cancelled by Consumer.Stop
+	// while the running handlers are allowed to finish.
+	seen, err := opts.Dedup.Seen(context.Background(), id)
+	if err != nil {
+		Logger.Printf("could not look up delivery %q of consumer %q in the dedup store: %v", id, d.ConsumerTag, err)
+		return false
+	}
+	return seen
+}
+
+// processed records an acknowledged delivery in the dedup store.
+func (opts HandlerOptions) processed(d Delivery) {
+	if opts.Dedup == nil {
+		return
+	}
+	id := opts.dedupID(d)
+	if id == "" {
+		return
+	}
+
+	ttl := opts.DedupTTL
+	if ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	var err error
+	if store, ok := opts.Dedup.(ExpiringDedupStore); ok {
+		err = store.MarkProcessed(context.Background(), id, ttl)
+	} else {
+		err = opts.Dedup.Mark(context.Background(), id)
+	}
+	if err != nil {
+		Logger.Printf("could not record delivery %q of consumer %q in the dedup store: %v", id, d.ConsumerTag, err)
+	}
+}
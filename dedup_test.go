@@ -0,0 +1,106 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStoreEvictsAndExpires(t *testing.T) {
+	now := time.Unix(0, 0)
+	s := NewLRUDedupStore(2)
+	s.now = func() time.Time { return now }
+
+	seen := func(id string) bool {
+		t.Helper()
+		ok, err := s.Seen(context.Background(), id)
+		if err != nil {
+			t.Fatalf("could not look up %q: %v", id, err)
+		}
+		return ok
+	}
+
+	ctx := context.Background()
+	_ = s.MarkProcessed(ctx, "a", time.Minute)
+	_ = s.Mark(ctx, "b")
+	_ = s.MarkProcessed(ctx, "a", time.Minute) // most recent again
+	_ = s.MarkProcessed(ctx, "c", time.Hour)   // evicts b
+
+	if !seen("a") || seen("b") || !seen("c") {
+		t.Fatalf("expected the least recently marked id to be evicted")
+	}
+
+	now = now.Add(time.Minute)
+	if seen("a") || !seen("c") {
+		t.Fatalf("expected only the expired id to be forgotten")
+	}
+	if s.Len() != 1 {
+		t.Fatalf("expected the expired id to be removed, got %d ids", s.Len())
+	}
+}
+
+func TestConsumeHandlerSkipsProcessedDeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			id := "m1"
+			if tag == 3 {
+				id = ""
+			}
+			srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: tag, Properties: properties{MessageId: id}})
+
+			ack := &basicAck{}
+			srv.recv(1, ack)
+			if ack.DeliveryTag != tag {
+				t.Errorf("expected delivery %d to be acknowledged, got %+v", tag, ack)
+			}
+		}
+
+		cancel()
+
+		basicCancel := &basicCancel{}
+		srv.recv(1, basicCancel)
+		srv.send(1, &basicCancelOk{ConsumerTag: basicCancel.ConsumerTag})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	var handled int32
+	err = ch.ConsumeHandler(ctx, "jobs", "", func(d Delivery) error {
+		atomic.AddInt32(&handled, 1)
+		return nil
+	}, HandlerOptions{Dedup: NewLRUDedupStore(100)})
+	if err != context.Canceled {
+		t.Fatalf("expected the handler to stop with the context, got: %v", err)
+	}
+
+	// The duplicate of m1 is skipped, the delivery without id is not.
+	if n := atomic.LoadInt32(&handled); n != 2 {
+		t.Fatalf("expected 2 handled deliveries, got %d", n)
+	}
+}
@@ -6,6 +6,7 @@
 package amqp091
 
 import (
+	"context"
 	"errors"
 	"time"
 )
@@ -56,9 +57,15 @@ type Delivery struct {
 	RoutingKey  string // basic.publish routing key
 
 	Body []byte
+
+	// cancelled when the consumer is cancelled or the channel is closed
+	ctx context.Context
+
+	// time the last frame of the delivery was read
+	receivedAt time.Time
 }
 
-func newDelivery(channel *Channel, msg messageWithContent) *Delivery {
+func newDelivery(channel *Channel, msg messageWithContent, receivedAt time.Time) *Delivery {
 	props, body := msg.getContent()
 
 	delivery := Delivery{
@@ -79,6 +86,8 @@ func newDelivery(channel *Channel, msg messageWithContent) *Delivery {
 		AppId:           props.AppId,
 
 		Body: body,
+
+		receivedAt: receivedAt,
 	}
 
 	// Properties for the delivery types
@@ -101,6 +110,77 @@ func newDelivery(channel *Channel, msg messageWithContent) *Delivery {
 	return &delivery
 }
 
+/*
+Context returns a context that is cancelled when the consumer that received
+this delivery is cancelled, or when the channel or connection is closed.
+Deliveries that are not acknowledged when the channel closes are redelivered by
+the server anyway, so long-running handlers can use this context to abort work
+whose result can no longer be acknowledged.
+
+Deliveries that were not received by a consumer, like those of Channel.Get,
+return context.Background().
+*/
+func (d Delivery) Context() context.Context {
+	if d.ctx == nil {
+		return context.Background()
+	}
+	return d.ctx
+}
+
+/*
+DeliveryCount returns the number of times the message was delivered before
+this delivery, 0 on its first delivery.
+
+Quorum queues count the deliveries in the x-delivery-count header, which is
+used when present.  For other queues, DeliveryCount only knows whether the
+message was delivered before, and returns 1 when Redelivered is set.
+*/
+func (d Delivery) DeliveryCount() int64 {
+	switch count := d.Headers["x-delivery-count"].(type) {
+	case int64:
+		return count
+	case int32:
+		return int64(count)
+	case int16:
+		return int64(count)
+	case int8:
+		return int64(count)
+	case byte:
+		return int64(count)
+	case uint16:
+		return int64(count)
+	case uint32:
+		return int64(count)
+	}
+
+	if d.Redelivered {
+		return 1
+	}
+	return 0
+}
+
+// ReceivedAt returns the time the last frame of the delivery was read from the
+// connection, or the zero time for deliveries not received from a server.
+func (d Delivery) ReceivedAt() time.Time {
+	return d.receivedAt
+}
+
+/*
+Latency returns the time between the publishing of the message, as given by
+its Timestamp property, and its reception, as given by ReceivedAt.  It returns
+zero when either is unknown.
+
+The Timestamp property only has a precision of one second and is set by the
+publisher, so the latency is as coarse as that, and as accurate as the clocks
+of the publisher and the consumer are synchronized.  It may be negative.
+*/
+func (d Delivery) Latency() time.Duration {
+	if d.Timestamp.IsZero() || d.receivedAt.IsZero() {
+		return 0
+	}
+	return d.receivedAt.Sub(d.Timestamp)
+}
+
 /*
 Ack delegates an acknowledgement through the Acknowledger interface that the
 client or server has finished work on a delivery.
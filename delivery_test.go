@@ -5,7 +5,11 @@
 
 package amqp091
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func shouldNotPanic(t *testing.T) {
 	if err := recover(); err != nil {
@@ -36,3 +40,144 @@ func TestRejectZeroValueAcknowledgerDoesNotPanic(t *testing.T) {
 		t.Errorf("expected Delivery{}.Ack to error")
 	}
 }
+
+func TestDeliveryContextCancelledWithChannel(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	received := make(chan struct{})
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 1})
+
+		<-received
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "gone"})
+		srv.recv(1, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.Consume("jobs", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	d := <-deliveries
+	if err := d.Context().Err(); err != nil {
+		t.Fatalf("expected the delivery context to be live, got %v", err)
+	}
+	close(received)
+
+	select {
+	case <-d.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the delivery context to be cancelled when the channel closes")
+	}
+}
+
+func TestDeliveryContextDefault(t *testing.T) {
+	if (Delivery{}).Context() != context.Background() {
+		t.Fatalf("expected context.Background() for a delivery without consumer")
+	}
+}
+
+func TestDeliveryCount(t *testing.T) {
+	tests := []struct {
+		delivery Delivery
+		expected int64
+	}{
+		{Delivery{}, 0},
+		{Delivery{Redelivered: true}, 1},
+		{Delivery{Redelivered: true, Headers: Table{"x-delivery-count": int64(3)}}, 3},
+		{Delivery{Redelivered: true, Headers: Table{"x-delivery-count": int32(2)}}, 2},
+		{Delivery{Redelivered: true, Headers: Table{"x-delivery-count": byte(4)}}, 4},
+		{Delivery{Redelivered: true, Headers: Table{"x-delivery-count": "5"}}, 1},
+	}
+
+	for i, test := range tests {
+		if got := test.delivery.DeliveryCount(); got != test.expected {
+			t.Errorf("%d: expected delivery count %d, got %d", i, test.expected, got)
+		}
+	}
+}
+
+func TestDeliveryReceivedAtAndLatency(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	published := time.Now().Add(-time.Minute).Truncate(time.Second)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+		srv.send(1, &basicDeliver{
+			ConsumerTag: req.ConsumerTag,
+			DeliveryTag: 1,
+			Properties:  properties{Timestamp: published},
+			Body:        []byte("job"),
+		})
+
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetOk{DeliveryTag: 2})
+
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	before := time.Now()
+	deliveries, err := ch.Consume("jobs", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	d := <-deliveries
+	if d.ReceivedAt().Before(before) || d.ReceivedAt().After(time.Now()) {
+		t.Errorf("expected the delivery to be received after %v, got %v", before, d.ReceivedAt())
+	}
+	if want, got := d.ReceivedAt().Sub(published), d.Latency(); want != got || got < time.Minute {
+		t.Errorf("expected latency %v, got %v", want, got)
+	}
+
+	got, ok, err := ch.Get("jobs", true)
+	if err != nil || !ok {
+		t.Fatalf("could not get: %t %v", ok, err)
+	}
+	if got.ReceivedAt().IsZero() {
+		t.Errorf("expected the receive time of Get deliveries to be set")
+	}
+	if got.Latency() != 0 {
+		t.Errorf("expected no latency without timestamp, got %v", got.Latency())
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
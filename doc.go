@@ -156,5 +156,17 @@ number of unconfirmed messages.
 
 It is important to consume from the confirmation channel at all times, in order to avoid
 deadlocks in the library.
+
+# Lean build
+
+Building with the amqp_lean tag trims the package for gateways and embedded
+Linux devices, and allows compiling applications with TinyGo:
+
+	tinygo build -tags amqp_lean -o app .
+
+It matches the replies of the server without reflection and leaves out
+HealthCheck, LoadConfig and the JSON configuration files, ConsumerGroup,
+Shovel, Migration, ProxyFromEnvironment and ManagementInspector.  The
+connection, channel, publishing and consuming API is unchanged.
 */
 package amqp091
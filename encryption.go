@@ -0,0 +1,267 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// EncryptionKeyIDHeader is the header holding the id of the key a message
+	// body was encrypted with.
+	EncryptionKeyIDHeader = "x-encryption-key-id"
+
+	// EncryptionAlgorithmHeader is the header holding the algorithm a message
+	// body was encrypted with.
+	EncryptionAlgorithmHeader = "x-encryption-algorithm"
+
+	// EncryptionAESGCM is the only supported algorithm: AES in Galois/Counter
+	// Mode, with the random nonce prepended to the ciphertext.
+	EncryptionAESGCM = "AES-GCM"
+)
+
+// ErrUnknownKey is returned by a KeyProvider that does not know the requested
+// key id.
+var ErrUnknownKey = errors.New("unknown encryption key")
+
+// KeyProvider gives access to the keys of an Encryptor.  Keys are AES keys of
+// 16, 24 or 32 bytes.  The current key encrypts new messages while older keys
+// stay available to decrypt the messages still in queues, which allows
+// rotating keys without coordinating publishers and consumers.
+type KeyProvider interface {
+	// CurrentKey returns the key to encrypt new messages with, and its id.
+	CurrentKey() (id string, key []byte, err error)
+
+	// Key returns the key with the given id, or an error wrapping
+	// ErrUnknownKey.
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeyProvider is a KeyProvider holding keys in memory.  It is safe for
+// concurrent use, and Rotate can be called while messages are published.
+type StaticKeyProvider struct {
+	m       sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeyProvider returns a provider encrypting with the key with the
+// current id among keys.
+func NewStaticKeyProvider(current string, keys map[string][]byte) (*StaticKeyProvider, error) {
+	p := &StaticKeyProvider{keys: make(map[string][]byte, len(keys))}
+
+	for id, key := range keys {
+		if err := p.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.Rotate(current); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// Add makes a key available for decryption, replacing the key with the same
+// id if any.
+func (p *StaticKeyProvider) Add(id string, key []byte) error {
+	if _, err := aes.NewCipher(key); err != nil {
+		return fmt.Errorf("encryption key %q: %w", id, err)
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+// Rotate makes the key with the given id the one new messages are encrypted
+// with.  The key must have been added before.
+func (p *StaticKeyProvider) Rotate(id string) error {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, found := p.keys[id]; !found {
+		return fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	p.current = id
+	return nil
+}
+
+// CurrentKey implements KeyProvider.
+func (p *StaticKeyProvider) CurrentKey() (string, []byte, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	return p.current, p.keys[p.current], nil
+}
+
+// Key implements KeyProvider.
+func (p *StaticKeyProvider) Key(id string) ([]byte, error) {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	key, found := p.keys[id]
+	if !found {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+/*
+Encryptor encrypts message bodies at the client with AES-GCM, so that
+sensitive payloads are never readable by the broker or by consumers without
+the key.  Only the body is encrypted, the properties and headers are not.
+
+Install the encryption on a publishing channel with PublishHook, and the
+decryption on a consumer with ConsumeDecrypt; handlers then see the plain body:
+
+	enc := amqp.NewEncryptor(keys)
+	ch.AddPublishHook(enc.PublishHook())
+	deliveries, err := ch.ConsumeWithOptions(ctx, "payments", "", false, amqp.ConsumeDecrypt(enc))
+
+The id of the key is sent in the EncryptionKeyIDHeader header and
+authenticated along with the body.
+*/
+type Encryptor struct {
+	keys KeyProvider
+}
+
+// NewEncryptor returns an Encryptor using the keys of the provider.
+func NewEncryptor(keys KeyProvider) *Encryptor {
+	return &Encryptor{keys: keys}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encrypt replaces the body of msg with its encryption with the current key,
+// and sets the encryption headers on a copy of the headers of msg.
+func (e *Encryptor) Encrypt(msg *Publishing) error {
+	id, key, err := e.keys.CurrentKey()
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return fmt.Errorf("encryption key %q: %w", id, err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(msg.Body)+gcm.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+
+	headers := make(Table, len(msg.Headers)+2)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[EncryptionKeyIDHeader] = id
+	headers[EncryptionAlgorithmHeader] = EncryptionAESGCM
+
+	msg.Headers = headers
+	msg.Body = gcm.Seal(nonce, nonce, msg.Body, []byte(id))
+
+	return nil
+}
+
+// Decrypt replaces the encrypted body of d with the plain body and removes
+// the encryption headers.  Deliveries without the EncryptionKeyIDHeader header
+// are left unchanged and reported as not encrypted.
+func (e *Encryptor) Decrypt(d *Delivery) (encrypted bool, err error) {
+	raw, found := d.Headers[EncryptionKeyIDHeader]
+	if !found {
+		return false, nil
+	}
+
+	id, ok := raw.(string)
+	if !ok {
+		return true, fmt.Errorf("%s header of type %T", EncryptionKeyIDHeader, raw)
+	}
+	if algorithm, _ := d.Headers[EncryptionAlgorithmHeader].(string); algorithm != EncryptionAESGCM {
+		return true, fmt.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+
+	key, err := e.keys.Key(id)
+	if err != nil {
+		return true, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return true, fmt.Errorf("encryption key %q: %w", id, err)
+	}
+
+	if len(d.Body) < gcm.NonceSize() {
+		return true, errors.New("encrypted body shorter than the nonce")
+	}
+
+	nonce, ciphertext := d.Body[:gcm.NonceSize()], d.Body[gcm.NonceSize():]
+	body, err := gcm.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return true, fmt.Errorf("could not decrypt body with key %q: %w", id, err)
+	}
+
+	headers := make(Table, len(d.Headers))
+	for k, v := range d.Headers {
+		if k != EncryptionKeyIDHeader && k != EncryptionAlgorithmHeader {
+			headers[k] = v
+		}
+	}
+
+	d.Headers = headers
+	d.Body = body
+
+	return true, nil
+}
+
+// PublishHook returns a hook for Channel.AddPublishHook that encrypts the body
+// of every message.  Messages are not sent when they cannot be encrypted.
+func (e *Encryptor) PublishHook() PublishHook {
+	return func(_, _ string, msg *Publishing) error {
+		return e.Encrypt(msg)
+	}
+}
+
+/*
+ConsumeDecrypt decrypts the body of every delivery of the consumer before it is
+handed to the application.  Deliveries that are not encrypted are handed over
+unchanged, which allows enabling encryption on publishers after consumers.
+
+Deliveries that cannot be decrypted, for example because their key is unknown,
+are rejected without requeue, so the server dead-letters them when the queue
+has a dead letter exchange.  When the consumer automatically acknowledges
+deliveries, they are simply discarded.
+*/
+func ConsumeDecrypt(e *Encryptor) ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.hooks = append(o.hooks, func(autoAck bool) DeliveryHook {
+			return func(d *Delivery) bool {
+				if _, err := e.Decrypt(d); err != nil {
+					if !autoAck {
+						_ = d.Reject(false)
+					}
+					return false
+				}
+				return true
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func testKeys(t *testing.T) *StaticKeyProvider {
+	t.Helper()
+
+	keys, err := NewStaticKeyProvider("k1", map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, 32),
+		"k2": bytes.Repeat([]byte{2}, 16),
+	})
+	if err != nil {
+		t.Fatalf("could not create key provider: %v", err)
+	}
+	return keys
+}
+
+func TestEncryptorRoundTripAcrossRotation(t *testing.T) {
+	keys := testKeys(t)
+	enc := NewEncryptor(keys)
+
+	headers := Table{"tenant": "acme"}
+	old := Publishing{Headers: headers, Body: []byte("card 4242")}
+	if err := enc.Encrypt(&old); err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	if bytes.Contains(old.Body, []byte("4242")) || old.Headers[EncryptionKeyIDHeader] != "k1" {
+		t.Fatalf("expected an encrypted body with the key id, got %+v", old)
+	}
+	if len(headers) != 1 {
+		t.Fatalf("expected the headers of the caller to be left unchanged, got %v", headers)
+	}
+
+	if err := keys.Rotate("k2"); err != nil {
+		t.Fatalf("could not rotate: %v", err)
+	}
+	current := Publishing{Body: []byte("card 4343")}
+	if err := enc.Encrypt(&current); err != nil {
+		t.Fatalf("could not encrypt: %v", err)
+	}
+	if current.Headers[EncryptionKeyIDHeader] != "k2" {
+		t.Fatalf("expected the rotated key, got %v", current.Headers)
+	}
+
+	for _, msg := range []Publishing{old, current} {
+		d := Delivery{Headers: msg.Headers, Body: msg.Body}
+		encrypted, err := enc.Decrypt(&d)
+		if err != nil || !encrypted {
+			t.Fatalf("could not decrypt: %v", err)
+		}
+		if !bytes.HasPrefix(d.Body, []byte("card ")) {
+			t.Fatalf("unexpected plain body %q", d.Body)
+		}
+		if _, found := d.Headers[EncryptionKeyIDHeader]; found {
+			t.Fatalf("expected the encryption headers to be removed, got %v", d.Headers)
+		}
+	}
+
+	plain := Delivery{Body: []byte("hello")}
+	if encrypted, err := enc.Decrypt(&plain); encrypted || err != nil || string(plain.Body) != "hello" {
+		t.Fatalf("expected a plain delivery to be left unchanged, got %v %v %q", encrypted, err, plain.Body)
+	}
+
+	tampered := Delivery{Headers: current.Headers, Body: append([]byte(nil), current.Body...)}
+	tampered.Body[len(tampered.Body)-1] ^= 1
+	if _, err := enc.Decrypt(&tampered); err == nil {
+		t.Fatalf("expected a tampered body not to decrypt")
+	}
+
+	unknown := Delivery{Headers: Table{EncryptionKeyIDHeader: "k3", EncryptionAlgorithmHeader: EncryptionAESGCM}, Body: current.Body}
+	if _, err := enc.Decrypt(&unknown); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected an unknown key error, got %v", err)
+	}
+}
+
+func TestNewStaticKeyProviderValidatesKeys(t *testing.T) {
+	if _, err := NewStaticKeyProvider("k1", map[string][]byte{"k1": []byte("short")}); err == nil {
+		t.Fatalf("expected an error for an invalid key size")
+	}
+	if _, err := NewStaticKeyProvider("k2", map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected an error for a missing current key, got %v", err)
+	}
+}
+
+func TestConsumeDecryptRejectsUndecryptableDeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	rejected := make(chan *basicReject, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		publish := srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		unknown := Table{EncryptionKeyIDHeader: "k3", EncryptionAlgorithmHeader: EncryptionAESGCM}
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 1, Properties: properties{Headers: unknown}, Body: publish.Body})
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 2, Properties: publish.Properties, Body: publish.Body})
+
+		reject := &basicReject{}
+		srv.recv(1, reject)
+		rejected <- reject
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	enc := NewEncryptor(testKeys(t))
+	ch.AddPublishHook(enc.PublishHook())
+
+	if err := ch.PublishWithContext(context.Background(), "", "payments", false, false, Publishing{Body: []byte("card 4242")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "payments", "", false, ConsumeDecrypt(enc))
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 2 || string(d.Body) != "card 4242" {
+		t.Fatalf("expected the decrypted delivery, got %d %q", d.DeliveryTag, d.Body)
+	}
+
+	if reject := <-rejected; reject.DeliveryTag != 1 || reject.Requeue {
+		t.Fatalf("expected the undecryptable delivery to be dead-lettered, got %+v", reject)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Environment variables read by ConfigFromEnv.
+const (
+	EnvURL            = "AMQP_URL"             // AMQP URI, required
+	EnvVhost          = "AMQP_VHOST"           // virtual host, overrides the one of the URI
+	EnvHeartbeat      = "AMQP_HEARTBEAT"       // heartbeat interval as a duration, e.g. "10s"
+	EnvConnectionName = "AMQP_CONNECTION_NAME" // connection_name client property
+	EnvChannelMax     = "AMQP_CHANNEL_MAX"     // maximum number of channels
+	EnvFrameSize      = "AMQP_FRAME_SIZE"      // maximum frame size in bytes
+	EnvLocale         = "AMQP_LOCALE"          // connection locale
+	EnvWriteTimeout   = "AMQP_WRITE_TIMEOUT"   // per frame write timeout as a duration
+	EnvReadTimeout    = "AMQP_READ_TIMEOUT"    // read timeout as a duration
+	EnvDialAttempts   = "AMQP_DIAL_ATTEMPTS"   // connection attempts, see DialRetry
+	EnvTLSCA          = "AMQP_TLS_CA"          // PEM file of the CA certificates
+	EnvTLSCert        = "AMQP_TLS_CERT"        // PEM file of the client certificate
+	EnvTLSKey         = "AMQP_TLS_KEY"         // PEM file of the client key
+	EnvTLSServerName  = "AMQP_TLS_SERVER_NAME" // server name to verify, the host of the URL by default
+)
+
+/*
+ConfigFromEnv reads the URL and the Config of a connection from the
+environment, for services configured the 12-factor way:
+
+	url, config, err := amqp.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conn, err := amqp.DialConfig(url, config)
+
+AMQP_URL is required; the other variables, listed in the Env constants, are
+optional and left unset keep the defaults of DialConfig.  Durations use the
+syntax of time.ParseDuration.  The TLS variables require an amqps URL.  As
+with DialConfig, a heartbeat set in the URL takes precedence over
+AMQP_HEARTBEAT.
+
+All the invalid variables are reported at once in the returned error.
+*/
+func ConfigFromEnv() (string, Config, error) {
+	return configFromEnv(os.LookupEnv)
+}
+
+func configFromEnv(lookup func(string) (string, bool)) (string, Config, error) {
+	var errs []error
+	config := Config{}
+
+	get := func(name string) string {
+		value, _ := lookup(name)
+		return value
+	}
+
+	duration := func(name string) time.Duration {
+		value := get(name)
+		if value == "" {
+			return 0
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("%s: invalid duration %q", name, value))
+			return 0
+		}
+		return d
+	}
+
+	integer := func(name string, bits int) uint64 {
+		value := get(name)
+		if value == "" {
+			return 0
+		}
+		n, err := strconv.ParseUint(value, 10, bits)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid value %q", name, value))
+			return 0
+		}
+		return n
+	}
+
+	url := get(EnvURL)
+	var uri URI
+	if url == "" {
+		errs = append(errs, fmt.Errorf("%s is not set", EnvURL))
+	} else if parsed, err := ParseURI(url); err != nil {
+		// The error of ParseURI may contain the password.
+		errs = append(errs, fmt.Errorf("%s: invalid AMQP URI", EnvURL))
+	} else {
+		uri = parsed
+	}
+
+	config.Vhost = get(EnvVhost)
+	config.Heartbeat = duration(EnvHeartbeat)
+	config.ChannelMax = uint16(integer(EnvChannelMax, 16))
+	config.FrameSize = int(integer(EnvFrameSize, 31))
+	config.Locale = get(EnvLocale)
+	config.WriteTimeout = duration(EnvWriteTimeout)
+	config.ReadTimeout = duration(EnvReadTimeout)
+	config.DialRetry.MaxAttempts = int(integer(EnvDialAttempts, 31))
+
+	if name := get(EnvConnectionName); name != "" {
+		config.Properties = NewConnectionProperties()
+		config.Properties.SetClientConnectionName(name)
+	}
+
+	tlsURI := URI{
+		CACertFile: get(EnvTLSCA),
+		CertFile:   get(EnvTLSCert),
+		KeyFile:    get(EnvTLSKey),
+		ServerName: get(EnvTLSServerName),
+	}
+	if tlsURI.CACertFile != "" || tlsURI.CertFile != "" || tlsURI.KeyFile != "" || tlsURI.ServerName != "" {
+		switch {
+		case uri.Scheme == "amqp":
+			errs = append(errs, fmt.Errorf("TLS variables set but %s does not use the amqps scheme", EnvURL))
+		case (tlsURI.CertFile == "") != (tlsURI.KeyFile == ""):
+			errs = append(errs, fmt.Errorf("%s and %s must be set together", EnvTLSCert, EnvTLSKey))
+		default:
+			if tlsURI.ServerName == "" {
+				tlsURI.ServerName = uri.Host
+			}
+			tlsConfig, err := tlsConfigFromURI(tlsURI)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("TLS variables: %w", err))
+			}
+			config.TLSClientConfig = tlsConfig
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return "", Config{}, err
+	}
+
+	return url, config, nil
+}
@@ -173,8 +173,8 @@ func ExampleChannel_Confirm_bridge() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	// Now pump the messages, one by one, a smarter implementation
-	// would batch the deliveries and use multiple ack/nacks
+	// Now pump the messages, one by one, see Shovel for an implementation
+	// that batches the deliveries and uses multiple ack/nacks
 	for {
 		msg, ok := <-shovel
 		if !ok {
@@ -448,6 +448,7 @@ func ExampleConnection_UpdateSecret() {
 	//
 	// The app should have a long-running task that checks the validity of the JWT token, and renew it before
 	// the refresher time expires. Once a new JWT token is obtained, it shall be used in Connection.UpdateSecret().
+	// Config.CredentialsProvider runs such a task, see the CredentialsProvider example.
 
 	token, _ := getJWToken("username", "password")
 
@@ -493,6 +494,34 @@ func ExampleConnection_UpdateSecret() {
 	)
 }
 
+func ExampleCredentialsProvider() {
+	// The connection authenticates with a JWT token obtained from the OAuth2
+	// server, and calls Connection.UpdateSecret with a new token a minute
+	// before the current one expires, without a refresher task.
+	c, err := amqp.DialConfig("amqp://localhost:5672", amqp.Config{
+		CredentialsProvider: func(ctx context.Context) (amqp.Credentials, error) {
+			token, err := getJWToken("username", "password")
+			if err != nil {
+				return amqp.Credentials{}, err
+			}
+			return amqp.Credentials{
+				Username: "client_id",
+				Password: token,
+				Expiry:   time.Now().Add(time.Hour),
+			}, nil
+		},
+		CredentialsRefresh: amqp.CredentialsRefresh{
+			OnError: func(err error) {
+				log.Printf("could not refresh the token: %v", err)
+			},
+		},
+	})
+	if err != nil {
+		log.Fatalf("connection.open: %s", err)
+	}
+	defer c.Close()
+}
+
 func getJWToken(username, password string) (string, error) {
 	// do OAuth2 things
 	return "a-token", nil
@@ -0,0 +1,81 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"strconv"
+	"time"
+)
+
+// ExpiredAction selects what ConsumeDropExpired does with expired deliveries.
+type ExpiredAction int
+
+const (
+	// AckExpired acknowledges expired deliveries, removing them from the queue.
+	AckExpired ExpiredAction = iota
+	// DeadLetterExpired rejects expired deliveries without requeue, so the
+	// server dead-letters them when the queue has a dead letter exchange.
+	DeadLetterExpired
+)
+
+// deliveryExpired reports whether d is older than maxAge or past its own
+// Expiration, based on its Timestamp.  Deliveries without a Timestamp never
+// expire.
+func deliveryExpired(d *Delivery, maxAge time.Duration, now time.Time) bool {
+	if d.Timestamp.IsZero() {
+		return false
+	}
+
+	age := now.Sub(d.Timestamp)
+
+	if maxAge > 0 && age > maxAge {
+		return true
+	}
+
+	if d.Expiration != "" {
+		if ttl, err := strconv.ParseInt(d.Expiration, 10, 64); err == nil && age > time.Duration(ttl)*time.Millisecond {
+			return true
+		}
+	}
+
+	return false
+}
+
+/*
+ConsumeDropExpired drops the deliveries that are already stale before they are
+handed to the application.  This is useful for cache invalidation and telemetry
+queues, where messages lose their value once a backlog has built up, for example
+after an outage.
+
+The age of a delivery is computed from its Timestamp property, so publishers
+must set it.  A delivery is expired when its age exceeds maxAge, or when it
+exceeds the per-message TTL in its Expiration property.  A maxAge of zero only
+honours the Expiration property.  The server only expires messages at the head
+of a queue, so deliveries past their TTL can still reach consumers.
+
+Expired deliveries are acknowledged or dead-lettered depending on action.  When
+the consumer automatically acknowledges deliveries, they are simply discarded.
+*/
+func ConsumeDropExpired(maxAge time.Duration, action ExpiredAction) ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.hooks = append(o.hooks, func(autoAck bool) DeliveryHook {
+			return func(d *Delivery) bool {
+				if !deliveryExpired(d, maxAge, time.Now()) {
+					return true
+				}
+
+				if !autoAck {
+					if action == DeadLetterExpired {
+						_ = d.Reject(false)
+					} else {
+						_ = d.Ack(false)
+					}
+				}
+				return false
+			}
+		})
+		return nil
+	}
+}
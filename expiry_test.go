@@ -0,0 +1,92 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestDeliveryExpired(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name     string
+		delivery Delivery
+		maxAge   time.Duration
+		expired  bool
+	}{
+		{name: "no timestamp", delivery: Delivery{Expiration: "1"}, maxAge: time.Second, expired: false},
+		{name: "fresh", delivery: Delivery{Timestamp: now.Add(-time.Second)}, maxAge: time.Minute, expired: false},
+		{name: "older than max age", delivery: Delivery{Timestamp: now.Add(-time.Hour)}, maxAge: time.Minute, expired: true},
+		{name: "past expiration", delivery: Delivery{Timestamp: now.Add(-time.Second), Expiration: "500"}, expired: true},
+		{name: "within expiration", delivery: Delivery{Timestamp: now.Add(-time.Second), Expiration: "5000"}, expired: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := deliveryExpired(&tt.delivery, tt.maxAge, now); got != tt.expired {
+				t.Errorf("deliveryExpired() = %v, want %v", got, tt.expired)
+			}
+		})
+	}
+}
+
+func TestConsumeDropExpiredDeadLetters(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	rejected := make(chan *basicReject, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		srv.send(1, &basicDeliver{
+			ConsumerTag: req.ConsumerTag,
+			DeliveryTag: 1,
+			Properties:  properties{Timestamp: time.Now().Add(-time.Hour)},
+		})
+		srv.send(1, &basicDeliver{
+			ConsumerTag: req.ConsumerTag,
+			DeliveryTag: 2,
+			Properties:  properties{Timestamp: time.Now()},
+		})
+
+		reject := &basicReject{}
+		srv.recv(1, reject)
+		rejected <- reject
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "telemetry", "", false,
+		ConsumeDropExpired(time.Minute, DeadLetterExpired),
+	)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 2 {
+		t.Fatalf("expected only the fresh delivery, got delivery tag %d", d.DeliveryTag)
+	}
+
+	if reject := <-rejected; reject.DeliveryTag != 1 || reject.Requeue {
+		t.Fatalf("expected the stale delivery to be dead-lettered, got %+v", reject)
+	}
+}
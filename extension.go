@@ -0,0 +1,111 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"io"
+)
+
+// UnknownMethod is a method frame with class and method ids that are not part
+// of AMQP 0-9-1 or of the RabbitMQ extensions implemented by this library.
+// See Config.UnknownMethod.
+type UnknownMethod struct {
+	Channel   uint16
+	ClassID   uint16
+	MethodID  uint16
+	Arguments []byte // encoded arguments, as defined by the extension
+}
+
+func (m UnknownMethod) String() string {
+	return fmt.Sprintf("unknown method %d for class %d on channel %d", m.MethodID, m.ClassID, m.Channel)
+}
+
+// unknownMethod carries the arguments of an unknown method, read and written
+// as raw bytes.
+type unknownMethod struct {
+	ClassId   uint16
+	MethodId  uint16
+	Arguments []byte
+}
+
+func (msg *unknownMethod) id() (uint16, uint16) {
+	return msg.ClassId, msg.MethodId
+}
+
+func (msg *unknownMethod) wait() bool {
+	return false
+}
+
+func (msg *unknownMethod) write(w io.Writer) error {
+	_, err := w.Write(msg.Arguments)
+	return err
+}
+
+func (msg *unknownMethod) read(r io.Reader) error {
+	_, err := io.ReadFull(r, msg.Arguments)
+	return err
+}
+
+// parseUnknownMethod reads the arguments of a method with unknown ids, the
+// ids themselves have already been read into mf.
+func (r *reader) parseUnknownMethod(mf *methodFrame, size uint32) (frame, error) {
+	if size < 4 {
+		return nil, ErrFrame
+	}
+
+	method := &unknownMethod{
+		ClassId:   mf.ClassId,
+		MethodId:  mf.MethodId,
+		Arguments: make([]byte, size-4),
+	}
+	if err := method.read(r.r); err != nil {
+		return nil, err
+	}
+
+	mf.Method = method
+	return mf, nil
+}
+
+// dispatchUnknown hands a method frame with unknown ids to
+// Config.UnknownMethod.  Without a handler, or when the handler fails, the
+// method is a fatal protocol error as before.
+func (c *Connection) dispatchUnknown(channel uint16, m *unknownMethod) {
+	method := UnknownMethod{
+		Channel:   channel,
+		ClassID:   m.ClassId,
+		MethodID:  m.MethodId,
+		Arguments: m.Arguments,
+	}
+
+	if c.unknownMethod == nil {
+		c.shutdown(&Error{Code: FrameError, Reason: fmt.Sprintf("Bad method frame, %s", method)})
+		return
+	}
+
+	if err := c.unknownMethod(method); err != nil {
+		// closeWith waits for the reader, do not block it
+		go func() {
+			if err := c.closeWith(&Error{Code: NotImplemented, Reason: err.Error()}); err != nil {
+				Logger.Printf("error closing connection after %s, error: %+v", method, err)
+			}
+		}()
+	}
+}
+
+/*
+SendMethod sends a method frame with the given class and method ids and
+encoded arguments on a channel, without waiting for any response.  It is meant
+for experimenting with broker specific protocol extensions together with
+Config.UnknownMethod, and does not check that the method exists nor that the
+channel is open.  Sending methods of AMQP 0-9-1 this way corrupts the state of
+the Connection and its Channels.
+*/
+func (c *Connection) SendMethod(channel, classID, methodID uint16, args []byte) error {
+	return c.send(&methodFrame{
+		ChannelId: channel,
+		Method:    &unknownMethod{ClassId: classID, MethodId: methodID, Arguments: args},
+	})
+}
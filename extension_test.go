@@ -0,0 +1,126 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestUnknownMethodHandler(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	reply := &unknownMethod{}
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.send(1, &unknownMethod{ClassId: 200, MethodId: 10, Arguments: []byte("ping")})
+		srv.recv(1, reply)
+		srv.connectionClose()
+	}()
+
+	received := make(chan UnknownMethod, 1)
+	config := defaultConfig()
+	config.UnknownMethod = func(m UnknownMethod) error {
+		received <- m
+		return nil
+	}
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	if _, err := c.Channel(); err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	select {
+	case m := <-received:
+		if m.Channel != 1 || m.ClassID != 200 || m.MethodID != 10 || string(m.Arguments) != "ping" {
+			t.Fatalf("unexpected method: %+v", m)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the unknown method handler to be called")
+	}
+
+	if err := c.SendMethod(1, 200, 11, []byte("pong")); err != nil {
+		t.Fatalf("could not send method: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	if reply.ClassId != 200 || reply.MethodId != 11 || !bytes.Equal(reply.Arguments, []byte("pong")) {
+		t.Fatalf("unexpected method sent: %+v", reply)
+	}
+}
+
+func TestUnknownMethodHandlerError(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	closing := &connectionClose{}
+	go func() {
+		srv.connectionOpen()
+		srv.send(0, &unknownMethod{ClassId: 200, MethodId: 10})
+		srv.recv(0, closing)
+		srv.send(0, &connectionCloseOk{})
+	}()
+
+	config := defaultConfig()
+	config.UnknownMethod = func(UnknownMethod) error {
+		return errors.New("unsupported extension")
+	}
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	closed := make(chan struct{})
+	c.OnClose(func(*Error) { close(closed) })
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to be closed")
+	}
+
+	if closing.ReplyCode != NotImplemented || closing.ReplyText != "unsupported extension" {
+		t.Fatalf("unexpected connection.close: %+v", closing)
+	}
+}
+
+func TestUnknownMethodWithoutHandler(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.send(0, &unknownMethod{ClassId: 200, MethodId: 10})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	closed := make(chan *Error, 1)
+	c.OnClose(func(err *Error) { closed <- err })
+
+	select {
+	case err := <-closed:
+		if err == nil || err.Code != FrameError {
+			t.Fatalf("expected a FrameError, got: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+// deliveryFlow holds the state of Channel.PauseDeliveries.
+type deliveryFlow struct {
+	global   *basicQos // last global prefetch set with Channel.Qos
+	paused   bool
+	fallback bool      // paused with the prefetch fallback
+	held     []message // settlements held back while paused with the fallback
+}
+
+// SupportsChannelFlow returns false when the server is known to refuse
+// channel.flow requests pausing deliveries.  RabbitMQ closes the connection
+// with a NOT_IMPLEMENTED error when asked to, other brokers are assumed to
+// implement the method.
+func (c *Connection) SupportsChannelFlow() bool {
+	product, _ := c.Properties["product"].(string)
+	return product != "RabbitMQ"
+}
+
+/*
+PauseDeliveries temporarily stops the deliveries to the consumers of this
+channel, without cancelling them, for example during a maintenance window of a
+downstream dependency.  Call Channel.ResumeDeliveries to restart them.
+
+When the server supports it, see Connection.SupportsChannelFlow, the
+deliveries are paused with Channel.Flow.  Otherwise, the channel falls back to
+a prefetch count of 1 for the whole channel and holds back the
+acknowledgements, nacks and rejects of its deliveries in the client until
+deliveries are resumed.  As the server does not deliver more messages than
+the prefetch count before they are settled, deliveries stop after at most one
+more message.  The fallback relies on the global prefetch of RabbitMQ, which
+quorum queues do not support.
+
+Deliveries already buffered by the library keep being received from the
+consumer chans while paused.
+*/
+func (ch *Channel) PauseDeliveries() error {
+	ch.flowM.Lock()
+	defer ch.flowM.Unlock()
+
+	if ch.flow.paused {
+		return nil
+	}
+
+	if ch.connection.SupportsChannelFlow() {
+		if err := ch.Flow(false); err != nil {
+			return err
+		}
+		ch.flow.paused = true
+		return nil
+	}
+
+	if err := ch.setQos(1, 0, true); err != nil {
+		return err
+	}
+	ch.flow.paused, ch.flow.fallback = true, true
+	return nil
+}
+
+// ResumeDeliveries restarts the deliveries paused by Channel.PauseDeliveries.
+// With the prefetch fallback, the global prefetch set with Channel.Qos is
+// restored, or removed when none was set, and the settlements held back are
+// sent in order.  The first error stops the resumption and is returned.
+func (ch *Channel) ResumeDeliveries() error {
+	ch.flowM.Lock()
+	defer ch.flowM.Unlock()
+
+	if !ch.flow.paused {
+		return nil
+	}
+
+	if !ch.flow.fallback {
+		if err := ch.Flow(true); err != nil {
+			return err
+		}
+		ch.flow.paused = false
+		return nil
+	}
+
+	// A global prefetch of 0 removes the limit set by PauseDeliveries.
+	restore := basicQos{Global: true}
+	if ch.flow.global != nil {
+		restore = *ch.flow.global
+	}
+	if err := ch.setQos(int(restore.PrefetchCount), int(restore.PrefetchSize), restore.Global); err != nil {
+		return err
+	}
+
+	ch.flow.paused, ch.flow.fallback = false, false
+	held := ch.flow.held
+	ch.flow.held = nil
+
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	for _, settle := range held {
+		if err := ch.send(settle); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setQos sets the prefetch without recording it as the one to restore.
+func (ch *Channel) setQos(prefetchCount, prefetchSize int, global bool) error {
+	qos := basicQos{
+		PrefetchCount: uint16(prefetchCount),
+		PrefetchSize:  uint32(prefetchSize),
+		Global:        global,
+	}
+	if err := ch.call(&qos, &basicQosOk{}); err != nil {
+		return err
+	}
+
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	recorded := ch.recordedQos[:0]
+	for _, q := range ch.recordedQos {
+		if q.Global != global {
+			recorded = append(recorded, q)
+		}
+	}
+	ch.recordedQos = append(recorded, qos)
+	return nil
+}
+
+// holdSettle keeps a settlement in the client while deliveries are paused
+// with the prefetch fallback, and returns true when it did.
+func (ch *Channel) holdSettle(settle message) bool {
+	ch.flowM.Lock()
+	defer ch.flowM.Unlock()
+
+	if !ch.flow.fallback {
+		return false
+	}
+	ch.flow.held = append(ch.flow.held, settle)
+	return true
+}
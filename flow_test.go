@@ -0,0 +1,103 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+)
+
+func TestPauseDeliveriesWithChannelFlow(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for _, active := range []bool{false, true} {
+			flow := &channelFlow{}
+			srv.recv(1, flow)
+			if flow.Active != active {
+				t.Errorf("expected channel.flow active=%t, got %t", active, flow.Active)
+			}
+			srv.send(1, &channelFlowOk{Active: active})
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.PauseDeliveries(); err != nil {
+		t.Fatalf("could not pause deliveries: %v", err)
+	}
+	if err := ch.PauseDeliveries(); err != nil {
+		t.Fatalf("expected pausing twice to do nothing, got: %v", err)
+	}
+	if err := ch.ResumeDeliveries(); err != nil {
+		t.Fatalf("could not resume deliveries: %v", err)
+	}
+}
+
+func TestPauseDeliveriesFallback(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for _, prefetch := range []uint16{5, 1, 5} {
+			qos := &basicQos{}
+			srv.recv(1, qos)
+			if qos.PrefetchCount != prefetch || !qos.Global {
+				t.Errorf("expected a global prefetch of %d, got %d (global: %t)", prefetch, qos.PrefetchCount, qos.Global)
+			}
+			srv.send(1, &basicQosOk{})
+		}
+
+		ack := &basicAck{}
+		srv.recv(1, ack)
+		if ack.DeliveryTag != 1 {
+			t.Errorf("expected the held ack of delivery tag 1, got %d", ack.DeliveryTag)
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	c.Properties = Table{"product": "RabbitMQ"}
+	if c.SupportsChannelFlow() {
+		t.Fatalf("expected RabbitMQ not to support channel.flow")
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.Qos(5, 0, true); err != nil {
+		t.Fatalf("could not set the prefetch: %v", err)
+	}
+	if err := ch.PauseDeliveries(); err != nil {
+		t.Fatalf("could not pause deliveries: %v", err)
+	}
+	if err := ch.Ack(1, false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if err := ch.ResumeDeliveries(); err != nil {
+		t.Fatalf("could not resume deliveries: %v", err)
+	}
+	<-served
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+Package frames reads and writes AMQP 0-9-1 frames.
+
+It is meant for tools that sit on the wire, like proxies, sniffers and test
+brokers, that need to split a stream into frames, tell methods apart and
+forward frames, without the connection and channel state machines of the
+amqp091 package.
+
+All frames consist of a header (7 octets), a payload of arbitrary size, and a
+'frame-end' octet that detects malformed frames:
+
+	0      1         3             7                  size+7 size+8
+	+------+---------+-------------+  +------------+  +-----------+
+	| type | channel |     size    |  |  payload   |  | frame-end |
+	+------+---------+-------------+  +------------+  +-----------+
+	 octet   short         long         size octets       octet
+
+Payloads are kept as raw bytes.  Method and content header frames can be split
+into their ids and encoded arguments or properties with Frame.Method and
+Frame.Header.
+*/
+package frames
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Frame types, see section 4.2.3 of the specification.
+const (
+	TypeMethod    = 1
+	TypeHeader    = 2
+	TypeBody      = 3
+	TypeHeartbeat = 8
+)
+
+const (
+	// End is the octet terminating every frame.
+	End = 206
+
+	// HeaderSize is the size of the header preceding every payload.
+	HeaderSize = 7
+
+	// MinSize is the largest frame every peer must accept before the
+	// connection is tuned.
+	MinSize = 4096
+)
+
+// ProtocolHeader is sent by clients when connecting, before any frame.
+var ProtocolHeader = []byte{'A', 'M', 'Q', 'P', 0, 0, 9, 1}
+
+var (
+	// ErrMalformed is returned for frames without a valid frame-end octet
+	// or with a payload too short for their type.
+	ErrMalformed = errors.New("frames: malformed frame")
+
+	// ErrTooLarge is returned when a frame is larger than the maximum size
+	// given to ReadFrame.
+	ErrTooLarge = errors.New("frames: frame too large")
+
+	// ErrProtocolHeader is returned by ReadProtocolHeader when the peer does
+	// not speak AMQP 0-9-1.
+	ErrProtocolHeader = errors.New("frames: unsupported protocol header")
+)
+
+// Frame is a single AMQP frame.
+type Frame struct {
+	Type    uint8
+	Channel uint16
+	Payload []byte
+}
+
+func (f Frame) String() string {
+	switch f.Type {
+	case TypeMethod:
+		if m, err := f.Method(); err == nil {
+			return fmt.Sprintf("method %s on channel %d", m, f.Channel)
+		}
+	case TypeHeader:
+		if h, err := f.Header(); err == nil {
+			return fmt.Sprintf("content header of %d bytes on channel %d", h.BodySize, f.Channel)
+		}
+	case TypeBody:
+		return fmt.Sprintf("content body of %d bytes on channel %d", len(f.Payload), f.Channel)
+	case TypeHeartbeat:
+		return "heartbeat"
+	}
+	return fmt.Sprintf("frame of type %d on channel %d", f.Type, f.Channel)
+}
+
+// ReadProtocolHeader reads the protocol header sent by a connecting client
+// and returns ErrProtocolHeader unless it is the one of AMQP 0-9-1.
+func ReadProtocolHeader(r io.Reader) error {
+	header := make([]byte, len(ProtocolHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header, ProtocolHeader) {
+		return ErrProtocolHeader
+	}
+	return nil
+}
+
+// ReadFrame reads the next frame from r.  Frames larger than maxSize,
+// including header and frame-end, are rejected with ErrTooLarge.  A maxSize
+// of 0 accepts frames of any size.
+func ReadFrame(r io.Reader, maxSize uint32) (Frame, error) {
+	var header [HeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, err
+	}
+
+	f := Frame{
+		Type:    header[0],
+		Channel: binary.BigEndian.Uint16(header[1:3]),
+	}
+
+	size := binary.BigEndian.Uint32(header[3:7])
+	if maxSize > 0 && uint64(size)+HeaderSize+1 > uint64(maxSize) {
+		return Frame{}, ErrTooLarge
+	}
+
+	// Read the payload and the frame-end octet at once.
+	buf := make([]byte, int(size)+1)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Frame{}, err
+	}
+
+	if buf[size] != End {
+		return Frame{}, ErrMalformed
+	}
+
+	f.Payload = buf[:size:size]
+	return f, nil
+}
+
+// WriteFrame writes f to w with a single call to w.Write.
+func WriteFrame(w io.Writer, f Frame) error {
+	buf := make([]byte, HeaderSize+len(f.Payload)+1)
+	buf[0] = f.Type
+	binary.BigEndian.PutUint16(buf[1:3], f.Channel)
+	binary.BigEndian.PutUint32(buf[3:7], uint32(len(f.Payload)))
+	copy(buf[HeaderSize:], f.Payload)
+	buf[len(buf)-1] = End
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// Heartbeat returns a heartbeat frame.
+func Heartbeat() Frame {
+	return Frame{Type: TypeHeartbeat}
+}
+
+// Method is the payload of a method frame.
+type Method struct {
+	ClassID   uint16
+	MethodID  uint16
+	Arguments []byte // encoded arguments, as defined by the method
+}
+
+// Name returns the name of the method as found in the specification, like
+// "basic.publish", or an empty string for unknown methods.
+func (m Method) Name() string {
+	return methodNames[methodID{m.ClassID, m.MethodID}]
+}
+
+func (m Method) String() string {
+	if name := m.Name(); name != "" {
+		return name
+	}
+	return fmt.Sprintf("%d.%d", m.ClassID, m.MethodID)
+}
+
+// HasContent returns true for the methods followed by a content header and
+// body frames: basic.publish, basic.return, basic.deliver and basic.get-ok.
+func (m Method) HasContent() bool {
+	if m.ClassID != 60 {
+		return false
+	}
+	switch m.MethodID {
+	case 40, 50, 60, 71:
+		return true
+	}
+	return false
+}
+
+// Method splits the payload of a method frame.
+func (f Frame) Method() (Method, error) {
+	if f.Type != TypeMethod || len(f.Payload) < 4 {
+		return Method{}, ErrMalformed
+	}
+
+	return Method{
+		ClassID:   binary.BigEndian.Uint16(f.Payload[0:2]),
+		MethodID:  binary.BigEndian.Uint16(f.Payload[2:4]),
+		Arguments: f.Payload[4:],
+	}, nil
+}
+
+// MethodFrame returns the method frame carrying m on channel.
+func MethodFrame(channel uint16, m Method) Frame {
+	payload := make([]byte, 4+len(m.Arguments))
+	binary.BigEndian.PutUint16(payload[0:2], m.ClassID)
+	binary.BigEndian.PutUint16(payload[2:4], m.MethodID)
+	copy(payload[4:], m.Arguments)
+
+	return Frame{Type: TypeMethod, Channel: channel, Payload: payload}
+}
+
+// ContentHeader is the payload of a content header frame.
+type ContentHeader struct {
+	ClassID       uint16
+	Weight        uint16
+	BodySize      uint64
+	PropertyFlags uint16
+	Properties    []byte // encoded properties selected by PropertyFlags
+}
+
+// Header splits the payload of a content header frame.
+func (f Frame) Header() (ContentHeader, error) {
+	if f.Type != TypeHeader || len(f.Payload) < 14 {
+		return ContentHeader{}, ErrMalformed
+	}
+
+	return ContentHeader{
+		ClassID:       binary.BigEndian.Uint16(f.Payload[0:2]),
+		Weight:        binary.BigEndian.Uint16(f.Payload[2:4]),
+		BodySize:      binary.BigEndian.Uint64(f.Payload[4:12]),
+		PropertyFlags: binary.BigEndian.Uint16(f.Payload[12:14]),
+		Properties:    f.Payload[14:],
+	}, nil
+}
+
+// HeaderFrame returns the content header frame carrying h on channel.
+func HeaderFrame(channel uint16, h ContentHeader) Frame {
+	payload := make([]byte, 14+len(h.Properties))
+	binary.BigEndian.PutUint16(payload[0:2], h.ClassID)
+	binary.BigEndian.PutUint16(payload[2:4], h.Weight)
+	binary.BigEndian.PutUint64(payload[4:12], h.BodySize)
+	binary.BigEndian.PutUint16(payload[12:14], h.PropertyFlags)
+	copy(payload[14:], h.Properties)
+
+	return Frame{Type: TypeHeader, Channel: channel, Payload: payload}
+}
+
+// BodyFrame returns a content body frame carrying body on channel.
+func BodyFrame(channel uint16, body []byte) Frame {
+	return Frame{Type: TypeBody, Channel: channel, Payload: body}
+}
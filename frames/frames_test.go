@@ -0,0 +1,97 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package frames
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	sent := []Frame{
+		MethodFrame(1, Method{ClassID: 60, MethodID: 40, Arguments: []byte{0, 0, 0, 0, 0}}),
+		HeaderFrame(1, ContentHeader{ClassID: 60, BodySize: 5, PropertyFlags: 0x8000, Properties: []byte{0}}),
+		BodyFrame(1, []byte("hello")),
+		Heartbeat(),
+	}
+
+	var buf bytes.Buffer
+	for _, f := range sent {
+		if err := WriteFrame(&buf, f); err != nil {
+			t.Fatalf("could not write %v: %v", f, err)
+		}
+	}
+
+	for i, want := range sent {
+		got, err := ReadFrame(&buf, MinSize)
+		if err != nil {
+			t.Fatalf("could not read frame %d: %v", i, err)
+		}
+		if got.Type != want.Type || got.Channel != want.Channel || !bytes.Equal(got.Payload, want.Payload) {
+			t.Fatalf("frame %d: expected %v, got %v", i, want, got)
+		}
+	}
+
+	if _, err := ReadFrame(&buf, MinSize); err != io.EOF {
+		t.Fatalf("expected io.EOF after the last frame, got: %v", err)
+	}
+}
+
+func TestFrameMethod(t *testing.T) {
+	m, err := MethodFrame(0, Method{ClassID: 10, MethodID: 10}).Method()
+	if err != nil {
+		t.Fatalf("could not split method: %v", err)
+	}
+	if m.Name() != "connection.start" || m.HasContent() {
+		t.Fatalf("expected connection.start without content, got %v", m)
+	}
+
+	publish := Method{ClassID: 60, MethodID: 40}
+	if publish.Name() != "basic.publish" || !publish.HasContent() {
+		t.Fatalf("expected basic.publish with content, got %v", publish)
+	}
+
+	if s := (Method{ClassID: 99, MethodID: 1}).String(); s != "99.1" {
+		t.Fatalf("expected unknown methods to print their ids, got %q", s)
+	}
+
+	if _, err := BodyFrame(1, nil).Method(); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected ErrMalformed for a body frame, got: %v", err)
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, BodyFrame(1, make([]byte, MinSize))); err != nil {
+		t.Fatalf("could not write frame: %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(buf.Bytes()), MinSize); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got: %v", err)
+	}
+	if _, err := ReadFrame(bytes.NewReader(buf.Bytes()), 0); err != nil {
+		t.Fatalf("expected frames of any size without a limit, got: %v", err)
+	}
+
+	corrupt := append([]byte{}, buf.Bytes()...)
+	corrupt[len(corrupt)-1] = 0
+	if _, err := ReadFrame(bytes.NewReader(corrupt), 0); !errors.Is(err, ErrMalformed) {
+		t.Fatalf("expected ErrMalformed, got: %v", err)
+	}
+
+	if _, err := ReadFrame(bytes.NewReader(buf.Bytes()[:10]), 0); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated frame, got: %v", err)
+	}
+}
+
+func TestReadProtocolHeader(t *testing.T) {
+	if err := ReadProtocolHeader(bytes.NewReader(ProtocolHeader)); err != nil {
+		t.Fatalf("expected the AMQP 0-9-1 header to be accepted, got: %v", err)
+	}
+	if err := ReadProtocolHeader(bytes.NewReader([]byte("AMQP\x01\x01\x00\x0a"))); !errors.Is(err, ErrProtocolHeader) {
+		t.Fatalf("expected ErrProtocolHeader for AMQP 1.0, got: %v", err)
+	}
+}
@@ -2,4 +2,7 @@ module github.com/rabbitmq/amqp091-go
 
 go 1.20
 
-require go.uber.org/goleak v1.3.0
+require (
+	go.uber.org/goleak v1.3.0
+	gopkg.in/yaml.v3 v3.0.1
+)
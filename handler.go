@@ -0,0 +1,241 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// Handler processes a delivery of a consumer started with
+// Channel.ConsumeHandler.  The delivery is acknowledged when the handler
+// returns nil, and settled according to HandlerOptions.OnError otherwise.  The
+// handler must not acknowledge or reject the delivery itself.
+type Handler func(d Delivery) error
+
+// Outcome is the way a delivery is settled when its handler fails.
+type Outcome int
+
+const (
+	// OutcomeRequeue rejects the delivery with requeue, so that it is
+	// delivered again, possibly to another consumer.
+	OutcomeRequeue Outcome = iota
+	// OutcomeDeadLetter rejects the delivery without requeue, so the server
+	// dead-letters it when the queue has a dead letter exchange.
+	OutcomeDeadLetter
+	// OutcomeAck acknowledges the delivery, discarding it.
+	OutcomeAck
+)
+
+func (o Outcome) settle(d Delivery) error {
+	switch o {
+	case OutcomeAck:
+		return d.Ack(false)
+	case OutcomeDeadLetter:
+		return d.Reject(false)
+	default:
+		return d.Reject(true)
+	}
+}
+
+// HandlerOptions configures Channel.ConsumeHandler.
+type HandlerOptions struct {
+	// Concurrency is the number of deliveries handled at the same time,
+	// 1 when zero.  Use Channel.Qos to let the server push enough deliveries.
+	Concurrency int
+
+	// OnError settles the deliveries for which the handler returned an error.
+	OnError Outcome
+
+	// OnPanic settles the deliveries for which the handler panicked.  The
+	// panic is recovered and logged with its stack trace with the package
+	// Logger.
+	OnPanic Outcome
+
+	// PanicHook, when set, is also called with the delivery, the recovered
+	// value and the stack trace of every panic, for example to emit a metric.
+	PanicHook func(d Delivery, recovered interface{}, stack []byte)
+
+	// Dedup, when set, is consulted before calling the handler: deliveries
+	// already processed are acknowledged without calling it.  Deliveries are
+	// marked as processed once the handler succeeded and their
+	// acknowledgement was sent, so a crash in between still processes them
+	// twice.  See DedupStore.
+	Dedup DedupStore
+
+	// DedupKey returns the id of a delivery in Dedup, the MessageId when
+	// nil.  Deliveries with an empty id are not deduplicated.
+	DedupKey func(d Delivery) string
+
+	// DedupTTL is how long an ExpiringDedupStore remembers a processed id,
+	// 24h when zero.
+	DedupTTL time.Duration
+}
+
+/*
+ConsumeHandler starts a consumer on queue and calls handler with every delivery
+until ctx is done or the channel is closed.  It blocks until the consumer has
+stopped and all running handlers have returned.
+
+The deliveries are never automatically acknowledged: ConsumeHandler settles
+each delivery once its handler returns, with an acknowledgement on success and
+according to opts otherwise.  A panic in the handler is recovered, so that it
+neither crashes the process nor leaves the delivery unacknowledged.
+
+It returns ctx.Err() when ctx is done, and ErrClosed when the channel or
+connection was closed.  The consumer is configured with consumeOpts as in
+Channel.ConsumeWithOptions.
+
+	err := ch.ConsumeHandler(ctx, "jobs", "", func(d amqp.Delivery) error {
+		return process(d.Body)
+	}, amqp.HandlerOptions{Concurrency: 4, OnPanic: amqp.OutcomeDeadLetter})
+*/
+func (ch *Channel) ConsumeHandler(ctx context.Context, queue, consumer string, handler Handler, opts HandlerOptions, consumeOpts ...ConsumeOption) error {
+	return ch.consumeHandler(ctx, queue, consumer, handler, opts, nil, consumeOpts...)
+}
+
+// consumeHandler implements ConsumeHandler, passing the workers to started
+// once the consumer is started, so that their number can be changed.
+func (ch *Channel) consumeHandler(ctx context.Context, queue, consumer string, handler Handler, opts HandlerOptions, started func(*workerPool), consumeOpts ...ConsumeOption) error {
+	deliveries, err := ch.ConsumeWithOptions(ctx, queue, consumer, false, consumeOpts...)
+	if err != nil {
+		return err
+	}
+
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = 1
+	}
+
+	pool := newWorkerPool(deliveries, handler, opts)
+	pool.resize(workers)
+	if started != nil {
+		started(pool)
+	}
+	<-pool.done
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return ErrClosed
+}
+
+// workerPool handles deliveries with a number of workers that can change
+// while it runs.
+type workerPool struct {
+	deliveries <-chan Delivery
+	handler    Handler
+	opts       HandlerOptions
+
+	m       sync.Mutex
+	stops   []chan struct{} // one per worker that was not asked to stop
+	running int             // workers that have not returned
+	closed  bool            // deliveries are closed, no worker is started anymore
+	done    chan struct{}   // closed once deliveries are closed and all workers returned
+}
+
+func newWorkerPool(deliveries <-chan Delivery, handler Handler, opts HandlerOptions) *workerPool {
+	return &workerPool{
+		deliveries: deliveries,
+		handler:    handler,
+		opts:       opts,
+		done:       make(chan struct{}),
+	}
+}
+
+// resize starts or stops workers until n are running, at least one.  Stopped
+// workers finish handling their current delivery first.
+func (p *workerPool) resize(n int) {
+	if n < 1 {
+		n = 1
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	for len(p.stops) < n {
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		p.running++
+		go p.work(stop)
+	}
+
+	for len(p.stops) > n {
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
+	}
+}
+
+// size returns the number of workers.
+func (p *workerPool) size() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return len(p.stops)
+}
+
+func (p *workerPool) work(stop chan struct{}) {
+	closed := false
+	defer func() {
+		p.m.Lock()
+		defer p.m.Unlock()
+
+		p.running--
+		if closed {
+			p.closed = true
+		}
+		if p.closed && p.running == 0 {
+			close(p.done)
+		}
+	}()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case d, ok := <-p.deliveries:
+			if !ok {
+				closed = true
+				return
+			}
+			handleDelivery(d, p.handler, p.opts)
+		}
+	}
+}
+
+// handleDelivery calls the handler and settles the delivery according to the
+// result, recovering from panics.
+func handleDelivery(d Delivery, handler Handler, opts HandlerOptions) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			stack := debug.Stack()
+			Logger.Printf("recovered from panic in handler of delivery %d of consumer %q: %v\n%s", d.DeliveryTag, d.ConsumerTag, recovered, stack)
+			if opts.PanicHook != nil {
+				opts.PanicHook(d, recovered, stack)
+			}
+			_ = opts.OnPanic.settle(d)
+		}
+	}()
+
+	if opts.seen(d) {
+		_ = d.Ack(false)
+		return
+	}
+
+	if err := handler(d); err != nil {
+		_ = opts.OnError.settle(d)
+		return
+	}
+
+	if err := d.Ack(false); err == nil {
+		opts.processed(d)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestConsumeHandlerOutcomes(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: tag})
+		}
+
+		panicked := &basicReject{}
+		srv.recv(1, panicked)
+		if panicked.DeliveryTag != 1 || panicked.Requeue {
+			t.Errorf("expected the delivery of the panicking handler to be dead-lettered, got %+v", panicked)
+		}
+
+		failed := &basicReject{}
+		srv.recv(1, failed)
+		if failed.DeliveryTag != 2 || !failed.Requeue {
+			t.Errorf("expected the delivery of the failing handler to be requeued, got %+v", failed)
+		}
+
+		ack := &basicAck{}
+		srv.recv(1, ack)
+		if ack.DeliveryTag != 3 {
+			t.Errorf("expected the handled delivery to be acknowledged, got %+v", ack)
+		}
+
+		cancel()
+
+		basicCancel := &basicCancel{}
+		srv.recv(1, basicCancel)
+		srv.send(1, &basicCancelOk{ConsumerTag: basicCancel.ConsumerTag})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	var recovered interface{}
+
+	err = ch.ConsumeHandler(ctx, "jobs", "", func(d Delivery) error {
+		switch d.DeliveryTag {
+		case 1:
+			panic("boom")
+		case 2:
+			return errors.New("failed")
+		}
+		return nil
+	}, HandlerOptions{
+		OnError:   OutcomeRequeue,
+		OnPanic:   OutcomeDeadLetter,
+		PanicHook: func(_ Delivery, v interface{}, _ []byte) { recovered = v },
+	})
+
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if recovered != "boom" {
+		t.Fatalf("expected the panic hook to receive the recovered value, got %v", recovered)
+	}
+}
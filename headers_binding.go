@@ -0,0 +1,160 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// HeadersMatchArg is the binding argument telling a headers exchange how the
+// headers of a binding are matched against the headers of a message.
+const HeadersMatchArg = "x-match"
+
+// HeadersMatch is the value of the x-match argument of a headers exchange
+// binding.
+type HeadersMatch string
+
+const (
+	// MatchAll routes messages having all the headers of the binding.
+	MatchAll HeadersMatch = "all"
+	// MatchAny routes messages having at least one of the headers of the
+	// binding.
+	MatchAny HeadersMatch = "any"
+	// MatchAllWithX is MatchAll also comparing the message headers starting
+	// with "x-", which are ignored otherwise.  Requires RabbitMQ 3.10.
+	MatchAllWithX HeadersMatch = "all-with-x"
+	// MatchAnyWithX is MatchAny also comparing the message headers starting
+	// with "x-", which are ignored otherwise.  Requires RabbitMQ 3.10.
+	MatchAnyWithX HeadersMatch = "any-with-x"
+)
+
+/*
+HeadersBinding builds the arguments of a binding to a headers exchange.
+
+A headers exchange compares both the type and the value of headers, so a
+binding on the int32 value 1 does not route a message with the int64 value 1
+in the same header.  The typed methods of HeadersBinding make the type of each
+matcher explicit, and the errors of invalid matchers are reported by Args
+instead of the binding silently never matching:
+
+	args, err := amqp.NewHeadersBinding(amqp.MatchAll).
+		String("format", "pdf").
+		Int32("version", 2).
+		Exists("tenant").
+		Args()
+	err = ch.QueueBind("reports", "", "documents", false, args)
+*/
+type HeadersBinding struct {
+	match   HeadersMatch
+	headers Table
+	errs    []error
+}
+
+// NewHeadersBinding returns an empty binding matching headers with match.
+func NewHeadersBinding(match HeadersMatch) *HeadersBinding {
+	b := &HeadersBinding{match: match, headers: Table{}}
+
+	switch match {
+	case MatchAll, MatchAny, MatchAllWithX, MatchAnyWithX:
+	default:
+		b.errs = append(b.errs, fmt.Errorf("unsupported %s value %q", HeadersMatchArg, match))
+	}
+
+	return b
+}
+
+// String matches the header key with a string value.
+func (b *HeadersBinding) String(key, value string) *HeadersBinding {
+	return b.set(key, value)
+}
+
+// Bool matches the header key with a boolean value.
+func (b *HeadersBinding) Bool(key string, value bool) *HeadersBinding {
+	return b.set(key, value)
+}
+
+// Int32 matches the header key with a signed 32-bit integer value.
+func (b *HeadersBinding) Int32(key string, value int32) *HeadersBinding {
+	return b.set(key, value)
+}
+
+// Int64 matches the header key with a signed 64-bit integer value.
+func (b *HeadersBinding) Int64(key string, value int64) *HeadersBinding {
+	return b.set(key, value)
+}
+
+// Float64 matches the header key with a double precision value.
+func (b *HeadersBinding) Float64(key string, value float64) *HeadersBinding {
+	return b.set(key, value)
+}
+
+// Exists matches messages having the header key, whatever its value.
+func (b *HeadersBinding) Exists(key string) *HeadersBinding {
+	return b.set(key, nil)
+}
+
+// Header matches the header key with value, which must be one of the field
+// types of Table other than nested tables and arrays.  Prefer the typed
+// methods, which make the matched type explicit.
+func (b *HeadersBinding) Header(key string, value interface{}) *HeadersBinding {
+	switch value.(type) {
+	case Table, []interface{}:
+		b.errs = append(b.errs, fmt.Errorf("header %q: %T values cannot be matched", key, value))
+		return b
+	}
+
+	if err := validateField(value); err != nil {
+		b.errs = append(b.errs, fmt.Errorf("header %q: %w", key, err))
+		return b
+	}
+
+	return b.set(key, value)
+}
+
+func (b *HeadersBinding) set(key string, value interface{}) *HeadersBinding {
+	switch {
+	case key == "":
+		b.errs = append(b.errs, errors.New("header key must not be empty"))
+	case strings.HasPrefix(key, "x-"):
+		// The exchange ignores the binding arguments starting with "x-".
+		b.errs = append(b.errs, fmt.Errorf("header %q: keys starting with x- are never matched", key))
+	default:
+		if _, dup := b.headers[key]; dup {
+			b.errs = append(b.errs, fmt.Errorf("header %q matched twice", key))
+		}
+		b.headers[key] = value
+	}
+	return b
+}
+
+// Args returns the binding arguments, or the errors of all invalid matchers.
+func (b *HeadersBinding) Args() (Table, error) {
+	if len(b.errs) > 0 {
+		return nil, errors.Join(b.errs...)
+	}
+	if len(b.headers) == 0 {
+		return nil, errors.New("headers binding without any header")
+	}
+
+	args := make(Table, len(b.headers)+1)
+	for k, v := range b.headers {
+		args[k] = v
+	}
+	args[HeadersMatchArg] = string(b.match)
+
+	return args, nil
+}
+
+// Binding returns a Binding from exchange with the arguments of b, for use
+// with Channel.QueueBindAll.
+func (b *HeadersBinding) Binding(exchange string) (Binding, error) {
+	args, err := b.Args()
+	if err != nil {
+		return Binding{}, err
+	}
+	return Binding{Exchange: exchange, Args: args}, nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHeadersBindingArgs(t *testing.T) {
+	b, err := NewHeadersBinding(MatchAnyWithX).
+		String("format", "pdf").
+		Int32("version", 2).
+		Int64("size", 1<<40).
+		Bool("signed", true).
+		Float64("ratio", 0.5).
+		Exists("tenant").
+		Header("checksum", []byte{1, 2}).
+		Binding("documents")
+	if err != nil {
+		t.Fatalf("could not build binding: %v", err)
+	}
+
+	expected := Table{
+		"x-match":  "any-with-x",
+		"format":   "pdf",
+		"version":  int32(2),
+		"size":     int64(1 << 40),
+		"signed":   true,
+		"ratio":    0.5,
+		"tenant":   nil,
+		"checksum": []byte{1, 2},
+	}
+	if b.Exchange != "documents" || b.Key != "" || !reflect.DeepEqual(b.Args, expected) {
+		t.Fatalf("unexpected binding %+v", b)
+	}
+	if err := b.Args.Validate(); err != nil {
+		t.Fatalf("expected valid arguments, got %v", err)
+	}
+}
+
+func TestHeadersBindingErrors(t *testing.T) {
+	tests := map[string]struct {
+		binding  *HeadersBinding
+		expected string
+	}{
+		"match":     {NewHeadersBinding("some").String("a", "b"), `unsupported x-match value "some"`},
+		"empty":     {NewHeadersBinding(MatchAll), "without any header"},
+		"empty key": {NewHeadersBinding(MatchAll).String("", "b"), "must not be empty"},
+		"x- key":    {NewHeadersBinding(MatchAll).String("x-match", "any"), "never matched"},
+		"duplicate": {NewHeadersBinding(MatchAll).String("a", "b").Int32("a", 1), "matched twice"},
+		"table":     {NewHeadersBinding(MatchAll).Header("a", Table{}), "cannot be matched"},
+		"type":      {NewHeadersBinding(MatchAll).Header("a", uint32(1)), "not supported"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			args, err := test.binding.Args()
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected error containing %q, got %v (%v)", test.expected, err, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+)
+
+/*
+HealthCheck verifies that conn is usable, which makes it suitable as the body of
+a readiness probe.  It checks that the connection is open, then opens and closes
+a throwaway channel, and finally, for each of the given queues, checks that the
+queue exists with a passive declare on that channel.
+
+HealthCheck returns nil when all checks pass within the deadline of ctx, and
+ctx.Err() when they did not complete in time.  The checks run in their own
+goroutine, which keeps waiting for the server in the background after ctx is
+done; on a dead connection it exits once the heartbeat timeout closes the
+connection.
+
+	http.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+		defer cancel()
+		if err := amqp.HealthCheck(ctx, conn, "jobs"); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		}
+	})
+*/
+func HealthCheck(ctx context.Context, conn *Connection, queues ...string) error {
+	if conn == nil || conn.IsClosed() {
+		return ErrClosed
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+
+	go func() {
+		ch, err := conn.Channel()
+		if err != nil {
+			done <- err
+			return
+		}
+
+		for _, name := range queues {
+			if _, err := ch.QueueDeclarePassive(name, false, false, false, false, nil); err != nil {
+				// the server closes the channel on failure
+				done <- fmt.Errorf("queue %q: %w", name, err)
+				return
+			}
+		}
+
+		done <- ch.Close()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckMissingQueue(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'jobs'"})
+		srv.recv(1, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	err = HealthCheck(context.Background(), c, "jobs")
+
+	var amqpErr *Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != NotFound {
+		t.Fatalf("expected a NOT_FOUND error, got %v", err)
+	}
+}
+
+func TestHealthCheckDeadline(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		// never reply to channel.open
+		srv.recv(1, &channelOpen{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := HealthCheck(ctx, c); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestHealthCheckClosedConnection(t *testing.T) {
+	if err := HealthCheck(context.Background(), nil); err != ErrClosed {
+		t.Fatalf("expected ErrClosed for a nil connection, got %v", err)
+	}
+}
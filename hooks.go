@@ -0,0 +1,86 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+// PublishHook is called with every message published on a channel, before it
+// is sent.  The hook may modify msg.  Returning an error rejects the message:
+// it is not sent and the error is returned by the publish method.
+//
+// The Headers table of msg is the one passed by the caller, so replace it with
+// a copy rather than modifying it when the caller may reuse it.
+type PublishHook func(exchange, key string, msg *Publishing) error
+
+// DeliveryHook is called with every delivery of a consumer started with
+// Channel.ConsumeWithOptions, before it is handed to the application.  The
+// hook may modify d.  Returning false drops the delivery, in which case the
+// hook is responsible for acknowledging or rejecting it unless the consumer
+// automatically acknowledges deliveries.
+type DeliveryHook func(d *Delivery) bool
+
+// AddPublishHook registers a hook called with every message published on this
+// channel.  Hooks are called in the order they were added.
+func (ch *Channel) AddPublishHook(hook PublishHook) {
+	ch.hookM.Lock()
+	defer ch.hookM.Unlock()
+
+	ch.publishHooks = append(ch.publishHooks, hook)
+}
+
+func (ch *Channel) runPublishHooks(exchange, key string, msg *Publishing) error {
+	ch.hookM.RLock()
+	hooks := ch.publishHooks
+	ch.hookM.RUnlock()
+
+	for _, hook := range hooks {
+		if err := hook(exchange, key, msg); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filterDeliveries runs the hooks on each delivery of in, and forwards the
+// deliveries kept by all hooks to the returned chan, which is closed when in
+// is closed.
+func filterDeliveries(in <-chan Delivery, hooks []DeliveryHook) <-chan Delivery {
+	out := make(chan Delivery)
+
+	go func() {
+		defer close(out)
+
+	deliveries:
+		for d := range in {
+			for _, hook := range hooks {
+				if !hook(&d) {
+					continue deliveries
+				}
+			}
+			out <- d
+		}
+	}()
+
+	return out
+}
+
+// deliveryPublishing returns a Publishing with the properties and body of d.
+func deliveryPublishing(d Delivery) Publishing {
+	return Publishing{
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// JournalEntry is a publishing recorded in a Journal.
+type JournalEntry struct {
+	ID         uint64 // assigned by Journal.Append
+	Exchange   string
+	RoutingKey string
+	Publishing Publishing
+}
+
+/*
+Journal is a write-ahead log of the publishings of a Publisher.  Every
+publishing is appended to the journal before it is sent, and completed once
+the server confirmed it, so that the publishings of a process that stopped
+before their confirmation can be published again by Publisher.RecoverJournal.
+
+Implementations must be safe for concurrent use, and Append must not return
+before the entry is durable.  FileJournal stores the journal in a file, other
+implementations can use a database or any storage shared by the instances of
+an application.
+*/
+type Journal interface {
+	// Append records a publishing and returns its ID.
+	Append(entry JournalEntry) (uint64, error)
+
+	// Complete marks the entry with the ID as confirmed.
+	Complete(id uint64) error
+
+	// Pending returns the entries not completed, in the order they were
+	// appended.
+	Pending() ([]JournalEntry, error)
+}
+
+// Kinds of the records of a FileJournal.
+const (
+	journalAppend   byte = 1
+	journalComplete byte = 2
+)
+
+/*
+FileJournal is a Journal stored in a file.  Appends are fsynced, completions
+are not: a completion lost in a crash makes the publishing published again,
+which at-least-once delivery allows.  The file is truncated whenever every
+entry is complete.
+*/
+type FileJournal struct {
+	m       sync.Mutex
+	f       *os.File
+	size    int64
+	nextID  uint64
+	pending map[uint64]int64 // offset of the entries not completed, by ID
+}
+
+// OpenFileJournal opens or creates the journal file at path.  A record
+// partially written by a crash is discarded.
+func OpenFileJournal(path string) (*FileJournal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	j := &FileJournal{f: f, nextID: 1, pending: make(map[uint64]int64)}
+	if err := j.scan(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// scan loads the pending entries of the file and truncates a partially
+// written record at its end.
+func (j *FileJournal) scan() error {
+	info, err := j.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(j.f, 0, info.Size()))}
+	for {
+		start := r.n
+		kind, id, err := readJournalRecord(r)
+		if err != nil {
+			if start < info.Size() {
+				return j.f.Truncate(start)
+			}
+			return nil
+		}
+
+		switch kind {
+		case journalAppend:
+			j.pending[id] = start
+		case journalComplete:
+			delete(j.pending, id)
+		}
+		if id >= j.nextID {
+			j.nextID = id + 1
+		}
+		j.size = r.n
+	}
+}
+
+// Append implements Journal.
+func (j *FileJournal) Append(entry JournalEntry) (uint64, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.f == nil {
+		return 0, ErrClosed
+	}
+
+	id := j.nextID
+	var buf bytes.Buffer
+	writeJournalHeader(&buf, journalAppend, id)
+	if err := writeSpooled(&buf, entry.Exchange, entry.RoutingKey, entry.Publishing); err != nil {
+		return 0, err
+	}
+
+	if err := j.write(buf.Bytes(), true); err != nil {
+		return 0, err
+	}
+
+	j.pending[id] = j.size - int64(buf.Len())
+	j.nextID++
+	return id, nil
+}
+
+// Complete implements Journal.
+func (j *FileJournal) Complete(id uint64) error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.f == nil {
+		return ErrClosed
+	}
+	if _, ok := j.pending[id]; !ok {
+		return nil
+	}
+
+	delete(j.pending, id)
+	if len(j.pending) == 0 {
+		j.size = 0
+		return j.f.Truncate(0)
+	}
+
+	var buf bytes.Buffer
+	writeJournalHeader(&buf, journalComplete, id)
+	return j.write(buf.Bytes(), false)
+}
+
+// write appends a record to the file, j.m must be held.
+func (j *FileJournal) write(record []byte, sync bool) error {
+	if _, err := j.f.WriteAt(record, j.size); err != nil {
+		return err
+	}
+	if sync {
+		if err := j.f.Sync(); err != nil {
+			return err
+		}
+	}
+	j.size += int64(len(record))
+	return nil
+}
+
+// Pending implements Journal.
+func (j *FileJournal) Pending() ([]JournalEntry, error) {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.f == nil {
+		return nil, ErrClosed
+	}
+
+	ids := make([]uint64, 0, len(j.pending))
+	for id := range j.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	entries := make([]JournalEntry, 0, len(ids))
+	for _, id := range ids {
+		// The header of the record is followed by the publishing.
+		offset := j.pending[id]
+		r := bufio.NewReader(io.NewSectionReader(j.f, offset+journalHeaderSize, j.size-offset-journalHeaderSize))
+		s, err := readSpooled(r)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, JournalEntry{ID: id, Exchange: s.exchange, RoutingKey: s.key, Publishing: s.msg})
+	}
+	return entries, nil
+}
+
+// Close closes the file of the journal.
+func (j *FileJournal) Close() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.f.Close()
+	j.f = nil
+	return err
+}
+
+// journalHeaderSize is the size of the kind and ID of a record.
+const journalHeaderSize = 9
+
+func writeJournalHeader(w *bytes.Buffer, kind byte, id uint64) {
+	var header [journalHeaderSize]byte
+	header[0] = kind
+	binary.BigEndian.PutUint64(header[1:], id)
+	w.Write(header[:])
+}
+
+// readJournalRecord reads a record and returns its kind and ID.
+func readJournalRecord(r io.Reader) (byte, uint64, error) {
+	var header [journalHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, 0, err
+	}
+
+	kind, id := header[0], binary.BigEndian.Uint64(header[1:])
+	switch kind {
+	case journalAppend:
+		if _, err := readSpooled(r); err != nil {
+			return 0, 0, err
+		}
+	case journalComplete:
+	default:
+		return 0, 0, fmt.Errorf("unknown journal record %d", kind)
+	}
+	return kind, id, nil
+}
+
+// journaled publishes with the journal of the publisher, if any.
+func (p *Publisher) journaled(ctx context.Context, exchange, key string, msg Publishing) error {
+	if p.opts.Journal == nil {
+		return p.spooled(ctx, exchange, key, msg)
+	}
+
+	id, err := p.opts.Journal.Append(JournalEntry{Exchange: exchange, RoutingKey: key, Publishing: msg})
+	if err != nil {
+		return fmt.Errorf("journal publishing: %w", err)
+	}
+
+	err = p.spooled(ctx, exchange, key, msg)
+	p.complete(id, err)
+	return err
+}
+
+// complete marks a journal entry as complete when its publishing is done
+// with: confirmed, returned as unroutable or spooled.
+func (p *Publisher) complete(id uint64, err error) {
+	var retErr *ReturnedError
+	if err != nil && !errors.As(err, &retErr) {
+		return
+	}
+	if err := p.opts.Journal.Complete(id); err != nil {
+		Logger.Printf("publisher could not complete journal entry %d: %v", id, err)
+	}
+}
+
+/*
+RecoverJournal publishes again, in order, the entries of the journal of the
+publisher that were not completed, usually because the process stopped before
+their confirmation.  Call it once at startup, before publishing.  It returns
+the number of entries published and stops at the first publishing that fails,
+returned publishings excepted.
+*/
+func (p *Publisher) RecoverJournal(ctx context.Context) (int, error) {
+	if p.opts.Journal == nil {
+		return 0, nil
+	}
+
+	entries, err := p.opts.Journal.Pending()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, entry := range entries {
+		err := p.spooled(ctx, entry.Exchange, entry.RoutingKey, entry.Publishing)
+		p.complete(entry.ID, err)
+
+		var retErr *ReturnedError
+		if err != nil && !errors.As(err, &retErr) {
+			return i, fmt.Errorf("recover journal entry %d: %w", entry.ID, err)
+		}
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileJournalPendingAfterReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+
+	j, err := OpenFileJournal(path)
+	if err != nil {
+		t.Fatalf("could not open journal: %v", err)
+	}
+
+	var ids []uint64
+	for _, key := range []string{"a", "b", "c"} {
+		id, err := j.Append(JournalEntry{RoutingKey: key, Publishing: Publishing{Body: []byte(key)}})
+		if err != nil {
+			t.Fatalf("could not append: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := j.Complete(ids[1]); err != nil {
+		t.Fatalf("could not complete: %v", err)
+	}
+	j.Close()
+
+	// A crash in the middle of appending another entry.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("could not open journal file: %v", err)
+	}
+	f.Write([]byte{journalAppend, 0, 0})
+	f.Close()
+
+	if j, err = OpenFileJournal(path); err != nil {
+		t.Fatalf("could not reopen journal: %v", err)
+	}
+	defer j.Close()
+
+	pending, err := j.Pending()
+	if err != nil {
+		t.Fatalf("could not list pending entries: %v", err)
+	}
+	if len(pending) != 2 || pending[0].ID != ids[0] || pending[0].RoutingKey != "a" ||
+		pending[1].ID != ids[2] || string(pending[1].Publishing.Body) != "c" {
+		t.Fatalf("unexpected pending entries: %+v", pending)
+	}
+
+	id, err := j.Append(JournalEntry{RoutingKey: "d"})
+	if err != nil || id <= ids[2] {
+		t.Fatalf("expected a new ID after %d, got %d: %v", ids[2], id, err)
+	}
+
+	for _, id := range []uint64{ids[0], ids[2], id} {
+		if err := j.Complete(id); err != nil {
+			t.Fatalf("could not complete: %v", err)
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the complete journal to be truncated, got: %v %v", info.Size(), err)
+	}
+}
+
+func TestPublisherRecoversJournal(t *testing.T) {
+	j, err := OpenFileJournal(filepath.Join(t.TempDir(), "journal"))
+	if err != nil {
+		t.Fatalf("could not open journal: %v", err)
+	}
+	defer j.Close()
+
+	// Left by a process that stopped before the confirmation.
+	if _, err := j.Append(JournalEntry{RoutingKey: "q", Publishing: Publishing{Body: []byte("unconfirmed")}}); err != nil {
+		t.Fatalf("could not append: %v", err)
+	}
+
+	bodies := make(chan string, 2)
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		for tag := uint64(1); tag <= 2; tag++ {
+			bodies <- string(srv.recv(1, &basicPublish{}).(*basicPublish).Body)
+			srv.send(1, &basicAck{DeliveryTag: tag})
+		}
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{Journal: j})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if n, err := p.RecoverJournal(ctx); n != 1 || err != nil {
+		t.Fatalf("expected 1 recovered publishing, got %d: %v", n, err)
+	}
+	if err := p.Publish(ctx, "", "q", Publishing{Body: []byte("new")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	for _, want := range []string{"unconfirmed", "new"} {
+		if got := <-bodies; got != want {
+			t.Fatalf("expected publishing %q, got %q", want, got)
+		}
+	}
+
+	if pending, err := j.Pending(); err != nil || len(pending) != 0 {
+		t.Fatalf("expected every entry to be complete, got %+v: %v", pending, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ChannelLeak describes a channel that has been open without any traffic for
+// longer than Config.ChannelLeakTimeout.
+type ChannelLeak struct {
+	ID      uint16        // channel id
+	Idle    time.Duration // time since the last frame sent or received on the channel
+	Created string        // stack trace of the goroutine that opened the channel
+}
+
+func (l ChannelLeak) String() string {
+	return fmt.Sprintf("channel %d has been idle for %s, opened at:\n%s", l.ID, l.Idle, l.Created)
+}
+
+// leakTracker records where a channel was opened and when it was last used.
+type leakTracker struct {
+	created  string
+	lastUsed int64 // unix nanoseconds, only accessed as atomic
+
+	// reported is only accessed by the sweeper, it suppresses repeated
+	// reports until the channel is used again.
+	reported bool
+}
+
+func newLeakTracker() *leakTracker {
+	pcs := make([]uintptr, 32)
+	// skip runtime.Callers, newLeakTracker and Connection.allocateChannel
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var b strings.Builder
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&b, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			break
+		}
+	}
+
+	return &leakTracker{
+		created:  b.String(),
+		lastUsed: time.Now().UnixNano(),
+	}
+}
+
+// touch records traffic on the channel.  It is a no-op unless leak detection
+// is enabled.
+func (ch *Channel) touch() {
+	if ch.leak != nil {
+		atomic.StoreInt64(&ch.leak.lastUsed, time.Now().UnixNano())
+	}
+}
+
+// sweepLeaks periodically reports the channels that have been idle for longer
+// than the leak timeout, until done is closed with the transport.
+func (c *Connection) sweepLeaks(done chan struct{}) {
+	interval := c.leakTimeout / 2
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			for _, leak := range c.idleChannels(now) {
+				c.leakReport(leak)
+			}
+		}
+	}
+}
+
+// idleChannels returns the channels idle for longer than the leak timeout
+// that have not been reported yet.
+func (c *Connection) idleChannels(now time.Time) []ChannelLeak {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var leaks []ChannelLeak
+	for id, ch := range c.channels {
+		if ch.leak == nil {
+			continue
+		}
+
+		idle := now.Sub(time.Unix(0, atomic.LoadInt64(&ch.leak.lastUsed)))
+		if idle < c.leakTimeout {
+			ch.leak.reported = false
+			continue
+		}
+
+		if !ch.leak.reported {
+			ch.leak.reported = true
+			leaks = append(leaks, ChannelLeak{ID: id, Idle: idle, Created: ch.leak.created})
+		}
+	}
+
+	return leaks
+}
+
+func logChannelLeak(leak ChannelLeak) {
+	Logger.Printf("%s", leak)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChannelLeakReportsIdleChannels(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.connectionClose()
+	}()
+
+	leaks := make(chan ChannelLeak, 1)
+	config := defaultConfig()
+	config.ChannelLeakTimeout = 20 * time.Millisecond
+	config.ChannelLeakReport = func(leak ChannelLeak) { leaks <- leak }
+
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	select {
+	case leak := <-leaks:
+		if leak.ID != 1 {
+			t.Fatalf("expected channel 1 to be reported, got %d", leak.ID)
+		}
+		if leak.Idle < config.ChannelLeakTimeout {
+			t.Fatalf("expected an idle time of at least %s, got %s", config.ChannelLeakTimeout, leak.Idle)
+		}
+		if !strings.Contains(leak.Created, "TestChannelLeakReportsIdleChannels") {
+			t.Fatalf("expected the creation site in the report, got:\n%s", leak.Created)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the idle channel to be reported")
+	}
+
+	select {
+	case leak := <-leaks:
+		t.Fatalf("expected a single report until the channel is used, got: %v", leak)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestIdleChannelsSkipsUsedChannels(t *testing.T) {
+	c := &Connection{
+		channels:    make(map[uint16]*Channel),
+		leakTimeout: time.Minute,
+	}
+
+	now := time.Now()
+	idle := &Channel{leak: &leakTracker{lastUsed: now.Add(-2 * time.Minute).UnixNano()}}
+	busy := &Channel{leak: &leakTracker{lastUsed: now.Add(-time.Second).UnixNano()}}
+	untracked := &Channel{}
+	c.channels[1] = idle
+	c.channels[2] = busy
+	c.channels[3] = untracked
+
+	leaks := c.idleChannels(now)
+	if len(leaks) != 1 || leaks[0].ID != 1 {
+		t.Fatalf("expected channel 1 to be reported, got: %v", leaks)
+	}
+
+	if leaks := c.idleChannels(now); len(leaks) != 0 {
+		t.Fatalf("expected no repeated report, got: %v", leaks)
+	}
+
+	idle.touch()
+	if leaks := c.idleChannels(time.Now()); len(leaks) != 0 {
+		t.Fatalf("expected a used channel not to be reported, got: %v", leaks)
+	}
+
+	if leaks := c.idleChannels(time.Now().Add(2 * time.Minute)); len(leaks) != 2 {
+		t.Fatalf("expected both channels to be reported again, got: %v", leaks)
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MigrationPhase tells a Migration where to publish.
+type MigrationPhase int32
+
+const (
+	// MigrationOld publishes to the old broker only.
+	MigrationOld MigrationPhase = iota
+	// MigrationDual publishes to both brokers and compares their
+	// confirmations.  The old broker remains authoritative.
+	MigrationDual
+	// MigrationNew publishes to the new broker only, once cut over.
+	MigrationNew
+)
+
+func (p MigrationPhase) String() string {
+	switch p {
+	case MigrationOld:
+		return "old"
+	case MigrationDual:
+		return "dual"
+	case MigrationNew:
+		return "new"
+	}
+	return "unknown"
+}
+
+// MigrationOptions configures a Migration.
+type MigrationOptions struct {
+	// Phase is the initial phase, MigrationOld when zero.
+	Phase MigrationPhase
+
+	// Shovel moves the messages left on the old broker to the new one
+	// during Migration.Drain, from its Queue to its Exchange.
+	Shovel ShovelOptions
+
+	// DrainInterval is how often Migration.Drain checks whether the queue
+	// is drained, 1s when zero.
+	DrainInterval time.Duration
+
+	// OnMismatch, when set, is called when the brokers did not confirm a
+	// publishing alike during the MigrationDual phase.
+	OnMismatch func(MigrationMismatch)
+}
+
+// MigrationMismatch is a publishing confirmed by one broker and not the
+// other during the MigrationDual phase.
+type MigrationMismatch struct {
+	Exchange   string
+	RoutingKey string
+	MessageId  string
+
+	// Old and New are the errors of the publishing on each broker, nil when
+	// it was confirmed, ErrNacked when it was negatively acknowledged.
+	Old error
+	New error
+}
+
+// MigrationStats are the counters of a Migration.
+type MigrationStats struct {
+	Phase      MigrationPhase
+	Mismatches uint64      // publishings the brokers did not confirm alike
+	Shovel     ShovelStats // of the last Drain
+}
+
+/*
+Migration moves an application between two brokers without downtime, for
+example to another cluster, or from a classic queue to a quorum queue declared
+on the new broker.  Publishers go through Migration.Publish, and the phase
+controls where publishings go:
+
+ 1. MigrationOld, the application publishes to the old broker.
+ 2. MigrationDual, publishings are sent to both brokers, so that the new one
+    receives the same traffic.  The confirmations are compared to detect the
+    publishings the new broker would have lost, but the result of the old
+    broker is returned.  Consumers move to the new broker.
+ 3. MigrationNew, after Cutover, publishings only go to the new broker.
+    Drain moves the messages left on the old broker with a Shovel.
+
+The phase can be changed back with SetPhase to roll back.  Each broker is a
+ChannelSource, like a Connection or a Client, on which the Migration opens its
+own channels in confirm mode.
+
+	migration := amqp.NewMigration(old, new, amqp.MigrationOptions{
+		Phase:  amqp.MigrationDual,
+		Shovel: amqp.ShovelOptions{Queue: "jobs", Exchange: "jobs"},
+	})
+	err := migration.Publish(ctx, "jobs", "resize", false, false, msg)
+	...
+	migration.Cutover()
+	err = migration.Drain(ctx)
+
+A Migration is safe for concurrent use.
+*/
+type Migration struct {
+	old, new migrationSide
+	opts     MigrationOptions
+
+	phase      int32
+	mismatches uint64
+
+	m      sync.Mutex
+	shovel *Shovel
+}
+
+// migrationSide is a broker of a Migration with its publishing channel.
+type migrationSide struct {
+	source ChannelSource
+
+	m  sync.Mutex
+	ch *Channel
+}
+
+// NewMigration returns a Migration from the old broker to the new one.
+func NewMigration(old, new ChannelSource, opts MigrationOptions) *Migration {
+	if opts.DrainInterval <= 0 {
+		opts.DrainInterval = time.Second
+	}
+
+	return &Migration{
+		old:   migrationSide{source: old},
+		new:   migrationSide{source: new},
+		opts:  opts,
+		phase: int32(opts.Phase),
+	}
+}
+
+// Phase returns the current phase.
+func (m *Migration) Phase() MigrationPhase {
+	return MigrationPhase(atomic.LoadInt32(&m.phase))
+}
+
+// SetPhase changes where the next publishings go.  Publishings in progress
+// complete in their phase.
+func (m *Migration) SetPhase(phase MigrationPhase) {
+	atomic.StoreInt32(&m.phase, int32(phase))
+}
+
+// Cutover publishes to the new broker only, like SetPhase(MigrationNew).
+func (m *Migration) Cutover() {
+	m.SetPhase(MigrationNew)
+}
+
+/*
+Publish sends msg to the brokers of the current phase and waits for their
+confirmations.  It returns ErrNacked when the authoritative broker negatively
+acknowledged the publishing, the old one until the cutover.
+
+In the MigrationDual phase, a publishing that only one of the brokers
+confirmed is counted as a mismatch and passed to OnMismatch.
+*/
+func (m *Migration) Publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) error {
+	if phase := m.Phase(); phase != MigrationDual {
+		side := &m.old
+		if phase == MigrationNew {
+			side = &m.new
+		}
+		confirm, err := side.publish(ctx, exchange, key, mandatory, immediate, msg)
+		return confirmed(ctx, confirm, err)
+	}
+
+	oldConfirm, oldErr := m.old.publish(ctx, exchange, key, mandatory, immediate, msg)
+	newConfirm, newErr := m.new.publish(ctx, exchange, key, mandatory, immediate, msg)
+	oldErr = confirmed(ctx, oldConfirm, oldErr)
+	newErr = confirmed(ctx, newConfirm, newErr)
+
+	if ctx.Err() == nil && (oldErr == nil) != (newErr == nil) {
+		atomic.AddUint64(&m.mismatches, 1)
+		if m.opts.OnMismatch != nil {
+			m.opts.OnMismatch(MigrationMismatch{
+				Exchange:   exchange,
+				RoutingKey: key,
+				MessageId:  msg.MessageId,
+				Old:        oldErr,
+				New:        newErr,
+			})
+		}
+	}
+
+	return oldErr
+}
+
+// publish sends msg on the channel of the broker, opening it in confirm mode
+// when it is missing or closed.
+func (s *migrationSide) publish(ctx context.Context, exchange, key string, mandatory, immediate bool, msg Publishing) (*DeferredConfirmation, error) {
+	s.m.Lock()
+	if s.ch == nil || s.ch.IsClosed() {
+		ch, err := s.source.Channel()
+		if err != nil {
+			s.m.Unlock()
+			return nil, err
+		}
+		if err := ch.Confirm(false); err != nil {
+			s.m.Unlock()
+			_ = ch.Close()
+			return nil, err
+		}
+		s.ch = ch
+	}
+	ch := s.ch
+	s.m.Unlock()
+
+	return ch.PublishWithDeferredConfirmWithContext(ctx, exchange, key, mandatory, immediate, msg)
+}
+
+func (s *migrationSide) close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.ch == nil {
+		return nil
+	}
+	err := s.ch.Close()
+	s.ch = nil
+	return err
+}
+
+/*
+Drain runs a Shovel moving the messages of MigrationOptions.Shovel.Queue on
+the old broker to the new one, until the queue is drained.  It returns nil
+once the queue was found empty twice in a row, DrainInterval apart, with no
+message moved in between and none in flight, which tells that the publishers
+and the consumers of the old queue are gone.
+
+Drain is usually called after Cutover.  It returns ctx.Err() when ctx is done
+first, and the error of the Shovel when it stops.
+*/
+func (m *Migration) Drain(ctx context.Context) error {
+	source, err := m.old.source.Channel()
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	check, err := m.old.source.Channel()
+	if err != nil {
+		return err
+	}
+	defer check.Close()
+
+	destination, err := m.new.source.Channel()
+	if err != nil {
+		return err
+	}
+	defer destination.Close()
+
+	shovel := NewShovel(source, destination, m.opts.Shovel)
+	m.m.Lock()
+	m.shovel = shovel
+	m.m.Unlock()
+
+	run, stop := context.WithCancel(ctx)
+	defer stop()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- shovel.Run(run)
+	}()
+
+	ticker := time.NewTicker(m.opts.DrainInterval)
+	defer ticker.Stop()
+
+	var last ShovelStats
+	idle := false
+	for {
+		select {
+		case err := <-done:
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		case <-ticker.C:
+		}
+
+		queue, err := check.QueueDeclarePassive(m.opts.Shovel.Queue, false, false, false, false, nil)
+		if err != nil {
+			stop()
+			<-done
+			return err
+		}
+
+		stats := shovel.Stats()
+		empty := queue.Messages == 0 && stats.InFlight == 0
+		if empty && idle && stats.Forwarded == last.Forwarded && stats.Requeued == last.Requeued {
+			stop()
+			<-done
+			return nil
+		}
+		idle, last = empty, stats
+	}
+}
+
+// Stats returns the counters of the migration.
+func (m *Migration) Stats() MigrationStats {
+	stats := MigrationStats{
+		Phase:      m.Phase(),
+		Mismatches: atomic.LoadUint64(&m.mismatches),
+	}
+
+	m.m.Lock()
+	if m.shovel != nil {
+		stats.Shovel = m.shovel.Stats()
+	}
+	m.m.Unlock()
+
+	return stats
+}
+
+// Close closes the publishing channels of the migration, not the brokers.
+func (m *Migration) Close() error {
+	err := m.old.close()
+	if e := m.new.close(); err == nil {
+		err = e
+	}
+	return err
+}
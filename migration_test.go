@@ -0,0 +1,152 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMigrationComparesDualPublishings(t *testing.T) {
+	served := make(chan struct{}, 2)
+
+	old := openPublisherConnection(t, func(srv *server) {
+		defer func() { served <- struct{}{} }()
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	var published []*basicPublish
+	next := openPublisherConnection(t, func(srv *server) {
+		defer func() { served <- struct{}{} }()
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 1})
+		published = append(published, srv.recv(1, &basicPublish{}).(*basicPublish))
+		srv.send(1, &basicAck{DeliveryTag: 2})
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	var mismatches []MigrationMismatch
+	migration := NewMigration(old, next, MigrationOptions{
+		Phase:      MigrationDual,
+		OnMismatch: func(m MigrationMismatch) { mismatches = append(mismatches, m) },
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := migration.Publish(ctx, "jobs", "resize", false, false, Publishing{MessageId: "1"}); err != nil {
+		t.Fatalf("expected the result of the old broker, got %v", err)
+	}
+	if len(mismatches) != 1 || mismatches[0].MessageId != "1" || mismatches[0].Old != nil || !errors.Is(mismatches[0].New, ErrNacked) {
+		t.Fatalf("expected the nack of the new broker to be reported, got %+v", mismatches)
+	}
+
+	migration.Cutover()
+	if err := migration.Publish(ctx, "jobs", "resize", false, false, Publishing{MessageId: "2"}); err != nil {
+		t.Fatalf("could not publish after the cutover: %v", err)
+	}
+	if len(published) != 1 || published[0].Properties.MessageId != "2" {
+		t.Fatalf("expected the publishing on the new broker only, got %+v", published)
+	}
+
+	if stats := migration.Stats(); stats.Phase != MigrationNew || stats.Mismatches != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	if err := migration.Close(); err != nil {
+		t.Fatalf("could not close migration: %v", err)
+	}
+	if err := old.Close(); err != nil {
+		t.Fatalf("could not close old connection: %v", err)
+	}
+	if err := next.Close(); err != nil {
+		t.Fatalf("could not close new connection: %v", err)
+	}
+	<-served
+	<-served
+}
+
+func TestMigrationDrainStopsOnceTheQueueIsEmpty(t *testing.T) {
+	served := make(chan struct{}, 2)
+	var polls int
+
+	old := openPublisherConnection(t, func(srv *server) {
+		defer func() { served <- struct{}{} }()
+		srv.channelOpen(1)
+		srv.channelOpen(2)
+
+		srv.recv(1, &basicQos{})
+		srv.send(1, &basicQosOk{})
+		consume := srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1, Body: []byte("left")})
+		srv.recv(1, &basicAck{})
+
+		for polls = 0; polls < 2; polls++ {
+			srv.recv(2, &queueDeclare{})
+			srv.send(2, &queueDeclareOk{Queue: "jobs"})
+		}
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: consume.ConsumerTag})
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	next := openPublisherConnection(t, func(srv *server) {
+		defer func() { served <- struct{}{} }()
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	migration := NewMigration(old, next, MigrationOptions{
+		Phase:         MigrationNew,
+		Shovel:        ShovelOptions{Queue: "jobs", Exchange: "jobs", BatchSize: 1},
+		DrainInterval: 100 * time.Millisecond,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := migration.Drain(ctx); err != nil {
+		t.Fatalf("could not drain the queue: %v", err)
+	}
+	if stats := migration.Stats(); stats.Shovel.Forwarded != 1 || stats.Shovel.InFlight != 0 {
+		t.Fatalf("expected the message left to be moved, got %+v", stats.Shovel)
+	}
+
+	if err := old.Close(); err != nil {
+		t.Fatalf("could not close old connection: %v", err)
+	}
+	if err := next.Close(); err != nil {
+		t.Fatalf("could not close new connection: %v", err)
+	}
+	<-served
+	<-served
+
+	if polls != 2 {
+		t.Fatalf("expected two polls of the queue, got %d", polls)
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"time"
+)
+
+// QueueSample is the depth of a queue at a point in time, as reported by a
+// passive declare.
+type QueueSample struct {
+	Queue     string
+	Messages  int // messages ready for delivery
+	Consumers int // consumers of the queue
+	At        time.Time
+	Err       error // set when the queue could not be sampled, e.g. it does not exist
+}
+
+// QueueEvent reports that the depth of a queue crossed one of its thresholds.
+type QueueEvent struct {
+	Queue     string
+	Threshold int  // the crossed threshold
+	Above     bool // true when the depth reached the threshold, false when it went back below
+	Sample    QueueSample
+}
+
+// QueueMonitorOptions configures a QueueMonitor.
+type QueueMonitorOptions struct {
+	// Queues to sample.
+	Queues []string
+
+	// Interval between samples, 10s when zero.
+	Interval time.Duration
+
+	// Thresholds lists, per queue, the depths for which an event is emitted
+	// when the number of ready messages reaches them or goes back below.
+	Thresholds map[string][]int
+
+	// OnSample is called with every sample.
+	OnSample func(QueueSample)
+
+	// OnEvent is called when a threshold is crossed.
+	OnEvent func(QueueEvent)
+}
+
+/*
+QueueMonitor periodically samples the depth and consumer count of queues with
+passive declares, and reports threshold crossings, which is enough to drive
+autoscaling or alerting without the management API.
+
+The samples are taken on a dedicated channel of the connection, reopened when
+the server closes it because a queue does not exist.  Callbacks run on the
+goroutine of Run.
+*/
+type QueueMonitor struct {
+	conn   *Connection
+	source ChannelSource // opens the sampling channel, conn unless sampling for a Consumer
+	opts   QueueMonitorOptions
+	ch     *Channel
+
+	above map[string]map[int]bool // threshold state per queue, only used by Run
+}
+
+// NewQueueMonitor returns a QueueMonitor of queues on conn.  Call Run to start
+// sampling.
+func NewQueueMonitor(conn *Connection, opts QueueMonitorOptions) *QueueMonitor {
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+
+	return &QueueMonitor{
+		conn:   conn,
+		source: conn,
+		opts:   opts,
+		above:  make(map[string]map[int]bool),
+	}
+}
+
+// Run samples the queues right away and then at every interval until ctx is
+// done or the connection is closed.  It returns ctx.Err() or ErrClosed.
+func (m *QueueMonitor) Run(ctx context.Context) error {
+	defer m.close()
+
+	ticker := time.NewTicker(m.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		for _, sample := range m.sample() {
+			if m.opts.OnSample != nil {
+				m.opts.OnSample(sample)
+			}
+			if sample.Err == nil {
+				m.check(sample)
+			}
+		}
+
+		if m.conn.IsClosed() {
+			return ErrClosed
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample takes one sample of every queue.
+func (m *QueueMonitor) sample() []QueueSample {
+	samples := make([]QueueSample, 0, len(m.opts.Queues))
+
+	for _, name := range m.opts.Queues {
+		sample := QueueSample{Queue: name, At: time.Now()}
+
+		if m.ch == nil || m.ch.IsClosed() {
+			if m.ch, sample.Err = m.source.Channel(); sample.Err != nil {
+				m.ch = nil
+				samples = append(samples, sample)
+				continue
+			}
+		}
+
+		q, err := m.ch.QueueDeclarePassive(name, false, false, false, false, nil)
+		if err != nil {
+			sample.Err = err
+		} else {
+			sample.Messages, sample.Consumers = q.Messages, q.Consumers
+		}
+
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
+
+// close closes the sampling channel.
+func (m *QueueMonitor) close() {
+	if m.ch != nil {
+		_ = m.ch.Close()
+		m.ch = nil
+	}
+}
+
+// check emits events for the thresholds crossed since the previous sample.
+func (m *QueueMonitor) check(sample QueueSample) {
+	state := m.above[sample.Queue]
+	if state == nil {
+		state = make(map[int]bool)
+		m.above[sample.Queue] = state
+	}
+
+	for _, threshold := range m.opts.Thresholds[sample.Queue] {
+		above := sample.Messages >= threshold
+		if above == state[threshold] {
+			continue
+		}
+		state[threshold] = above
+
+		if m.opts.OnEvent != nil {
+			m.opts.OnEvent(QueueEvent{Queue: sample.Queue, Threshold: threshold, Above: above, Sample: sample})
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueueMonitorSamplesAndReportsCrossings(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+
+		// first round, the missing queue closes the channel
+		srv.channelOpen(1)
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &queueDeclareOk{Queue: "jobs", MessageCount: 5, ConsumerCount: 1})
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND"})
+		srv.recv(1, &channelCloseOk{})
+
+		// second round on a new channel
+		srv.channelOpen(2)
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &queueDeclareOk{Queue: "jobs", MessageCount: 1, ConsumerCount: 2})
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &queueDeclareOk{Queue: "other", MessageCount: 0, ConsumerCount: 0})
+
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var samples []QueueSample
+	var events []QueueEvent
+	monitor := NewQueueMonitor(c, QueueMonitorOptions{
+		Queues:     []string{"jobs", "other"},
+		Interval:   time.Millisecond,
+		Thresholds: map[string][]int{"jobs": {3}},
+		OnSample: func(s QueueSample) {
+			samples = append(samples, s)
+			if len(samples) == 4 {
+				cancel()
+			}
+		},
+		OnEvent: func(e QueueEvent) { events = append(events, e) },
+	})
+
+	if err := monitor.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the monitor to stop with the context, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	if len(samples) != 4 {
+		t.Fatalf("expected 4 samples, got %+v", samples)
+	}
+	if s := samples[0]; s.Messages != 5 || s.Consumers != 1 || s.Err != nil {
+		t.Fatalf("unexpected first sample %+v", s)
+	}
+	var amqpErr *Error
+	if !errors.As(samples[1].Err, &amqpErr) || amqpErr.Code != NotFound {
+		t.Fatalf("expected the missing queue to fail with NOT_FOUND, got %+v", samples[1])
+	}
+	if s := samples[2]; s.Messages != 1 || s.Consumers != 2 || s.Err != nil {
+		t.Fatalf("unexpected third sample %+v", s)
+	}
+
+	if len(events) != 2 || !events[0].Above || events[1].Above || events[1].Threshold != 3 {
+		t.Fatalf("expected the threshold to be crossed up then down, got %+v", events)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+)
+
+// closeCallbacks holds the callbacks registered with OnClose.  They run once,
+// when their owner shuts down, or right away when registered afterwards.
+type closeCallbacks struct {
+	m     sync.Mutex
+	fired bool
+	err   *Error
+	funcs []func(*Error)
+}
+
+func (cb *closeCallbacks) add(f func(*Error)) {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if cb.fired {
+		go f(cb.err)
+		return
+	}
+
+	cb.funcs = append(cb.funcs, f)
+}
+
+// fire runs the registered callbacks, in registration order, on a new
+// goroutine so that they can safely call back into the closed Connection or
+// Channel.  Only the first call has any effect.
+func (cb *closeCallbacks) fire(err *Error) {
+	cb.m.Lock()
+	defer cb.m.Unlock()
+
+	if cb.fired {
+		return
+	}
+
+	cb.fired = true
+	cb.err = err
+
+	funcs := cb.funcs
+	cb.funcs = nil
+
+	if len(funcs) > 0 {
+		go func() {
+			for _, f := range funcs {
+				f(err)
+			}
+		}()
+	}
+}
+
+/*
+OnClose registers a callback run exactly once when the connection is closed.
+The callback receives the error that closed the connection, or nil on a
+graceful close.
+
+Unlike NotifyClose, there is no chan to drain and nothing is missed when the
+callback is registered too late: when the connection is already closed, the
+callback runs right away with the error the connection was closed with.
+
+Callbacks run on their own goroutine, in the order they were registered, and
+may call methods of the connection.
+*/
+func (c *Connection) OnClose(f func(*Error)) {
+	c.onClose.add(f)
+}
+
+/*
+OnClose registers a callback run exactly once when the channel is closed,
+either by a channel or connection exception, or by Channel.Close or
+Connection.Close.  The callback receives the error that closed the channel, or
+nil on a graceful close.
+
+When the channel is already closed, the callback runs right away with the
+error the channel was closed with.
+
+Callbacks run on their own goroutine, in the order they were registered, and
+may call methods of the channel.
+*/
+func (ch *Channel) OnClose(f func(*Error)) {
+	ch.onClose.add(f)
+}
+
+// closeError waits for the channel to finish shutting down and returns the
+// error it was closed with, nil on a graceful close.  It must only be called
+// once the channel is closed.
+func (ch *Channel) closeError(ctx context.Context) *Error {
+	closed := make(chan *Error, 1)
+	ch.onClose.add(func(e *Error) { closed <- e })
+
+	select {
+	case e := <-closed:
+		return e
+	case <-ctx.Done():
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func receiveClose(t *testing.T, fired <-chan *Error) *Error {
+	t.Helper()
+
+	select {
+	case err := <-fired:
+		return err
+	case <-time.After(time.Second):
+		t.Fatalf("expected the close callback to run")
+		return nil
+	}
+}
+
+func TestConnectionOnCloseFiresOnce(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	fired := make(chan *Error, 2)
+	c.OnClose(func(err *Error) { fired <- err })
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	if err := receiveClose(t, fired); err != nil {
+		t.Fatalf("expected a nil error on graceful close, got: %v", err)
+	}
+
+	c.shutdown(ErrClosed)
+
+	select {
+	case err := <-fired:
+		t.Fatalf("expected the callback to run once, ran again with: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConnectionOnCloseAfterClose(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.send(0, &connectionClose{ReplyCode: ConnectionForced, ReplyText: "shutdown"})
+		srv.recv(0, &connectionCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	closed := make(chan struct{})
+	c.OnClose(func(*Error) { close(closed) })
+	<-closed
+
+	fired := make(chan *Error, 1)
+	c.OnClose(func(err *Error) { fired <- err })
+
+	if err := receiveClose(t, fired); err == nil || err.Code != ConnectionForced {
+		t.Fatalf("expected the connection error, got: %v", err)
+	}
+}
+
+func TestChannelOnCloseWithChannelException(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.send(1, &channelClose{ReplyCode: PreconditionFailed, ReplyText: "precondition"})
+		srv.recv(1, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	fired := make(chan *Error, 1)
+	ch.OnClose(func(err *Error) { fired <- err })
+
+	if err := receiveClose(t, fired); err == nil || err.Code != PreconditionFailed {
+		t.Fatalf("expected the channel error, got: %v", err)
+	}
+
+	late := make(chan *Error, 1)
+	ch.OnClose(func(err *Error) { late <- err })
+
+	if err := receiveClose(t, late); err == nil || err.Code != PreconditionFailed {
+		t.Fatalf("expected the channel error for a late callback, got: %v", err)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+OpError describes the synchronous method that failed with a server exception,
+like the queue.declare of a queue that could not be declared.
+
+Errors returned by methods of Channel for server exceptions are still of type
+*Error, so that existing type assertions keep working, and give access to the
+OpError with errors.As:
+
+	if _, err := ch.QueueDeclare("jobs", true, false, false, false, nil); err != nil {
+		var op *amqp.OpError
+		if errors.As(err, &op) {
+			log.Printf("%s failed: %v", op.Op, op)
+		}
+	}
+*/
+type OpError struct {
+	Op          string // class and method, e.g. "queue.declare"
+	Queue       string // queue argument of the method, if any
+	Exchange    string // exchange argument, the destination of exchange.bind and exchange.unbind
+	Source      string // source exchange of exchange.bind and exchange.unbind
+	RoutingKey  string // routing key argument of bindings
+	ConsumerTag string // consumer tag of basic.consume and basic.cancel
+	Err         *Error // exception raised by the server
+}
+
+func (e *OpError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Op)
+
+	arg := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, " %s=%q", name, value)
+		}
+	}
+	arg("queue", e.Queue)
+	arg("exchange", e.Exchange)
+	arg("source", e.Source)
+	arg("routing-key", e.RoutingKey)
+	arg("consumer-tag", e.ConsumerTag)
+
+	b.WriteString(": ")
+	b.WriteString(e.Err.Error())
+	return b.String()
+}
+
+// Unwrap returns the server exception.
+func (e *OpError) Unwrap() error {
+	return e.Err
+}
+
+// As lets errors.As find the OpError of an exception returned by a method of
+// Channel.
+func (e *Error) As(target interface{}) bool {
+	if op, ok := target.(**OpError); ok && e.op != nil {
+		*op = e.op
+		return true
+	}
+	return false
+}
+
+// withOp returns a copy of the server exception e annotated with the method
+// req that raised it.  Errors of this library are returned as is, so that
+// comparisons with ErrClosed and the like keep working.
+func withOp(e *Error, req message) *Error {
+	if e == nil || !e.Server {
+		return e
+	}
+
+	cause := *e
+	cause.op = nil
+
+	op := newOpError(req)
+	op.Err = &cause
+
+	annotated := cause
+	annotated.op = op
+	return &annotated
+}
+
+func newOpError(req message) *OpError {
+	switch m := req.(type) {
+	case *exchangeDeclare:
+		return &OpError{Op: "exchange.declare", Exchange: m.Exchange}
+	case *exchangeDelete:
+		return &OpError{Op: "exchange.delete", Exchange: m.Exchange}
+	case *exchangeBind:
+		return &OpError{Op: "exchange.bind", Exchange: m.Destination, Source: m.Source, RoutingKey: m.RoutingKey}
+	case *exchangeUnbind:
+		return &OpError{Op: "exchange.unbind", Exchange: m.Destination, Source: m.Source, RoutingKey: m.RoutingKey}
+	case *queueDeclare:
+		return &OpError{Op: "queue.declare", Queue: m.Queue}
+	case *queueBind:
+		return &OpError{Op: "queue.bind", Queue: m.Queue, Exchange: m.Exchange, RoutingKey: m.RoutingKey}
+	case *queueUnbind:
+		return &OpError{Op: "queue.unbind", Queue: m.Queue, Exchange: m.Exchange, RoutingKey: m.RoutingKey}
+	case *queuePurge:
+		return &OpError{Op: "queue.purge", Queue: m.Queue}
+	case *queueDelete:
+		return &OpError{Op: "queue.delete", Queue: m.Queue}
+	case *basicQos:
+		return &OpError{Op: "basic.qos"}
+	case *basicConsume:
+		return &OpError{Op: "basic.consume", Queue: m.Queue, ConsumerTag: m.ConsumerTag}
+	case *basicCancel:
+		return &OpError{Op: "basic.cancel", ConsumerTag: m.ConsumerTag}
+	case *basicGet:
+		return &OpError{Op: "basic.get", Queue: m.Queue}
+	case *basicRecover:
+		return &OpError{Op: "basic.recover"}
+	case *channelOpen:
+		return &OpError{Op: "channel.open"}
+	case *channelFlow:
+		return &OpError{Op: "channel.flow"}
+	case *channelClose:
+		return &OpError{Op: "channel.close"}
+	case *txSelect:
+		return &OpError{Op: "tx.select"}
+	case *txCommit:
+		return &OpError{Op: "tx.commit"}
+	case *txRollback:
+		return &OpError{Op: "tx.rollback"}
+	case *confirmSelect:
+		return &OpError{Op: "confirm.select"}
+	}
+
+	class, method := req.id()
+	return &OpError{Op: fmt.Sprintf("class %d method %d", class, method)}
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpErrorNamesFailingMethod(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'jobs'"})
+		srv.recv(1, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	_, err = ch.QueueDeclarePassive("jobs", false, false, false, false, nil)
+
+	amqpErr, ok := err.(*Error)
+	if !ok || amqpErr.Code != NotFound {
+		t.Fatalf("expected a *Error with code %d, got %#v", NotFound, err)
+	}
+
+	var op *OpError
+	if !errors.As(err, &op) {
+		t.Fatalf("expected an *OpError, got %v", err)
+	}
+	if want, got := "queue.declare", op.Op; want != got {
+		t.Errorf("expected op %q, got %q", want, got)
+	}
+	if want, got := "jobs", op.Queue; want != got {
+		t.Errorf("expected queue %q, got %q", want, got)
+	}
+	if want, got := `queue.declare queue="jobs": Exception (404) Reason: "NOT_FOUND - no queue 'jobs'"`, op.Error(); want != got {
+		t.Errorf("expected message %q, got %q", want, got)
+	}
+	if !errors.Is(op, op.Err) || op.Err.Code != NotFound {
+		t.Errorf("expected the OpError to unwrap to the exception, got %#v", op.Err)
+	}
+}
+
+func TestOpErrorArguments(t *testing.T) {
+	e := newError(NotFound, "NOT_FOUND")
+
+	tests := []struct {
+		req      message
+		expected string
+	}{
+		{&exchangeDeclare{Exchange: "logs"}, `exchange.declare exchange="logs": ` + e.Error()},
+		{&exchangeBind{Destination: "dst", Source: "src", RoutingKey: "a.b"}, `exchange.bind exchange="dst" source="src" routing-key="a.b": ` + e.Error()},
+		{&queueBind{Queue: "q", Exchange: "logs", RoutingKey: "info"}, `queue.bind queue="q" exchange="logs" routing-key="info": ` + e.Error()},
+		{&basicConsume{Queue: "q", ConsumerTag: "ctag"}, `basic.consume queue="q" consumer-tag="ctag": ` + e.Error()},
+		{&basicQos{}, `basic.qos: ` + e.Error()},
+	}
+
+	for _, test := range tests {
+		var op *OpError
+		if !errors.As(withOp(e, test.req), &op) {
+			t.Fatalf("expected an *OpError for %T", test.req)
+		}
+		if got := op.Error(); got != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, got)
+		}
+	}
+}
+
+func TestOpErrorKeepsLibraryErrors(t *testing.T) {
+	if err := withOp(ErrClosed, &queueDeclare{}); err != ErrClosed {
+		t.Errorf("expected ErrClosed to be returned as is, got %#v", err)
+	}
+
+	var op *OpError
+	if errors.As(ErrClosed, &op) {
+		t.Errorf("expected no OpError for ErrClosed, got %v", op)
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+)
+
+var errNoPartitions = errors.New("a partitioned publisher needs at least one source")
+
+// PartitionedPublisherOptions configures a PartitionedPublisher.
+type PartitionedPublisherOptions struct {
+	// Publisher configures the Publisher of each partition.
+	Publisher PublisherOptions
+
+	// PartitionRoutingKeys publishes with the routing key of the partition,
+	// ShardQueueName(key, partition), instead of the routing key given to
+	// Publish, for topologies with a queue bound per partition.
+	PartitionRoutingKeys bool
+
+	// Hash returns the partition of a key among n, with a jump consistent
+	// hash of the FNV-1a hash of the key when nil.  The jump hash moves the
+	// fewest keys when partitions are added or removed at the end.
+	Hash func(key string, n int) int
+
+	// OnRebalance is called by SetSources after the partitions changed, with
+	// their previous and new number.
+	OnRebalance func(from, to int)
+}
+
+/*
+PartitionedPublisher spreads publishings over partitions by hashing a
+partition key, while keeping the publishings of a key in order.  Each partition
+is a Publisher with its own channel, opened from its ChannelSource: pass the
+same connection several times for channels of a single connection, or
+different connections to also spread the load over them.
+
+Publishings of a key go to the same partition, and a Publisher confirms each
+publishing before Publish returns, so the publishings of a key published one
+after the other reach the broker in order.  When the partitions change with
+SetSources, the publishings running on the previous partitions complete first.
+
+	p, err := amqp.NewPartitionedPublisher([]amqp.ChannelSource{conn, conn, conn, conn},
+		amqp.PartitionedPublisherOptions{PartitionRoutingKeys: true})
+	err = p.Publish(ctx, "orders", "orders", order.CustomerID, msg)
+*/
+type PartitionedPublisher struct {
+	opts PartitionedPublisherOptions
+
+	// Publish holds m for reading while publishing, SetSources for writing
+	// to wait for the publishings on the previous partitions.
+	m          sync.RWMutex
+	sources    []ChannelSource
+	partitions []*Publisher
+	closed     bool
+}
+
+// NewPartitionedPublisher returns a publisher with a partition per source.
+func NewPartitionedPublisher(sources []ChannelSource, opts PartitionedPublisherOptions) (*PartitionedPublisher, error) {
+	if len(sources) == 0 {
+		return nil, errNoPartitions
+	}
+	if opts.Hash == nil {
+		opts.Hash = jumpHash
+	}
+
+	p := &PartitionedPublisher{opts: opts}
+	p.sources, p.partitions = p.open(sources)
+	return p, nil
+}
+
+// open returns a Publisher per source, reusing those of the current sources
+// at the same index, p.m must be held.
+func (p *PartitionedPublisher) open(sources []ChannelSource) ([]ChannelSource, []*Publisher) {
+	partitions := make([]*Publisher, len(sources))
+	for i, source := range sources {
+		if i < len(p.sources) && p.sources[i] == source {
+			partitions[i] = p.partitions[i]
+			continue
+		}
+		partitions[i] = NewPublisher(source, p.opts.Publisher)
+	}
+	return append([]ChannelSource(nil), sources...), partitions
+}
+
+// Partition returns the partition of the key.
+func (p *PartitionedPublisher) Partition(key string) int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.opts.Hash(key, len(p.partitions))
+}
+
+// Len returns the number of partitions.
+func (p *PartitionedPublisher) Len() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return len(p.partitions)
+}
+
+// Publish publishes msg with the Publisher of the partition of partitionKey,
+// see Publisher.Publish.
+func (p *PartitionedPublisher) Publish(ctx context.Context, exchange, key, partitionKey string, msg Publishing) error {
+	p.m.RLock()
+	defer p.m.RUnlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+
+	partition := p.opts.Hash(partitionKey, len(p.partitions))
+	if p.opts.PartitionRoutingKeys {
+		key = ShardQueueName(key, partition)
+	}
+	return p.partitions[partition].Publish(ctx, exchange, key, msg)
+}
+
+/*
+SetSources changes the partitions, for example when a connection is added to
+spread the load further.  It waits for the running publishings to complete,
+then routes the next ones to the new partitions, so that the publishings of a
+key moved to another partition stay in order.
+
+Partitions keep their Publisher when their source is unchanged, the Publishers
+of the other partitions are closed.
+*/
+func (p *PartitionedPublisher) SetSources(sources []ChannelSource) error {
+	if len(sources) == 0 {
+		return errNoPartitions
+	}
+
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return ErrClosed
+	}
+
+	previous := p.partitions
+	from := len(previous)
+	p.sources, p.partitions = p.open(sources)
+
+	kept := make(map[*Publisher]bool, len(p.partitions))
+	for _, partition := range p.partitions {
+		kept[partition] = true
+	}
+	p.m.Unlock()
+
+	var errs []error
+	for _, partition := range previous {
+		if !kept[partition] {
+			if err := partition.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	if p.opts.OnRebalance != nil {
+		p.opts.OnRebalance(from, len(sources))
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the Publishers of all partitions.
+func (p *PartitionedPublisher) Close() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	partitions := p.partitions
+	p.m.Unlock()
+
+	var errs []error
+	for _, partition := range partitions {
+		if err := partition.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach over the FNV-1a
+// hash of key.
+func jumpHash(key string, n int) int {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	k := h.Sum64()
+
+	b, j := int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		k = k*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((k>>33)+1)))
+	}
+	return int(b)
+}
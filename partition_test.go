@@ -0,0 +1,77 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestJumpHashMovesFewKeys(t *testing.T) {
+	const keys = 10000
+
+	moved := 0
+	for i := 0; i < keys; i++ {
+		key := "customer-" + strconv.Itoa(i)
+		before, after := jumpHash(key, 4), jumpHash(key, 5)
+		if before < 0 || before >= 4 {
+			t.Fatalf("partition %d of %q out of range", before, key)
+		}
+		if before != after {
+			if after != 4 {
+				t.Fatalf("expected %q to move to the new partition, moved from %d to %d", key, before, after)
+			}
+			moved++
+		}
+	}
+
+	// A fifth of the keys is expected to move to the fifth partition.
+	if moved < keys/5-keys/50 || moved > keys/5+keys/50 {
+		t.Fatalf("expected about %d keys to move, %d did", keys/5, moved)
+	}
+}
+
+func TestPartitionedPublisherRoutesKeys(t *testing.T) {
+	published := make(chan *basicPublish, 1)
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	})
+
+	var rebalanced [2]int
+	p, err := NewPartitionedPublisher([]ChannelSource{c, c}, PartitionedPublisherOptions{
+		PartitionRoutingKeys: true,
+		OnRebalance:          func(from, to int) { rebalanced = [2]int{from, to} },
+	})
+	if err != nil {
+		t.Fatalf("could not create publisher: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	partition := p.Partition("customer-1")
+	if err := p.Publish(ctx, "orders", "orders", "customer-1", Publishing{}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if got := (<-published).RoutingKey; got != ShardQueueName("orders", partition) {
+		t.Fatalf("expected the routing key of partition %d, got %q", partition, got)
+	}
+
+	if err := p.SetSources([]ChannelSource{c, c, c}); err != nil {
+		t.Fatalf("could not set sources: %v", err)
+	}
+	if p.Len() != 3 || rebalanced != [2]int{2, 3} {
+		t.Fatalf("expected a rebalance from 2 to 3 partitions, got %d partitions and %v", p.Len(), rebalanced)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+)
+
+/*
+PeekQueue returns up to n messages from the head of queue without removing
+them, for inspecting production queues while debugging.
+
+The messages are fetched with basic.get without auto-ack on a throwaway channel
+and all of them are requeued before PeekQueue returns, even when it fails.
+While they are fetched, the messages are not available to the consumers of the
+queue.  Once requeued, they keep their position in a classic queue, but are
+flagged as redelivered and, for quorum queues, count as a delivery attempt
+against the delivery limit.
+
+The returned deliveries are copies for inspection without Acknowledger, so
+acknowledging them fails.  The context is checked between messages.
+*/
+func PeekQueue(ctx context.Context, conn *Connection, queue string, n int) ([]Delivery, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	var peeked []Delivery
+	var getErr error
+	for len(peeked) < n {
+		if getErr = ctx.Err(); getErr != nil {
+			break
+		}
+
+		var d Delivery
+		var ok bool
+		if d, ok, getErr = ch.Get(queue, false); getErr != nil || !ok {
+			break
+		}
+
+		d.Acknowledger = nil
+		peeked = append(peeked, d)
+	}
+
+	if len(peeked) > 0 && !ch.IsClosed() {
+		last := peeked[len(peeked)-1].DeliveryTag
+		if err := ch.Nack(last, true, true); err != nil && getErr == nil {
+			getErr = err
+		}
+	}
+
+	// Closing the channel also requeues anything left unacknowledged.
+	if err := ch.Close(); err != nil && !errors.Is(err, ErrClosed) && getErr == nil {
+		getErr = err
+	}
+
+	if getErr != nil {
+		return nil, getErr
+	}
+	return peeked, nil
+}
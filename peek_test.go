@@ -0,0 +1,58 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPeekQueueRequeuesMessages(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	nack := &basicNack{}
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for tag := uint64(1); tag <= 2; tag++ {
+			srv.recv(1, &basicGet{})
+			srv.send(1, &basicGetOk{DeliveryTag: tag, MessageCount: uint32(2 - tag), Body: []byte("job")})
+		}
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetEmpty{})
+
+		srv.recv(1, nack)
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	peeked, err := PeekQueue(context.Background(), c, "jobs", 5)
+	if err != nil {
+		t.Fatalf("could not peek queue: %v", err)
+	}
+
+	if len(peeked) != 2 || string(peeked[0].Body) != "job" {
+		t.Fatalf("expected 2 messages, got %+v", peeked)
+	}
+	if err := peeked[0].Ack(false); err == nil {
+		t.Fatalf("expected peeked messages not to be acknowledgeable")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	if nack.DeliveryTag != 2 || !nack.Multiple || !nack.Requeue {
+		t.Fatalf("expected all messages to be requeued, got %+v", nack)
+	}
+}
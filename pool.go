@@ -0,0 +1,345 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// defaultMaxIdle is the number of idle resources kept by a pool when
+// PoolOptions.MaxIdle is zero.
+const defaultMaxIdle = 2
+
+// PoolOptions bounds the idle resources kept by a ChannelPool or a
+// ConnectionPool.
+type PoolOptions struct {
+	// MaxIdle is the maximum number of idle resources kept for reuse, 2 when
+	// zero.  Resources returned to a pool already holding MaxIdle idle ones
+	// are closed.
+	MaxIdle int
+
+	// MinIdle is the number of idle resources the reaper never closes, so
+	// that a burst after a quiet period does not start from scratch.
+	MinIdle int
+
+	// IdleTimeout is how long a resource stays idle before the reaper closes
+	// it.  Idle resources are never reaped when zero.
+	IdleTimeout time.Duration
+
+	// Selector chooses which idle channel ChannelPool.Get returns, the most
+	// recently used one when nil.  It is not used by ConnectionPool.
+	Selector ChannelSelector
+}
+
+// pooled is a resource held by an idlePool.
+type pooled interface {
+	Close() error
+	IsClosed() bool
+}
+
+type idleResource struct {
+	res   pooled
+	since time.Time
+}
+
+// idlePool keeps idle resources for reuse, most recently used first, and
+// reaps the ones idle for too long.
+type idlePool struct {
+	opts PoolOptions
+
+	// choose returns the index of the idle resource to reuse, the last one
+	// when nil.
+	choose func(idle []pooled) int
+
+	m       sync.Mutex
+	idle    []idleResource // oldest first
+	onClose []func(pooled)
+	closed  bool
+	done    chan struct{}
+}
+
+func newIdlePool(opts PoolOptions) *idlePool {
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = defaultMaxIdle
+	}
+	if opts.MinIdle > opts.MaxIdle {
+		opts.MinIdle = opts.MaxIdle
+	}
+
+	p := &idlePool{opts: opts, done: make(chan struct{})}
+	if opts.IdleTimeout > 0 {
+		go p.reaper()
+	}
+	return p
+}
+
+func (p *idlePool) reaper() {
+	interval := p.opts.IdleTimeout / 2
+	if interval <= 0 {
+		interval = p.opts.IdleTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-ticker.C:
+			p.reap(now)
+		}
+	}
+}
+
+// get returns the most recently used idle resource that is still open, or
+// nil when there is none.
+func (p *idlePool) get() (pooled, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+
+	open := p.idle[:0]
+	for _, r := range p.idle {
+		if !r.res.IsClosed() {
+			open = append(open, r)
+		}
+	}
+	for i := len(open); i < len(p.idle); i++ {
+		p.idle[i] = idleResource{}
+	}
+	p.idle = open
+
+	if len(p.idle) == 0 {
+		return nil, nil
+	}
+
+	i := len(p.idle) - 1
+	if p.choose != nil {
+		candidates := make([]pooled, len(p.idle))
+		for j, r := range p.idle {
+			candidates[j] = r.res
+		}
+		i = p.choose(candidates)
+	}
+
+	res := p.idle[i].res
+	copy(p.idle[i:], p.idle[i+1:])
+	p.idle[len(p.idle)-1] = idleResource{}
+	p.idle = p.idle[:len(p.idle)-1]
+
+	return res, nil
+}
+
+// put keeps res for reuse, or closes it when the pool is full or closed.
+func (p *idlePool) put(res pooled) {
+	if res.IsClosed() {
+		return
+	}
+
+	p.m.Lock()
+	if p.closed || len(p.idle) >= p.opts.MaxIdle {
+		p.m.Unlock()
+		p.close(res)
+		return
+	}
+	p.idle = append(p.idle, idleResource{res: res, since: time.Now()})
+	p.m.Unlock()
+}
+
+// reap closes the resources idle since before now minus the idle timeout,
+// oldest first, keeping at least MinIdle of them.
+func (p *idlePool) reap(now time.Time) {
+	var expired []pooled
+
+	p.m.Lock()
+	for len(p.idle) > p.opts.MinIdle && now.Sub(p.idle[0].since) >= p.opts.IdleTimeout {
+		expired = append(expired, p.idle[0].res)
+		p.idle[0] = idleResource{}
+		p.idle = p.idle[1:]
+	}
+	p.m.Unlock()
+
+	for _, res := range expired {
+		p.close(res)
+	}
+}
+
+// close closes res and calls the close callbacks.
+func (p *idlePool) close(res pooled) error {
+	err := res.Close()
+
+	p.m.Lock()
+	callbacks := p.onClose
+	p.m.Unlock()
+
+	for _, f := range callbacks {
+		f(res)
+	}
+
+	return err
+}
+
+func (p *idlePool) addOnClose(f func(pooled)) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.onClose = append(p.onClose, f)
+}
+
+func (p *idlePool) len() int {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return len(p.idle)
+}
+
+// shutdown stops the reaper and closes all idle resources.
+func (p *idlePool) shutdown() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	idle := p.idle
+	p.idle = nil
+	p.m.Unlock()
+
+	var errs []error
+	for _, r := range idle {
+		if err := p.close(r.res); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+ChannelPool keeps the idle channels of a connection for reuse, so that short
+tasks do not pay for a channel.open round trip each time, while a reaper closes
+the channels left idle after a burst so they do not count against the channel
+limit of the broker.
+
+Take a channel with Get and give it back with Put once done with it.  Channels
+that were closed, for example after a channel exception, are dropped instead of
+being reused.
+*/
+type ChannelPool struct {
+	open func() (*Channel, error) // opens the channels, Connection.Channel by default
+	pool *idlePool
+}
+
+// NewChannelPool returns a pool of the channels of conn.  Call Close to stop
+// its reaper.
+func NewChannelPool(conn *Connection, opts PoolOptions) *ChannelPool {
+	pool := newIdlePool(opts)
+
+	if selector := opts.Selector; selector != nil {
+		pool.choose = func(idle []pooled) int {
+			channels := make([]*Channel, len(idle))
+			for i, res := range idle {
+				channels[i] = res.(*Channel)
+			}
+			return selector.Select(channels)
+		}
+	}
+
+	return &ChannelPool{open: conn.Channel, pool: pool}
+}
+
+// Get returns an idle channel of the pool, or opens a new one when none is
+// idle.  It returns ErrClosed once the pool is closed.
+func (p *ChannelPool) Get() (*Channel, error) {
+	res, err := p.pool.get()
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		return res.(*Channel), nil
+	}
+	return p.open()
+}
+
+// Put gives back a channel taken with Get.  The channel must not be used by
+// the caller afterwards.
+func (p *ChannelPool) Put(ch *Channel) {
+	p.pool.put(ch)
+}
+
+// OnClose registers a callback called with every channel closed by the pool,
+// because the pool was full, the channel was idle for too long, or the pool
+// was closed.
+func (p *ChannelPool) OnClose(f func(*Channel)) {
+	p.pool.addOnClose(func(res pooled) { f(res.(*Channel)) })
+}
+
+// Idle returns the number of idle channels in the pool.
+func (p *ChannelPool) Idle() int {
+	return p.pool.len()
+}
+
+// Close stops the reaper and closes the idle channels.  Channels taken with
+// Get and given back afterwards are closed by Put.
+func (p *ChannelPool) Close() error {
+	return p.pool.shutdown()
+}
+
+/*
+ConnectionPool keeps idle connections for reuse, dialing new ones with the
+given function when none is idle, and reaps the connections left idle after a
+burst, as ChannelPool does for channels.
+*/
+type ConnectionPool struct {
+	dial func() (*Connection, error)
+	pool *idlePool
+}
+
+// NewConnectionPool returns a pool of connections opened with dial.  Call
+// Close to stop its reaper.
+func NewConnectionPool(dial func() (*Connection, error), opts PoolOptions) *ConnectionPool {
+	return &ConnectionPool{dial: dial, pool: newIdlePool(opts)}
+}
+
+// Get returns an idle connection of the pool, or dials a new one when none is
+// idle.  It returns ErrClosed once the pool is closed.
+func (p *ConnectionPool) Get() (*Connection, error) {
+	res, err := p.pool.get()
+	if err != nil {
+		return nil, err
+	}
+	if res != nil {
+		return res.(*Connection), nil
+	}
+	return p.dial()
+}
+
+// Put gives back a connection taken with Get.  The connection must not be
+// used by the caller afterwards.
+func (p *ConnectionPool) Put(c *Connection) {
+	p.pool.put(c)
+}
+
+// OnClose registers a callback called with every connection closed by the
+// pool.
+func (p *ConnectionPool) OnClose(f func(*Connection)) {
+	p.pool.addOnClose(func(res pooled) { f(res.(*Connection)) })
+}
+
+// Idle returns the number of idle connections in the pool.
+func (p *ConnectionPool) Idle() int {
+	return p.pool.len()
+}
+
+// Close stops the reaper and closes the idle connections.
+func (p *ConnectionPool) Close() error {
+	return p.pool.shutdown()
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeResource struct {
+	closed bool
+}
+
+func (r *fakeResource) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (r *fakeResource) IsClosed() bool {
+	return r.closed
+}
+
+func TestIdlePoolReapsOldestAboveMinIdle(t *testing.T) {
+	p := newIdlePool(PoolOptions{MaxIdle: 3, MinIdle: 1, IdleTimeout: time.Hour})
+	defer p.shutdown()
+
+	var reaped []pooled
+	p.addOnClose(func(res pooled) { reaped = append(reaped, res) })
+
+	resources := []*fakeResource{{}, {}, {}, {}}
+	for _, r := range resources {
+		p.put(r)
+	}
+
+	if !resources[3].closed || len(reaped) != 1 || p.len() != 3 {
+		t.Fatalf("expected the resource above MaxIdle to be closed, got %d idle and %v reaped", p.len(), reaped)
+	}
+
+	p.reap(time.Now())
+	if p.len() != 3 {
+		t.Fatalf("expected recent resources to be kept, got %d idle", p.len())
+	}
+
+	p.reap(time.Now().Add(time.Hour))
+	if p.len() != 1 || !resources[0].closed || !resources[1].closed || resources[2].closed {
+		t.Fatalf("expected the oldest resources to be reaped down to MinIdle, got %d idle", p.len())
+	}
+
+	if res, err := p.get(); err != nil || res != resources[2] {
+		t.Fatalf("expected the remaining idle resource, got %v %v", res, err)
+	}
+
+	resources[2].closed = true
+	p.put(resources[2])
+	if res, err := p.get(); err != nil || res != nil {
+		t.Fatalf("expected closed resources not to be reused, got %v %v", res, err)
+	}
+}
+
+func TestChannelPoolReusesAndClosesChannels(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.channelOpen(2)
+
+		// over MaxIdle
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+
+		// pool closed
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	pool := NewChannelPool(c, PoolOptions{MaxIdle: 1, IdleTimeout: time.Hour})
+
+	closed := make(chan *Channel, 2)
+	pool.OnClose(func(ch *Channel) { closed <- ch })
+
+	first, err := pool.Get()
+	if err != nil {
+		t.Fatalf("could not get channel: %v", err)
+	}
+	second, err := pool.Get()
+	if err != nil {
+		t.Fatalf("could not get channel: %v", err)
+	}
+
+	pool.Put(first)
+	pool.Put(second)
+	if ch := <-closed; ch != second || pool.Idle() != 1 {
+		t.Fatalf("expected the channel over MaxIdle to be closed, got %v with %d idle", ch, pool.Idle())
+	}
+
+	reused, err := pool.Get()
+	if err != nil || reused != first {
+		t.Fatalf("expected the idle channel to be reused, got %v %v", reused, err)
+	}
+	pool.Put(reused)
+
+	if err := pool.Close(); err != nil {
+		t.Fatalf("could not close pool: %v", err)
+	}
+	if ch := <-closed; ch != first {
+		t.Fatalf("expected the idle channel to be closed with the pool, got %v", ch)
+	}
+	if _, err := pool.Get(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from a closed pool, got %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
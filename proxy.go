@@ -0,0 +1,138 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// ProxyURL returns a Config.Proxy tunneling every connection through the
+// proxy at u.
+func ProxyURL(u *url.URL) func(addr string) (*url.URL, error) {
+	return func(string) (*url.URL, error) {
+		return u, nil
+	}
+}
+
+// dialProxy returns a dialer connecting to the proxy returned by proxy with
+// dial, and opening a tunnel to the requested address.  Addresses without a
+// proxy are dialed directly.
+func dialProxy(dial func(network, addr string) (net.Conn, error), proxy func(addr string) (*url.URL, error)) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		u, err := proxy(addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy for %s: %w", addr, err)
+		}
+		if u == nil {
+			return dial(network, addr)
+		}
+
+		switch u.Scheme {
+		case "http", "https":
+			return dialConnect(dial, network, addr, u)
+		case "socks5", "socks5h":
+			return dialSOCKS5(dial, network, addr, u)
+		}
+		return nil, fmt.Errorf("proxy %s: unsupported scheme %q", u.Redacted(), u.Scheme)
+	}
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request to the
+// proxy at u, authenticating with the user info of u.
+func dialConnect(dial func(network, addr string) (net.Conn, error), network, addr string, u *url.URL) (net.Conn, error) {
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	conn, err := dial(network, proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "https" {
+		client := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := client.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+		}
+		conn = client
+	}
+
+	header := "CONNECT " + addr + " HTTP/1.1\r\nHost: " + addr + "\r\n"
+	if u.User != nil {
+		password, _ := u.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(u.User.Username() + ":" + password))
+		header += "Proxy-Authorization: Basic " + credentials + "\r\n"
+	}
+
+	if _, err := io.WriteString(conn, header+"\r\n"); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+	}
+
+	br := bufio.NewReader(conn)
+	status, err := readConnectResponse(textproto.NewReader(br))
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %w", u.Redacted(), err)
+	}
+	if status != "200" {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: CONNECT %s: %s", u.Redacted(), addr, status)
+	}
+
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// readConnectResponse reads the response of the proxy to a CONNECT request
+// and returns its status, like "200" or "407 Proxy Authentication Required".
+func readConnectResponse(r *textproto.Reader) (string, error) {
+	line, err := r.ReadLine()
+	if err != nil {
+		return "", err
+	}
+
+	proto, status, ok := strings.Cut(line, " ")
+	if !ok || !strings.HasPrefix(proto, "HTTP/") {
+		return "", fmt.Errorf("malformed response %q", line)
+	}
+
+	// A successful CONNECT has no body, only headers.
+	if _, err := r.ReadMIMEHeader(); err != nil {
+		return "", err
+	}
+
+	if strings.HasPrefix(status, "200 ") {
+		return "200", nil
+	}
+	return status, nil
+}
+
+// bufferedConn reads what the proxy sent after its response before reading
+// from the connection.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,24 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"net/http"
+	"net/url"
+)
+
+/*
+ProxyFromEnvironment is a Config.Proxy tunneling the connections through the
+proxy named by the HTTPS_PROXY environment variable, or its lowercase version,
+unless the host of the broker matches NO_PROXY.  Connections to localhost are
+never proxied.  The environment is read once, the first time it is used, see
+http.ProxyFromEnvironment.
+*/
+func ProxyFromEnvironment(addr string) (*url.URL, error) {
+	return http.ProxyFromEnvironment(&http.Request{URL: &url.URL{Scheme: "https", Host: addr}})
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// socksProxy serves one SOCKS5 CONNECT command on a local listener, requiring
+// the given password for user "user", and echoes what is written to the
+// tunnel.
+func socksProxy(t *testing.T, password string) (*url.URL, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	targets := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		read := func(n int) []byte {
+			buf := make([]byte, n)
+			if _, err := io.ReadFull(conn, buf); err != nil {
+				t.Errorf("could not read from the client: %v", err)
+			}
+			return buf
+		}
+
+		greeting := read(2)
+		methods := read(int(greeting[1]))
+		if greeting[0] != socksVersion || methods[0] != socksUserPass {
+			t.Errorf("unexpected greeting %v %v", greeting, methods)
+			return
+		}
+		_, _ = conn.Write([]byte{socksVersion, socksUserPass})
+
+		auth := read(2)
+		username := string(read(int(auth[1])))
+		given := string(read(int(read(1)[0])))
+		if username != "user" || given != password {
+			_, _ = conn.Write([]byte{socksUserPassVersion, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{socksUserPassVersion, 0})
+
+		req := read(5)
+		host := string(read(int(req[4])))
+		port := binary.BigEndian.Uint16(read(2))
+		targets <- net.JoinHostPort(host, strconv.Itoa(int(port)))
+
+		_, _ = conn.Write([]byte{socksVersion, socksSucceeded, 0, socksIPv4, 127, 0, 0, 1, 0x16, 0x28})
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	return &url.URL{Scheme: "socks5", Host: l.Addr().String()}, targets
+}
+
+func TestDialProxySOCKS5(t *testing.T) {
+	u, targets := socksProxy(t, "secret")
+	u.User = url.UserPassword("user", "secret")
+
+	conn, err := dialProxy(net.Dial, ProxyURL(u))("tcp", "broker.example.com:5672")
+	if err != nil {
+		t.Fatalf("could not dial through the proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if want, got := "broker.example.com:5672", <-targets; want != got {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if _, err := io.WriteString(conn, "AMQP"); err != nil {
+		t.Fatalf("could not write to the tunnel: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "AMQP" {
+		t.Fatalf("expected the tunnel to be echoed, got %q (%v)", buf, err)
+	}
+}
+
+func TestDialProxySOCKS5AuthenticationFailed(t *testing.T) {
+	u, _ := socksProxy(t, "secret")
+	u.User = url.UserPassword("user", "wrong")
+
+	_, err := dialProxy(net.Dial, ProxyURL(u))("tcp", "broker.example.com:5672")
+	if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+		t.Fatalf("expected the proxy to refuse the credentials, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Fatalf("expected the proxy password to be redacted, got: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// connectProxy serves CONNECT requests on a local listener, requiring the
+// given Proxy-Authorization, and echoes what is written to the tunnels.
+func connectProxy(t *testing.T, authorization string) (*url.URL, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	targets := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			t.Errorf("could not read the CONNECT request: %v", err)
+			return
+		}
+		targets <- req.Method + " " + req.Host
+
+		if req.Header.Get("Proxy-Authorization") != authorization {
+			_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}, targets
+}
+
+func TestDialProxyConnect(t *testing.T) {
+	u, targets := connectProxy(t, "Basic dXNlcjpzZWNyZXQ=")
+	u.User = url.UserPassword("user", "secret")
+
+	conn, err := dialProxy(net.Dial, ProxyURL(u))("tcp", "broker.example.com:5672")
+	if err != nil {
+		t.Fatalf("could not dial through the proxy: %v", err)
+	}
+	defer conn.Close()
+
+	if want, got := "CONNECT broker.example.com:5672", <-targets; want != got {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+
+	if _, err := io.WriteString(conn, "AMQP"); err != nil {
+		t.Fatalf("could not write to the tunnel: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "AMQP" {
+		t.Fatalf("expected the tunnel to be echoed, got %q (%v)", buf, err)
+	}
+}
+
+func TestDialProxyAuthenticationRequired(t *testing.T) {
+	u, _ := connectProxy(t, "Basic dXNlcjpzZWNyZXQ=")
+	u.User = url.UserPassword("user", "wrong")
+
+	_, err := dialProxy(net.Dial, ProxyURL(u))("tcp", "broker.example.com:5672")
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("expected the proxy to refuse the tunnel, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Fatalf("expected the proxy password to be redacted, got: %v", err)
+	}
+}
+
+func TestDialProxyDirect(t *testing.T) {
+	var dialed string
+	dial := func(network, addr string) (net.Conn, error) {
+		dialed = addr
+		return nil, io.EOF
+	}
+	noProxy := func(string) (*url.URL, error) { return nil, nil }
+
+	if _, err := dialProxy(dial, noProxy)("tcp", "broker:5672"); err != io.EOF || dialed != "broker:5672" {
+		t.Fatalf("expected the broker to be dialed directly, dialed %q: %v", dialed, err)
+	}
+}
+
+// forwardingProxy serves one CONNECT request on a local listener by
+// connecting to the requested address and relaying the tunnel to it.
+func forwardingProxy(t *testing.T) (*url.URL, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	targets := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			t.Errorf("could not read the CONNECT request: %v", err)
+			return
+		}
+		targets <- req.Host
+
+		upstream, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer upstream.Close()
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+
+		go func() { _, _ = io.Copy(upstream, br) }()
+		_, _ = io.Copy(conn, upstream)
+	}()
+
+	return &url.URL{Scheme: "http", Host: l.Addr().String()}, targets
+}
+
+func TestDialTLSThroughProxyHandshakesWithBroker(t *testing.T) {
+	srv := startTLSServer(t, tlsServerConfig(t))
+	defer srv.Close()
+	u, targets := forwardingProxy(t)
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		session := <-srv.Sessions
+		session.connectionOpen()
+		session.connectionClose()
+		session.S.Close()
+	}()
+
+	c, err := DialConfig(srv.URL, Config{
+		TLSClientConfig: tlsClientConfig(t),
+		Proxy:           ProxyURL(u),
+	})
+	if err != nil {
+		t.Fatalf("could not dial through the proxy: %v", err)
+	}
+	if st := c.ConnectionState(); !st.HandshakeComplete || len(st.VerifiedChains) == 0 {
+		t.Fatalf("expected a verified TLS handshake with the broker, got %+v", st)
+	}
+	if want, got := srv.Addr().String(), <-targets; want != got {
+		t.Fatalf("expected the tunnel to lead to %q, got %q", want, got)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+)
+
+// publishToQueueOptions holds the settings collected from PublishToQueueOption
+// values.
+type publishToQueueOptions struct {
+	mandatory bool
+	verify    bool
+}
+
+// PublishToQueueOption configures Channel.PublishToQueue.
+type PublishToQueueOption func(*publishToQueueOptions)
+
+// PublishToQueueMandatory sets the mandatory flag, so that the server returns
+// the message with a basic.return when the queue does not exist.  See
+// Channel.NotifyReturn.
+func PublishToQueueMandatory() PublishToQueueOption {
+	return func(o *publishToQueueOptions) {
+		o.mandatory = true
+	}
+}
+
+// PublishToQueueVerify checks that the queue exists with a passive declare
+// before publishing.  The check runs on a temporary channel, so that a
+// missing queue does not close the publishing channel, and costs a round trip
+// to the server on every call.
+func PublishToQueueVerify() PublishToQueueOption {
+	return func(o *publishToQueueOptions) {
+		o.verify = true
+	}
+}
+
+/*
+PublishToQueue publishes msg directly to queue, through the default exchange
+with the queue name as routing key.  This is the point-to-point case of
+Channel.PublishWithContext spelled out, so that the exchange and the routing
+key cannot be swapped by mistake.
+
+Without options, a message published to a queue that does not exist is
+silently dropped by the server, as with any unroutable message.  With
+PublishToQueueVerify, an *Error with the NotFound code is returned instead and
+nothing is published; with PublishToQueueMandatory, the server returns the
+message.
+*/
+func (ch *Channel) PublishToQueue(ctx context.Context, queue string, msg Publishing, opts ...PublishToQueueOption) error {
+	o := &publishToQueueOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.verify {
+		p := &probe{conn: ch.connection}
+		exists, err := p.queue(queue)
+		p.close()
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return &Error{Code: NotFound, Reason: fmt.Sprintf("no queue '%s'", queue)}
+		}
+	}
+
+	_, err := ch.publish(ctx, "", queue, o.mandatory, false, msg)
+	return err
+}
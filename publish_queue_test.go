@@ -0,0 +1,70 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPublishToQueueVerifiesQueue(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	declare := &queueDeclare{}
+	publish := &basicPublish{}
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		// missing queue, checked on a temporary channel
+		srv.channelOpen(2)
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND"})
+		srv.recv(2, &channelCloseOk{})
+
+		srv.channelOpen(3)
+		srv.recv(3, declare)
+		srv.send(3, &queueDeclareOk{Queue: "jobs"})
+		srv.recv(3, &channelClose{})
+		srv.send(3, &channelCloseOk{})
+
+		srv.recv(1, publish)
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	ctx := context.Background()
+	msg := Publishing{Body: []byte("job")}
+
+	err = ch.PublishToQueue(ctx, "jbos", msg, PublishToQueueVerify(), PublishToQueueMandatory())
+	if !isNotFound(err) {
+		t.Fatalf("expected a NOT_FOUND error for a missing queue, got %v", err)
+	}
+
+	if err := ch.PublishToQueue(ctx, "jobs", msg, PublishToQueueVerify(), PublishToQueueMandatory()); err != nil {
+		t.Fatalf("could not publish to queue: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+
+	if !declare.Passive || declare.Queue != "jobs" {
+		t.Fatalf("expected a passive declare of the queue, got %+v", declare)
+	}
+	if publish.Exchange != "" || publish.RoutingKey != "jobs" || !publish.Mandatory || string(publish.Body) != "job" {
+		t.Fatalf("expected a mandatory publishing through the default exchange, got %+v", publish)
+	}
+}
@@ -0,0 +1,476 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ChannelSource opens channels.  *Connection and *Session are channel
+// sources.
+type ChannelSource interface {
+	Channel() (*Channel, error)
+}
+
+// ErrNacked is returned by Publisher.Publish when the server negatively
+// acknowledged a publishing on every attempt.
+var ErrNacked = errors.New("publishing was nacked by the server")
+
+// ReturnedError is returned by Publisher.Publish when a mandatory publishing
+// could not be routed to any queue.  It is not retried.
+type ReturnedError struct {
+	Return Return
+}
+
+func (e *ReturnedError) Error() string {
+	return fmt.Sprintf("publishing returned: %d %s", e.Return.ReplyCode, e.Return.ReplyText)
+}
+
+// PublisherOptions configures a Publisher.
+type PublisherOptions struct {
+	// Mandatory publishes with the mandatory flag, so that unroutable
+	// publishings fail with a *ReturnedError instead of being dropped.
+	// Returns are matched to the pending publishing of the channel with the
+	// same exchange, routing key, MessageId and body, the one published first
+	// when several match, so the publishings are sent unchanged.
+	Mandatory bool
+
+	// MaxInFlight caps the number of publishings waiting for their
+	// confirmation, 1024 when zero.  Publish blocks while the cap is reached.
+	MaxInFlight int
+
+	// MaxAttempts is the number of times a publishing is sent before Publish
+	// gives up, 5 when zero.
+	MaxAttempts int
+
+	// BaseDelay and MaxDelay bound the exponential backoff between attempts,
+	// 100ms and 5s when zero.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// DelayedMessagePlugin makes PublishAfter use the exchange type of the
+	// RabbitMQ delayed message plugin.  It cannot be detected safely, since
+	// declaring an exchange of an unknown type closes the connection.
+	DelayedMessagePlugin bool
+
+	// Redeclare is the topology the publishings depend on.  When a publishing
+	// to one of its exchanges, or to one of its queues through the default
+	// exchange, fails with NOT_FOUND or is returned as unroutable, the
+	// topology is applied again and the publishing is retried once right
+	// away.  This recovers from exchanges and queues deleted while the
+	// application runs, for example from the management UI.
+	Redeclare *Topology
+
+	// Breaker, when set, fails publishings right away with ErrCircuitOpen
+	// after consecutive failures, so that callers can shed load instead of
+	// piling up on a sick broker.  See BreakerOptions.
+	Breaker *BreakerOptions
+
+	// Spool, when set, keeps the publishings that cannot be sent because the
+	// connection is down or the circuit breaker is open, and publishes them
+	// in order once the broker is reachable.  Publish returns nil once such a
+	// publishing is spooled.  The spool is not closed by Close.  See Spool.
+	Spool *Spool
+
+	// Journal, when set, records every publishing before it is sent and
+	// completes it once confirmed, so that Publisher.RecoverJournal can
+	// publish again what a stopped process did not get confirmed.  See
+	// Journal.
+	Journal Journal
+}
+
+/*
+Publisher publishes with at-least-once semantics: Publish returns nil only once
+the server has confirmed the publishing.
+
+The Publisher owns a channel in confirm mode, obtained from its ChannelSource.
+When the channel or its connection is closed, the next attempt opens a new
+channel from the source, so a source that reconnects, like a Session recovered
+on a new connection, is picked up transparently.  Publishings that were not
+confirmed are sent again after an exponential backoff, which means consumers
+may receive duplicates.
+
+A Publisher is safe for concurrent use.
+*/
+type Publisher struct {
+	source   ChannelSource
+	opts     PublisherOptions
+	inflight chan struct{}
+	breaker  *circuitBreaker // nil without PublisherOptions.Breaker
+
+	m      sync.Mutex
+	ch     *Channel
+	closed bool
+
+	// State of the goroutine draining PublisherOptions.Spool.
+	drainM    sync.Mutex
+	draining  bool
+	drainCtx  context.Context
+	drainStop context.CancelFunc
+	drained   sync.WaitGroup
+
+	// returnsM is separate from m, which is held while opening channels,
+	// since returns are handed over on the connection reader goroutine.
+	returnsM sync.Mutex
+	returns  map[returnTag]pendingReturn
+
+	// State of PublishAfter, declarations use their own channel.
+	scheduleM       sync.Mutex
+	declareCh       *Channel
+	delayedBindings map[string]bool
+}
+
+// NewPublisher returns a Publisher opening its channel from source.  The
+// channel is opened by the first call to Publish.
+func NewPublisher(source ChannelSource, opts PublisherOptions) *Publisher {
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 1024
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = 5
+	}
+	if opts.BaseDelay <= 0 {
+		opts.BaseDelay = 100 * time.Millisecond
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 5 * time.Second
+	}
+
+	p := &Publisher{
+		source:   source,
+		opts:     opts,
+		inflight: make(chan struct{}, opts.MaxInFlight),
+		returns:  make(map[returnTag]pendingReturn),
+	}
+	if opts.Breaker != nil {
+		p.breaker = newCircuitBreaker(*opts.Breaker)
+	}
+	if opts.Spool != nil {
+		p.drainCtx, p.drainStop = context.WithCancel(context.Background())
+		// Publish what a previous process left in the spool.
+		p.drain()
+	}
+	return p
+}
+
+/*
+Publish sends msg to the exchange with the routing key and waits for the
+server to confirm it, retrying on nacks, closed channels and connections.
+
+It returns nil once the publishing is confirmed, a *ReturnedError when a
+mandatory publishing is unroutable, the context error when ctx is done, or the
+error of the last attempt once MaxAttempts is reached.  When an error is
+returned, the publishing may still have reached the queues.
+
+With a circuit breaker, it returns ErrCircuitOpen right away while the breaker
+is open.
+
+With a spool, publishings that fail with ErrCircuitOpen, ErrClosed or a network
+error are spooled and Publish returns nil, unless the spool is full.  While the
+spool is not empty, publishings are spooled right away to keep their order.
+
+With a journal, the publishing is appended to the journal first, and Publish
+fails when it cannot be.
+*/
+func (p *Publisher) Publish(ctx context.Context, exchange, key string, msg Publishing) error {
+	return p.journaled(ctx, exchange, key, msg)
+}
+
+// spooled publishes with the spool of the publisher, if any.
+func (p *Publisher) spooled(ctx context.Context, exchange, key string, msg Publishing) error {
+	if p.opts.Spool == nil {
+		return p.guarded(ctx, exchange, key, msg)
+	}
+
+	if p.opts.Spool.Len() > 0 {
+		if p.isClosed() {
+			return ErrClosed
+		}
+		return p.spool(exchange, key, msg, nil)
+	}
+
+	err := p.guarded(ctx, exchange, key, msg)
+	if err != nil && p.spoolable(err) {
+		return p.spool(exchange, key, msg, err)
+	}
+	return err
+}
+
+// guarded sends a publishing through the circuit breaker, if any.
+func (p *Publisher) guarded(ctx context.Context, exchange, key string, msg Publishing) error {
+	if p.breaker == nil {
+		return p.send(ctx, exchange, key, msg)
+	}
+
+	probe, err := p.breaker.allow()
+	if err != nil {
+		return err
+	}
+	err = p.send(ctx, exchange, key, msg)
+	p.breaker.record(err, probe)
+	return err
+}
+
+// BreakerStats returns the state and counters of the circuit breaker, the
+// zero value without PublisherOptions.Breaker.
+func (p *Publisher) BreakerStats() BreakerStats {
+	if p.breaker == nil {
+		return BreakerStats{}
+	}
+	return p.breaker.snapshot()
+}
+
+func (p *Publisher) send(ctx context.Context, exchange, key string, msg Publishing) error {
+	select {
+	case p.inflight <- struct{}{}:
+		defer func() { <-p.inflight }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	var returned chan Return
+	if p.opts.Mandatory {
+		returned = make(chan Return, 1)
+	}
+	return p.publish(ctx, exchange, key, msg, returned)
+}
+
+func (p *Publisher) publish(ctx context.Context, exchange, key string, msg Publishing, returned chan Return) error {
+	delays := newBackoff(p.opts.BaseDelay, p.opts.MaxDelay)
+
+	var err error
+	redeclared := false
+	for attempt := 1; ; attempt++ {
+		if err = p.attempt(ctx, exchange, key, msg, returned); err == nil {
+			return nil
+		}
+
+		if !redeclared && p.redeclarable(exchange, key, err) {
+			redeclared = true
+			if rerr := p.opts.Redeclare.apply(ctx, p.source, ApplyOptions{}); rerr != nil {
+				return fmt.Errorf("redeclare topology after %v: %w", err, rerr)
+			}
+			// The retry after redeclaring does not count as an attempt.
+			attempt--
+			continue
+		}
+
+		var retErr *ReturnedError
+		if errors.As(err, &retErr) || errors.Is(err, ErrClosed) && p.isClosed() || ctx.Err() != nil {
+			return err
+		}
+
+		if attempt >= p.opts.MaxAttempts {
+			return fmt.Errorf("publish failed after %d attempts: %w", attempt, err)
+		}
+
+		if err := sleepContext(ctx, delays.next()); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *Publisher) attempt(ctx context.Context, exchange, key string, msg Publishing, returned chan Return) error {
+	ch, err := p.channel()
+	if err != nil {
+		return err
+	}
+
+	var sent func(uint64, Publishing)
+	if returned != nil {
+		// Register the publishing before it is sent, returns are handed over
+		// on the connection reader goroutine.
+		var tag returnTag
+		sent = func(deliveryTag uint64, msg Publishing) {
+			tag = returnTag{ch: ch, deliveryTag: deliveryTag}
+			p.returnsM.Lock()
+			p.returns[tag] = pendingReturn{exchange: exchange, key: key, msg: msg, returned: returned}
+			p.returnsM.Unlock()
+		}
+		defer func() {
+			p.returnsM.Lock()
+			// The delivery tag of a publishing that could not be sent is
+			// reused by the next one.
+			if pending, ok := p.returns[tag]; ok && pending.returned == returned {
+				delete(p.returns, tag)
+			}
+			p.returnsM.Unlock()
+		}()
+	}
+
+	dc, err := ch.publishTracked(ctx, exchange, key, p.opts.Mandatory, false, msg, sent)
+	if err != nil {
+		return err
+	}
+
+	ack, err := dc.WaitContext(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Returns are dispatched before the confirmation of the same publishing.
+	select {
+	case ret := <-returned:
+		return &ReturnedError{Return: ret}
+	default:
+	}
+
+	if !ack {
+		if ch.IsClosed() {
+			// Report why the server closed the channel, like NOT_FOUND for a
+			// missing exchange.
+			if e := ch.closeError(ctx); e != nil && e.Server {
+				return e
+			}
+			return ErrClosed
+		}
+		return ErrNacked
+	}
+
+	return nil
+}
+
+// redeclarable returns true when err may be fixed by redeclaring the topology
+// of the publisher.
+func (p *Publisher) redeclarable(exchange, key string, err error) bool {
+	if p.opts.Redeclare == nil || !p.opts.Redeclare.declares(exchange, key) {
+		return false
+	}
+	var retErr *ReturnedError
+	return isNotFound(err) || errors.As(err, &retErr)
+}
+
+// channel returns the channel of the publisher, opening a new one in confirm
+// mode when there is none or it has been closed.
+func (p *Publisher) channel() (*Channel, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed {
+		return nil, ErrClosed
+	}
+
+	if p.ch != nil && !p.ch.IsClosed() {
+		return p.ch, nil
+	}
+
+	ch, err := p.source.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		_ = ch.Close()
+		return nil, err
+	}
+
+	ch.notifyM.Lock()
+	ch.returnHook = func(ret Return) { p.returned(ch, ret) }
+	ch.notifyM.Unlock()
+
+	p.ch = ch
+	return ch, nil
+}
+
+// returnTag identifies a mandatory publishing waiting for its confirmation.
+type returnTag struct {
+	ch          *Channel
+	deliveryTag uint64
+}
+
+// pendingReturn is a mandatory publishing as sent, after the publish hooks of
+// the channel, to match its return.
+type pendingReturn struct {
+	exchange string
+	key      string
+	msg      Publishing
+	returned chan Return
+}
+
+// matches returns true when ret may be the return of the publishing.
+func (pending pendingReturn) matches(ret Return) bool {
+	return pending.exchange == ret.Exchange &&
+		pending.key == ret.RoutingKey &&
+		pending.msg.MessageId == ret.MessageId &&
+		bytes.Equal(pending.msg.Body, ret.Body)
+}
+
+/*
+returned hands a returned publishing to the Publish call waiting for it.
+
+The server returns a publishing before confirming it, so the return belongs to
+one of the publishings of the channel waiting for their confirmation.  Among
+those with the same content, the one with the lowest delivery tag is picked,
+since the server processes the publishings of a channel in order.
+*/
+func (p *Publisher) returned(ch *Channel, ret Return) {
+	p.returnsM.Lock()
+	defer p.returnsM.Unlock()
+
+	var (
+		found bool
+		tag   returnTag
+	)
+	for t, pending := range p.returns {
+		if t.ch != ch || !pending.matches(ret) {
+			continue
+		}
+		if !found || t.deliveryTag < tag.deliveryTag {
+			found, tag = true, t
+		}
+	}
+	if !found {
+		return
+	}
+
+	pending := p.returns[tag]
+	delete(p.returns, tag)
+	select {
+	case pending.returned <- ret:
+	default:
+	}
+}
+
+func (p *Publisher) isClosed() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.closed
+}
+
+// Close closes the channel of the publisher.  Pending calls to Publish fail
+// and later calls return ErrClosed.  Spooled publishings stay in the spool.
+func (p *Publisher) Close() error {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil
+	}
+	p.closed = true
+	ch := p.ch
+	p.m.Unlock()
+
+	// scheduleM is held while checking whether the publisher is closed, take
+	// it after releasing m.
+	p.scheduleM.Lock()
+	if p.declareCh != nil {
+		_ = p.declareCh.Close()
+	}
+	p.scheduleM.Unlock()
+
+	if p.drainStop != nil {
+		p.drainStop()
+		p.drained.Wait()
+	}
+
+	if ch != nil {
+		return ch.Close()
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func (t *server) confirmedChannelOpen(id int) {
+	t.channelOpen(id)
+	t.recv(id, &confirmSelect{})
+	t.send(id, &confirmSelectOk{})
+}
+
+func openPublisherConnection(t *testing.T, serve func(srv *server)) *Connection {
+	t.Helper()
+
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		serve(srv)
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	return c
+}
+
+func TestPublisherRetriesNackedPublishings(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 1})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 2})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{BaseDelay: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := p.Publish(ctx, "", "q", Publishing{Body: []byte("hello")}); err != nil {
+		t.Fatalf("expected the second attempt to be confirmed, got: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublisherReopensClosedChannel(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "internal"})
+		srv.recv(1, &channelCloseOk{})
+		srv.confirmedChannelOpen(2)
+		srv.recv(2, &basicPublish{})
+		srv.send(2, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{BaseDelay: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := p.Publish(ctx, "", "q", Publishing{Body: []byte("hello")}); err != nil {
+		t.Fatalf("expected the publishing to be confirmed on a new channel, got: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublisherReturnsUnroutablePublishings(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		published := srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicReturn{
+			ReplyCode:  NoRoute,
+			ReplyText:  "NO_ROUTE",
+			RoutingKey: published.RoutingKey,
+			Properties: published.Properties,
+			Body:       published.Body,
+		})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{Mandatory: true, BaseDelay: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := p.Publish(ctx, "", "nowhere", Publishing{Body: []byte("hello")})
+
+	var returned *ReturnedError
+	if !errors.As(err, &returned) || returned.Return.ReplyCode != NoRoute {
+		t.Fatalf("expected a ReturnedError, got: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublisherReturnsSharingMessageId(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		first := srv.recv(1, &basicPublish{}).(*basicPublish)
+		second := srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		unroutable := first
+		if second.RoutingKey == "nowhere" {
+			unroutable = second
+		}
+		srv.send(1, &basicReturn{
+			ReplyCode:  NoRoute,
+			ReplyText:  "NO_ROUTE",
+			RoutingKey: unroutable.RoutingKey,
+			Properties: unroutable.Properties,
+			Body:       unroutable.Body,
+		})
+		srv.send(1, &basicAck{DeliveryTag: 2, Multiple: true})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{Mandatory: true, BaseDelay: time.Millisecond})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg := Publishing{MessageId: "order-1", Headers: Table{"tenant": "acme"}, Body: []byte("hello")}
+	errs := map[string]chan error{"orders": make(chan error, 1), "nowhere": make(chan error, 1)}
+	for key, result := range errs {
+		go func(key string, result chan error) { result <- p.Publish(ctx, "", key, msg) }(key, result)
+	}
+
+	if err := <-errs["orders"]; err != nil {
+		t.Fatalf("expected the routable publishing to be confirmed, got: %v", err)
+	}
+
+	var returned *ReturnedError
+	if err := <-errs["nowhere"]; !errors.As(err, &returned) || returned.Return.RoutingKey != "nowhere" {
+		t.Fatalf("expected a ReturnedError for the unroutable publishing, got: %v", err)
+	}
+	if ret := returned.Return; ret.MessageId != "order-1" || len(ret.Headers) != 1 || ret.Headers["tenant"] != "acme" {
+		t.Fatalf("expected the properties of the caller, got %+v", ret)
+	}
+	if len(msg.Headers) != 1 {
+		t.Fatalf("expected the headers of the caller to be left alone, got %v", msg.Headers)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublisherMandatoryLeavesHeadersAlone(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.channelOpen(1)
+		consume := srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+
+		srv.confirmedChannelOpen(2)
+		pub := srv.recv(2, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicDeliver{
+			ConsumerTag: consume.ConsumerTag,
+			DeliveryTag: 1,
+			RoutingKey:  pub.RoutingKey,
+			Properties:  pub.Properties,
+			Body:        pub.Body,
+		})
+		srv.send(2, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	})
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	deliveries, err := ch.Consume("orders", "", true, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	p := NewPublisher(c, PublisherOptions{Mandatory: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg := Publishing{MessageId: "order-1", Headers: Table{"tenant": "acme"}, Body: []byte("hello")}
+	if err := p.Publish(ctx, "", "orders", msg); err != nil {
+		t.Fatalf("expected the routed publishing to be confirmed, got: %v", err)
+	}
+
+	select {
+	case d := <-deliveries:
+		if len(d.Headers) != 1 || d.Headers["tenant"] != "acme" {
+			t.Fatalf("expected the consumer to get the headers of the publisher, got %v", d.Headers)
+		}
+	case <-ctx.Done():
+		t.Fatal("timeout waiting for the delivery")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublisherClosed(t *testing.T) {
+	p := NewPublisher(nil, PublisherOptions{})
+	if err := p.Close(); err != nil {
+		t.Fatalf("could not close publisher: %v", err)
+	}
+
+	if err := p.Publish(context.Background(), "", "q", Publishing{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got: %v", err)
+	}
+}
+
+func TestPublisherRedeclaresDeletedExchange(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no exchange 'events'"})
+		srv.recv(1, &channelCloseOk{})
+
+		// Topology.Apply: declaring channel, then probe channel.
+		srv.channelOpen(2)
+		srv.channelOpen(3)
+		srv.recv(3, &exchangeDeclare{})
+		srv.send(3, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no exchange 'events'"})
+		srv.recv(3, &channelCloseOk{})
+		if declare := srv.recv(2, &exchangeDeclare{}).(*exchangeDeclare); declare.Exchange != "events" || declare.Passive {
+			t.Errorf("expected exchange events to be declared, got %+v", declare)
+		}
+		srv.send(2, &exchangeDeclareOk{})
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+
+		srv.confirmedChannelOpen(4)
+		srv.recv(4, &basicPublish{})
+		srv.send(4, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{
+		// The retry after redeclaring is not delayed.
+		BaseDelay: time.Hour,
+		Redeclare: &Topology{Exchanges: []ExchangeSpec{{Name: "events", Kind: Topic, Durable: true}}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := p.Publish(ctx, "events", "order.created", Publishing{Body: []byte("hello")}); err != nil {
+		t.Fatalf("expected the publishing to be confirmed after redeclaring, got: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublisherDoesNotRedeclareUnknownExchange(t *testing.T) {
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no exchange 'audit'"})
+		srv.recv(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{
+		MaxAttempts: 1,
+		Redeclare:   &Topology{Exchanges: []ExchangeSpec{{Name: "events", Kind: Topic}}},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := p.Publish(ctx, "audit", "login", Publishing{Body: []byte("hello")})
+	if !isNotFound(err) {
+		t.Fatalf("expected NOT_FOUND, got: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
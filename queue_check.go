@@ -0,0 +1,302 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// QueueInspector returns the declaration of an existing queue, arguments
+// included, which AMQP cannot do.  It returns nil when the queue does not
+// exist.  See ManagementInspector.
+type QueueInspector interface {
+	InspectQueue(ctx context.Context, name string) (*QueueSpec, error)
+}
+
+// QueueArgMismatch is a property or argument of a queue that differs from
+// its desired declaration.
+type QueueArgMismatch struct {
+	// Arg is the argument, like "x-message-ttl", or one of the "durable",
+	// "auto_delete" and "exclusive" properties.
+	Arg string
+
+	// Desired and Actual are the values of the argument, nil when absent or,
+	// for Actual, unknown.
+	Desired interface{}
+	Actual  interface{}
+
+	// Detail explains the mismatch, as reported by the server when known.
+	Detail string
+}
+
+func (m QueueArgMismatch) String() string {
+	if m.Detail != "" {
+		return fmt.Sprintf("%s: %s", m.Arg, m.Detail)
+	}
+	return fmt.Sprintf("%s: desired %s, current %s", m.Arg, describeArg(m.Desired), describeArg(m.Actual))
+}
+
+func describeArg(v interface{}) string {
+	if v == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%v (%T)", v, v)
+}
+
+// QueueArgsDiff is the result of QueueSpec.CheckArgs.
+type QueueArgsDiff struct {
+	Queue  string
+	Exists bool // false when the queue does not exist, which will be declared as desired
+
+	// Mismatches are the differences that make a declaration fail with
+	// PRECONDITION_FAILED.  Without a QueueInspector, only the first one is
+	// known.
+	Mismatches []QueueArgMismatch
+
+	// Migration suggests how to reach the desired declaration.
+	Migration []string
+}
+
+// Equivalent returns true when declaring the queue as desired will succeed.
+func (d QueueArgsDiff) Equivalent() bool {
+	return len(d.Mismatches) == 0
+}
+
+/*
+CheckArgs compares the existing queue named q.Name with its declaration by q,
+to find the arguments that would make Channel.QueueDeclare fail with
+PRECONDITION_FAILED, and close the channel, before deploying a new
+declaration.
+
+With an inspector, the current arguments are read from it, see
+ManagementInspector, and every mismatch is reported.  Arguments are compared
+by value, so that an integer matches a float with the same value.
+
+Without an inspector, the queue is checked over AMQP: a passive declare tells
+whether it exists, then it is declared as desired on a throwaway channel.  An
+equivalent declaration leaves the queue as it is, and the server names the
+first mismatching argument of the others.  The queue could be created if it
+is deleted in between.
+
+The migration steps only depend on the names of the mismatching arguments,
+they are suggestions for an operator rather than instructions.
+*/
+func (q QueueSpec) CheckArgs(ctx context.Context, conn *Connection, inspector QueueInspector) (QueueArgsDiff, error) {
+	diff := QueueArgsDiff{Queue: q.Name}
+	if err := ctx.Err(); err != nil {
+		return diff, err
+	}
+
+	if inspector != nil {
+		actual, err := inspector.InspectQueue(ctx, q.Name)
+		if err != nil || actual == nil {
+			return diff, err
+		}
+		diff.Exists = true
+		diff.Mismatches = q.compare(*actual)
+	} else {
+		p := &probe{conn: conn}
+		defer p.close()
+
+		found, err := p.queue(q.Name)
+		if err != nil || !found {
+			return diff, err
+		}
+		diff.Exists = true
+
+		mismatch, err := q.probeDeclare(conn)
+		if err != nil {
+			return diff, err
+		}
+		if mismatch != nil {
+			diff.Mismatches = []QueueArgMismatch{*mismatch}
+		}
+	}
+
+	diff.Migration = migrationSteps(diff.Mismatches)
+	return diff, nil
+}
+
+// compare returns the mismatches between the desired declaration and actual.
+func (q QueueSpec) compare(actual QueueSpec) []QueueArgMismatch {
+	var mismatches []QueueArgMismatch
+
+	for _, property := range []struct {
+		name            string
+		desired, actual bool
+	}{
+		{"durable", q.Durable, actual.Durable},
+		{"auto_delete", q.AutoDelete, actual.AutoDelete},
+		{"exclusive", q.Exclusive, actual.Exclusive},
+	} {
+		if property.desired != property.actual {
+			mismatches = append(mismatches, QueueArgMismatch{Arg: property.name, Desired: property.desired, Actual: property.actual})
+		}
+	}
+
+	var names []string
+	for name := range q.Args {
+		names = append(names, name)
+	}
+	for name := range actual.Args {
+		if _, ok := q.Args[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		desired, actualValue := q.Args[name], actual.Args[name]
+		if !equivalentArg(desired, actualValue) {
+			mismatches = append(mismatches, QueueArgMismatch{Arg: name, Desired: desired, Actual: actualValue})
+		}
+	}
+
+	return mismatches
+}
+
+// probeDeclare declares the queue as desired on a new channel and returns the
+// mismatch reported by the server, if any.
+func (q QueueSpec) probeDeclare(conn *Connection) (*QueueArgMismatch, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args)
+	if err == nil {
+		_ = ch.Close()
+		return nil, nil
+	}
+
+	var amqpErr *Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != PreconditionFailed {
+		return nil, err
+	}
+
+	mismatch := parseInequivalentArg(amqpErr.Reason)
+	switch mismatch.Arg {
+	case "durable":
+		mismatch.Desired = q.Durable
+	case "auto_delete":
+		mismatch.Desired = q.AutoDelete
+	case "exclusive":
+		mismatch.Desired = q.Exclusive
+	default:
+		mismatch.Desired = q.Args[mismatch.Arg]
+	}
+	return &mismatch, nil
+}
+
+// parseInequivalentArg extracts the argument and the explanation of a
+// PRECONDITION_FAILED reason of RabbitMQ, like "PRECONDITION_FAILED -
+// inequivalent arg 'x-message-ttl' for queue 'jobs' in vhost '/': received
+// the value '2000' of type 'signedint' but current is none".
+func parseInequivalentArg(reason string) QueueArgMismatch {
+	const marker = "inequivalent arg '"
+
+	i := strings.Index(reason, marker)
+	if i < 0 {
+		return QueueArgMismatch{Detail: reason}
+	}
+	rest := reason[i+len(marker):]
+
+	end := strings.Index(rest, "'")
+	if end < 0 {
+		return QueueArgMismatch{Detail: reason}
+	}
+	mismatch := QueueArgMismatch{Arg: rest[:end], Detail: reason}
+
+	if _, detail, ok := strings.Cut(rest, "': "); ok {
+		mismatch.Detail = detail
+	}
+	return mismatch
+}
+
+// equivalentArg compares argument values, numbers by value regardless of
+// their type.
+func equivalentArg(a, b interface{}) bool {
+	if x, ok := argNumber(a); ok {
+		y, ok := argNumber(b)
+		return ok && x == y
+	}
+	if _, ok := argNumber(b); ok {
+		return false
+	}
+	return fmt.Sprintf("%#v", a) == fmt.Sprintf("%#v", b)
+}
+
+func argNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// policyArgs are the queue arguments that can be set with a policy instead,
+// which changes them without redeclaring the queue.
+var policyArgs = map[string]bool{
+	QueueMessageTTLArg:          true,
+	QueueTTLArg:                 true,
+	QueueMaxLenArg:              true,
+	QueueMaxLenBytesArg:         true,
+	QueueOverflowArg:            true,
+	"x-dead-letter-exchange":    true,
+	"x-dead-letter-routing-key": true,
+	"x-delivery-limit":          true,
+	StreamMaxAgeArg:             true,
+}
+
+// migrationSteps suggests how to resolve the mismatches.
+func migrationSteps(mismatches []QueueArgMismatch) []string {
+	var steps []string
+	var policy []string
+	recreate := false
+
+	for _, m := range mismatches {
+		if policyArgs[m.Arg] {
+			policy = append(policy, m.Arg)
+		} else {
+			recreate = true
+		}
+	}
+
+	if len(policy) > 0 {
+		steps = append(steps, fmt.Sprintf("remove %s from the declaration and set the desired values with a policy, which applies to the existing queue without redeclaring it", strings.Join(policy, ", ")))
+	}
+	if recreate {
+		steps = append(steps,
+			"declare a new queue with the desired declaration and bind it like the existing one",
+			"move the consumers to the new queue, then the publishers",
+			"move the remaining messages, for example with a Shovel, and delete the existing queue",
+		)
+	}
+	return steps
+}
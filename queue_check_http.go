@@ -0,0 +1,88 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ManagementInspector is a QueueInspector reading the queues of a virtual
+// host from the HTTP API of the RabbitMQ management plugin.
+type ManagementInspector struct {
+	// URL of the management API, like "http://localhost:15672".
+	URL string
+
+	// Vhost of the queues, "/" when empty.
+	Vhost string
+
+	// Credentials of a user with at least the monitoring tag.
+	Username string
+	Password string
+
+	// Client sends the requests, http.DefaultClient when nil.
+	Client *http.Client
+}
+
+// InspectQueue returns the declaration of the queue name, or nil when the
+// API does not know it.
+func (m ManagementInspector) InspectQueue(ctx context.Context, name string) (*QueueSpec, error) {
+	vhost := m.Vhost
+	if vhost == "" {
+		vhost = "/"
+	}
+
+	endpoint := strings.TrimSuffix(m.URL, "/") + "/api/queues/" + url.PathEscape(vhost) + "/" + url.PathEscape(name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(m.Username, m.Password)
+
+	client := m.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("inspect queue %q: %s", name, res.Status)
+	}
+
+	var queue struct {
+		Name       string                 `json:"name"`
+		Durable    bool                   `json:"durable"`
+		AutoDelete bool                   `json:"auto_delete"`
+		Exclusive  bool                   `json:"exclusive"`
+		Arguments  map[string]interface{} `json:"arguments"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&queue); err != nil {
+		return nil, fmt.Errorf("inspect queue %q: %w", name, err)
+	}
+
+	return &QueueSpec{
+		Name:       queue.Name,
+		Durable:    queue.Durable,
+		AutoDelete: queue.AutoDelete,
+		Exclusive:  queue.Exclusive,
+		Args:       Table(queue.Arguments),
+	}, nil
+}
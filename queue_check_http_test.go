@@ -0,0 +1,50 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestQueueSpecCheckArgsWithManagementInspector(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _, _ := r.BasicAuth(); user != "monitor" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.EscapedPath() {
+		case "/api/queues/%2F/jobs":
+			_, _ = w.Write([]byte(`{"name":"jobs","durable":true,"auto_delete":false,"exclusive":false,"arguments":{"x-queue-type":"classic","x-message-ttl":1000}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(api.Close)
+
+	inspector := ManagementInspector{URL: api.URL, Username: "monitor"}
+
+	spec := QueueSpec{Name: "jobs", Durable: true, Args: Table{QueueTypeArg: QueueTypeQuorum, QueueMessageTTLArg: int64(1000)}}
+	diff, err := spec.CheckArgs(context.Background(), nil, inspector)
+	if err != nil {
+		t.Fatalf("could not check the queue: %v", err)
+	}
+	if len(diff.Mismatches) != 1 || diff.Mismatches[0].Arg != QueueTypeArg {
+		t.Fatalf("expected only the queue type to differ, got %+v", diff.Mismatches)
+	}
+	if len(diff.Migration) != 3 {
+		t.Fatalf("expected the queue to be recreated, got %q", diff.Migration)
+	}
+
+	missing, err := QueueSpec{Name: "other"}.CheckArgs(context.Background(), nil, inspector)
+	if err != nil || missing.Exists || !missing.Equivalent() {
+		t.Fatalf("expected a missing queue, got %+v (%v)", missing, err)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+)
+
+func TestQueueSpecCheckArgsOverAMQP(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		srv.connectionOpen()
+
+		srv.channelOpen(1)
+		passive := &queueDeclare{}
+		srv.recv(1, passive)
+		if !passive.Passive {
+			t.Errorf("expected a passive declare first")
+		}
+		srv.send(1, &queueDeclareOk{Queue: "jobs"})
+
+		srv.channelOpen(2)
+		srv.recv(2, &queueDeclare{})
+		srv.send(2, &channelClose{
+			ReplyCode: PreconditionFailed,
+			ReplyText: "PRECONDITION_FAILED - inequivalent arg 'x-message-ttl' for queue 'jobs' in vhost '/': received the value '2000' of type 'signedint' but current is none",
+		})
+		srv.recv(2, &channelCloseOk{})
+
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	spec := QueueSpec{Name: "jobs", Durable: true, Args: Table{QueueMessageTTLArg: int32(2000)}}
+	diff, err := spec.CheckArgs(context.Background(), c, nil)
+	if err != nil {
+		t.Fatalf("could not check the queue: %v", err)
+	}
+	<-served
+
+	if !diff.Exists || diff.Equivalent() {
+		t.Fatalf("expected an existing queue with a mismatch, got %+v", diff)
+	}
+	m := diff.Mismatches[0]
+	if m.Arg != QueueMessageTTLArg || m.Desired != int32(2000) || m.Detail != "received the value '2000' of type 'signedint' but current is none" {
+		t.Fatalf("unexpected mismatch %+v", m)
+	}
+	if len(diff.Migration) != 1 {
+		t.Fatalf("expected a policy to be suggested, got %q", diff.Migration)
+	}
+}
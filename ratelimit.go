@@ -0,0 +1,133 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limiter throttles publishings.  WaitN blocks until n events are allowed to
+// happen, or returns an error when ctx is done first or when n can never be
+// allowed.  The method set matches *rate.Limiter from golang.org/x/time/rate,
+// which can be used directly as a Limiter.
+type Limiter interface {
+	WaitN(ctx context.Context, n int) error
+}
+
+// TokenBucket is a Limiter that allows events at a steady rate, with bursts of
+// up to burst events.  The bucket holds at most burst tokens, refilled at rate
+// tokens per second, and each event consumes one token.
+type TokenBucket struct {
+	m      sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a TokenBucket allowing rate events per second with
+// bursts of up to burst events.  The bucket starts full.  A rate of zero or
+// less disables the limit.
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n tokens are available and consumes them.  It returns an
+// error without waiting when n exceeds the burst size, and ctx.Err() when ctx
+// is done before the tokens are available, in which case no token is consumed.
+func (b *TokenBucket) WaitN(ctx context.Context, n int) error {
+	if b.rate <= 0 {
+		return nil
+	}
+
+	if n > b.burst {
+		return fmt.Errorf("token bucket: %d exceeds the burst size of %d", n, b.burst)
+	}
+
+	b.m.Lock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	deficit := -b.tokens
+	b.m.Unlock()
+
+	if deficit <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(time.Duration(deficit / b.rate * float64(time.Second)))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		b.m.Lock()
+		b.tokens += float64(n)
+		b.m.Unlock()
+		return ctx.Err()
+	}
+}
+
+/*
+SetPublishLimiter throttles the publishings on this channel.  Before sending a
+message, every publish method waits for one event of messages and for as many
+events of bytes as there are bytes in the message body.  Either limiter can be
+nil to only limit the other dimension, and calling SetPublishLimiter(nil, nil)
+removes the limits.
+
+	// at most 100 messages and 1 MiB per second, in bursts of 10 messages
+	ch.SetPublishLimiter(
+		amqp.NewTokenBucket(100, 10),
+		amqp.NewTokenBucket(1<<20, 1<<20),
+	)
+
+The bytes limiter must allow bursts at least as large as the largest message
+body, as a message is never split.
+
+The wait honours the context given to Channel.PublishWithContext and
+Channel.PublishWithDeferredConfirmWithContext.  When the context is done, or
+when a limiter returns an error, the message is not published and the error is
+returned.
+*/
+func (ch *Channel) SetPublishLimiter(messages, bytes Limiter) {
+	ch.limiterM.Lock()
+	defer ch.limiterM.Unlock()
+
+	ch.msgLimiter = messages
+	ch.bytesLimiter = bytes
+}
+
+func (ch *Channel) waitPublishLimiter(ctx context.Context, msg Publishing) error {
+	ch.limiterM.RLock()
+	messages, bytes := ch.msgLimiter, ch.bytesLimiter
+	ch.limiterM.RUnlock()
+
+	if messages != nil {
+		if err := messages.WaitN(ctx, 1); err != nil {
+			return err
+		}
+	}
+
+	if bytes != nil && len(msg.Body) > 0 {
+		if err := bytes.WaitN(ctx, len(msg.Body)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketBurstThenRate(t *testing.T) {
+	b := NewTokenBucket(100, 2)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := b.WaitN(context.Background(), 1); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// The burst of 2 is immediate, the third event waits ~10ms.
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Fatalf("expected the event after the burst to be delayed, took %v", elapsed)
+	}
+}
+
+func TestTokenBucketExceedsBurst(t *testing.T) {
+	if err := NewTokenBucket(100, 2).WaitN(context.Background(), 3); err == nil {
+		t.Fatalf("expected an error when waiting for more than the burst size")
+	}
+}
+
+func TestTokenBucketContextDone(t *testing.T) {
+	b := NewTokenBucket(1, 1)
+	if err := b.WaitN(context.Background(), 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := b.WaitN(ctx, 1); err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.tokens < -0.5 {
+		t.Fatalf("expected the tokens of a cancelled wait to be given back, got %v", b.tokens)
+	}
+}
+
+type failingLimiter struct{ err error }
+
+func (l failingLimiter) WaitN(context.Context, int) error { return l.err }
+
+func TestPublishLimiterError(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		pub := &basicPublish{}
+		srv.recv(1, pub)
+		if want, got := "allowed", string(pub.Body); want != got {
+			t.Errorf("expected only the %q message to be published, got %q", want, got)
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	limited := errors.New("limited")
+	ch.SetPublishLimiter(nil, failingLimiter{limited})
+
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("denied")}); err != limited {
+		t.Fatalf("expected the limiter error, got %v", err)
+	}
+
+	ch.SetPublishLimiter(NewTokenBucket(10, 1), nil)
+
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("allowed")}); err != nil {
+		t.Fatalf("unexpected error during publish: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{Body: []byte("cancelled")}); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
@@ -6,8 +6,11 @@
 package amqp091
 
 import (
+	"bytes"
 	"strings"
 	"testing"
+
+	"github.com/rabbitmq/amqp091-go/frames"
 )
 
 func TestGoFuzzCrashers(t *testing.T) {
@@ -29,3 +32,52 @@ func TestGoFuzzCrashers(t *testing.T) {
 		}
 	}
 }
+
+func TestFramesPackageReadsClientFrames(t *testing.T) {
+	var buf bytes.Buffer
+	w := &writer{w: &buf}
+
+	if err := w.WriteFrame(&methodFrame{ChannelId: 3, Method: &basicPublish{Exchange: "ex", RoutingKey: "key"}}); err != nil {
+		t.Fatalf("could not write method frame: %v", err)
+	}
+	if err := w.WriteFrame(&headerFrame{ChannelId: 3, ClassId: 60, Size: 5}); err != nil {
+		t.Fatalf("could not write header frame: %v", err)
+	}
+
+	f, err := frames.ReadFrame(&buf, frames.MinSize)
+	if err != nil {
+		t.Fatalf("could not read method frame: %v", err)
+	}
+	m, err := f.Method()
+	if err != nil || f.Channel != 3 || m.Name() != "basic.publish" {
+		t.Fatalf("expected basic.publish on channel 3, got %v (%v)", f, err)
+	}
+
+	f, err = frames.ReadFrame(&buf, frames.MinSize)
+	if err != nil {
+		t.Fatalf("could not read header frame: %v", err)
+	}
+	h, err := f.Header()
+	if err != nil || h.ClassID != 60 || h.BodySize != 5 {
+		t.Fatalf("expected a content header of 5 bytes, got %+v (%v)", h, err)
+	}
+
+	r := reader{bytes.NewReader(mustFrame(t, frames.MethodFrame(1, m)))}
+	read, err := r.ReadFrame()
+	if err != nil {
+		t.Fatalf("could not read back frame written by the frames package: %v", err)
+	}
+	if publish, ok := read.(*methodFrame).Method.(*basicPublish); !ok || publish.Exchange != "ex" || publish.RoutingKey != "key" {
+		t.Fatalf("expected the same basic.publish, got %#v", read)
+	}
+}
+
+func mustFrame(t *testing.T, f frames.Frame) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := frames.WriteFrame(&buf, f); err != nil {
+		t.Fatalf("could not write frame: %v", err)
+	}
+	return buf.Bytes()
+}
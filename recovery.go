@@ -0,0 +1,451 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+RecoveryPolicy configures the automatic recovery of a connection, see
+Config.RecoveryPolicy.
+
+When the transport fails, or the server closes the connection, the
+connection is dialed again with an exponential backoff and the handshake runs
+again, on the same Connection value.  While it recovers, the connection is
+closed: IsClosed returns true and opening channels fails with ErrClosed.  The
+channels of the lost connection are closed with the error that interrupted it
+and must be opened again once recovered, see Connection.NotifyReconnect, or
+recovered with the topology declared on them, see TopologyRecovery.
+
+The NotifyClose listeners are only notified when the connection is closed for
+good, by Close or when the recovery gives up, with the error that interrupted
+the connection.  Closing the connection stops a recovery in progress.
+*/
+type RecoveryPolicy struct {
+	// MaxAttempts is the number of connection attempts of a recovery before
+	// giving up, unlimited when zero.  Errors that another attempt cannot fix,
+	// like rejected credentials, end the recovery right away.
+	MaxAttempts int
+
+	// BaseDelay and MaxDelay bound the exponential backoff before each
+	// attempt, 100ms and 10s when zero.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+
+	// ShouldRecover tells whether the connection recovers from err.  When nil,
+	// the connection recovers from every error.
+	ShouldRecover func(err *Error) bool
+
+	// Topology, when not nil, declares the exchanges, queues, bindings and
+	// consumers of the channels of the connection again once recovered, see
+	// TopologyRecovery.
+	Topology *TopologyRecovery
+
+	// RepublishUnconfirmed makes the channels in confirm mode retain their
+	// unconfirmed publishings, see Channel.RetainUnconfirmed.  The copies of
+	// the publishings left unconfirmed by the lost connection are published
+	// again with the PossibleDuplicateHeader header once recovered, after
+	// the topology and before the NotifyReconnect listeners are notified, on
+	// a channel of the recovery which waits for their confirmations.  This
+	// gives at-least-once delivery across reconnects without external
+	// storage.  The original publishings are still negatively acknowledged
+	// on the lost channels, and Channel.Unconfirmed returns nil for them.
+	// Republished messages that are negatively acknowledged by the server
+	// are logged.
+	RepublishUnconfirmed bool
+}
+
+// recovery is the state of the automatic recovery of a connection.
+type recovery struct {
+	policy RecoveryPolicy
+	config Config
+	redial func() (net.Conn, error)
+
+	running chan struct{} // closed when the running recovery ends, nil when none runs
+	stop    chan struct{} // closed to stop the running recovery
+
+	unconfirmed []UnconfirmedPublishing // left by the lost connection, see RepublishUnconfirmed
+}
+
+// enableRecovery arms the recovery of an open connection.  It returns
+// ErrClosed when the connection was already lost.
+func (c *Connection) enableRecovery(policy RecoveryPolicy, config Config, redial func() (net.Conn, error)) error {
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = defaultDialRetryBaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = defaultDialRetryMaxDelay
+	}
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.noNotify {
+		return ErrClosed
+	}
+	c.Config.RecoveryPolicy = config.RecoveryPolicy
+	c.recovery = &recovery{policy: policy, config: config, redial: redial}
+	if policy.Topology != nil {
+		c.topology = newTopologyRecorder(*policy.Topology)
+	}
+	return nil
+}
+
+/*
+NotifyReconnect registers a listener for the recoveries of the connection, see
+Config.RecoveryPolicy.  A value is sent once the connection was dialed again
+and the handshake completed, when channels can be opened again.  The chan is
+closed when the connection is closed for good.
+
+The value is sent synchronously, so the chan must be read, or be buffered, for
+the recoveries to complete.
+*/
+func (c *Connection) NotifyReconnect(receiver chan struct{}) chan struct{} {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.noNotify {
+		close(receiver)
+	} else {
+		c.reconnects = append(c.reconnects, receiver)
+	}
+
+	return receiver
+}
+
+// writable returns true when f can be sent: the connection is open, or a
+// recovery runs the handshake on channel 0.
+func (c *Connection) writable(f frame) bool {
+	if !c.IsClosed() {
+		return true
+	}
+	if atomic.LoadInt32(&c.reopening) == 0 {
+		return false
+	}
+	if _, ok := f.(*protocolHeader); ok {
+		return true
+	}
+	return f.channel() == 0
+}
+
+// fail shuts the connection down after the transport of generation gen
+// failed, unless a recovery already replaced it.
+func (c *Connection) fail(gen uint64, err *Error) {
+	if atomic.LoadUint64(&c.generation) == gen {
+		c.shutdown(err)
+	}
+}
+
+// recoverable returns true when the connection recovers from err instead of
+// shutting down.
+func (c *Connection) recoverable(err *Error) bool {
+	if err == nil || atomic.LoadInt32(&c.closing) == 1 {
+		return false
+	}
+
+	c.m.Lock()
+	r := c.recovery
+	c.m.Unlock()
+
+	return r != nil && (r.policy.ShouldRecover == nil || r.policy.ShouldRecover(err))
+}
+
+// interrupt closes the transport and the channels after err, like shutdown
+// but keeping the listeners, and starts a recovery unless one is running.
+func (c *Connection) interrupt(err *Error) {
+	atomic.StoreInt32(&c.closed, 1)
+
+	c.destructor.Do(func() {
+		c.m.Lock()
+		defer c.m.Unlock()
+
+		c.errors <- err
+		close(c.errors)
+
+		if c.topology != nil {
+			c.topology.suspend()
+		}
+		if r := c.recovery; r.policy.RepublishUnconfirmed {
+			ids := make([]int, 0, len(c.channels))
+			for id := range c.channels {
+				ids = append(ids, int(id))
+			}
+			sort.Ints(ids)
+			for _, id := range ids {
+				r.unconfirmed = append(r.unconfirmed, c.channels[uint16(id)].confirms.takeUnconfirmed()...)
+			}
+		}
+		for _, ch := range c.channels {
+			ch.shutdown(err)
+		}
+
+		c.conn.Close()
+		close(c.close)
+
+		c.channels = nil
+		c.allocator = nil
+
+		if r := c.recovery; r.running == nil {
+			r.running = make(chan struct{})
+			r.stop = make(chan struct{})
+			go c.recoverTransport(r, err)
+		}
+	})
+}
+
+// recoverTransport dials the connection again until it succeeds, the policy
+// gives up or the recovery is stopped.
+func (c *Connection) recoverTransport(r *recovery, cause *Error) {
+	delays := newBackoff(r.policy.BaseDelay, r.policy.MaxDelay)
+
+	for attempt := 1; ; attempt++ {
+		delay := delays.next()
+		Logger.Printf("connection lost, recovering in %s: %v", delay, cause)
+
+		select {
+		case <-r.stop:
+			c.abandon(nil)
+			c.endRecovery(r)
+			return
+		case <-time.After(delay):
+		}
+
+		err := c.reopen(r)
+		if err != nil {
+			Logger.Printf("connection recovery attempt %d failed: %v", attempt, err)
+			if (r.policy.MaxAttempts > 0 && attempt >= r.policy.MaxAttempts) || !isRetryableDialError(err) {
+				c.abandon(cause)
+				c.endRecovery(r)
+				return
+			}
+			continue
+		}
+
+		select {
+		case <-r.stop:
+			// Closed while the handshake was running.
+			_ = c.Close()
+			c.endRecovery(r)
+			return
+		default:
+		}
+
+		if !c.recovered(r) {
+			// Lost again before the recovery ended, which did not start
+			// another one.
+			attempt, delays = 0, newBackoff(r.policy.BaseDelay, r.policy.MaxDelay)
+			continue
+		}
+
+		if c.topology != nil {
+			c.topology.recover(c)
+		}
+		c.republishUnconfirmed(r)
+
+		c.m.Lock()
+		var reconnects []chan struct{}
+		if !c.noNotify {
+			reconnects = append(reconnects, c.reconnects...)
+		}
+		c.m.Unlock()
+
+		for _, receiver := range reconnects {
+			receiver <- struct{}{}
+		}
+		return
+	}
+}
+
+// republishesUnconfirmed returns true when the channels retain their
+// unconfirmed publishings for the recovery, see RepublishUnconfirmed.
+func (c *Connection) republishesUnconfirmed() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.recovery != nil && c.recovery.policy.RepublishUnconfirmed
+}
+
+// republishUnconfirmed publishes the copies of the publishings left
+// unconfirmed by the lost connection again, and waits for their
+// confirmations.  The copies that could not be published are kept for the
+// next recovery.
+func (c *Connection) republishUnconfirmed(r *recovery) {
+	c.m.Lock()
+	pending := r.unconfirmed
+	r.unconfirmed = nil
+	c.m.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		Logger.Printf("could not publish %d unconfirmed publishings again: %v", len(pending), err)
+		c.m.Lock()
+		r.unconfirmed = append(pending, r.unconfirmed...)
+		c.m.Unlock()
+		return
+	}
+	defer func() { _ = ch.Close() }()
+	ch.unrecorded = true
+
+	var confirmations []*DeferredConfirmation
+	if err = ch.Confirm(false); err == nil {
+		confirmations, err = ch.RepublishUnconfirmed(context.Background(), pending)
+	}
+	if err != nil {
+		Logger.Printf("could not publish %d unconfirmed publishings again: %v", len(pending)-len(confirmations), err)
+		c.m.Lock()
+		r.unconfirmed = append(pending[len(confirmations):], r.unconfirmed...)
+		c.m.Unlock()
+	}
+
+	for i, confirmation := range confirmations {
+		// The publishings of a channel lost in the meantime are retained
+		// for the next recovery.
+		if !confirmation.Wait() && !ch.IsClosed() {
+			Logger.Printf("unconfirmed publishing to %q with key %q nacked again by the server", pending[i].Exchange, pending[i].RoutingKey)
+		}
+	}
+}
+
+// recovered ends the recovery after a successful handshake, unless the
+// connection was lost again, in which case it returns false and the recovery
+// goes on.
+func (c *Connection) recovered(r *recovery) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.IsClosed() && atomic.LoadInt32(&c.closing) == 0 {
+		return false
+	}
+
+	close(r.running)
+	r.running = nil
+	return true
+}
+
+// endRecovery marks the recovery as ended.
+func (c *Connection) endRecovery(r *recovery) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	close(r.running)
+	r.running = nil
+}
+
+// reopen dials the connection again and runs the handshake on the new
+// transport.
+func (c *Connection) reopen(r *recovery) error {
+	config := r.config
+	c.m.Lock()
+	credentials := c.credentials
+	c.m.Unlock()
+	if credentials != nil {
+		var err error
+		if config.SASL, err = credentials.sasl(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	conn, err := r.redial()
+	if err != nil {
+		return err
+	}
+
+	// The reader of the lost transport uses the fields replaced below.
+	<-c.readerDone
+
+	c.sendM.Lock()
+	c.m.Lock()
+	c.conn = conn
+	c.writer = &writer{w: bufio.NewWriter(conn), conn: conn, unbuffered: r.config.LowLatencyWrites}
+	c.rpc = make(chan message)
+	c.errors = make(chan *Error, 1)
+	c.close = make(chan struct{})
+	c.channels = make(map[uint16]*Channel)
+	c.destructor = sync.Once{}
+	c.readerDone = make(chan struct{})
+	gen := atomic.AddUint64(&c.generation, 1)
+	done := c.readerDone
+	c.m.Unlock()
+	c.sendM.Unlock()
+
+	atomic.StoreInt32(&c.reopening, 1)
+	defer atomic.StoreInt32(&c.reopening, 0)
+
+	go c.reader(conn, gen, done)
+	if err := c.open(config); err != nil {
+		c.interrupt(&Error{Code: FrameError, Reason: err.Error()})
+		return err
+	}
+
+	atomic.StoreInt32(&c.closed, 0)
+	return nil
+}
+
+// abandon closes the connection for good after its recovery ended, notifying
+// the listeners like shutdown.
+func (c *Connection) abandon(err *Error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if err != nil {
+		for _, c := range c.closes {
+			c <- err
+		}
+	}
+
+	for _, c := range c.closes {
+		close(c)
+	}
+
+	for _, c := range c.blocks {
+		close(c)
+	}
+
+	for _, c := range c.reconnects {
+		close(c)
+	}
+
+	c.noNotify = true
+	c.onClose.fire(err)
+
+	if c.topology != nil {
+		c.topology.abandon()
+	}
+}
+
+// stopRecovery stops the running recovery, if any, and waits for it to end.
+// It returns false when no recovery was running.
+func (c *Connection) stopRecovery() bool {
+	c.m.Lock()
+	r := c.recovery
+	var running chan struct{}
+	if r != nil && r.running != nil {
+		running = r.running
+		atomic.StoreInt32(&c.closing, 1)
+		select {
+		case <-r.stop:
+		default:
+			close(r.stop)
+		}
+	}
+	c.m.Unlock()
+
+	if running == nil {
+		return false
+	}
+	<-running
+	return true
+}
@@ -0,0 +1,268 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recoveryDialer returns a Config.Dial serving each connection with the next
+// function of serve, and failing once they are exhausted.
+func recoveryDialer(t *testing.T, serve ...func(srv *server)) (func(network, addr string) (net.Conn, error), *sync.WaitGroup) {
+	var m sync.Mutex
+	var served sync.WaitGroup
+
+	return func(network, addr string) (net.Conn, error) {
+		m.Lock()
+		defer m.Unlock()
+
+		if len(serve) == 0 {
+			return nil, errors.New("connection refused")
+		}
+		next := serve[0]
+		serve = serve[1:]
+
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+
+		srv := newServer(t, server, client)
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			next(srv)
+		}()
+
+		return client, nil
+	}, &served
+}
+
+func TestConnectionRecoversAfterTransportFailure(t *testing.T) {
+	lose := make(chan struct{})
+	dial, served := recoveryDialer(t,
+		func(srv *server) {
+			srv.connectionOpen()
+			<-lose
+			srv.S.Close()
+		},
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+			srv.connectionClose()
+		},
+	)
+
+	c, err := DialConfig("amqp://localhost", Config{
+		RecoveryPolicy: &RecoveryPolicy{BaseDelay: time.Millisecond},
+		Dial:           dial,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+
+	reconnects := c.NotifyReconnect(make(chan struct{}, 1))
+	closes := c.NotifyClose(make(chan *Error, 1))
+	close(lose)
+
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to recover")
+	}
+
+	if c.IsClosed() {
+		t.Fatalf("expected the recovered connection to be open")
+	}
+	if _, err := c.Channel(); err != nil {
+		t.Fatalf("could not open a channel on the recovered connection: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	served.Wait()
+
+	if err, ok := <-closes; ok {
+		t.Fatalf("expected a graceful close without error, got %v", err)
+	}
+	if _, ok := <-reconnects; ok {
+		t.Fatalf("expected the reconnect listener to be closed")
+	}
+}
+
+func TestConnectionRecoveryGivesUp(t *testing.T) {
+	lose := make(chan struct{})
+	dial, served := recoveryDialer(t, func(srv *server) {
+		srv.connectionOpen()
+		<-lose
+		srv.S.Close()
+	})
+
+	c, err := DialConfig("amqp://localhost", Config{
+		RecoveryPolicy: &RecoveryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond},
+		Dial:           dial,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	closes := c.NotifyClose(make(chan *Error, 1))
+	close(lose)
+	served.Wait()
+
+	select {
+	case err := <-closes:
+		if err == nil || err.Code != FrameError {
+			t.Fatalf("expected the error that interrupted the connection, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the recovery to give up")
+	}
+
+	if !c.IsClosed() {
+		t.Fatalf("expected the connection to be closed")
+	}
+	if err := c.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestConnectionCloseStopsRecovery(t *testing.T) {
+	lose := make(chan struct{})
+	dial, served := recoveryDialer(t, func(srv *server) {
+		srv.connectionOpen()
+		<-lose
+		srv.S.Close()
+	})
+
+	c, err := DialConfig("amqp://localhost", Config{
+		RecoveryPolicy: &RecoveryPolicy{BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond},
+		Dial:           dial,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	closes := c.NotifyClose(make(chan *Error, 1))
+	close(lose)
+	served.Wait()
+
+	for !c.IsClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("expected closing a recovering connection to succeed, got %v", err)
+	}
+
+	if err, ok := <-closes; ok {
+		t.Fatalf("expected a graceful close without error, got %v", err)
+	}
+	if _, err := c.Channel(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestConnectionRecoveryPolicyShouldRecover(t *testing.T) {
+	lose := make(chan struct{})
+	dial, served := recoveryDialer(t, func(srv *server) {
+		srv.connectionOpen()
+		<-lose
+		srv.send(0, &connectionClose{ReplyCode: ConnectionForced, ReplyText: "shutdown"})
+		srv.recv(0, &connectionCloseOk{})
+	})
+
+	c, err := DialConfig("amqp://localhost", Config{
+		RecoveryPolicy: &RecoveryPolicy{
+			BaseDelay:     time.Millisecond,
+			ShouldRecover: func(err *Error) bool { return err.Code != ConnectionForced },
+		},
+		Dial: dial,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	closes := c.NotifyClose(make(chan *Error, 1))
+	close(lose)
+	served.Wait()
+
+	select {
+	case err := <-closes:
+		if err == nil || err.Code != ConnectionForced {
+			t.Fatalf("expected the connection to close with the server error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to be closed")
+	}
+}
+
+func TestConnectionRecoveryRepublishesUnconfirmed(t *testing.T) {
+	republished := &basicPublish{}
+	dial, served := recoveryDialer(t,
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+			srv.recv(1, &confirmSelect{})
+			srv.send(1, &confirmSelectOk{})
+			srv.recv(1, &basicPublish{})
+			srv.S.Close()
+		},
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+			srv.recv(1, &confirmSelect{})
+			srv.send(1, &confirmSelectOk{})
+			srv.recv(1, republished)
+			srv.send(1, &basicAck{DeliveryTag: 1})
+			srv.recv(1, &channelClose{})
+			srv.send(1, &channelCloseOk{})
+			srv.connectionClose()
+		},
+	)
+
+	c, err := DialConfig("amqp://localhost", Config{
+		Dial: dial,
+		RecoveryPolicy: &RecoveryPolicy{
+			BaseDelay:            time.Millisecond,
+			RepublishUnconfirmed: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	reconnects := c.NotifyReconnect(make(chan struct{}, 1))
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("could not enable confirms: %v", err)
+	}
+	confirmation, err := ch.PublishWithDeferredConfirm("", "jobs", false, false, Publishing{Body: []byte("payload")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if confirmation.Wait() {
+		t.Fatalf("expected the publishing of the lost connection to be nacked")
+	}
+
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to recover")
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	served.Wait()
+
+	if string(republished.Body) != "payload" || republished.RoutingKey != "jobs" || republished.Properties.Headers[PossibleDuplicateHeader] != true {
+		t.Fatalf("expected the unconfirmed publishing to be published again as a possible duplicate, got %+v", republished)
+	}
+	if pending := ch.Unconfirmed(); pending != nil {
+		t.Fatalf("expected the recovery to take over the unconfirmed publishings, got %+v", pending)
+	}
+}
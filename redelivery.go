@@ -0,0 +1,170 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errRedeliverySuperseded = errors.New("the channel was recovered again")
+
+// Redelivery follows the deliveries redelivered by the server after
+// Channel.RecoverWithContext.
+type Redelivery struct {
+	// Expected is the number of deliveries that were awaiting acknowledgement
+	// on the channel when it was recovered.
+	Expected int
+
+	m        sync.Mutex
+	received int
+	err      error
+	done     chan struct{}
+}
+
+func newRedelivery(expected int) *Redelivery {
+	r := &Redelivery{Expected: expected, done: make(chan struct{})}
+	if expected == 0 {
+		close(r.done)
+	}
+	return r
+}
+
+// Received returns the number of redelivered deliveries received so far.
+func (r *Redelivery) Received() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.received
+}
+
+// Done returns a chan that is closed once Expected redelivered deliveries
+// were received on the channel, or when the redelivery ends early, see Wait.
+func (r *Redelivery) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait blocks until the redelivery completes, or ctx is done.  It returns
+// ErrClosed when the channel was closed before all deliveries were
+// redelivered, and an error when it was recovered again.
+func (r *Redelivery) Wait(ctx context.Context) error {
+	select {
+	case <-r.done:
+		r.m.Lock()
+		defer r.m.Unlock()
+		return r.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// observe counts a redelivered delivery and returns true when the redelivery
+// is complete.
+func (r *Redelivery) observe() bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	select {
+	case <-r.done:
+		return true
+	default:
+	}
+
+	r.received++
+	if r.received == r.Expected {
+		close(r.done)
+		return true
+	}
+	return false
+}
+
+func (r *Redelivery) abort(err error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	select {
+	case <-r.done:
+	default:
+		r.err = err
+		close(r.done)
+	}
+}
+
+/*
+RecoverWithContext asks the server to redeliver all unacknowledged messages
+on this channel, like Channel.Recover, and returns a Redelivery counting the
+redelivered deliveries as they arrive, so that retry schemes based on recovery
+can tell when all messages came back.
+
+Counting requires the channel to know its deliveries awaiting
+acknowledgement, which is enabled by Config.TrackDeliveries or
+Config.AckDiagnostics.  Otherwise the returned Redelivery is nil.  The
+delivery tags of the recovered deliveries are no longer valid afterwards.
+
+Redelivered deliveries already on their way when the channel is recovered are
+counted as well.  When requeue is true, the server may deliver the messages to
+other consumers, in which case the Redelivery never completes.  Messages that
+were delivered with automatic acknowledgement are not redelivered.  Recovering
+the channel again ends the pending Redelivery with an error.
+
+The context is only checked before the basic.recover is sent.
+*/
+func (ch *Channel) RecoverWithContext(ctx context.Context, requeue bool) (*Redelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if ch.acks == nil {
+		return nil, ch.Recover(requeue)
+	}
+
+	r := newRedelivery(ch.acks.reset())
+	if r.Expected == 0 {
+		if err := ch.Recover(requeue); err != nil {
+			return nil, err
+		}
+		return r, nil
+	}
+
+	ch.redeliveryM.Lock()
+	previous := ch.redelivery
+	ch.redelivery = r
+	ch.redeliveryM.Unlock()
+	if previous != nil {
+		previous.abort(errRedeliverySuperseded)
+	}
+
+	if err := ch.Recover(requeue); err != nil {
+		ch.endRedelivery(r, err)
+		return nil, err
+	}
+	return r, nil
+}
+
+// redelivered counts a delivery flagged as redelivered toward the pending
+// Redelivery, if any.
+func (ch *Channel) redelivered() {
+	ch.redeliveryM.Lock()
+	r := ch.redelivery
+	ch.redeliveryM.Unlock()
+
+	if r != nil && r.observe() {
+		ch.endRedelivery(r, nil)
+	}
+}
+
+// endRedelivery forgets r when it is the pending Redelivery, aborting it with
+// err when not nil.
+func (ch *Channel) endRedelivery(r *Redelivery, err error) {
+	ch.redeliveryM.Lock()
+	if ch.redelivery == r {
+		ch.redelivery = nil
+	}
+	ch.redeliveryM.Unlock()
+
+	if err != nil {
+		r.abort(err)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecoverWithContextCountsRedeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: tag})
+		}
+		srv.recv(1, &basicAck{})
+
+		srv.recv(1, &basicRecover{})
+		srv.send(1, &basicRecoverOk{})
+
+		for tag := uint64(4); tag <= 5; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: tag, Redelivered: true})
+		}
+	}()
+
+	config := defaultConfig()
+	config.TrackDeliveries = true
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, err := ch.Consume("q", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	first := <-deliveries
+	<-deliveries
+	<-deliveries
+	if err := first.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	r, err := ch.RecoverWithContext(context.Background(), false)
+	if err != nil {
+		t.Fatalf("could not recover: %v", err)
+	}
+	if want, got := 2, r.Expected; want != got {
+		t.Fatalf("expected %d deliveries to be redelivered, got %d", want, got)
+	}
+
+	go func() {
+		for range deliveries {
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := r.Wait(ctx); err != nil {
+		t.Fatalf("expected the redelivery to complete, got: %v", err)
+	}
+	if want, got := 2, r.Received(); want != got {
+		t.Fatalf("expected %d redelivered deliveries, got %d", want, got)
+	}
+	<-served
+}
+
+func TestRedeliveryAbort(t *testing.T) {
+	r := newRedelivery(2)
+	r.observe()
+	r.abort(ErrClosed)
+	r.abort(ErrClosed)
+
+	if err := r.Wait(context.Background()); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got: %v", err)
+	}
+	if r.observe() != true || r.Received() != 1 {
+		t.Fatalf("expected an aborted redelivery to stop counting")
+	}
+}
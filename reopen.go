@@ -0,0 +1,266 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync/atomic"
+)
+
+// ErrStaleDeliveryTag is returned when settling a delivery received before the
+// channel was reopened, see Channel.SetAutoReopen.  The server already requeued
+// the delivery, which will be delivered again.
+var ErrStaleDeliveryTag = errors.New("delivery tag is from before the channel was reopened")
+
+/*
+SetAutoReopen makes the channel open itself again, on the same Channel value,
+when the server closes it with a soft error, like NOT_FOUND after a passive
+declare of a missing queue, instead of shutting it down.  The call that raised
+the error still returns it, but the channel remains usable and the listeners
+registered with the Notify methods stay in place.  Errors closing the
+connection always shut the channel down.
+
+Once reopened, the prefetch set with Qos and the confirm mode are applied again
+and the consumers are registered again with their consumer tags, delivering to
+the same chans.  A consumer that cannot be registered again, for example
+because its queue was deleted, is cancelled and its chan closed.  The
+transaction mode of Tx is not restored.
+
+The publishings awaiting confirmation when the channel was closed are
+negatively acknowledged, and the publishing sequence starts over, see
+GetNextPublishSeqNo.  When the channel retains its unconfirmed publishings, see
+RetainUnconfirmed, their copies are published again with the
+PossibleDuplicateHeader header once the confirm mode is restored, before the
+consumers are registered again.  The deliveries received before are requeued
+by the server: settling them sends nothing and fails with ErrStaleDeliveryTag.
+The delivery tags of the deliveries received after go on increasing, so that
+they are never mistaken for earlier ones.
+
+Use NotifyReopen to learn about the errors the channel recovered from.
+*/
+func (ch *Channel) SetAutoReopen(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&ch.autoReopen, v)
+}
+
+/*
+NotifyReopen registers a listener for the soft errors the channel recovered
+from, see Channel.SetAutoReopen.  The error is sent once the channel was opened
+again and its consumers registered again.  The chan is closed when the channel
+is closed.
+
+The error is sent synchronously, so the chan must be read, or be buffered, to
+avoid deadlocks.
+*/
+func (ch *Channel) NotifyReopen(c chan *Error) chan *Error {
+	ch.notifyM.Lock()
+	defer ch.notifyM.Unlock()
+
+	if ch.noNotify {
+		close(c)
+	} else {
+		ch.reopens = append(ch.reopens, c)
+	}
+
+	return c
+}
+
+// reopenable returns true when the channel closed by the server with e opens
+// itself again.
+func (ch *Channel) reopenable(e *Error) bool {
+	return atomic.LoadInt32(&ch.autoReopen) == 1 && e.Recover
+}
+
+// interrupt hands e to the call waiting for a reply, if any, after the server
+// closed the channel and before it is reopened.
+func (ch *Channel) interrupt(e *Error) {
+	ch.m.Lock()
+	defer ch.m.Unlock()
+
+	if ch.noNotify {
+		return
+	}
+	select {
+	case ch.errors <- e:
+	default:
+	}
+}
+
+// reopen opens the channel closed by the server with e again, and restores its
+// prefetch, confirm mode and consumers.  It stops when the channel is closed
+// in the meantime, by the application, the connection, or another soft error
+// which reopens it again.
+func (ch *Channel) reopen(e *Error) {
+	ch.reopenM.Lock()
+	defer ch.reopenM.Unlock()
+
+	ch.m.Lock()
+	if ch.noNotify {
+		ch.m.Unlock()
+		return
+	}
+	if atomic.LoadInt32(&ch.calls) == 0 {
+		// Nobody took the error of the close, which must not fail the next
+		// call.
+		select {
+		case <-ch.errors:
+		default:
+		}
+	}
+	atomic.StoreUint64(&ch.tagOffset, atomic.LoadUint64(&ch.lastTag))
+	ch.m.Unlock()
+
+	ch.flowM.Lock()
+	ch.flow.held = nil
+	ch.flowM.Unlock()
+
+	ch.redeliveryM.Lock()
+	if ch.redelivery != nil {
+		ch.redelivery.abort(e)
+		ch.redelivery = nil
+	}
+	ch.redeliveryM.Unlock()
+
+	// Only channel.open is sent until the channel is open again.
+	atomic.StoreInt32(&ch.reopening, 1)
+	err := ch.open()
+	atomic.StoreInt32(&ch.reopening, 0)
+	if err != nil {
+		Logger.Printf("could not reopen channel %d: %v", ch.id, err)
+		return
+	}
+
+	ch.m.Lock()
+	if ch.noNotify {
+		ch.m.Unlock()
+		return
+	}
+	atomic.StoreInt32(&ch.closed, 0)
+	qos := append([]basicQos(nil), ch.recordedQos...)
+	ch.m.Unlock()
+
+	for _, q := range qos {
+		if err := ch.setQos(int(q.PrefetchCount), int(q.PrefetchSize), q.Global); err != nil {
+			Logger.Printf("could not restore the prefetch of channel %d: %v", ch.id, err)
+			return
+		}
+	}
+
+	if ch.confirming {
+		pending := ch.confirms.reset()
+		if err := ch.call(&confirmSelect{}, &confirmSelectOk{}); err != nil {
+			ch.confirms.strand(pending)
+			Logger.Printf("could not restore the confirm mode of channel %d: %v", ch.id, err)
+			return
+		}
+		if confirmations, err := ch.RepublishUnconfirmed(context.Background(), pending); err != nil {
+			ch.confirms.strand(pending[len(confirmations):])
+			Logger.Printf("could not publish the unconfirmed publishings of channel %d again: %v", ch.id, err)
+			return
+		}
+	}
+
+	for _, req := range ch.consumers.consumeRequests() {
+		again := *req
+		again.NoWait = false
+		if err := ch.call(&again, &basicConsumeOk{}); err != nil {
+			Logger.Printf("could not consume %q again on channel %d: %v", req.ConsumerTag, ch.id, err)
+			ch.consumers.cancel(req.ConsumerTag)
+			if ch.IsClosed() {
+				return
+			}
+		}
+	}
+
+	ch.notifyM.RLock()
+	for _, c := range ch.reopens {
+		c <- e
+	}
+	ch.notifyM.RUnlock()
+}
+
+// deliveryTag returns the tag of a delivery received on the channel, which
+// goes on increasing after the channel was reopened.
+func (ch *Channel) deliveryTag(wire uint64) uint64 {
+	tag := wire + atomic.LoadUint64(&ch.tagOffset)
+	for {
+		last := atomic.LoadUint64(&ch.lastTag)
+		if tag <= last || atomic.CompareAndSwapUint64(&ch.lastTag, last, tag) {
+			return tag
+		}
+	}
+}
+
+// wireTag returns the tag the server knows a delivery by, false when the
+// delivery was received before the channel was reopened.
+func (ch *Channel) wireTag(tag uint64) (uint64, bool) {
+	offset := atomic.LoadUint64(&ch.tagOffset)
+	if tag == 0 {
+		// With multiple, all the deliveries of the current channel.
+		return 0, true
+	}
+	if tag <= offset {
+		return 0, false
+	}
+	return tag - offset, true
+}
+
+// remember keeps the request of a consumer to register it again when the
+// channel is reopened.
+func (subs *consumers) remember(tag string, req *basicConsume) {
+	subs.Lock()
+	defer subs.Unlock()
+
+	if _, ok := subs.chans[tag]; ok {
+		subs.requests[tag] = req
+	}
+}
+
+// consumeRequests returns the requests of the consumers, in consumer tag
+// order.
+func (subs *consumers) consumeRequests() []*basicConsume {
+	subs.Lock()
+	defer subs.Unlock()
+
+	reqs := make([]*basicConsume, 0, len(subs.requests))
+	for _, req := range subs.requests {
+		reqs = append(reqs, req)
+	}
+	sort.Slice(reqs, func(i, j int) bool { return reqs[i].ConsumerTag < reqs[j].ConsumerTag })
+	return reqs
+}
+
+// reset negatively acknowledges the publishings awaiting confirmation and
+// starts the publishing sequence over, once the channel was reopened.  It
+// returns the retained copies of the publishings, to be published again.
+func (c *confirms) reset() []UnconfirmedPublishing {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	c.publishedMut.Lock()
+	published := c.published
+	c.publishedMut.Unlock()
+
+	pending := c.takeUnconfirmed()
+	if c.expecting <= published {
+		c.deferredConfirmations.ConfirmMultiple(Confirmation{published, false})
+		for c.expecting <= published {
+			c.confirm(Confirmation{c.expecting, false})
+		}
+	}
+
+	c.publishedMut.Lock()
+	c.published = 0
+	c.publishedMut.Unlock()
+	c.expecting = 1
+	c.sequencer = map[uint64]Confirmation{}
+
+	return pending
+}
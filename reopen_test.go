@@ -0,0 +1,232 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChannelAutoReopenAfterSoftError(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	reconsume := &basicConsume{}
+	qos := &basicQos{}
+	ack := &basicAck{}
+	go func() {
+		defer close(served)
+
+		srv.connectionOpen()
+		srv.consumerStart(1, "worker", 1)
+
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'missing'"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(1)
+		srv.recv(1, qos)
+		srv.send(1, &basicQosOk{})
+		srv.recv(1, reconsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: reconsume.ConsumerTag})
+		srv.send(1, &basicDeliver{ConsumerTag: "worker", DeliveryTag: 1})
+
+		srv.recv(1, ack)
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	ch.SetAutoReopen(true)
+	reopens := ch.NotifyReopen(make(chan *Error, 1))
+	closes := ch.NotifyClose(make(chan *Error, 1))
+
+	if err := ch.Qos(5, 0, false); err != nil {
+		t.Fatalf("could not set qos: %v", err)
+	}
+	deliveries, err := ch.Consume("jobs", "worker", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+	before := <-deliveries
+
+	_, err = ch.QueueDeclarePassive("missing", false, false, false, false, nil)
+	var amqpErr *Error
+	if !errors.As(err, &amqpErr) || amqpErr.Code != NotFound {
+		t.Fatalf("expected the NOT_FOUND error of the declare, got %v", err)
+	}
+
+	select {
+	case e := <-reopens:
+		if e.Code != NotFound {
+			t.Fatalf("expected the error the channel recovered from, got %v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to reopen")
+	}
+	if ch.IsClosed() {
+		t.Fatalf("expected the reopened channel to be open")
+	}
+	if qos.PrefetchCount != 5 || reconsume.ConsumerTag != "worker" || reconsume.Queue != "jobs" {
+		t.Fatalf("expected the prefetch and the consumer to be restored, got %+v %+v", qos, reconsume)
+	}
+
+	after := <-deliveries
+	if after.DeliveryTag <= before.DeliveryTag {
+		t.Fatalf("expected the delivery tags to go on increasing, got %d after %d", after.DeliveryTag, before.DeliveryTag)
+	}
+	if err := before.Ack(false); !errors.Is(err, ErrStaleDeliveryTag) {
+		t.Fatalf("expected the ack of an earlier delivery to fail with ErrStaleDeliveryTag, got %v", err)
+	}
+	if err := before.Nack(false, true); !errors.Is(err, ErrStaleDeliveryTag) {
+		t.Fatalf("expected the nack of an earlier delivery to fail with ErrStaleDeliveryTag, got %v", err)
+	}
+	if err := before.Reject(true); !errors.Is(err, ErrStaleDeliveryTag) {
+		t.Fatalf("expected the reject of an earlier delivery to fail with ErrStaleDeliveryTag, got %v", err)
+	}
+	if err := after.Ack(false); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+
+	if ack.DeliveryTag != 1 {
+		t.Fatalf("expected the delivery tag known by the server, got %d", ack.DeliveryTag)
+	}
+	if err, ok := <-closes; ok {
+		t.Fatalf("expected the close listener not to be notified of the soft error, got %v", err)
+	}
+}
+
+func TestChannelWithoutAutoReopenClosesOnSoftError(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: NotFound})
+		srv.recv(1, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	reopens := ch.NotifyReopen(make(chan *Error, 1))
+
+	if _, err := ch.QueueDeclarePassive("missing", false, false, false, false, nil); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if _, ok := <-reopens; ok {
+		t.Fatalf("expected the reopen listener to be closed with the channel")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+}
+
+func TestChannelAutoReopenRepublishesUnconfirmed(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	republished := &basicPublish{}
+	go func() {
+		defer close(served)
+
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+		srv.recv(1, &basicPublish{})
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicAck{DeliveryTag: 1})
+
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'missing'"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(1)
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+		srv.recv(1, republished)
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	ch.SetAutoReopen(true)
+	reopens := ch.NotifyReopen(make(chan *Error, 1))
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("could not enable confirms: %v", err)
+	}
+	ch.RetainUnconfirmed()
+
+	first, err := ch.PublishWithDeferredConfirm("", "jobs", false, false, Publishing{Body: []byte("first")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	second, err := ch.PublishWithDeferredConfirm("", "jobs", false, false, Publishing{Body: []byte("second")})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if !first.Wait() {
+		t.Fatalf("expected the first publishing to be confirmed")
+	}
+
+	if _, err := ch.QueueDeclarePassive("missing", false, false, false, false, nil); err == nil {
+		t.Fatalf("expected the NOT_FOUND error of the declare")
+	}
+	select {
+	case <-reopens:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the channel to reopen")
+	}
+	if second.Wait() {
+		t.Fatalf("expected the publishing unconfirmed by the closed channel to be nacked")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+
+	if string(republished.Body) != "second" || republished.RoutingKey != "jobs" || republished.Properties.Headers[PossibleDuplicateHeader] != true {
+		t.Fatalf("expected the unconfirmed publishing to be published again as a possible duplicate, got %+v", republished)
+	}
+	if pending := ch.Unconfirmed(); len(pending) != 0 {
+		t.Fatalf("expected the republished message to be confirmed, got %+v", pending)
+	}
+}
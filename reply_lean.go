@@ -0,0 +1,98 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build amqp_lean
+// +build amqp_lean
+
+package amqp091
+
+// setReply copies msg into the first of res with the same class and method
+// ids and returns true, or returns false when msg is none of the expected
+// replies.  Unlike the default build, it does not use reflection, which is
+// limited or costly on TinyGo.
+func setReply(msg message, res ...message) bool {
+	class, method := msg.id()
+	for _, try := range res {
+		if c, m := try.id(); c == class && m == method {
+			return copyReply(try, msg)
+		}
+	}
+	return false
+}
+
+// copyReply does *dst = *src for the methods the server sends in reply to a
+// synchronous request.
+func copyReply(dst, src message) bool {
+	switch src := src.(type) {
+	case *connectionStart:
+		*dst.(*connectionStart) = *src
+	case *connectionStartOk:
+		*dst.(*connectionStartOk) = *src
+	case *connectionSecure:
+		*dst.(*connectionSecure) = *src
+	case *connectionSecureOk:
+		*dst.(*connectionSecureOk) = *src
+	case *connectionTune:
+		*dst.(*connectionTune) = *src
+	case *connectionTuneOk:
+		*dst.(*connectionTuneOk) = *src
+	case *connectionOpenOk:
+		*dst.(*connectionOpenOk) = *src
+	case *connectionClose:
+		*dst.(*connectionClose) = *src
+	case *connectionCloseOk:
+		*dst.(*connectionCloseOk) = *src
+	case *connectionUpdateSecretOk:
+		*dst.(*connectionUpdateSecretOk) = *src
+	case *channelOpenOk:
+		*dst.(*channelOpenOk) = *src
+	case *channelFlowOk:
+		*dst.(*channelFlowOk) = *src
+	case *channelClose:
+		*dst.(*channelClose) = *src
+	case *channelCloseOk:
+		*dst.(*channelCloseOk) = *src
+	case *exchangeDeclareOk:
+		*dst.(*exchangeDeclareOk) = *src
+	case *exchangeDeleteOk:
+		*dst.(*exchangeDeleteOk) = *src
+	case *exchangeBindOk:
+		*dst.(*exchangeBindOk) = *src
+	case *exchangeUnbindOk:
+		*dst.(*exchangeUnbindOk) = *src
+	case *queueDeclareOk:
+		*dst.(*queueDeclareOk) = *src
+	case *queueBindOk:
+		*dst.(*queueBindOk) = *src
+	case *queueUnbindOk:
+		*dst.(*queueUnbindOk) = *src
+	case *queuePurgeOk:
+		*dst.(*queuePurgeOk) = *src
+	case *queueDeleteOk:
+		*dst.(*queueDeleteOk) = *src
+	case *basicQosOk:
+		*dst.(*basicQosOk) = *src
+	case *basicConsumeOk:
+		*dst.(*basicConsumeOk) = *src
+	case *basicCancelOk:
+		*dst.(*basicCancelOk) = *src
+	case *basicGetOk:
+		*dst.(*basicGetOk) = *src
+	case *basicGetEmpty:
+		*dst.(*basicGetEmpty) = *src
+	case *basicRecoverOk:
+		*dst.(*basicRecoverOk) = *src
+	case *txSelectOk:
+		*dst.(*txSelectOk) = *src
+	case *txCommitOk:
+		*dst.(*txCommitOk) = *src
+	case *txRollbackOk:
+		*dst.(*txRollbackOk) = *src
+	case *confirmSelectOk:
+		*dst.(*confirmSelectOk) = *src
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,22 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "testing"
+
+func TestSetReply(t *testing.T) {
+	get, declare := &basicGetOk{}, &queueDeclareOk{}
+
+	if !setReply(&queueDeclareOk{Queue: "q", MessageCount: 3}, get, declare) {
+		t.Fatalf("expected queue.declare-ok to match")
+	}
+	if declare.Queue != "q" || declare.MessageCount != 3 {
+		t.Fatalf("expected the reply to be copied, got %+v", declare)
+	}
+
+	if setReply(&basicGetEmpty{}, get, declare) {
+		t.Fatalf("expected basic.get-empty not to match")
+	}
+}
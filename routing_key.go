@@ -0,0 +1,93 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxRoutingKeyLength is the longest routing key, see writeShortstr.
+const maxRoutingKeyLength = 0xFF
+
+// routingKeyEscaper escapes the characters with a meaning in topic routing,
+// and the escape character itself so the escaping can be reversed.
+var routingKeyEscaper = strings.NewReplacer(
+	"%", "%25",
+	".", "%2E",
+	"*", "%2A",
+	"#", "%23",
+)
+
+var routingKeyUnescaper = strings.NewReplacer(
+	"%25", "%",
+	"%2E", ".",
+	"%2A", "*",
+	"%23", "#",
+)
+
+// EscapeRoutingKeySegment escapes the dots and wildcards of s, so that it can
+// be used as a single word of a routing key, for example a user supplied name.
+// The characters are percent encoded, and UnescapeRoutingKeySegment reverses
+// the escaping.
+func EscapeRoutingKeySegment(s string) string {
+	return routingKeyEscaper.Replace(s)
+}
+
+// UnescapeRoutingKeySegment reverses EscapeRoutingKeySegment.
+func UnescapeRoutingKeySegment(s string) string {
+	return routingKeyUnescaper.Replace(s)
+}
+
+/*
+BuildRoutingKey joins segments into a dot-separated routing key, escaping each
+segment with EscapeRoutingKeySegment so that it stays a single word.  An error
+is returned when a segment is empty or when the key is longer than the server
+accepts.
+
+	key, err := amqp.BuildRoutingKey("orders", region, "created")
+*/
+func BuildRoutingKey(segments ...string) (string, error) {
+	escaped := make([]string, len(segments))
+	for i, segment := range segments {
+		if segment == "" {
+			return "", fmt.Errorf("routing key segment %d is empty", i)
+		}
+		escaped[i] = EscapeRoutingKeySegment(segment)
+	}
+
+	key := strings.Join(escaped, ".")
+	if len(key) > maxRoutingKeyLength {
+		return "", fmt.Errorf("routing key of %d bytes exceeds the maximum of %d", len(key), maxRoutingKeyLength)
+	}
+
+	return key, nil
+}
+
+/*
+ValidateRoutingKey returns an error when key is not a well formed routing key
+to publish with: longer than the server accepts, with an empty word, or
+containing the "*" or "#" wildcards.  Wildcards only have a meaning in binding
+patterns; in a published routing key they are literal characters, so such a
+message is only routed by bindings that happen to contain the same literal
+word.  The empty routing key is valid.
+*/
+func ValidateRoutingKey(key string) error {
+	if len(key) > maxRoutingKeyLength {
+		return fmt.Errorf("routing key of %d bytes exceeds the maximum of %d", len(key), maxRoutingKeyLength)
+	}
+
+	var errs []error
+	for i, word := range splitTopic(key) {
+		if word == "" {
+			errs = append(errs, fmt.Errorf("routing key %q: word %d is empty", key, i))
+		} else if strings.ContainsAny(word, "*#") {
+			errs = append(errs, fmt.Errorf("routing key %q: word %q contains a wildcard", key, word))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildRoutingKey(t *testing.T) {
+	key, err := BuildRoutingKey("orders", "eu.west", "50%*", "#1")
+	if err != nil {
+		t.Fatalf("could not build routing key: %v", err)
+	}
+
+	if expected := "orders.eu%2Ewest.50%25%2A.%231"; key != expected {
+		t.Fatalf("expected %q, got %q", expected, key)
+	}
+	if err := ValidateRoutingKey(key); err != nil {
+		t.Fatalf("expected a valid routing key, got %v", err)
+	}
+
+	words := strings.Split(key, ".")
+	if len(words) != 4 || UnescapeRoutingKeySegment(words[1]) != "eu.west" || UnescapeRoutingKeySegment(words[2]) != "50%*" {
+		t.Fatalf("expected escaped segments to be reversible, got %q", words)
+	}
+	if !TopicMatches("orders.*.#", key) {
+		t.Fatalf("expected escaped segments to stay single words")
+	}
+
+	if _, err := BuildRoutingKey("orders", ""); err == nil {
+		t.Fatalf("expected an error for an empty segment")
+	}
+	if _, err := BuildRoutingKey(strings.Repeat("a", 200), strings.Repeat("b", 60)); err == nil {
+		t.Fatalf("expected an error for a routing key too long")
+	}
+}
+
+func TestValidateRoutingKey(t *testing.T) {
+	for _, key := range []string{"", "a", "orders.eu.created"} {
+		if err := ValidateRoutingKey(key); err != nil {
+			t.Errorf("expected %q to be valid, got %v", key, err)
+		}
+	}
+
+	for _, key := range []string{"a..b", ".a", "a.", "orders.*", "orders.#", "a#b", strings.Repeat("a", 256)} {
+		if err := ValidateRoutingKey(key); err == nil {
+			t.Errorf("expected %q to be invalid", key)
+		}
+	}
+}
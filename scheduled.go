@@ -0,0 +1,178 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// delayedExchangePrefix names the x-delayed-message exchanges declared in
+	// front of target exchanges.
+	delayedExchangePrefix = "amqp.delayed."
+
+	// delayQueuePrefix names the queues holding publishings until their TTL
+	// expires and they are dead-lettered to their target.
+	delayQueuePrefix = "amqp.delay."
+
+	// delayQueueGrace is how long a delay queue outlives its last publishing.
+	delayQueueGrace = time.Minute
+)
+
+// PublishAt publishes msg to the exchange with the routing key at time t, see
+// PublishAfter.
+func (p *Publisher) PublishAt(ctx context.Context, t time.Time, exchange, key string, msg Publishing) error {
+	return p.PublishAfter(ctx, time.Until(t), exchange, key, msg)
+}
+
+/*
+PublishAfter publishes msg so that it is routed by the exchange with the routing
+key once delay has elapsed.  It returns once the server has confirmed that it
+holds the publishing, as Publish.  A delay lower than a millisecond publishes
+right away.
+
+When PublisherOptions.DelayedMessagePlugin is set, the publishing goes through
+an x-delayed-message exchange named "amqp.delayed.<exchange>", declared and
+bound to the target exchange on first use.  The default exchange cannot be the
+destination of a binding, so it always uses the fallback below.
+
+Otherwise the publishing waits in a queue named after the delay and the
+target, with a message TTL of delay and the target as dead letter exchange and
+routing key.  The queue is declared on every call, which keeps it from expiring
+while it holds publishings, and is deleted by the server one minute after the
+last publishing expired.  Each distinct delay uses its own queue, since
+publishings expire in order in a queue.  Publishings that are dead-lettered
+carry an x-death header, and the mandatory flag applies to the delay queue,
+not to the target.
+*/
+func (p *Publisher) PublishAfter(ctx context.Context, delay time.Duration, exchange, key string, msg Publishing) error {
+	delay = delay.Round(time.Millisecond)
+	if delay <= 0 {
+		return p.Publish(ctx, exchange, key, msg)
+	}
+
+	var err error
+	if p.opts.DelayedMessagePlugin && exchange != "" {
+		exchange, err = p.declareDelayedExchange(exchange, key)
+		if err != nil {
+			return err
+		}
+
+		headers := make(Table, len(msg.Headers)+1)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers["x-delay"] = delay.Milliseconds()
+		msg.Headers = headers
+	} else {
+		queue, err := p.declareDelayQueue(delay, exchange, key)
+		if err != nil {
+			return err
+		}
+		exchange, key = "", queue
+	}
+
+	return p.Publish(ctx, exchange, key, msg)
+}
+
+// declareDelayedExchange declares the x-delayed-message exchange in front of
+// exchange and binds it with key, once per exchange and key.
+func (p *Publisher) declareDelayedExchange(exchange, key string) (string, error) {
+	delayed := delayedExchangePrefix + exchange
+
+	p.scheduleM.Lock()
+	defer p.scheduleM.Unlock()
+
+	binding := exchange + "\x00" + key
+	if p.delayedBindings[binding] {
+		return delayed, nil
+	}
+
+	err := p.declare(func(ch *Channel) error {
+		args := Table{"x-delayed-type": string(Direct)}
+		if err := ch.ExchangeDeclare(delayed, "x-delayed-message", true, false, false, false, args); err != nil {
+			return err
+		}
+		return ch.ExchangeBind(exchange, key, delayed, false, nil)
+	})
+	if err != nil {
+		return "", fmt.Errorf("declare delayed exchange for %q: %w", exchange, err)
+	}
+
+	if p.delayedBindings == nil {
+		p.delayedBindings = make(map[string]bool)
+	}
+	p.delayedBindings[binding] = true
+
+	return delayed, nil
+}
+
+// declareDelayQueue declares the queue holding publishings for delay before
+// dead-lettering them to exchange with key.
+func (p *Publisher) declareDelayQueue(delay time.Duration, exchange, key string) (string, error) {
+	queue := delayQueueName(delay, exchange, key)
+	ttl := delay.Milliseconds()
+
+	p.scheduleM.Lock()
+	defer p.scheduleM.Unlock()
+
+	err := p.declare(func(ch *Channel) error {
+		_, err := ch.QueueDeclare(queue, true, false, false, false, Table{
+			"x-message-ttl":             ttl,
+			"x-dead-letter-exchange":    exchange,
+			"x-dead-letter-routing-key": key,
+			"x-expires":                 ttl + delayQueueGrace.Milliseconds(),
+		})
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("declare delay queue %q: %w", queue, err)
+	}
+
+	return queue, nil
+}
+
+// delayQueueName names a delay queue after the delay and target, hashing the
+// target when the name would exceed the limit of 255 bytes.
+func delayQueueName(delay time.Duration, exchange, key string) string {
+	name := fmt.Sprintf("%s%dms.%s.%s", delayQueuePrefix, delay.Milliseconds(), exchange, key)
+	if len(name) <= 255 {
+		return name
+	}
+
+	sum := sha1.Sum([]byte(exchange + "\x00" + key))
+	return fmt.Sprintf("%s%dms.%s", delayQueuePrefix, delay.Milliseconds(), hex.EncodeToString(sum[:]))
+}
+
+// declare runs f on the channel used for declarations, opening it when
+// needed.  scheduleM must be held.
+func (p *Publisher) declare(f func(ch *Channel) error) error {
+	if p.isClosed() {
+		return ErrClosed
+	}
+
+	if p.declareCh == nil || p.declareCh.IsClosed() {
+		ch, err := p.source.Channel()
+		if err != nil {
+			return err
+		}
+		p.declareCh = ch
+	}
+
+	err := f(p.declareCh)
+
+	var amqpErr *Error
+	if errors.As(err, &amqpErr) {
+		// the server closed the channel, do not keep it around
+		p.declareCh = nil
+	}
+
+	return err
+}
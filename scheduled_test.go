@@ -0,0 +1,119 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPublishAfterWithDelayQueue(t *testing.T) {
+	declared := make(chan *queueDeclare, 1)
+	published := make(chan *basicPublish, 1)
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.channelOpen(1)
+		declare := &queueDeclare{}
+		srv.recv(1, declare)
+		srv.send(1, &queueDeclareOk{Queue: declare.Queue})
+		declared <- declare
+
+		srv.confirmedChannelOpen(2)
+		published <- srv.recv(2, &basicPublish{}).(*basicPublish)
+		srv.send(2, &basicAck{DeliveryTag: 1})
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := p.PublishAfter(ctx, 1500*time.Millisecond, "events", "user.created", Publishing{Body: []byte("hi")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	declare := <-declared
+	if declare.Queue != "amqp.delay.1500ms.events.user.created" || !declare.Durable {
+		t.Fatalf("unexpected delay queue: %+v", declare)
+	}
+	want := Table{
+		"x-message-ttl":             int64(1500),
+		"x-dead-letter-exchange":    "events",
+		"x-dead-letter-routing-key": "user.created",
+		"x-expires":                 int64(61500),
+	}
+	for k, v := range want {
+		if declare.Arguments[k] != v {
+			t.Fatalf("expected argument %s to be %v, got %v", k, v, declare.Arguments[k])
+		}
+	}
+
+	publish := <-published
+	if publish.Exchange != "" || publish.RoutingKey != declare.Queue {
+		t.Fatalf("expected the publishing to go to the delay queue, got %q %q", publish.Exchange, publish.RoutingKey)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestPublishAfterWithDelayedMessagePlugin(t *testing.T) {
+	declared := make(chan *exchangeDeclare, 1)
+	published := make(chan *basicPublish, 1)
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.channelOpen(1)
+		declare := &exchangeDeclare{}
+		srv.recv(1, declare)
+		srv.send(1, &exchangeDeclareOk{})
+		declared <- declare
+		srv.recv(1, &exchangeBind{})
+		srv.send(1, &exchangeBindOk{})
+
+		srv.confirmedChannelOpen(2)
+		for tag := uint64(1); tag <= 2; tag++ {
+			published <- srv.recv(2, &basicPublish{}).(*basicPublish)
+			srv.send(2, &basicAck{DeliveryTag: tag})
+		}
+		srv.connectionClose()
+	})
+
+	p := NewPublisher(c, PublisherOptions{DelayedMessagePlugin: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	headers := Table{"trace": "abc"}
+	for i := 0; i < 2; i++ {
+		if err := p.PublishAfter(ctx, time.Second, "events", "user.created", Publishing{Headers: headers}); err != nil {
+			t.Fatalf("could not publish: %v", err)
+		}
+
+		publish := <-published
+		if publish.Exchange != "amqp.delayed.events" || publish.Properties.Headers["x-delay"] != int64(1000) {
+			t.Fatalf("expected a delayed publishing, got %q with headers %v", publish.Exchange, publish.Properties.Headers)
+		}
+	}
+
+	if declare := <-declared; declare.Type != "x-delayed-message" || declare.Arguments["x-delayed-type"] != "direct" {
+		t.Fatalf("unexpected delayed exchange: %+v", declare)
+	}
+	if _, found := headers["x-delay"]; found {
+		t.Fatalf("expected the headers of the caller to be left alone")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestDelayQueueNameIsBounded(t *testing.T) {
+	name := delayQueueName(time.Second, strings.Repeat("e", 200), strings.Repeat("k", 200))
+	if len(name) > 255 || !strings.HasPrefix(name, "amqp.delay.1000ms.") {
+		t.Fatalf("unexpected delay queue name %q", name)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "sync/atomic"
+
+// ChannelSelector chooses one channel among several open channels, for
+// example the idle channels of a ChannelPool.  Select is only called with at
+// least one channel and returns the index of the chosen one.
+type ChannelSelector interface {
+	Select(channels []*Channel) int
+}
+
+// ChannelSelectorFunc adapts a function to a ChannelSelector.
+type ChannelSelectorFunc func(channels []*Channel) int
+
+// Select implements ChannelSelector.
+func (f ChannelSelectorFunc) Select(channels []*Channel) int {
+	return f(channels)
+}
+
+type roundRobinSelector struct {
+	next uint64
+}
+
+func (s *roundRobinSelector) Select(channels []*Channel) int {
+	return int((atomic.AddUint64(&s.next, 1) - 1) % uint64(len(channels)))
+}
+
+// RoundRobinSelector returns a selector rotating over the channels.
+func RoundRobinSelector() ChannelSelector {
+	return &roundRobinSelector{}
+}
+
+// LeastOutstandingConfirmsSelector returns a selector choosing the channel
+// with the fewest publishings awaiting confirmation, see
+// Channel.OutstandingConfirms.  This spreads publishings away from channels
+// slowed down by a busy queue.  Ties are broken in favour of the last channel.
+func LeastOutstandingConfirmsSelector() ChannelSelector {
+	return ChannelSelectorFunc(func(channels []*Channel) int {
+		best, fewest := 0, -1
+		for i, ch := range channels {
+			if n := ch.OutstandingConfirms(); fewest < 0 || n <= fewest {
+				best, fewest = i, n
+			}
+		}
+		return best
+	})
+}
+
+// LeastRecentErrorSelector returns a selector choosing the channel whose
+// publishings failed the longest time ago, see Channel.LastPublishError, so
+// that channels publishing to a failing destination are avoided.  Ties are
+// broken in favour of the last channel.
+func LeastRecentErrorSelector() ChannelSelector {
+	return ChannelSelectorFunc(func(channels []*Channel) int {
+		best := 0
+		oldest := channels[0].LastPublishError()
+		for i, ch := range channels {
+			if at := ch.LastPublishError(); !at.After(oldest) {
+				best, oldest = i, at
+			}
+		}
+		return best
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestChannelSelectors(t *testing.T) {
+	channels := []*Channel{newChannel(nil, 1), newChannel(nil, 2), newChannel(nil, 3)}
+
+	rr := RoundRobinSelector()
+	for _, expected := range []int{0, 1, 2, 0} {
+		if i := rr.Select(channels); i != expected {
+			t.Fatalf("expected round robin to select %d, got %d", expected, i)
+		}
+	}
+
+	channels[0].confirms.publish()
+	channels[2].confirms.publish()
+	channels[2].confirms.publish()
+	if i := LeastOutstandingConfirmsSelector().Select(channels); i != 1 {
+		t.Fatalf("expected the channel without outstanding confirms, got %d", i)
+	}
+
+	if i := LeastRecentErrorSelector().Select(channels); i != 2 {
+		t.Fatalf("expected the last channel when none failed, got %d", i)
+	}
+	channels[2].publishFailed()
+	time.Sleep(time.Millisecond)
+	channels[0].publishFailed()
+	if i := LeastRecentErrorSelector().Select(channels); i != 1 {
+		t.Fatalf("expected the channel that never failed, got %d", i)
+	}
+	channels[1].publishFailed()
+	if i := LeastRecentErrorSelector().Select(channels); i != 2 {
+		t.Fatalf("expected the channel that failed the longest time ago, got %d", i)
+	}
+}
+
+func TestChannelPoolSelector(t *testing.T) {
+	p := newIdlePool(PoolOptions{MaxIdle: 3})
+	defer p.shutdown()
+
+	p.choose = func(idle []pooled) int { return 0 }
+
+	first, second := &fakeResource{}, &fakeResource{}
+	p.put(first)
+	p.put(second)
+
+	if res, _ := p.get(); res != first {
+		t.Fatalf("expected the chosen resource, got %v", res)
+	}
+	if res, _ := p.get(); res != second {
+		t.Fatalf("expected the remaining resource, got %v", res)
+	}
+}
+
+func TestChannelTracksPublishFailures(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, &basicPublish{})
+		srv.send(1, &basicNack{DeliveryTag: 1})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("could not enter confirm mode: %v", err)
+	}
+
+	dc, err := ch.PublishWithDeferredConfirmWithContext(context.Background(), "", "q", false, false, Publishing{})
+	if err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if n := ch.OutstandingConfirms(); n > 1 {
+		t.Fatalf("expected at most one outstanding confirm, got %d", n)
+	}
+
+	if dc.Wait() {
+		t.Fatalf("expected a nack")
+	}
+	if ch.OutstandingConfirms() != 0 || ch.LastPublishError().IsZero() {
+		t.Fatalf("expected the nack to be tracked, got %d outstanding and last error at %v", ch.OutstandingConfirms(), ch.LastPublishError())
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SessionOptions configures the channels of a Session.
+type SessionOptions struct {
+	// Name identifies the session, like the subsystem using it, in log
+	// messages.
+	Name string
+
+	// Labels are attached to the log messages of the session, and can be
+	// used by the application to label metrics.
+	Labels map[string]string
+
+	// Logger receives the log messages of the session.  When nil, the
+	// package Logger is used.
+	Logger Logging
+
+	// Confirm puts every channel of the session in confirm mode.
+	Confirm bool
+
+	// OnConfirm is called with the publisher confirmations of every channel
+	// of the session.  Setting it implies Confirm.  It runs on a goroutine
+	// per channel and must not block for long, since confirmations are
+	// delivered in order.
+	OnConfirm func(ch *Channel, confirm Confirmation)
+
+	// OnReturn is called with the publishings returned on every channel of
+	// the session.  It runs on a goroutine per channel.
+	OnReturn func(ch *Channel, ret Return)
+
+	// Setup is called with every channel opened by the session, including
+	// the ones reopened by Recover, after confirm mode and handlers are set
+	// up.  Use it to set the QoS or declare the topology the session depends
+	// on.  When it fails, the channel is closed and the error returned.
+	Setup func(ch *Channel) error
+}
+
+/*
+Session groups the channels used by one part of an application, such as a
+subsystem or a worker pool.  The channels of a session share their setup,
+confirm and return handling, and their lifecycle: they are closed together by
+Close and reopened together by Recover.
+
+A Session is safe for concurrent use.
+*/
+type Session struct {
+	opts SessionOptions
+
+	m        sync.Mutex
+	conn     *Connection
+	channels []*Channel
+	closed   bool
+}
+
+// NewSession returns an empty session opening its channels on conn.
+func NewSession(conn *Connection, opts SessionOptions) *Session {
+	labels := make(map[string]string, len(opts.Labels))
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+	opts.Labels = labels
+
+	return &Session{opts: opts, conn: conn}
+}
+
+// Name returns the name of the session.
+func (s *Session) Name() string {
+	return s.opts.Name
+}
+
+// Labels returns a copy of the labels of the session.
+func (s *Session) Labels() map[string]string {
+	labels := make(map[string]string, len(s.opts.Labels))
+	for k, v := range s.opts.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// Printf logs a message prefixed with the name and labels of the session.
+func (s *Session) Printf(format string, v ...interface{}) {
+	logger := s.opts.Logger
+	if logger == nil {
+		logger = Logger
+	}
+
+	keys := make([]string, 0, len(s.opts.Labels))
+	for k := range s.opts.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var prefix strings.Builder
+	prefix.WriteString("[session ")
+	prefix.WriteString(s.opts.Name)
+	for _, k := range keys {
+		fmt.Fprintf(&prefix, " %s=%s", k, s.opts.Labels[k])
+	}
+	prefix.WriteString("] ")
+
+	logger.Printf(prefix.String()+format, v...)
+}
+
+// Channel opens a new channel owned by the session.
+func (s *Session) Channel() (*Channel, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	ch, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	s.channels = append(s.channels, ch)
+	return ch, nil
+}
+
+// open opens and sets up a channel, s.m must be held.
+func (s *Session) open() (*Channel, error) {
+	ch, err := s.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if s.opts.Confirm || s.opts.OnConfirm != nil {
+		if err := ch.Confirm(false); err != nil {
+			_ = ch.Close()
+			return nil, err
+		}
+	}
+
+	if s.opts.OnConfirm != nil {
+		confirms := ch.NotifyPublish(make(chan Confirmation, 1))
+		go func() {
+			for c := range confirms {
+				s.opts.OnConfirm(ch, c)
+			}
+		}()
+	}
+
+	if s.opts.OnReturn != nil {
+		returns := ch.NotifyReturn(make(chan Return, 1))
+		go func() {
+			for r := range returns {
+				s.opts.OnReturn(ch, r)
+			}
+		}()
+	}
+
+	if s.opts.Setup != nil {
+		if err := s.opts.Setup(ch); err != nil {
+			_ = ch.Close()
+			return nil, err
+		}
+	}
+
+	return ch, nil
+}
+
+// Channels returns the channels of the session, including the ones closed
+// since the last call to Recover.
+func (s *Session) Channels() []*Channel {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]*Channel(nil), s.channels...)
+}
+
+/*
+Recover reopens the closed channels of the session, keeping their number, and
+runs the session setup on them.  When conn is not nil, the session moves to
+conn first, which is how a session follows a reconnection: every channel is
+then reopened on the new connection.
+
+Channels returned earlier by Channel are not reused, call Channels to get the
+current ones.  Recover stops at the first channel that cannot be reopened.
+*/
+func (s *Session) Recover(conn *Connection) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if conn != nil && conn != s.conn {
+		s.conn = conn
+		for _, ch := range s.channels {
+			_ = ch.Close()
+		}
+	}
+
+	for i, ch := range s.channels {
+		if !ch.IsClosed() {
+			continue
+		}
+
+		reopened, err := s.open()
+		if err != nil {
+			return fmt.Errorf("recover channel %d of session %q: %w", i, s.opts.Name, err)
+		}
+		s.channels[i] = reopened
+	}
+
+	return nil
+}
+
+// Close closes every channel of the session.  The session cannot be used
+// afterwards.  The errors of the channels that failed to close are joined.
+func (s *Session) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	var errs []error
+	for _, ch := range s.channels {
+		if err := ch.Close(); err != nil && !errors.Is(err, ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	s.channels = nil
+
+	return errors.Join(errs...)
+}
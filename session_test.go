@@ -0,0 +1,118 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func TestSessionRecoversClosedChannels(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	setup := func(id int) {
+		srv.channelOpen(id)
+		srv.recv(id, &confirmSelect{})
+		srv.send(id, &confirmSelectOk{})
+		srv.recv(id, &basicQos{})
+		srv.send(id, &basicQosOk{})
+	}
+
+	go func() {
+		srv.connectionOpen()
+		setup(1)
+		setup(2)
+
+		srv.send(1, &channelClose{ReplyCode: PreconditionFailed, ReplyText: "precondition"})
+		srv.recv(1, &channelCloseOk{})
+
+		setup(3)
+
+		srv.recv(3, &channelClose{})
+		srv.send(3, &channelCloseOk{})
+		srv.recv(2, &channelClose{})
+		srv.send(2, &channelCloseOk{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	setups := 0
+	s := NewSession(c, SessionOptions{
+		Name:    "billing",
+		Confirm: true,
+		Setup: func(ch *Channel) error {
+			setups++
+			return ch.Qos(10, 0, false)
+		},
+	})
+
+	first, err := s.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if _, err := s.Channel(); err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	closed := make(chan struct{})
+	first.OnClose(func(*Error) { close(closed) })
+	<-closed
+
+	if err := s.Recover(nil); err != nil {
+		t.Fatalf("could not recover session: %v", err)
+	}
+
+	var ids []uint16
+	for _, ch := range s.Channels() {
+		ids = append(ids, ch.id)
+	}
+	if !reflect.DeepEqual(ids, []uint16{3, 2}) {
+		t.Fatalf("expected the closed channel to be replaced, got ids: %v", ids)
+	}
+	if setups != 3 {
+		t.Fatalf("expected setup to run for every opened channel, ran %d times", setups)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("could not close session: %v", err)
+	}
+	if _, err := s.Channel(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed from a closed session, got: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
+
+func TestSessionPrintf(t *testing.T) {
+	logger := &recordingLogger{}
+	s := NewSession(nil, SessionOptions{
+		Name:   "billing",
+		Labels: map[string]string{"team": "payments", "env": "prod"},
+		Logger: logger,
+	})
+
+	s.Printf("published %d messages", 3)
+
+	want := []string{"[session billing env=prod team=payments] published 3 messages"}
+	if !reflect.DeepEqual(logger.lines, want) {
+		t.Fatalf("expected %q, got %q", want, logger.lines)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// ModulusHash is the exchange type provided by the rabbitmq_sharding plugin.
+// An exchange of this type routes each message to exactly one of its bound
+// queues, picked by hashing the routing key modulo the number of bindings.
+const ModulusHash ExchangeType = "x-modulus-hash"
+
+var errShardCount = errors.New("the number of shards must be greater than zero")
+
+// ShardQueueName returns the name of the shard-th queue of the sharded queue
+// queue, that is "queue.0", "queue.1" and so on.
+func ShardQueueName(queue string, shard int) string {
+	return queue + "." + strconv.Itoa(shard)
+}
+
+/*
+ExchangeDeclareModulusHash declares an exchange of the ModulusHash type. It is
+equivalent to calling Channel.ExchangeDeclare with ModulusHash as the kind.
+
+The x-modulus-hash exchange type is only available when the rabbitmq_sharding
+plugin is enabled on the server.  Declaring an exchange of an unknown type is a
+connection error, so make sure the plugin is enabled before using this method.
+*/
+func (ch *Channel) ExchangeDeclareModulusHash(name string, durable, autoDelete, internal, noWait bool, args Table) error {
+	return ch.ExchangeDeclare(name, ModulusHash, durable, autoDelete, internal, noWait, args)
+}
+
+/*
+QueueDeclareShards declares shards queues named after ShardQueueName and binds
+each of them to exchange, which is expected to be of the ModulusHash type.
+The routing key of the binding is not used by the x-modulus-hash exchange.
+
+All shards are declared with the same durable, autoDelete and args parameters.
+The first error stops the declaration and is returned, in which case the channel
+will be closed.
+
+	ch.ExchangeDeclareModulusHash("events", true, false, false, false, nil)
+	ch.QueueDeclareShards("events", 3, "events", true, false, nil)
+
+	Delivery       Exchange   Queue
+	-----------------------------------------------
+	key: a ------> events --> events.0
+	key: b ------> events --> events.2
+	key: c ------> events --> events.1
+*/
+func (ch *Channel) QueueDeclareShards(queue string, shards int, exchange string, durable, autoDelete bool, args Table) error {
+	if shards <= 0 {
+		return errShardCount
+	}
+
+	for i := 0; i < shards; i++ {
+		name := ShardQueueName(queue, i)
+		if _, err := ch.QueueDeclare(name, durable, autoDelete, false, false, args); err != nil {
+			return err
+		}
+		if err := ch.QueueBind(name, "", exchange, false, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+/*
+ConsumeShards starts one consumer per shard of a sharded queue and merges the
+deliveries of all shards into a single chan Delivery, so the shards can be
+processed as one logical stream.
+
+Every shard is consumed on this channel, which means that all deliveries share
+the same delivery tag sequence.  Acknowledging a delivery with multiple set to
+true acknowledges all prior deliveries of every shard.
+
+The consumer tag of each shard is derived from consumer with ShardQueueName.
+An empty consumer will cause the library to generate a unique identity.  The
+consumer is returned along with the deliveries, pass it to Channel.CancelShards
+with the same shard count to stop all shard consumers at once.
+
+The returned chan is closed when the consumers of all shards have been
+cancelled, or when the channel or connection is closed.  If one of the shards
+cannot be consumed, the consumers started so far are cancelled and the error is
+returned.
+
+All other parameters have the same semantics as in Channel.Consume.
+*/
+func (ch *Channel) ConsumeShards(queue string, shards int, consumer string, autoAck, exclusive, noLocal, noWait bool, args Table) (<-chan Delivery, string, error) {
+	if shards <= 0 {
+		return nil, "", errShardCount
+	}
+
+	if consumer == "" {
+		consumer = uniqueConsumerTag()
+	}
+
+	sources := make([]<-chan Delivery, 0, shards)
+	for i := 0; i < shards; i++ {
+		deliveries, err := ch.Consume(ShardQueueName(queue, i), ShardQueueName(consumer, i), autoAck, exclusive, noLocal, noWait, args)
+		if err != nil {
+			for j := 0; j < i; j++ {
+				_ = ch.Cancel(ShardQueueName(consumer, j), false)
+			}
+			return nil, "", err
+		}
+		sources = append(sources, deliveries)
+	}
+
+	out := make(chan Delivery)
+
+	var wg sync.WaitGroup
+	wg.Add(len(sources))
+	for _, deliveries := range sources {
+		go func(deliveries <-chan Delivery) {
+			defer wg.Done()
+			for d := range deliveries {
+				out <- d
+			}
+		}(deliveries)
+	}
+
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, consumer, nil
+}
+
+/*
+CancelShards stops the consumers started by Channel.ConsumeShards for the given
+consumer tag and shard count.  It attempts to cancel every shard and returns the
+first error encountered.
+
+See Channel.Cancel for the semantics of noWait.
+*/
+func (ch *Channel) CancelShards(consumer string, shards int, noWait bool) error {
+	var first error
+	for i := 0; i < shards; i++ {
+		if err := ch.Cancel(ShardQueueName(consumer, i), noWait); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
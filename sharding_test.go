@@ -0,0 +1,144 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+)
+
+func TestShardQueueName(t *testing.T) {
+	if want, got := "events.2", ShardQueueName("events", 2); want != got {
+		t.Errorf("expected shard queue name %q, got %q", want, got)
+	}
+}
+
+func TestConsumeShardsMergesDeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < 2; i++ {
+			consume := &basicConsume{}
+			srv.recv(1, consume)
+			if want, got := ShardQueueName("events", i), consume.Queue; want != got {
+				t.Errorf("expected consume on queue %q, got %q", want, got)
+			}
+			srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		}
+
+		srv.send(1, &basicDeliver{ConsumerTag: "worker.0", DeliveryTag: 1})
+		srv.send(1, &basicDeliver{ConsumerTag: "worker.1", DeliveryTag: 2})
+
+		for i := 0; i < 2; i++ {
+			cancel := &basicCancel{}
+			srv.recv(1, cancel)
+			srv.send(1, &basicCancelOk{ConsumerTag: cancel.ConsumerTag})
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, consumer, err := ch.ConsumeShards("events", 2, "worker", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume shards: %v", err)
+	}
+	if consumer != "worker" {
+		t.Fatalf("expected the consumer tag worker, got %q", consumer)
+	}
+
+	seen := map[string]uint64{}
+	for i := 0; i < 2; i++ {
+		d := <-deliveries
+		seen[d.ConsumerTag] = d.DeliveryTag
+	}
+
+	if want, got := uint64(1), seen["worker.0"]; want != got {
+		t.Errorf("expected delivery tag %d from shard 0, got %d", want, got)
+	}
+	if want, got := uint64(2), seen["worker.1"]; want != got {
+		t.Errorf("expected delivery tag %d from shard 1, got %d", want, got)
+	}
+
+	if err := ch.CancelShards("worker", 2, false); err != nil {
+		t.Fatalf("could not cancel shards: %v", err)
+	}
+
+	if _, open := <-deliveries; open {
+		t.Fatalf("expected merged deliveries chan to be closed after all shards are cancelled")
+	}
+}
+
+func TestConsumeShardsRejectsZeroShards(t *testing.T) {
+	ch := &Channel{}
+	if _, _, err := ch.ConsumeShards("events", 0, "", false, false, false, false, nil); err != errShardCount {
+		t.Fatalf("expected errShardCount, got %v", err)
+	}
+}
+
+func TestCancelShardsWithGeneratedConsumer(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	tags := make(chan string, 2)
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		for i := 0; i < 2; i++ {
+			consume := &basicConsume{}
+			srv.recv(1, consume)
+			tags <- consume.ConsumerTag
+			srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		}
+
+		for i := 0; i < 2; i++ {
+			cancel := &basicCancel{}
+			srv.recv(1, cancel)
+			srv.send(1, &basicCancelOk{ConsumerTag: cancel.ConsumerTag})
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	deliveries, consumer, err := ch.ConsumeShards("events", 2, "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume shards: %v", err)
+	}
+	if consumer == "" {
+		t.Fatal("expected a generated consumer tag")
+	}
+	for i := 0; i < 2; i++ {
+		if want, got := ShardQueueName(consumer, i), <-tags; want != got {
+			t.Errorf("expected consumer tag %q, got %q", want, got)
+		}
+	}
+
+	if err := ch.CancelShards(consumer, 2, false); err != nil {
+		t.Fatalf("could not cancel shards: %v", err)
+	}
+
+	if _, open := <-deliveries; open {
+		t.Fatalf("expected merged deliveries chan to be closed after all shards are cancelled")
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShovelOptions configures a Shovel.
+type ShovelOptions struct {
+	// Queue to consume from on the source channel.
+	Queue string
+
+	// Exchange to publish to on the destination channel.
+	Exchange string
+
+	// RoutingKey of the publishings, the routing key of each delivery when
+	// empty.
+	RoutingKey string
+
+	// BatchSize is the maximum number of messages published before waiting
+	// for their confirmations, 100 when zero.
+	BatchSize int
+
+	// BatchTimeout is how long to wait for a batch to fill up before
+	// publishing it anyway, 50ms when zero.
+	BatchTimeout time.Duration
+
+	// MaxInFlight is the maximum number of messages consumed from the source
+	// and not yet confirmed by the destination, set as the prefetch count of
+	// the source channel.  It defaults to 10 batches and is never less than
+	// one batch.
+	MaxInFlight int
+
+	// OnBatch, when set, is called once every batch is settled on the
+	// source, for example to emit metrics.
+	OnBatch func(ShovelBatch)
+}
+
+// ShovelBatch describes a batch of messages moved by a Shovel.
+type ShovelBatch struct {
+	Messages int           // messages in the batch
+	Bytes    int           // sum of the body sizes
+	Requeued int           // messages not confirmed by the destination, requeued on the source
+	Latency  time.Duration // from the first publishing to the settlement on the source
+	Err      error         // set when the batch could not be settled on the source
+}
+
+// ShovelStats are the counters of a Shovel since it was started.
+type ShovelStats struct {
+	Batches   uint64 // batches settled on the source
+	Forwarded uint64 // messages confirmed by the destination and acknowledged on the source
+	Requeued  uint64 // messages requeued on the source
+	InFlight  int64  // messages published and not yet settled on the source
+}
+
+/*
+Shovel moves the messages of a queue to an exchange, usually on another
+broker, without losing any of them: a message is only acknowledged on the
+source once the destination confirmed it.
+
+Messages are published in batches, and every batch is acknowledged on the
+source with a single multiple acknowledgement once all its confirmations
+arrived.  The messages of a batch the destination did not confirm are requeued
+on the source one by one.  Several batches are in flight at once, bounded by
+MaxInFlight through the prefetch count of the source, so that the throughput
+is not limited by the round trip to the destination.
+
+	shovel := amqp.NewShovel(source, destination, amqp.ShovelOptions{
+		Queue:    "remote-tee",
+		Exchange: "logs",
+	})
+	err := shovel.Run(ctx)
+*/
+type Shovel struct {
+	source      *Channel
+	destination *Channel
+	opts        ShovelOptions
+
+	batches   uint64
+	forwarded uint64
+	requeued  uint64
+	inflight  int64
+}
+
+// shovelBatch is a batch published on the destination and waiting for its
+// confirmations.
+type shovelBatch struct {
+	deliveries []Delivery
+	confirms   []*DeferredConfirmation
+	bytes      int
+	started    time.Time
+}
+
+// NewShovel returns a Shovel moving messages from the source channel to the
+// destination channel.  Call Run to start it.
+func NewShovel(source, destination *Channel, opts ShovelOptions) *Shovel {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.BatchTimeout <= 0 {
+		opts.BatchTimeout = 50 * time.Millisecond
+	}
+	if opts.MaxInFlight <= 0 {
+		opts.MaxInFlight = 10 * opts.BatchSize
+	}
+	if opts.MaxInFlight < opts.BatchSize {
+		opts.MaxInFlight = opts.BatchSize
+	}
+
+	return &Shovel{
+		source:      source,
+		destination: destination,
+		opts:        opts,
+	}
+}
+
+/*
+Run puts the destination channel in confirm mode, consumes the queue on the
+source channel and moves messages until ctx is done or one of the channels is
+closed.  It waits for the batches in flight to be settled before returning.
+
+It returns ctx.Err() when ctx is done, the publishing error when a message
+could not be published, and ErrClosed when a channel was closed.  Messages
+consumed and not yet settled are requeued by the server when the source
+channel is closed.
+*/
+func (s *Shovel) Run(ctx context.Context) error {
+	if err := s.source.Qos(s.opts.MaxInFlight, 0, false); err != nil {
+		return err
+	}
+
+	if err := s.destination.Confirm(false); err != nil {
+		return err
+	}
+
+	deliveries, err := s.source.ConsumeWithOptions(ctx, s.opts.Queue, "", false)
+	if err != nil {
+		return err
+	}
+
+	pending := make(chan *shovelBatch, s.opts.MaxInFlight/s.opts.BatchSize)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for batch := range pending {
+			s.settle(batch)
+		}
+	}()
+
+	err = s.pump(ctx, deliveries, pending)
+	close(pending)
+	wg.Wait()
+
+	return err
+}
+
+// pump publishes batches of deliveries until the deliveries are closed and
+// hands them to the settling goroutine.
+func (s *Shovel) pump(ctx context.Context, deliveries <-chan Delivery, pending chan<- *shovelBatch) error {
+	for {
+		collected, open := s.collect(deliveries)
+
+		if len(collected) > 0 {
+			batch := &shovelBatch{deliveries: collected, started: time.Now()}
+			atomic.AddInt64(&s.inflight, int64(len(collected)))
+
+			for _, d := range collected {
+				key := s.opts.RoutingKey
+				if key == "" {
+					key = d.RoutingKey
+				}
+
+				confirm, err := s.destination.PublishWithDeferredConfirmWithContext(ctx, s.opts.Exchange, key, false, false, deliveryPublishing(d))
+				if err != nil {
+					// The unpublished deliveries stay unacknowledged and are
+					// requeued when the source channel closes.
+					atomic.AddInt64(&s.inflight, -int64(len(collected)-len(batch.confirms)))
+					batch.deliveries = batch.deliveries[:len(batch.confirms)]
+					if len(batch.confirms) > 0 {
+						pending <- batch
+					}
+					return err
+				}
+
+				batch.confirms = append(batch.confirms, confirm)
+				batch.bytes += len(d.Body)
+			}
+
+			pending <- batch
+		}
+
+		if !open {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return ErrClosed
+		}
+	}
+}
+
+// collect receives the next batch of deliveries, waiting at most the batch
+// timeout after the first one.  It returns false once deliveries are closed.
+func (s *Shovel) collect(deliveries <-chan Delivery) ([]Delivery, bool) {
+	first, ok := <-deliveries
+	if !ok {
+		return nil, false
+	}
+
+	batch := make([]Delivery, 1, s.opts.BatchSize)
+	batch[0] = first
+
+	timeout := time.NewTimer(s.opts.BatchTimeout)
+	defer timeout.Stop()
+
+	for len(batch) < s.opts.BatchSize {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, d)
+		case <-timeout.C:
+			return batch, true
+		}
+	}
+
+	return batch, true
+}
+
+// settle waits for the confirmations of a batch and acknowledges it on the
+// source, with a single multiple acknowledgement when all were positive.
+// Batches are settled in order, so a multiple acknowledgement never covers
+// the deliveries of a later batch.
+func (s *Shovel) settle(batch *shovelBatch) {
+	acked := make([]bool, len(batch.confirms))
+	requeued := 0
+	for i, confirm := range batch.confirms {
+		acked[i] = confirm.Wait()
+		if !acked[i] {
+			requeued++
+		}
+	}
+
+	var err error
+	if requeued == 0 {
+		err = s.source.Ack(batch.deliveries[len(batch.deliveries)-1].DeliveryTag, true)
+	} else {
+		for i, d := range batch.deliveries {
+			var e error
+			if acked[i] {
+				e = s.source.Ack(d.DeliveryTag, false)
+			} else {
+				e = s.source.Nack(d.DeliveryTag, false, true)
+			}
+			if err == nil {
+				err = e
+			}
+		}
+	}
+
+	atomic.AddInt64(&s.inflight, -int64(len(batch.deliveries)))
+	if err == nil {
+		atomic.AddUint64(&s.batches, 1)
+		atomic.AddUint64(&s.forwarded, uint64(len(batch.deliveries)-requeued))
+		atomic.AddUint64(&s.requeued, uint64(requeued))
+	}
+
+	if s.opts.OnBatch != nil {
+		s.opts.OnBatch(ShovelBatch{
+			Messages: len(batch.deliveries),
+			Bytes:    batch.bytes,
+			Requeued: requeued,
+			Latency:  time.Since(batch.started),
+			Err:      err,
+		})
+	}
+}
+
+// Stats returns the counters of the shovel.
+func (s *Shovel) Stats() ShovelStats {
+	return ShovelStats{
+		Batches:   atomic.LoadUint64(&s.batches),
+		Forwarded: atomic.LoadUint64(&s.forwarded),
+		Requeued:  atomic.LoadUint64(&s.requeued),
+		InFlight:  atomic.LoadInt64(&s.inflight),
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !amqp_lean
+// +build !amqp_lean
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestShovelAcknowledgesConfirmedBatches(t *testing.T) {
+	qos := &basicQos{}
+	var acks []basicAck
+	nack := &basicNack{}
+
+	source := openPublisherConnection(t, func(srv *server) {
+		srv.channelOpen(1)
+		srv.recv(1, qos)
+		srv.send(1, &basicQosOk{})
+
+		consume := srv.recv(1, &basicConsume{}).(*basicConsume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		for tag := uint64(1); tag <= 4; tag++ {
+			srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: tag, RoutingKey: "app.info", Body: []byte("log")})
+		}
+
+		acks = append(acks, *srv.recv(1, &basicAck{}).(*basicAck))
+		acks = append(acks, *srv.recv(1, &basicAck{}).(*basicAck))
+		srv.recv(1, nack)
+
+		srv.recv(1, &basicCancel{})
+		srv.send(1, &basicCancelOk{ConsumerTag: consume.ConsumerTag})
+		srv.connectionClose()
+	})
+
+	var published []*basicPublish
+	destination := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+
+		published = append(published, srv.recv(1, &basicPublish{}).(*basicPublish))
+		published = append(published, srv.recv(1, &basicPublish{}).(*basicPublish))
+		srv.send(1, &basicAck{DeliveryTag: 2, Multiple: true})
+
+		published = append(published, srv.recv(1, &basicPublish{}).(*basicPublish))
+		published = append(published, srv.recv(1, &basicPublish{}).(*basicPublish))
+		srv.send(1, &basicAck{DeliveryTag: 3})
+		srv.send(1, &basicNack{DeliveryTag: 4})
+
+		srv.connectionClose()
+	})
+
+	chs, err := source.Channel()
+	if err != nil {
+		t.Fatalf("could not open source channel: %v", err)
+	}
+	chd, err := destination.Channel()
+	if err != nil {
+		t.Fatalf("could not open destination channel: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var batches []ShovelBatch
+	shovel := NewShovel(chs, chd, ShovelOptions{
+		Queue:     "remote-tee",
+		Exchange:  "logs",
+		BatchSize: 2,
+		OnBatch: func(b ShovelBatch) {
+			batches = append(batches, b)
+			if len(batches) == 2 {
+				cancel()
+			}
+		},
+	})
+
+	if err := shovel.Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the shovel to stop with the context, got %v", err)
+	}
+
+	if err := destination.Close(); err != nil {
+		t.Fatalf("could not close destination: %v", err)
+	}
+	if err := source.Close(); err != nil {
+		t.Fatalf("could not close source: %v", err)
+	}
+
+	if qos.PrefetchCount != 20 {
+		t.Fatalf("expected the in-flight window as prefetch, got %d", qos.PrefetchCount)
+	}
+	if len(published) != 4 || published[0].Exchange != "logs" || published[0].RoutingKey != "app.info" || string(published[0].Body) != "log" {
+		t.Fatalf("unexpected publishings %+v", published)
+	}
+
+	if acks[0].DeliveryTag != 2 || !acks[0].Multiple {
+		t.Fatalf("expected the first batch to be acknowledged at once, got %+v", acks[0])
+	}
+	if acks[1].DeliveryTag != 3 || acks[1].Multiple {
+		t.Fatalf("expected the confirmed message of the second batch to be acknowledged, got %+v", acks[1])
+	}
+	if nack.DeliveryTag != 4 || nack.Multiple || !nack.Requeue {
+		t.Fatalf("expected the unconfirmed message to be requeued, got %+v", nack)
+	}
+
+	if len(batches) != 2 || batches[0].Messages != 2 || batches[0].Bytes != 6 || batches[1].Requeued != 1 || batches[1].Err != nil {
+		t.Fatalf("unexpected batches %+v", batches)
+	}
+	if stats := shovel.Stats(); stats.Batches != 2 || stats.Forwarded != 3 || stats.Requeued != 1 || stats.InFlight != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
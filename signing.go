@@ -0,0 +1,283 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	// SignatureHeader is the header holding the signature of a message.
+	SignatureHeader = "x-signature"
+
+	// SignatureKeyIDHeader is the header holding the id of the key a message
+	// was signed with.
+	SignatureKeyIDHeader = "x-signature-key-id"
+
+	// SignatureAlgorithmHeader is the header holding the algorithm a message
+	// was signed with.
+	SignatureAlgorithmHeader = "x-signature-algorithm"
+
+	// SignatureErrorHeader is set on the deliveries that failed verification
+	// when they are handed to the application with SignatureFlag.
+	SignatureErrorHeader = "x-signature-error"
+)
+
+const (
+	// SignatureHMACSHA256 is the algorithm of the signers returned by
+	// NewHMACSigner.
+	SignatureHMACSHA256 = "HMAC-SHA256"
+
+	// SignatureEd25519 is the algorithm of the signers returned by
+	// NewEd25519Signer.
+	SignatureEd25519 = "Ed25519"
+)
+
+// ErrInvalidSignature is returned when the signature of a delivery is missing
+// or does not match its content.
+var ErrInvalidSignature = errors.New("invalid message signature")
+
+// Signer signs messages.  See NewHMACSigner and NewEd25519Signer.
+type Signer interface {
+	KeyID() string
+	Algorithm() string
+	Sign(data []byte) ([]byte, error)
+}
+
+type hmacSigner struct {
+	id  string
+	key []byte
+}
+
+// NewHMACSigner returns a Signer computing an HMAC-SHA256 with a secret key
+// shared with the consumers.
+func NewHMACSigner(keyID string, key []byte) Signer {
+	return &hmacSigner{id: keyID, key: append([]byte(nil), key...)}
+}
+
+func (s *hmacSigner) KeyID() string     { return s.id }
+func (s *hmacSigner) Algorithm() string { return SignatureHMACSHA256 }
+
+func (s *hmacSigner) Sign(data []byte) ([]byte, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+type ed25519Signer struct {
+	id  string
+	key ed25519.PrivateKey
+}
+
+// NewEd25519Signer returns a Signer with an Ed25519 private key, whose
+// signatures consumers verify with the public key only.
+func NewEd25519Signer(keyID string, key ed25519.PrivateKey) Signer {
+	return &ed25519Signer{id: keyID, key: key}
+}
+
+func (s *ed25519Signer) KeyID() string     { return s.id }
+func (s *ed25519Signer) Algorithm() string { return SignatureEd25519 }
+
+func (s *ed25519Signer) Sign(data []byte) ([]byte, error) {
+	if len(s.key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("signing key %q: invalid Ed25519 private key size %d", s.id, len(s.key))
+	}
+	return ed25519.Sign(s.key, data), nil
+}
+
+// signedContent returns the data covered by the signature of a message: its
+// body and the properties that describe how to interpret it, each prefixed by
+// its length so that no two messages share the same signed data.
+func signedContent(contentType, contentEncoding, messageID, msgType string, body []byte) []byte {
+	var data []byte
+	for _, field := range [][]byte{[]byte(contentType), []byte(contentEncoding), []byte(messageID), []byte(msgType), body} {
+		data = binary.BigEndian.AppendUint32(data, uint32(len(field)))
+		data = append(data, field...)
+	}
+	return data
+}
+
+// Sign signs the body, content type, content encoding, message id and type of
+// msg with signer, and sets the signature headers on a copy of the headers of
+// msg.
+func Sign(signer Signer, msg *Publishing) error {
+	signature, err := signer.Sign(signedContent(msg.ContentType, msg.ContentEncoding, msg.MessageId, msg.Type, msg.Body))
+	if err != nil {
+		return err
+	}
+
+	headers := make(Table, len(msg.Headers)+3)
+	for k, v := range msg.Headers {
+		headers[k] = v
+	}
+	headers[SignatureHeader] = signature
+	headers[SignatureKeyIDHeader] = signer.KeyID()
+	headers[SignatureAlgorithmHeader] = signer.Algorithm()
+
+	msg.Headers = headers
+	return nil
+}
+
+// SignPublishHook returns a hook for Channel.AddPublishHook that signs every
+// message with signer.  When combined with encryption, add the encryption
+// hook first so that the signature covers the encrypted body.
+func SignPublishHook(signer Signer) PublishHook {
+	return func(_, _ string, msg *Publishing) error {
+		return Sign(signer, msg)
+	}
+}
+
+type verificationKey struct {
+	algorithm string
+	key       []byte
+}
+
+// SignatureVerifier holds the keys to verify signed deliveries, by key id.
+// It is safe for concurrent use, so keys can be added and removed while
+// consuming, for example to rotate them.
+type SignatureVerifier struct {
+	m    sync.RWMutex
+	keys map[string]verificationKey
+}
+
+// NewSignatureVerifier returns a verifier without any key.
+func NewSignatureVerifier() *SignatureVerifier {
+	return &SignatureVerifier{keys: make(map[string]verificationKey)}
+}
+
+// AddHMAC adds the secret key of an HMAC-SHA256 signer.
+func (v *SignatureVerifier) AddHMAC(keyID string, key []byte) {
+	v.add(keyID, SignatureHMACSHA256, append([]byte(nil), key...))
+}
+
+// AddEd25519 adds the public key of an Ed25519 signer.
+func (v *SignatureVerifier) AddEd25519(keyID string, key ed25519.PublicKey) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("verification key %q: invalid Ed25519 public key size %d", keyID, len(key))
+	}
+	v.add(keyID, SignatureEd25519, append([]byte(nil), key...))
+	return nil
+}
+
+func (v *SignatureVerifier) add(keyID, algorithm string, key []byte) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	v.keys[keyID] = verificationKey{algorithm: algorithm, key: key}
+}
+
+// Remove removes the key with the given id, so that the deliveries signed with
+// it no longer verify.
+func (v *SignatureVerifier) Remove(keyID string) {
+	v.m.Lock()
+	defer v.m.Unlock()
+
+	delete(v.keys, keyID)
+}
+
+// Verify returns nil when d carries a valid signature from one of the keys of
+// the verifier, and an error wrapping ErrInvalidSignature otherwise, including
+// when d is not signed.
+func (v *SignatureVerifier) Verify(d *Delivery) error {
+	signature, _ := d.Headers[SignatureHeader].([]byte)
+	keyID, _ := d.Headers[SignatureKeyIDHeader].(string)
+	algorithm, _ := d.Headers[SignatureAlgorithmHeader].(string)
+
+	if signature == nil || keyID == "" {
+		return fmt.Errorf("%w: message is not signed", ErrInvalidSignature)
+	}
+
+	v.m.RLock()
+	key, found := v.keys[keyID]
+	v.m.RUnlock()
+
+	if !found {
+		return fmt.Errorf("%w: unknown key %q", ErrInvalidSignature, keyID)
+	}
+	if key.algorithm != algorithm {
+		return fmt.Errorf("%w: key %q is for %s, not %q", ErrInvalidSignature, keyID, key.algorithm, algorithm)
+	}
+
+	data := signedContent(d.ContentType, d.ContentEncoding, d.MessageId, d.Type, d.Body)
+
+	var valid bool
+	switch algorithm {
+	case SignatureHMACSHA256:
+		expected, _ := NewHMACSigner(keyID, key.key).Sign(data)
+		valid = hmac.Equal(signature, expected)
+	case SignatureEd25519:
+		valid = ed25519.Verify(ed25519.PublicKey(key.key), data, signature)
+	}
+
+	if !valid {
+		return fmt.Errorf("%w: signature does not match with key %q", ErrInvalidSignature, keyID)
+	}
+	return nil
+}
+
+// SignatureFailure is what ConsumeVerify does with the deliveries that fail
+// verification.
+type SignatureFailure int
+
+const (
+	// SignatureDeadLetter rejects the delivery without requeue, so the server
+	// dead-letters it when the queue has a dead letter exchange.
+	SignatureDeadLetter SignatureFailure = iota
+	// SignatureDiscard acknowledges the delivery, discarding it.
+	SignatureDiscard
+	// SignatureFlag hands the delivery to the application with the
+	// SignatureErrorHeader header describing the failure.
+	SignatureFlag
+)
+
+/*
+ConsumeVerify verifies the signature of every delivery of the consumer before
+it is handed to the application, and handles the deliveries that fail
+verification, including unsigned ones, according to onFailure.
+
+When the consumer automatically acknowledges deliveries, failed deliveries are
+discarded unless onFailure is SignatureFlag.
+*/
+func ConsumeVerify(v *SignatureVerifier, onFailure SignatureFailure) ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.hooks = append(o.hooks, func(autoAck bool) DeliveryHook {
+			return func(d *Delivery) bool {
+				err := v.Verify(d)
+				if err == nil {
+					return true
+				}
+
+				switch onFailure {
+				case SignatureFlag:
+					headers := make(Table, len(d.Headers)+1)
+					for k, val := range d.Headers {
+						headers[k] = val
+					}
+					headers[SignatureErrorHeader] = err.Error()
+					d.Headers = headers
+					return true
+
+				case SignatureDiscard:
+					if !autoAck {
+						_ = d.Ack(false)
+					}
+
+				default:
+					if !autoAck {
+						_ = d.Reject(false)
+					}
+				}
+				return false
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func signedDelivery(t *testing.T, signer Signer, msg Publishing) Delivery {
+	t.Helper()
+
+	if err := Sign(signer, &msg); err != nil {
+		t.Fatalf("could not sign: %v", err)
+	}
+	return Delivery{Headers: msg.Headers, ContentType: msg.ContentType, MessageId: msg.MessageId, Body: msg.Body}
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	v := NewSignatureVerifier()
+	v.AddHMAC("shared", []byte("secret"))
+	if err := v.AddEd25519("signing", public); err != nil {
+		t.Fatalf("could not add public key: %v", err)
+	}
+
+	msg := Publishing{ContentType: "application/json", MessageId: "42", Body: []byte(`{"amount":10}`)}
+
+	for _, signer := range []Signer{NewHMACSigner("shared", []byte("secret")), NewEd25519Signer("signing", private)} {
+		d := signedDelivery(t, signer, msg)
+		if err := v.Verify(&d); err != nil {
+			t.Fatalf("%s: expected a valid signature, got %v", signer.Algorithm(), err)
+		}
+
+		tampered := d
+		tampered.Body = []byte(`{"amount":1000}`)
+		if err := v.Verify(&tampered); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: expected a tampered body to fail, got %v", signer.Algorithm(), err)
+		}
+
+		retyped := d
+		retyped.ContentType = "text/plain"
+		if err := v.Verify(&retyped); !errors.Is(err, ErrInvalidSignature) {
+			t.Fatalf("%s: expected a changed content type to fail, got %v", signer.Algorithm(), err)
+		}
+	}
+
+	tests := map[string]Delivery{
+		"unsigned":    {Body: msg.Body},
+		"unknown key": signedDelivery(t, NewHMACSigner("other", []byte("secret")), msg),
+		"wrong key":   signedDelivery(t, NewHMACSigner("signing", []byte("secret")), msg),
+	}
+	for name, d := range tests {
+		if err := v.Verify(&d); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: expected an invalid signature, got %v", name, err)
+		}
+	}
+
+	d := signedDelivery(t, NewHMACSigner("shared", []byte("secret")), msg)
+	v.Remove("shared")
+	if err := v.Verify(&d); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected a removed key to fail, got %v", err)
+	}
+}
+
+func TestConsumeVerifyFlagsFailures(t *testing.T) {
+	o, err := newConsumeOptions([]ConsumeOption{ConsumeVerify(NewSignatureVerifier(), SignatureFlag)})
+	if err != nil {
+		t.Fatalf("could not build options: %v", err)
+	}
+	hook := o.hooks[0](true)
+
+	d := Delivery{Headers: Table{"tenant": "acme"}, Body: []byte("unsigned")}
+	if !hook(&d) {
+		t.Fatalf("expected the delivery to be handed to the application")
+	}
+	if reason, _ := d.Headers[SignatureErrorHeader].(string); !strings.Contains(reason, "not signed") {
+		t.Fatalf("expected the failure in the headers, got %v", d.Headers)
+	}
+}
+
+func TestConsumeVerifyDeadLettersInvalidDeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	rejected := make(chan *basicReject, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		publish := srv.recv(1, &basicPublish{}).(*basicPublish)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 1, Properties: publish.Properties, Body: []byte("forged")})
+		srv.send(1, &basicDeliver{ConsumerTag: req.ConsumerTag, DeliveryTag: 2, Properties: publish.Properties, Body: publish.Body})
+
+		reject := &basicReject{}
+		srv.recv(1, reject)
+		rejected <- reject
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	ch.AddPublishHook(SignPublishHook(NewHMACSigner("shared", []byte("secret"))))
+	if err := ch.PublishWithContext(context.Background(), "", "payments", false, false, Publishing{Body: []byte("pay 10")}); err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+
+	v := NewSignatureVerifier()
+	v.AddHMAC("shared", []byte("secret"))
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "payments", "", false, ConsumeVerify(v, SignatureDeadLetter))
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	if d := <-deliveries; d.DeliveryTag != 2 || string(d.Body) != "pay 10" {
+		t.Fatalf("expected the signed delivery, got %d %q", d.DeliveryTag, d.Body)
+	}
+
+	if reject := <-rejected; reject.DeliveryTag != 1 || reject.Requeue {
+		t.Fatalf("expected the forged delivery to be dead-lettered, got %+v", reject)
+	}
+}
@@ -155,7 +155,6 @@ var (
 	package amqp091
 
 	import (
-		"fmt"
 		"encoding/binary"
 		"io"
 	)
@@ -250,11 +249,11 @@ var (
         mf.Method = method
       {{end}}
       default:
-        return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+        return r.parseUnknownMethod(mf, size)
       }
     {{end}}
     default:
-      return nil, fmt.Errorf("Bad method frame, unknown class %d", mf.ClassId)
+      return r.parseUnknownMethod(mf, size)
     }
 
     return mf, nil
@@ -10,7 +10,6 @@ package amqp091
 
 import (
 	"encoding/binary"
-	"fmt"
 	"io"
 )
 
@@ -2830,7 +2829,7 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	case 20: // channel
@@ -2885,7 +2884,7 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	case 40: // exchange
@@ -2956,7 +2955,7 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	case 50: // queue
@@ -3043,7 +3042,7 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	case 60: // basic
@@ -3194,7 +3193,7 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	case 90: // tx
@@ -3249,7 +3248,7 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	case 85: // confirm
@@ -3272,11 +3271,11 @@ func (r *reader) parseMethodFrame(channel uint16, size uint32) (f frame, err err
 			mf.Method = method
 
 		default:
-			return nil, fmt.Errorf("Bad method frame, unknown method %d for class %d", mf.MethodId, mf.ClassId)
+			return r.parseUnknownMethod(mf, size)
 		}
 
 	default:
-		return nil, fmt.Errorf("Bad method frame, unknown class %d", mf.ClassId)
+		return r.parseUnknownMethod(mf, size)
 	}
 
 	return mf, nil
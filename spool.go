@@ -0,0 +1,415 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// ErrSpoolFull is returned when a publishing does not fit in a Spool.
+var ErrSpoolFull = errors.New("spool is full")
+
+// defaultSpoolSize is the size of a Spool when maxBytes is zero.
+const defaultSpoolSize = 64 << 20
+
+/*
+Spool is a bounded file-backed queue of publishings.  A Publisher with a spool
+appends the publishings it cannot send while the connection is down or its
+circuit breaker is open, and publishes them again, in order, once the broker
+is reachable.
+
+Publishings are stored with the AMQP encoding of basic.publish and fsynced
+before Publisher.Publish returns.  Drained publishings are only removed from
+the file when the spool is empty or closed, so they may be published again
+after a crash: consumers must tolerate duplicates.
+*/
+type Spool struct {
+	path string
+	max  int64
+
+	m      sync.Mutex
+	f      *os.File
+	size   int64 // bytes in the file
+	offset int64 // bytes of the drained publishings
+	count  int   // publishings not drained yet
+}
+
+// spooled is a publishing read from a Spool.
+type spooled struct {
+	exchange string
+	key      string
+	msg      Publishing
+	size     int64 // bytes in the file
+}
+
+/*
+OpenSpool opens or creates the spool file at path, holding at most maxBytes,
+64MiB when zero.  Publishings left in the file by a previous process are
+published again by the Publisher using the spool.  A publishing partially
+written by a crash is discarded.
+*/
+func OpenSpool(path string, maxBytes int64) (*Spool, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultSpoolSize
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Spool{path: path, max: maxBytes, f: f}
+	if err := s.scan(); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("spool %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// scan counts the publishings of the file and truncates a partially written
+// one at its end.
+func (s *Spool) scan() error {
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(s.f, 0, info.Size()))}
+	for {
+		start := r.n
+		if _, err := readSpooled(r); err != nil {
+			if start < info.Size() {
+				return s.f.Truncate(start)
+			}
+			return nil
+		}
+		s.size = r.n
+		s.count++
+	}
+}
+
+// Len returns the number of publishings waiting in the spool.
+func (s *Spool) Len() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.count
+}
+
+// Close removes the drained publishings from the file and closes it.
+func (s *Spool) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.f == nil {
+		return nil
+	}
+
+	err := s.compact()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	s.f = nil
+	return err
+}
+
+// push appends a publishing to the spool.
+func (s *Spool) push(exchange, key string, msg Publishing) error {
+	var buf bytes.Buffer
+	if err := writeSpooled(&buf, exchange, key, msg); err != nil {
+		return err
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.f == nil {
+		return ErrClosed
+	}
+	if s.size-s.offset+int64(buf.Len()) > s.max {
+		return ErrSpoolFull
+	}
+
+	if _, err := s.f.WriteAt(buf.Bytes(), s.size); err != nil {
+		return err
+	}
+	if err := s.f.Sync(); err != nil {
+		return err
+	}
+
+	s.size += int64(buf.Len())
+	s.count++
+	return nil
+}
+
+// peek returns the oldest publishing that was not drained, false when there
+// is none.
+func (s *Spool) peek() (spooled, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.f == nil {
+		return spooled{}, false, ErrClosed
+	}
+	if s.count == 0 {
+		return spooled{}, false, nil
+	}
+
+	r := &countingReader{r: bufio.NewReader(io.NewSectionReader(s.f, s.offset, s.size-s.offset))}
+	p, err := readSpooled(r)
+	if err != nil {
+		return spooled{}, false, err
+	}
+	p.size = r.n
+	return p, true, nil
+}
+
+// pop marks the publishing returned by peek as drained.
+func (s *Spool) pop(p spooled) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.offset += p.size
+	s.count--
+
+	if s.count == 0 && s.f != nil {
+		s.offset, s.size = 0, 0
+		return s.f.Truncate(0)
+	}
+	return nil
+}
+
+// compact rewrites the file without the drained publishings, s.m must be
+// held.
+func (s *Spool) compact() error {
+	if s.offset == 0 {
+		return nil
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(f, io.NewSectionReader(s.f, s.offset, s.size-s.offset))
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	_ = s.f.Close()
+	s.f = f
+	s.size -= s.offset
+	s.offset = 0
+	return nil
+}
+
+// spool appends a publishing to the spool of the publisher and starts
+// draining it.  cause is the error of sending the publishing, if any.
+func (p *Publisher) spool(exchange, key string, msg Publishing, cause error) error {
+	if err := p.opts.Spool.push(exchange, key, msg); err != nil {
+		if cause != nil {
+			return fmt.Errorf("spool publishing after %v: %w", cause, err)
+		}
+		return fmt.Errorf("spool publishing: %w", err)
+	}
+	p.drain()
+	return nil
+}
+
+// spoolable returns true when err means the broker cannot be reached.
+func (p *Publisher) spoolable(err error) bool {
+	if p.isClosed() {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrClosed) || errors.As(err, &netErr)
+}
+
+// drain starts the goroutine publishing the spool, unless it is running.
+func (p *Publisher) drain() {
+	p.drainM.Lock()
+	defer p.drainM.Unlock()
+
+	if p.draining || p.drainCtx.Err() != nil {
+		return
+	}
+	p.draining = true
+	p.drained.Add(1)
+	go p.drainSpool()
+}
+
+// drainSpool publishes the spooled publishings in order until the spool is
+// empty or the publisher is closed.  A publishing is retried with backoff
+// while the broker cannot be reached, and dropped when it fails otherwise, so
+// that it does not hold back the rest of the spool.
+func (p *Publisher) drainSpool() {
+	defer p.drained.Done()
+
+	spool := p.opts.Spool
+	delays := newBackoff(p.opts.BaseDelay, p.opts.MaxDelay)
+	for {
+		// Publish pushes before calling drain, so checking the length with
+		// drainM held does not miss a publishing.
+		p.drainM.Lock()
+		if spool.Len() == 0 || p.drainCtx.Err() != nil {
+			p.draining = false
+			p.drainM.Unlock()
+			return
+		}
+		p.drainM.Unlock()
+
+		s, ok, err := spool.peek()
+		if err != nil || !ok {
+			if err != nil && !errors.Is(err, ErrClosed) {
+				Logger.Printf("publisher could not read its spool: %v", err)
+			}
+			p.drainM.Lock()
+			p.draining = false
+			p.drainM.Unlock()
+			return
+		}
+
+		err = p.guarded(p.drainCtx, s.exchange, s.key, s.msg)
+		if err != nil && p.drainCtx.Err() != nil {
+			continue
+		}
+		if err != nil && (p.spoolable(err) || errors.Is(err, ErrNacked)) {
+			_ = sleepContext(p.drainCtx, delays.next())
+			continue
+		}
+		if err != nil {
+			Logger.Printf("publisher dropped a spooled publishing to exchange %q with routing key %q: %v", s.exchange, s.key, err)
+		}
+
+		delays.reset()
+		if err := spool.pop(s); err != nil {
+			Logger.Printf("publisher could not truncate its spool: %v", err)
+		}
+	}
+}
+
+// writeSpooled encodes a publishing as the frames of a basic.publish.
+func writeSpooled(w io.Writer, exchange, key string, msg Publishing) error {
+	frames := []frame{
+		&methodFrame{Method: &basicPublish{Exchange: exchange, RoutingKey: key}},
+		&headerFrame{ClassId: 60, Size: uint64(len(msg.Body)), Properties: publishingProperties(msg)},
+	}
+	if len(msg.Body) > 0 {
+		frames = append(frames, &bodyFrame{Body: msg.Body})
+	}
+
+	for _, f := range frames {
+		if err := f.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSpooled decodes a publishing written by writeSpooled.
+func readSpooled(r io.Reader) (spooled, error) {
+	fr := &reader{r: r}
+
+	f, err := fr.ReadFrame()
+	if err != nil {
+		return spooled{}, err
+	}
+	mf, ok := f.(*methodFrame)
+	if !ok {
+		return spooled{}, ErrUnexpectedFrame
+	}
+	pub, ok := mf.Method.(*basicPublish)
+	if !ok {
+		return spooled{}, ErrUnexpectedFrame
+	}
+
+	if f, err = fr.ReadFrame(); err != nil {
+		return spooled{}, err
+	}
+	hf, ok := f.(*headerFrame)
+	if !ok {
+		return spooled{}, ErrUnexpectedFrame
+	}
+
+	body := make([]byte, 0, hf.Size)
+	for uint64(len(body)) < hf.Size {
+		if f, err = fr.ReadFrame(); err != nil {
+			return spooled{}, err
+		}
+		bf, ok := f.(*bodyFrame)
+		if !ok {
+			return spooled{}, ErrUnexpectedFrame
+		}
+		body = append(body, bf.Body...)
+	}
+
+	props := hf.Properties
+	return spooled{
+		exchange: pub.Exchange,
+		key:      pub.RoutingKey,
+		msg: Publishing{
+			Headers:         props.Headers,
+			ContentType:     props.ContentType,
+			ContentEncoding: props.ContentEncoding,
+			DeliveryMode:    props.DeliveryMode,
+			Priority:        props.Priority,
+			CorrelationId:   props.CorrelationId,
+			ReplyTo:         props.ReplyTo,
+			Expiration:      props.Expiration,
+			MessageId:       props.MessageId,
+			Timestamp:       props.Timestamp,
+			Type:            props.Type,
+			UserId:          props.UserId,
+			AppId:           props.AppId,
+			Body:            body,
+		},
+	}, nil
+}
+
+// publishingProperties returns the content properties of a publishing.
+func publishingProperties(msg Publishing) properties {
+	return properties{
+		Headers:         msg.Headers,
+		ContentType:     msg.ContentType,
+		ContentEncoding: msg.ContentEncoding,
+		DeliveryMode:    msg.DeliveryMode,
+		Priority:        msg.Priority,
+		CorrelationId:   msg.CorrelationId,
+		ReplyTo:         msg.ReplyTo,
+		Expiration:      msg.Expiration,
+		MessageId:       msg.MessageId,
+		Timestamp:       msg.Timestamp,
+		Type:            msg.Type,
+		UserId:          msg.UserId,
+		AppId:           msg.AppId,
+	}
+}
+
+// countingReader counts the bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpoolRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+
+	s, err := OpenSpool(path, 0)
+	if err != nil {
+		t.Fatalf("could not open spool: %v", err)
+	}
+
+	msg := Publishing{
+		Headers:      Table{"tenant": "acme"},
+		ContentType:  "text/plain",
+		DeliveryMode: Persistent,
+		MessageId:    "m1",
+		Body:         []byte("hello"),
+	}
+	if err := s.push("events", "sensor.1", msg); err != nil {
+		t.Fatalf("could not push: %v", err)
+	}
+	if err := s.push("events", "sensor.2", Publishing{}); err != nil {
+		t.Fatalf("could not push: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("could not close spool: %v", err)
+	}
+
+	if s, err = OpenSpool(path, 0); err != nil {
+		t.Fatalf("could not reopen spool: %v", err)
+	}
+	defer s.Close()
+
+	if s.Len() != 2 {
+		t.Fatalf("expected 2 spooled publishings, got %d", s.Len())
+	}
+
+	got, ok, err := s.peek()
+	if err != nil || !ok {
+		t.Fatalf("could not peek: %v", err)
+	}
+	if got.exchange != "events" || got.key != "sensor.1" || string(got.msg.Body) != "hello" ||
+		got.msg.ContentType != "text/plain" || got.msg.MessageId != "m1" || got.msg.Headers["tenant"] != "acme" {
+		t.Fatalf("unexpected publishing: %+v", got)
+	}
+	if err := s.pop(got); err != nil {
+		t.Fatalf("could not pop: %v", err)
+	}
+
+	if got, _, _ = s.peek(); got.key != "sensor.2" || len(got.msg.Body) != 0 {
+		t.Fatalf("unexpected publishing: %+v", got)
+	}
+	if err := s.pop(got); err != nil {
+		t.Fatalf("could not pop: %v", err)
+	}
+
+	if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+		t.Fatalf("expected the empty spool to be truncated, got: %v %v", info.Size(), err)
+	}
+}
+
+func TestSpoolDiscardsTornPublishing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool")
+
+	s, err := OpenSpool(path, 0)
+	if err != nil {
+		t.Fatalf("could not open spool: %v", err)
+	}
+	if err := s.push("", "q", Publishing{Body: []byte("whole")}); err != nil {
+		t.Fatalf("could not push: %v", err)
+	}
+	size := s.size
+	if err := s.push("", "q", Publishing{Body: []byte("torn")}); err != nil {
+		t.Fatalf("could not push: %v", err)
+	}
+	s.Close()
+
+	// A crash in the middle of the second write.
+	if err := os.Truncate(path, size+5); err != nil {
+		t.Fatalf("could not truncate: %v", err)
+	}
+
+	if s, err = OpenSpool(path, 0); err != nil {
+		t.Fatalf("could not reopen spool: %v", err)
+	}
+	defer s.Close()
+
+	if s.Len() != 1 || s.size != size {
+		t.Fatalf("expected the torn publishing to be discarded, got %d publishings in %d bytes", s.Len(), s.size)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	s, err := OpenSpool(filepath.Join(t.TempDir(), "spool"), 64)
+	if err != nil {
+		t.Fatalf("could not open spool: %v", err)
+	}
+	defer s.Close()
+
+	if err := s.push("", "q", Publishing{Body: make([]byte, 64)}); !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("expected ErrSpoolFull, got: %v", err)
+	}
+}
+
+// unreachableSource fails to open channels until a connection is set.
+type unreachableSource struct {
+	m    sync.Mutex
+	conn *Connection
+}
+
+func (s *unreachableSource) Channel() (*Channel, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.conn == nil {
+		return nil, ErrClosed
+	}
+	return s.conn.Channel()
+}
+
+func TestPublisherDrainsSpoolInOrder(t *testing.T) {
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool"), 0)
+	if err != nil {
+		t.Fatalf("could not open spool: %v", err)
+	}
+	defer spool.Close()
+
+	source := &unreachableSource{}
+	p := NewPublisher(source, PublisherOptions{MaxAttempts: 1, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, Spool: spool})
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for _, body := range []string{"a", "b"} {
+		if err := p.Publish(ctx, "", "q", Publishing{Body: []byte(body)}); err != nil {
+			t.Fatalf("expected the publishing to be spooled, got: %v", err)
+		}
+	}
+
+	bodies := make(chan string, 2)
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		for tag := uint64(1); tag <= 2; tag++ {
+			bodies <- string(srv.recv(1, &basicPublish{}).(*basicPublish).Body)
+			srv.send(1, &basicAck{DeliveryTag: tag})
+		}
+		srv.connectionClose()
+	})
+
+	source.m.Lock()
+	source.conn = c
+	source.m.Unlock()
+
+	for _, want := range []string{"a", "b"} {
+		select {
+		case got := <-bodies:
+			if got != want {
+				t.Fatalf("expected publishing %q, got %q", want, got)
+			}
+		case <-ctx.Done():
+			t.Fatalf("publishing %q was not drained", want)
+		}
+	}
+
+	for spool.Len() > 0 {
+		if ctx.Err() != nil {
+			t.Fatalf("expected the spool to be drained, %d publishings left", spool.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
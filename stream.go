@@ -0,0 +1,375 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreamOffsetArg is the consumer argument telling a stream queue where to
+// start delivering from, and the header of the deliveries of a stream queue
+// holding their offset.
+const StreamOffsetArg = "x-stream-offset"
+
+// ConsumeStreamOffset sets the x-stream-offset consumer argument of a
+// consumer of a stream queue.  The offset is "first", "last" or "next", an
+// interval like "1h", an int or int64 offset, or a time.Time.  The server
+// starts from "next" when the argument is absent.
+func ConsumeStreamOffset(offset interface{}) ConsumeOption {
+	return func(o *consumeOptions) error {
+		switch v := offset.(type) {
+		case string, int64, time.Time:
+		case int:
+			offset = int64(v)
+		default:
+			return fmt.Errorf("%s of type %T not supported", StreamOffsetArg, offset)
+		}
+		o.setArg(StreamOffsetArg, offset)
+		return nil
+	}
+}
+
+// StreamOffset returns the offset of a delivery from a stream queue, false
+// for the deliveries of other queues.
+func (d Delivery) StreamOffset() (int64, bool) {
+	switch v := d.Headers[StreamOffsetArg].(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// CheckpointStore persists the offsets of the consumers of stream queues,
+// see Channel.ConsumeStreamFrom.  Load returns false when there is no
+// checkpoint for name yet.
+type CheckpointStore interface {
+	Load(ctx context.Context, name string) (offset int64, ok bool, err error)
+	Save(ctx context.Context, name string, offset int64) error
+}
+
+// StreamCheckpoint tells Channel.ConsumeStreamFrom where to resume.
+type StreamCheckpoint struct {
+	Store CheckpointStore
+
+	// Name identifies the checkpoint in the store, usually after the stream
+	// and the consumer, like "orders.billing".
+	Name string
+
+	// Start is the offset to start from when there is no checkpoint, see
+	// ConsumeStreamOffset.  The server starts from "next" when nil.
+	Start interface{}
+
+	// Every is the number of acknowledgements between saves, 1 when zero.
+	// A larger value saves less often, at the cost of redelivering up to
+	// Every deliveries after a restart.
+	Every int
+}
+
+/*
+ConsumeStreamFrom consumes a stream queue from the offset saved in the
+checkpoint, so that stream processing restarts where it left off.  The offset
+of a delivery is saved once it is acknowledged, so deliveries processed and
+not yet acknowledged when the consumer stops are delivered again.
+
+Streams require manual acknowledgement and a prefetch count, set with
+Channel.Qos before calling this method.  Acknowledging a delivery returns the
+error of saving the checkpoint, after the acknowledgement was sent.  Nacks and
+rejects do not move the checkpoint.
+
+The other options are applied as in Channel.ConsumeWithOptions, before the
+x-stream-offset argument set from the checkpoint.
+
+	err := ch.Qos(100, 0, false)
+	deliveries, err := ch.ConsumeStreamFrom(ctx, "orders", "billing", amqp.StreamCheckpoint{
+		Store: amqp.FileCheckpointStore{Dir: "/var/lib/billing"},
+		Name:  "orders.billing",
+		Start: "first",
+	})
+*/
+func (ch *Channel) ConsumeStreamFrom(ctx context.Context, queue, consumer string, checkpoint StreamCheckpoint, opts ...ConsumeOption) (<-chan Delivery, error) {
+	if checkpoint.Store == nil {
+		return nil, errors.New("stream checkpoint without a store")
+	}
+	if checkpoint.Every <= 0 {
+		checkpoint.Every = 1
+	}
+
+	offset, ok, err := checkpoint.Store.Load(ctx, checkpoint.Name)
+	if err != nil {
+		return nil, fmt.Errorf("load stream checkpoint %q: %w", checkpoint.Name, err)
+	}
+
+	tracker := &streamCheckpointer{Acknowledger: ch, checkpoint: checkpoint, offsets: make(map[uint64]int64), saved: -1}
+
+	opts = append([]ConsumeOption{ConsumeDeliveryHook(tracker.track)}, opts...)
+	if ok {
+		tracker.saved = offset
+		opts = append(opts, ConsumeStreamOffset(offset+1))
+	} else if checkpoint.Start != nil {
+		opts = append(opts, ConsumeStreamOffset(checkpoint.Start))
+	}
+
+	return ch.ConsumeWithOptions(ctx, queue, consumer, false, opts...)
+}
+
+// streamCheckpointer saves the offset of the deliveries acknowledged through
+// it.
+type streamCheckpointer struct {
+	Acknowledger
+	checkpoint StreamCheckpoint
+
+	m       sync.Mutex
+	offsets map[uint64]int64 // by delivery tag, until settled
+	acked   int              // acknowledgements since the last save
+	pending int64            // highest offset acknowledged and not saved
+
+	saveM sync.Mutex
+	saved int64
+}
+
+func (c *streamCheckpointer) track(d *Delivery) bool {
+	if offset, ok := d.StreamOffset(); ok {
+		c.m.Lock()
+		c.offsets[d.DeliveryTag] = offset
+		c.m.Unlock()
+	}
+	d.Acknowledger = c
+	return true
+}
+
+func (c *streamCheckpointer) Ack(tag uint64, multiple bool) error {
+	if err := c.Acknowledger.Ack(tag, multiple); err != nil {
+		return err
+	}
+
+	offset, ok := c.settle(tag, multiple, true)
+	if !ok {
+		return nil
+	}
+
+	c.saveM.Lock()
+	defer c.saveM.Unlock()
+
+	// Saves of concurrent acknowledgements must not move the checkpoint back.
+	if offset <= c.saved {
+		return nil
+	}
+	if err := c.checkpoint.Store.Save(context.Background(), c.checkpoint.Name, offset); err != nil {
+		return fmt.Errorf("save stream checkpoint %q: %w", c.checkpoint.Name, err)
+	}
+	c.saved = offset
+	return nil
+}
+
+func (c *streamCheckpointer) Nack(tag uint64, multiple, requeue bool) error {
+	c.settle(tag, multiple, false)
+	return c.Acknowledger.Nack(tag, multiple, requeue)
+}
+
+func (c *streamCheckpointer) Reject(tag uint64, requeue bool) error {
+	c.settle(tag, false, false)
+	return c.Acknowledger.Reject(tag, requeue)
+}
+
+// settle forgets the settled deliveries and returns the offset to save, if
+// any is due.
+func (c *streamCheckpointer) settle(tag uint64, multiple, ack bool) (int64, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	highest, found := c.offsets[tag]
+	delete(c.offsets, tag)
+	if multiple {
+		for t, offset := range c.offsets {
+			if t <= tag {
+				delete(c.offsets, t)
+				if !found || offset > highest {
+					highest, found = offset, true
+				}
+			}
+		}
+	}
+
+	if !ack || !found {
+		return 0, false
+	}
+
+	if c.acked == 0 || highest > c.pending {
+		c.pending = highest
+	}
+	c.acked++
+	if c.acked < c.checkpoint.Every {
+		return 0, false
+	}
+	c.acked = 0
+	return c.pending, true
+}
+
+// FileCheckpointStore keeps each checkpoint in a file of Dir, named after the
+// checkpoint.  Files are replaced atomically.
+type FileCheckpointStore struct {
+	Dir string
+}
+
+func (s FileCheckpointStore) path(name string) string {
+	return filepath.Join(s.Dir, url.PathEscape(name)+".offset")
+}
+
+// Load reads the checkpoint named name.
+func (s FileCheckpointStore) Load(_ context.Context, name string) (int64, bool, error) {
+	data, err := os.ReadFile(s.path(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("checkpoint %s: %w", s.path(name), err)
+	}
+	return offset, true, nil
+}
+
+// Save writes the checkpoint named name.
+func (s FileCheckpointStore) Save(_ context.Context, name string, offset int64) error {
+	path := s.path(name)
+	tmp := path + ".tmp"
+
+	err := os.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)+"\n"), 0o600)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+	}
+	return err
+}
+
+/*
+BrokerCheckpointStore keeps the checkpoints on the broker, each in a durable
+queue named after the checkpoint holding at most one message, the last offset
+saved.  This tracks the offsets of the consumers without a local disk, like
+the offset tracking of the RabbitMQ stream protocol which AMQP 0-9-1 clients
+cannot use.
+
+Offsets are published as persistent messages and confirmed.  The store opens
+its own channel from Source and is safe for concurrent use.
+*/
+type BrokerCheckpointStore struct {
+	Source ChannelSource
+
+	// Prefix of the queues holding the checkpoints, "stream-offsets." when
+	// empty.
+	Prefix string
+
+	m        sync.Mutex
+	ch       *Channel
+	declared map[string]bool
+}
+
+func (s *BrokerCheckpointStore) queue(name string) string {
+	if s.Prefix == "" {
+		return "stream-offsets." + name
+	}
+	return s.Prefix + name
+}
+
+// channel returns the channel of the store in confirm mode, with the queue of
+// the checkpoint declared.  It is called with s.m held.
+func (s *BrokerCheckpointStore) channel(queue string) (*Channel, error) {
+	if s.ch == nil || s.ch.IsClosed() {
+		ch, err := s.Source.Channel()
+		if err != nil {
+			return nil, err
+		}
+		if err := ch.Confirm(false); err != nil {
+			_ = ch.Close()
+			return nil, err
+		}
+		s.ch, s.declared = ch, make(map[string]bool)
+	}
+
+	if !s.declared[queue] {
+		args := Table{QueueMaxLenArg: int64(1)}
+		if _, err := s.ch.QueueDeclare(queue, true, false, false, false, args); err != nil {
+			return nil, err
+		}
+		s.declared[queue] = true
+	}
+	return s.ch, nil
+}
+
+// Load reads the last offset saved in the queue of the checkpoint, and leaves
+// it in the queue.
+func (s *BrokerCheckpointStore) Load(_ context.Context, name string) (int64, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	queue := s.queue(name)
+	ch, err := s.channel(queue)
+	if err != nil {
+		return 0, false, err
+	}
+
+	msg, ok, err := ch.Get(queue, false)
+	if err != nil || !ok {
+		return 0, false, err
+	}
+	if err := msg.Reject(true); err != nil {
+		return 0, false, err
+	}
+
+	offset, err := strconv.ParseInt(string(msg.Body), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("checkpoint in queue %s: %w", queue, err)
+	}
+	return offset, true, nil
+}
+
+// Save publishes offset to the queue of the checkpoint, replacing the
+// previous one, and waits for its confirmation.
+func (s *BrokerCheckpointStore) Save(ctx context.Context, name string, offset int64) error {
+	s.m.Lock()
+	queue := s.queue(name)
+	ch, err := s.channel(queue)
+	s.m.Unlock()
+	if err != nil {
+		return err
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(ctx, "", queue, true, false, Publishing{
+		DeliveryMode: Persistent,
+		Body:         []byte(strconv.FormatInt(offset, 10)),
+	})
+	return confirmed(ctx, confirm, err)
+}
+
+// Close closes the channel of the store.
+func (s *BrokerCheckpointStore) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.ch == nil {
+		return nil
+	}
+	err := s.ch.Close()
+	s.ch = nil
+	return err
+}
@@ -0,0 +1,218 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryCheckpointStore struct {
+	m       sync.Mutex
+	offsets map[string]int64
+	saves   int
+}
+
+func (s *memoryCheckpointStore) Load(_ context.Context, name string) (int64, bool, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	offset, ok := s.offsets[name]
+	return offset, ok, nil
+}
+
+func (s *memoryCheckpointStore) Save(_ context.Context, name string, offset int64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.offsets[name] = offset
+	s.saves++
+	return nil
+}
+
+type nopAcknowledger struct{}
+
+func (nopAcknowledger) Ack(uint64, bool) error        { return nil }
+func (nopAcknowledger) Nack(uint64, bool, bool) error { return nil }
+func (nopAcknowledger) Reject(uint64, bool) error     { return nil }
+
+func TestConsumeStreamFromResumesAfterTheCheckpoint(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	served := make(chan struct{})
+	consume := &basicConsume{}
+	go func() {
+		defer close(served)
+
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, consume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		for tag := uint64(1); tag <= 3; tag++ {
+			srv.send(1, &basicDeliver{
+				ConsumerTag: consume.ConsumerTag,
+				DeliveryTag: tag,
+				Properties:  properties{Headers: Table{StreamOffsetArg: int64(41 + tag)}},
+			})
+		}
+
+		srv.recv(1, &basicAck{})
+		srv.recv(1, &basicReject{})
+		srv.connectionClose()
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+
+	store := &memoryCheckpointStore{offsets: map[string]int64{"orders.billing": 41}}
+	deliveries, err := ch.ConsumeStreamFrom(context.Background(), "orders", "billing", StreamCheckpoint{
+		Store: store,
+		Name:  "orders.billing",
+		Start: "first",
+	})
+	if err != nil {
+		t.Fatalf("could not consume the stream: %v", err)
+	}
+
+	if offset := consume.Arguments[StreamOffsetArg]; offset != int64(42) {
+		t.Fatalf("expected to resume after the checkpoint, got %v", offset)
+	}
+
+	var received []Delivery
+	for len(received) < 3 {
+		received = append(received, <-deliveries)
+	}
+	if offset, ok := received[1].StreamOffset(); !ok || offset != 43 {
+		t.Fatalf("unexpected offset %d", offset)
+	}
+
+	if err := received[1].Ack(true); err != nil {
+		t.Fatalf("could not ack: %v", err)
+	}
+	if err := received[2].Reject(false); err != nil {
+		t.Fatalf("could not reject: %v", err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+
+	if store.saves != 1 || store.offsets["orders.billing"] != 43 {
+		t.Fatalf("expected the offset of the acknowledged deliveries to be saved once, got %d after %d saves", store.offsets["orders.billing"], store.saves)
+	}
+}
+
+func TestStreamCheckpointerSavesEveryAcknowledgements(t *testing.T) {
+	store := &memoryCheckpointStore{offsets: map[string]int64{}}
+	c := &streamCheckpointer{
+		Acknowledger: nopAcknowledger{},
+		checkpoint:   StreamCheckpoint{Store: store, Name: "s", Every: 2},
+		offsets:      map[uint64]int64{},
+		saved:        -1,
+	}
+
+	for tag := uint64(1); tag <= 3; tag++ {
+		d := Delivery{DeliveryTag: tag, Headers: Table{StreamOffsetArg: int64(tag * 10)}}
+		c.track(&d)
+	}
+
+	// Acknowledged out of order, the highest offset is saved.
+	for _, tag := range []uint64{2, 1, 3} {
+		if err := c.Ack(tag, false); err != nil {
+			t.Fatalf("could not ack: %v", err)
+		}
+	}
+
+	if store.saves != 1 || store.offsets["s"] != 20 {
+		t.Fatalf("expected offset 20 saved once, got %d after %d saves", store.offsets["s"], store.saves)
+	}
+}
+
+func TestConsumeStreamOffsetValidatesTheOffset(t *testing.T) {
+	for _, offset := range []interface{}{"first", 42, int64(42), time.Now()} {
+		if _, err := newConsumeOptions([]ConsumeOption{ConsumeStreamOffset(offset)}); err != nil {
+			t.Errorf("unexpected error for %v: %v", offset, err)
+		}
+	}
+	if _, err := newConsumeOptions([]ConsumeOption{ConsumeStreamOffset(4.2)}); err == nil {
+		t.Fatalf("expected an error for a float offset")
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	store := FileCheckpointStore{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	if _, ok, err := store.Load(ctx, "orders/billing"); ok || err != nil {
+		t.Fatalf("expected no checkpoint, got %v, %v", ok, err)
+	}
+
+	for _, offset := range []int64{7, 1234} {
+		if err := store.Save(ctx, "orders/billing", offset); err != nil {
+			t.Fatalf("could not save: %v", err)
+		}
+	}
+
+	offset, ok, err := store.Load(ctx, "orders/billing")
+	if err != nil || !ok || offset != 1234 {
+		t.Fatalf("expected the last offset, got %d, %v, %v", offset, ok, err)
+	}
+}
+
+func TestBrokerCheckpointStore(t *testing.T) {
+	declare := &queueDeclare{}
+	published := &basicPublish{}
+
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		srv.recv(1, declare)
+		srv.send(1, &queueDeclareOk{Queue: declare.Queue})
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetEmpty{})
+
+		srv.recv(1, published)
+		srv.send(1, &basicAck{DeliveryTag: 1})
+
+		srv.recv(1, &basicGet{})
+		srv.send(1, &basicGetOk{DeliveryTag: 1, Properties: properties{DeliveryMode: Persistent}, Body: []byte("99")})
+		srv.recv(1, &basicReject{})
+		srv.connectionClose()
+	})
+
+	store := &BrokerCheckpointStore{Source: c}
+	ctx := context.Background()
+
+	if _, ok, err := store.Load(ctx, "orders.billing"); ok || err != nil {
+		t.Fatalf("expected no checkpoint, got %v, %v", ok, err)
+	}
+	if declare.Queue != "stream-offsets.orders.billing" || !declare.Durable || declare.Arguments[QueueMaxLenArg] != int64(1) {
+		t.Fatalf("unexpected declaration %+v", declare)
+	}
+
+	if err := store.Save(ctx, "orders.billing", 99); err != nil {
+		t.Fatalf("could not save: %v", err)
+	}
+	if published.RoutingKey != declare.Queue || string(published.Body) != "99" || published.Properties.DeliveryMode != Persistent {
+		t.Fatalf("unexpected publishing %+v", published)
+	}
+
+	offset, ok, err := store.Load(ctx, "orders.billing")
+	if err != nil || !ok || offset != 99 {
+		t.Fatalf("expected the saved offset, got %d, %v, %v", offset, ok, err)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
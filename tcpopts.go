@@ -0,0 +1,73 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPKeepAlive configures the keepalive probes of the TCP connections opened
+// by DialConfig, so that connections silently dropped by a NAT or a load
+// balancer are detected by the kernel.  See Config.TCPKeepAlive.
+type TCPKeepAlive struct {
+	// Idle is how long a connection stays idle before the first probe is
+	// sent, 15 seconds when zero.  A negative Idle disables keepalive.
+	Idle time.Duration
+
+	// Interval is the delay between unanswered probes, Idle when zero.
+	Interval time.Duration
+
+	// Count is the number of unanswered probes after which the connection is
+	// dropped, the system default when zero.
+	Count int
+}
+
+// tcpOptions returns dial setting the options of config on the TCP
+// connections it opens that net.Dialer does not support: TCP_NODELAY, the
+// keepalive interval and count, and the user timeout.
+func tcpOptions(dial func(network, addr string) (net.Conn, error), config Config) func(network, addr string) (net.Conn, error) {
+	keepAlive, userTimeout := config.TCPKeepAlive, config.TCPUserTimeout
+	if keepAlive.Idle < 0 {
+		keepAlive.Interval, keepAlive.Count = 0, 0
+	}
+	if config.NoDelay == nil && keepAlive.Interval <= 0 && keepAlive.Count <= 0 && userTimeout <= 0 {
+		return dial
+	}
+
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := dial(network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tcp, ok := conn.(*net.TCPConn)
+		if !ok {
+			return conn, nil
+		}
+
+		if config.NoDelay != nil {
+			err = tcp.SetNoDelay(*config.NoDelay)
+		}
+		var raw syscall.RawConn
+		if err == nil {
+			raw, err = tcp.SyscallConn()
+		}
+		if err == nil {
+			controlErr := raw.Control(func(fd uintptr) {
+				err = setTCPOptions(fd, keepAlive, userTimeout)
+			})
+			if controlErr != nil {
+				err = controlErr
+			}
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("set TCP options: %w", err)
+		}
+		return conn, nil
+	}
+}
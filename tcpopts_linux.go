@@ -0,0 +1,37 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package amqp091
+
+import (
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is TCP_USER_TIMEOUT, missing from the syscall package.
+const tcpUserTimeout = 0x12
+
+// setTCPOptions sets the keepalive interval and count, and the user timeout,
+// of the socket fd.  Zero values keep the current settings.
+func setTCPOptions(fd uintptr, keepAlive TCPKeepAlive, userTimeout time.Duration) error {
+	if keepAlive.Interval > 0 {
+		secs := int((keepAlive.Interval + time.Second - 1) / time.Second)
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPINTVL, secs); err != nil {
+			return err
+		}
+	}
+	if keepAlive.Count > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT, keepAlive.Count); err != nil {
+			return err
+		}
+	}
+	if userTimeout > 0 {
+		if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(userTimeout.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build linux
+
+package amqp091
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestTCPOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+
+	noDelay := false
+	dial := tcpOptions(net.Dial, Config{
+		TCPKeepAlive:   TCPKeepAlive{Interval: 1500 * time.Millisecond, Count: 4},
+		TCPUserTimeout: 20 * time.Second,
+		NoDelay:        &noDelay,
+	})
+	conn, err := dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatalf("could not get the raw connection: %v", err)
+	}
+	for name, opt := range map[string]struct{ opt, want int }{
+		"TCP_KEEPINTVL":    {syscall.TCP_KEEPINTVL, 2},
+		"TCP_KEEPCNT":      {syscall.TCP_KEEPCNT, 4},
+		"TCP_USER_TIMEOUT": {tcpUserTimeout, 20000},
+		"TCP_NODELAY":      {syscall.TCP_NODELAY, 0},
+	} {
+		var got int
+		var getErr error
+		if err := raw.Control(func(fd uintptr) {
+			got, getErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, opt.opt)
+		}); err != nil || getErr != nil {
+			t.Fatalf("could not get %s: %v %v", name, err, getErr)
+		}
+		if got != opt.want {
+			t.Errorf("expected %s %d, got %d", name, opt.want, got)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+
+package amqp091
+
+import "time"
+
+// setTCPOptions is a no-op outside of Linux, where the keepalive interval
+// and count, and the user timeout, are left to the system.
+func setTCPOptions(fd uintptr, keepAlive TCPKeepAlive, userTimeout time.Duration) error {
+	return nil
+}
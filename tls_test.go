@@ -11,6 +11,9 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -108,6 +111,35 @@ func TestTlsConfigFromUriPushdownServerNameIndication(t *testing.T) {
 	}
 }
 
+func TestTlsConfigFromUriVerify(t *testing.T) {
+	for verify, skip := range map[string]bool{"": false, "verify_peer": false, "verify_none": true} {
+		parsedUri, err := ParseURI("amqps://example.com/?verify=" + verify)
+		if err != nil {
+			t.Fatalf("expected to parse URI successfully, got error: %s", err)
+		}
+
+		tlsConf, err := tlsConfigFromURI(parsedUri)
+		if err != nil {
+			t.Fatalf("expected tlsConfigFromURI to succeed, got error: %s", err)
+		}
+		if tlsConf.InsecureSkipVerify != skip {
+			t.Fatalf("verify=%q: expected InsecureSkipVerify %v, got %v", verify, skip, tlsConf.InsecureSkipVerify)
+		}
+	}
+}
+
+func TestTlsConfigFromUriRejectsEmptyCACertFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(file, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("could not write CA file: %v", err)
+	}
+
+	_, err := tlsConfigFromURI(URI{Scheme: "amqps", CACertFile: file})
+	if err == nil || !strings.Contains(err.Error(), "no certificate") {
+		t.Fatalf("expected a CA file without certificates to be rejected, got: %v", err)
+	}
+}
+
 // Tests opening a connection of a TLS enabled socket server
 func TestTLSHandshake(t *testing.T) {
 	srv := startTLSServer(t, tlsServerConfig(t))
@@ -150,6 +182,50 @@ func TestTLSHandshake(t *testing.T) {
 	}
 }
 
+func TestGetTLSConfigCalledOnEveryDial(t *testing.T) {
+	srv := startTLSServer(t, tlsServerConfig(t))
+	defer srv.Close()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		session := <-srv.Sessions
+		session.connectionOpen()
+		session.connectionClose()
+		session.S.Close()
+	}()
+
+	shared := tlsClientConfig(t)
+	calls := 0
+	c, err := DialConfig(srv.URL, Config{
+		GetTLSConfig: func() (*tls.Config, error) {
+			calls++
+			if calls == 1 {
+				return nil, errors.New("certificate not rotated yet")
+			}
+			return shared, nil
+		},
+		DialRetry: DialRetry{MaxAttempts: 2, BaseDelay: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected the TLS config to be fetched for each attempt, got %d calls", calls)
+	}
+	if st := c.ConnectionState(); !st.HandshakeComplete {
+		t.Fatalf("expected to complete a TLS handshake, got %+v", st)
+	}
+	if shared.ServerName != "" {
+		t.Fatalf("expected the returned TLS config to be left alone, got server name %q", shared.ServerName)
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+}
+
 const caCert = `
 -----BEGIN CERTIFICATE-----
 MIIC0TCCAbmgAwIBAgIUW418AvO6YD2WD5X/coo9geXvauEwDQYJKoZIhvcNAQEL
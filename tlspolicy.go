@@ -0,0 +1,163 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+// ErrTLSPolicy is wrapped by the errors of connections refused by
+// Config.TLSPolicy.
+var ErrTLSPolicy = errors.New("TLS policy violation")
+
+/*
+TLSPolicy enforces the security of the transport of a connection, to meet
+compliance requirements at the client rather than trusting every Config and
+URL of an application.  DialConfig applies it to the TLS configuration before
+the handshake, and Open verifies the negotiated connection before sending
+anything, failing with an error wrapping ErrTLSPolicy.
+*/
+type TLSPolicy struct {
+	// MinVersion is the oldest TLS version accepted, like tls.VersionTLS12.
+	// No version is enforced when zero.
+	MinVersion uint16
+
+	// CipherSuites are the TLS 1.0 to 1.2 cipher suites accepted, all those
+	// of crypto/tls when empty.  The TLS 1.3 suites cannot be restricted.
+	CipherSuites []uint16
+
+	// RequireTLS refuses to send credentials over a connection without TLS,
+	// unless its peer is on the loopback interface.  Connections
+	// authenticating with EXTERNAL send no credentials.
+	RequireTLS bool
+}
+
+// apply returns a copy of config complying with the policy, or an error when
+// config explicitly conflicts with it.
+func (p *TLSPolicy) apply(config *tls.Config) (*tls.Config, error) {
+	if p == nil {
+		return config, nil
+	}
+
+	config = config.Clone()
+
+	if p.MinVersion != 0 {
+		if config.MaxVersion != 0 && config.MaxVersion < p.MinVersion {
+			return nil, fmt.Errorf("%w: TLS configuration has maximum version %s, policy requires at least %s",
+				ErrTLSPolicy, tlsVersionName(config.MaxVersion), tlsVersionName(p.MinVersion))
+		}
+		if config.MinVersion < p.MinVersion {
+			config.MinVersion = p.MinVersion
+		}
+	}
+
+	if len(p.CipherSuites) > 0 {
+		if len(config.CipherSuites) == 0 {
+			config.CipherSuites = append([]uint16(nil), p.CipherSuites...)
+		} else {
+			var allowed []uint16
+			for _, suite := range config.CipherSuites {
+				if p.allows(suite) {
+					allowed = append(allowed, suite)
+				}
+			}
+			if len(allowed) == 0 {
+				return nil, fmt.Errorf("%w: none of the cipher suites of the TLS configuration is allowed", ErrTLSPolicy)
+			}
+			config.CipherSuites = allowed
+		}
+	}
+
+	return config, nil
+}
+
+func (p *TLSPolicy) allows(suite uint16) bool {
+	for _, allowed := range p.CipherSuites {
+		if suite == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// enforce verifies the transport of a connection authenticating with sasl,
+// completing the TLS handshake when it was not done yet.
+func (p *TLSPolicy) enforce(conn io.ReadWriteCloser, sasl []Authentication) error {
+	if p == nil {
+		return nil
+	}
+
+	if ws, ok := conn.(*webSocketConn); ok {
+		conn = ws.Conn
+	}
+	tlsConn, secure := conn.(*tls.Conn)
+	if !secure {
+		if p.RequireTLS && sendsCredentials(sasl) && !isLoopback(conn) {
+			return fmt.Errorf("%w: refusing to send credentials without TLS", ErrTLSPolicy)
+		}
+		return nil
+	}
+
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	state := tlsConn.ConnectionState()
+
+	if state.Version < p.MinVersion {
+		return fmt.Errorf("%w: negotiated %s, policy requires at least %s",
+			ErrTLSPolicy, tlsVersionName(state.Version), tlsVersionName(p.MinVersion))
+	}
+	if len(p.CipherSuites) > 0 && state.Version < tls.VersionTLS13 && !p.allows(state.CipherSuite) {
+		return fmt.Errorf("%w: negotiated cipher suite %s is not allowed", ErrTLSPolicy, tls.CipherSuiteName(state.CipherSuite))
+	}
+	return nil
+}
+
+// tlsVersionName returns the name of a TLS version, like tls.VersionName
+// which requires Go 1.21.
+func tlsVersionName(version uint16) string {
+	switch version {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("0x%04X", version)
+}
+
+// sendsCredentials returns true when one of the mechanisms sends a secret.
+func sendsCredentials(sasl []Authentication) bool {
+	for _, auth := range sasl {
+		if auth.Mechanism() != "EXTERNAL" {
+			return true
+		}
+	}
+	return false
+}
+
+// isLoopback returns true when the peer of conn is on the loopback interface
+// or a Unix socket.  Transports without a remote address are not.
+func isLoopback(conn io.ReadWriteCloser) bool {
+	remote, ok := conn.(interface{ RemoteAddr() net.Addr })
+	if !ok {
+		return false
+	}
+
+	switch addr := remote.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return addr.IP.IsLoopback()
+	case *net.UnixAddr:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestTLSPolicyApply(t *testing.T) {
+	policy := &TLSPolicy{
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+	}
+
+	user := &tls.Config{
+		MinVersion:   tls.VersionTLS10,
+		CipherSuites: []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_RSA_WITH_AES_128_CBC_SHA},
+	}
+	applied, err := policy.apply(user)
+	if err != nil {
+		t.Fatalf("could not apply policy: %v", err)
+	}
+	if applied.MinVersion != tls.VersionTLS12 || len(applied.CipherSuites) != 1 {
+		t.Fatalf("expected the policy to restrict the configuration, got version %x and suites %v", applied.MinVersion, applied.CipherSuites)
+	}
+	if user.MinVersion != tls.VersionTLS10 || len(user.CipherSuites) != 2 {
+		t.Fatalf("expected the configuration of the application to be left alone")
+	}
+
+	if _, err := policy.apply(&tls.Config{MaxVersion: tls.VersionTLS11}); !errors.Is(err, ErrTLSPolicy) {
+		t.Fatalf("expected a conflicting maximum version to be refused, got: %v", err)
+	}
+	if _, err := policy.apply(&tls.Config{CipherSuites: []uint16{tls.TLS_RSA_WITH_AES_128_CBC_SHA}}); !errors.Is(err, ErrTLSPolicy) {
+		t.Fatalf("expected disallowed cipher suites to be refused, got: %v", err)
+	}
+}
+
+func TestTLSPolicyRefusesCredentialsWithoutTLS(t *testing.T) {
+	rwc, _ := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	config := defaultConfig()
+	config.TLSPolicy = &TLSPolicy{RequireTLS: true}
+
+	if _, err := Open(rwc, config); !errors.Is(err, ErrTLSPolicy) {
+		t.Fatalf("expected the plain credentials to be refused, got: %v", err)
+	}
+
+	if err := config.TLSPolicy.enforce(rwc, []Authentication{&ExternalAuth{}}); err != nil {
+		t.Fatalf("expected EXTERNAL to be allowed without TLS, got: %v", err)
+	}
+}
+
+func TestTLSPolicyRefusesOldVersion(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	serverConfig := tlsServerConfig(t)
+	serverConfig.MaxVersion = tls.VersionTLS12
+	go func() { _ = tls.Server(server, serverConfig).Handshake() }()
+
+	clientConfig := tlsClientConfig(t)
+	clientConfig.ServerName = "127.0.0.1"
+	conn := tls.Client(client, clientConfig)
+
+	policy := &TLSPolicy{MinVersion: tls.VersionTLS13}
+	err := policy.enforce(conn, nil)
+	if !errors.Is(err, ErrTLSPolicy) {
+		t.Fatalf("expected TLS 1.2 to be refused, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "negotiated TLS 1.2, policy requires at least TLS 1.3") {
+		t.Fatalf("expected the error to name the versions, got: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrPeerVerification is wrapped by the errors of PinSPKI and AllowSANs when
+// the certificate of the server is rejected.
+var ErrPeerVerification = errors.New("TLS peer verification failed")
+
+// withVerifyPeer returns a copy of config that also calls verify once the
+// handshake verified the certificate chain.
+func withVerifyPeer(config *tls.Config, verify func(tls.ConnectionState) error) *tls.Config {
+	if verify == nil {
+		return config
+	}
+
+	config = config.Clone()
+	previous := config.VerifyConnection
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if previous != nil {
+			if err := previous(state); err != nil {
+				return err
+			}
+		}
+		return verify(state)
+	}
+	return config
+}
+
+/*
+PinSPKI returns a Config.VerifyPeer accepting the servers whose certificate
+chain holds a public key with one of the pins, the base64 encoded SHA-256
+digests of the DER encoded SubjectPublicKeyInfo, as produced by:
+
+	openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | openssl dgst -sha256 -binary | base64
+
+Pinning the key of an intermediate CA rather than the leaf certificate
+survives the rotation of the certificates of the broker.  The pins are matched
+against the chains verified by the handshake, not the certificates the server
+presented, so with InsecureSkipVerify only the key of the leaf can be pinned.
+*/
+func PinSPKI(pins ...string) func(tls.ConnectionState) error {
+	allowed := make(map[string]bool, len(pins))
+	for _, pin := range pins {
+		allowed[pin] = true
+	}
+
+	pinned := func(cert *x509.Certificate) bool {
+		digest := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return allowed[base64.StdEncoding.EncodeToString(digest[:])]
+	}
+
+	return func(state tls.ConnectionState) error {
+		// The peer may append any certificate to the chain it presents, so
+		// only the verified chains are trusted to hold the pinned key.
+		for _, chain := range state.VerifiedChains {
+			for _, cert := range chain {
+				if pinned(cert) {
+					return nil
+				}
+			}
+		}
+
+		// Without verification, as with InsecureSkipVerify, only the leaf
+		// is known to belong to the peer, having signed the handshake.
+		if len(state.VerifiedChains) == 0 && len(state.PeerCertificates) > 0 && pinned(state.PeerCertificates[0]) {
+			return nil
+		}
+
+		return fmt.Errorf("%w: no public key of the verified certificate chain matches a pin", ErrPeerVerification)
+	}
+}
+
+// AllowSANs returns a Config.VerifyPeer accepting the servers whose leaf
+// certificate has one of the names as a DNS name, IP address, URI or email
+// address subject alternative name.  DNS names are compared case
+// insensitively.
+func AllowSANs(names ...string) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("%w: no certificate", ErrPeerVerification)
+		}
+		leaf := state.PeerCertificates[0]
+
+		for _, name := range names {
+			for _, dns := range leaf.DNSNames {
+				if strings.EqualFold(dns, name) {
+					return nil
+				}
+			}
+			for _, ip := range leaf.IPAddresses {
+				if ip.String() == name {
+					return nil
+				}
+			}
+			for _, uri := range leaf.URIs {
+				if uri.String() == name {
+					return nil
+				}
+			}
+			for _, email := range leaf.EmailAddresses {
+				if email == name {
+					return nil
+				}
+			}
+		}
+		return fmt.Errorf("%w: certificate of %q has no allowed subject alternative name", ErrPeerVerification, leaf.Subject.CommonName)
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"net"
+	"testing"
+)
+
+// handshakeWithVerifyPeer runs a TLS handshake with the test server
+// certificate, verifying the peer with verify.
+func handshakeWithVerifyPeer(t *testing.T, verify func(tls.ConnectionState) error) error {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	go func() { _ = tls.Server(server, tlsServerConfig(t)).Handshake() }()
+
+	config := tlsClientConfig(t)
+	config.ServerName = "127.0.0.1"
+	return tls.Client(client, withVerifyPeer(config, verify)).Handshake()
+}
+
+func testServerCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(serverCert))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("could not parse the server certificate: %v", err)
+	}
+	return cert
+}
+
+func TestPinSPKI(t *testing.T) {
+	digest := sha256.Sum256(testServerCertificate(t).RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(digest[:])
+
+	if err := handshakeWithVerifyPeer(t, PinSPKI("other", pin)); err != nil {
+		t.Fatalf("expected the pinned key to be accepted, got: %v", err)
+	}
+
+	if err := handshakeWithVerifyPeer(t, PinSPKI("other")); !errors.Is(err, ErrPeerVerification) {
+		t.Fatalf("expected an unpinned key to be rejected, got: %v", err)
+	}
+}
+
+// handshakeWithExtraCertificate runs a TLS handshake where the server
+// presents extra after its certificate, verifying the peer with verify.
+func handshakeWithExtraCertificate(t *testing.T, extra *x509.Certificate, config *tls.Config, verify func(tls.ConnectionState) error) error {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	serverConfig := tlsServerConfig(t)
+	serverConfig.Certificates[0].Certificate = append(serverConfig.Certificates[0].Certificate, extra.Raw)
+	go func() { _ = tls.Server(server, serverConfig).Handshake() }()
+
+	config.ServerName = "127.0.0.1"
+	return tls.Client(client, withVerifyPeer(config, verify)).Handshake()
+}
+
+func TestPinSPKIIgnoresUnverifiedCertificates(t *testing.T) {
+	block, _ := pem.Decode([]byte(clientCert))
+	extra, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("could not parse the client certificate: %v", err)
+	}
+	digest := sha256.Sum256(extra.RawSubjectPublicKeyInfo)
+	pin := base64.StdEncoding.EncodeToString(digest[:])
+
+	if err := handshakeWithExtraCertificate(t, extra, tlsClientConfig(t), PinSPKI(pin)); !errors.Is(err, ErrPeerVerification) {
+		t.Fatalf("expected a pinned key outside of the verified chain to be rejected, got: %v", err)
+	}
+
+	insecure := tlsClientConfig(t)
+	insecure.InsecureSkipVerify = true
+	if err := handshakeWithExtraCertificate(t, extra, insecure, PinSPKI(pin)); !errors.Is(err, ErrPeerVerification) {
+		t.Fatalf("expected a pinned key other than the leaf to be rejected without verification, got: %v", err)
+	}
+
+	leaf := sha256.Sum256(testServerCertificate(t).RawSubjectPublicKeyInfo)
+	if err := handshakeWithExtraCertificate(t, extra, insecure, PinSPKI(base64.StdEncoding.EncodeToString(leaf[:]))); err != nil {
+		t.Fatalf("expected the pinned leaf to be accepted without verification, got: %v", err)
+	}
+}
+
+func TestAllowSANs(t *testing.T) {
+	cert := testServerCertificate(t)
+	var san string
+	switch {
+	case len(cert.DNSNames) > 0:
+		san = cert.DNSNames[0]
+	case len(cert.IPAddresses) > 0:
+		san = cert.IPAddresses[0].String()
+	default:
+		t.Skip("the test server certificate has no subject alternative name")
+	}
+
+	if err := handshakeWithVerifyPeer(t, AllowSANs("broker.example.com", san)); err != nil {
+		t.Fatalf("expected the allowed name to be accepted, got: %v", err)
+	}
+
+	if err := handshakeWithVerifyPeer(t, AllowSANs("broker.example.com")); !errors.Is(err, ErrPeerVerification) {
+		t.Fatalf("expected a certificate without an allowed name to be rejected, got: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"fmt"
+	"strings"
+)
+
+// splitTopic splits a routing key or binding pattern into its words.  As in
+// the server, the empty string has no words at all.
+func splitTopic(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ".")
+}
+
+/*
+TopicMatches reports whether a topic exchange routes a message published with
+routingKey to a binding with pattern, following the semantics of RabbitMQ.
+
+Routing keys and patterns are lists of words separated by dots.  In a pattern,
+the word "*" matches exactly one word and "#" matches zero or more words; any
+other word, including one merely containing "*" or "#", matches itself only.
+
+	amqp.TopicMatches("logs.*.error", "logs.app.error") // true
+	amqp.TopicMatches("logs.#", "logs")                 // true
+	amqp.TopicMatches("logs.*", "logs")                 // false
+*/
+func TopicMatches(pattern, routingKey string) bool {
+	words := splitTopic(pattern)
+	key := splitTopic(routingKey)
+
+	// matched[j] reports whether the words of the pattern seen so far match
+	// the first j words of the key.
+	matched := make([]bool, len(key)+1)
+	next := make([]bool, len(key)+1)
+	matched[0] = true
+
+	for _, word := range words {
+		switch word {
+		case "#":
+			next[0] = matched[0]
+			for j := 1; j <= len(key); j++ {
+				next[j] = matched[j] || next[j-1]
+			}
+		default:
+			next[0] = false
+			for j := 1; j <= len(key); j++ {
+				next[j] = matched[j-1] && (word == "*" || word == key[j-1])
+			}
+		}
+		matched, next = next, matched
+	}
+
+	return matched[len(key)]
+}
+
+/*
+ValidateTopicPattern returns an error when pattern cannot be used as the
+routing key of a binding, or contains a word mixing a wildcard with other
+characters such as "logs*" or "#errors".  The server accepts the latter, but
+treats them as literal words, which is rarely what was intended.
+*/
+func ValidateTopicPattern(pattern string) error {
+	if len(pattern) > maxRoutingKeyLength {
+		return fmt.Errorf("topic pattern of %d bytes exceeds the maximum of %d", len(pattern), maxRoutingKeyLength)
+	}
+
+	for _, word := range splitTopic(pattern) {
+		if word != "*" && word != "#" && strings.ContainsAny(word, "*#") {
+			return fmt.Errorf("topic pattern %q: word %q mixes a wildcard with other characters", pattern, word)
+		}
+	}
+
+	return nil
+}
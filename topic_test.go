@@ -0,0 +1,67 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTopicMatches(t *testing.T) {
+	tests := []struct {
+		pattern, key string
+		expected     bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"a.b.c", "a.b.c", true},
+		{"a.b.c", "a.b", false},
+		{"a.*.c", "a.b.c", true},
+		{"a.*.c", "a.c", false},
+		{"*", "", false},
+		{"*", "a", true},
+		{"*", "a.b", false},
+		{"#", "", true},
+		{"#", "a.b.c", true},
+		{"a.#", "a", true},
+		{"a.#", "a.b.c", true},
+		{"a.#", "b.a", false},
+		{"#.c", "a.b.c", true},
+		{"#.c", "c.b", false},
+		{"a.#.c", "a.c", true},
+		{"a.#.c", "a.b.b.c", true},
+		{"a.#.#.c", "a.c", true},
+		{"#.*", "", false},
+		{"#.*", "a.b", true},
+		{"*.#.*", "a", false},
+		{"*.#.*", "a.b", true},
+		{"a.b", "a..b", false},
+		{"a.*.b", "a..b", true},
+		{"a*", "ab", false},
+		{"a*", "a*", true},
+		{"#.a.#.a.#", "b.a.b.a", true},
+		{"#.a.#.a.#", "b.a.b", false},
+	}
+
+	for _, test := range tests {
+		if actual := TopicMatches(test.pattern, test.key); actual != test.expected {
+			t.Errorf("TopicMatches(%q, %q) = %v, expected %v", test.pattern, test.key, actual, test.expected)
+		}
+	}
+}
+
+func TestValidateTopicPattern(t *testing.T) {
+	for _, pattern := range []string{"", "#", "a.*.c", "a.#", "a..b"} {
+		if err := ValidateTopicPattern(pattern); err != nil {
+			t.Errorf("expected %q to be valid, got %v", pattern, err)
+		}
+	}
+
+	for _, pattern := range []string{"logs*", "a.#b", "a.**", strings.Repeat("a", 256)} {
+		if err := ValidateTopicPattern(pattern); err == nil {
+			t.Errorf("expected %q to be invalid", pattern)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ExchangeSpec describes an exchange of a Topology.  The fields have the same
+// meaning as the parameters of Channel.ExchangeDeclare.
+type ExchangeSpec struct {
+	Name       string
+	Kind       ExchangeType
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Args       Table
+
+	// Bindings of this exchange, as the destination, to source exchanges.
+	Bindings []Binding
+}
+
+// QueueSpec describes a queue of a Topology.  The fields have the same
+// meaning as the parameters of Channel.QueueDeclare.
+type QueueSpec struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       Table
+
+	// Bindings of this queue to source exchanges.
+	Bindings []Binding
+}
+
+// Topology declaratively describes a set of exchanges, queues and bindings
+// that an application depends on.  Use Topology.Apply to declare them on the
+// server.
+type Topology struct {
+	Exchanges []ExchangeSpec
+	Queues    []QueueSpec
+}
+
+// ApplyOptions controls how Topology.Apply behaves when one of its steps fails.
+type ApplyOptions struct {
+	// Rollback deletes the exchanges and queues created by Apply when a later
+	// step fails, leaving the server as it was found.  When false, the objects
+	// created so far are left in place and the returned TopologyError carries
+	// a diff between the requested topology and the server state.
+	Rollback bool
+}
+
+// TopologyDiff lists the exchanges and queues of a Topology that do not exist
+// on the server.  Bindings cannot be inspected over AMQP and are not part of
+// the diff.
+type TopologyDiff struct {
+	MissingExchanges []string
+	MissingQueues    []string
+}
+
+// Empty returns true when every exchange and queue of the topology exists.
+func (d TopologyDiff) Empty() bool {
+	return len(d.MissingExchanges) == 0 && len(d.MissingQueues) == 0
+}
+
+// TopologyError is returned by Topology.Apply when a step fails.
+type TopologyError struct {
+	Step string // description of the failing step, e.g. `declare queue "jobs"`
+	Err  error  // error returned by the failing step
+
+	// Names of the exchanges and queues created by Apply before the failure.
+	CreatedExchanges []string
+	CreatedQueues    []string
+
+	// RolledBack is true when the created objects have been deleted.  When
+	// rollback was requested but failed, RollbackErr holds the reason.
+	RolledBack  bool
+	RollbackErr error
+
+	// Diff is the state of the server after the failure.  It is only set
+	// when rollback was not requested.
+	Diff *TopologyDiff
+}
+
+func (e *TopologyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "topology: %s: %v", e.Step, e.Err)
+	if e.RollbackErr != nil {
+		fmt.Fprintf(&b, " (rollback failed: %v)", e.RollbackErr)
+	} else if e.RolledBack {
+		b.WriteString(" (rolled back)")
+	}
+	return b.String()
+}
+
+func (e *TopologyError) Unwrap() error {
+	return e.Err
+}
+
+func isNotFound(err error) bool {
+	var amqpErr *Error
+	return errors.As(err, &amqpErr) && amqpErr.Code == NotFound
+}
+
+// probe runs passive declares on a channel dedicated to existence checks,
+// reopening it whenever the server closes it with NOT_FOUND.
+type probe struct {
+	conn ChannelSource
+	ch   *Channel
+}
+
+func (p *probe) exists(check func(*Channel) error) (bool, error) {
+	if p.ch == nil {
+		ch, err := p.conn.Channel()
+		if err != nil {
+			return false, err
+		}
+		p.ch = ch
+	}
+
+	err := check(p.ch)
+	if err == nil {
+		return true, nil
+	}
+
+	// The channel is closed by the server after any error.
+	p.ch = nil
+	if isNotFound(err) {
+		return false, nil
+	}
+	return false, err
+}
+
+func (p *probe) exchange(name string) (bool, error) {
+	return p.exists(func(ch *Channel) error {
+		return ch.ExchangeDeclarePassive(name, "", false, false, false, false, nil)
+	})
+}
+
+func (p *probe) queue(name string) (bool, error) {
+	return p.exists(func(ch *Channel) error {
+		_, err := ch.QueueDeclarePassive(name, false, false, false, false, nil)
+		return err
+	})
+}
+
+func (p *probe) close() {
+	if p.ch != nil {
+		_ = p.ch.Close()
+		p.ch = nil
+	}
+}
+
+/*
+Apply declares the exchanges, exchange bindings, queues and queue bindings of
+the topology, in that order, on channels of conn.
+
+Apply keeps track of the exchanges and queues that did not exist before it ran.
+When a step fails, a *TopologyError is returned naming the failing step and
+listing the objects created so far.  If opts.Rollback is true, those objects are
+deleted again, which also removes their bindings.  Bindings added between
+objects that existed before Apply ran are left in place, since there is no way
+to tell over AMQP whether they existed already.  If opts.Rollback is false, the
+error carries a TopologyDiff of what is still missing on the server.
+
+A queue with an empty name is declared with a server-generated name, which is
+used for its bindings.
+
+The context is checked between steps.
+*/
+func (t Topology) Apply(ctx context.Context, conn *Connection, opts ApplyOptions) error {
+	return t.apply(ctx, conn, opts)
+}
+
+// apply implements Apply on the channels of any source, so that publishers
+// and consumers can redeclare the topology on their own source.
+func (t Topology) apply(ctx context.Context, conn ChannelSource, opts ApplyOptions) error {
+	p := &probe{conn: conn}
+	defer p.close()
+
+	var createdExchanges, createdQueues []string
+
+	fail := func(step string, err error) error {
+		terr := &TopologyError{
+			Step:             step,
+			Err:              err,
+			CreatedExchanges: createdExchanges,
+			CreatedQueues:    createdQueues,
+		}
+
+		if opts.Rollback {
+			terr.RollbackErr = rollbackTopology(conn, createdExchanges, createdQueues)
+			terr.RolledBack = terr.RollbackErr == nil
+		} else if diff, err := t.diff(p); err == nil {
+			terr.Diff = &diff
+		}
+
+		return terr
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return fail("open channel", err)
+	}
+	defer func() { _ = ch.Close() }()
+
+	for _, e := range t.Exchanges {
+		step := fmt.Sprintf("declare exchange %q", e.Name)
+		if err := ctx.Err(); err != nil {
+			return fail(step, err)
+		}
+
+		found, err := p.exchange(e.Name)
+		if err != nil {
+			return fail(step, err)
+		}
+
+		if err := ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, false, e.Args); err != nil {
+			return fail(step, err)
+		}
+
+		if !found {
+			createdExchanges = append(createdExchanges, e.Name)
+		}
+	}
+
+	for _, e := range t.Exchanges {
+		for _, b := range e.Bindings {
+			step := fmt.Sprintf("bind exchange %q to %q with key %q", e.Name, b.Exchange, b.Key)
+			if err := ctx.Err(); err != nil {
+				return fail(step, err)
+			}
+
+			if err := ch.ExchangeBind(e.Name, b.Key, b.Exchange, false, b.Args); err != nil {
+				return fail(step, err)
+			}
+		}
+	}
+
+	for _, q := range t.Queues {
+		step := fmt.Sprintf("declare queue %q", q.Name)
+		if err := ctx.Err(); err != nil {
+			return fail(step, err)
+		}
+
+		found := false
+		if q.Name != "" {
+			if found, err = p.queue(q.Name); err != nil {
+				return fail(step, err)
+			}
+		}
+
+		declared, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.Args)
+		if err != nil {
+			return fail(step, err)
+		}
+
+		if !found {
+			createdQueues = append(createdQueues, declared.Name)
+		}
+
+		if len(q.Bindings) > 0 {
+			if err := ch.QueueBindAll(ctx, declared.Name, q.Bindings); err != nil {
+				var bindErr *BindingError
+				if errors.As(err, &bindErr) {
+					b := bindErr.Binding
+					return fail(fmt.Sprintf("bind queue %q to %q with key %q", declared.Name, b.Exchange, b.Key), bindErr.Err)
+				}
+				return fail(fmt.Sprintf("bind queue %q", declared.Name), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// declares returns true when the topology declares the exchange, or the queue
+// named by the routing key for the default exchange.
+func (t Topology) declares(exchange, key string) bool {
+	if exchange == "" {
+		return t.declaresQueue(key)
+	}
+	for _, e := range t.Exchanges {
+		if e.Name == exchange {
+			return true
+		}
+	}
+	return false
+}
+
+// declaresQueue returns true when the topology declares the queue.
+func (t Topology) declaresQueue(name string) bool {
+	for _, q := range t.Queues {
+		if q.Name != "" && q.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff reports which exchanges and queues of the topology do not exist on
+// the server.  Queues with an empty name are skipped.
+func (t Topology) Diff(ctx context.Context, conn *Connection) (TopologyDiff, error) {
+	if err := ctx.Err(); err != nil {
+		return TopologyDiff{}, err
+	}
+
+	p := &probe{conn: conn}
+	defer p.close()
+
+	return t.diff(p)
+}
+
+func (t Topology) diff(p *probe) (TopologyDiff, error) {
+	var diff TopologyDiff
+
+	for _, e := range t.Exchanges {
+		found, err := p.exchange(e.Name)
+		if err != nil {
+			return diff, err
+		}
+		if !found {
+			diff.MissingExchanges = append(diff.MissingExchanges, e.Name)
+		}
+	}
+
+	for _, q := range t.Queues {
+		if q.Name == "" {
+			continue
+		}
+		found, err := p.queue(q.Name)
+		if err != nil {
+			return diff, err
+		}
+		if !found {
+			diff.MissingQueues = append(diff.MissingQueues, q.Name)
+		}
+	}
+
+	return diff, nil
+}
+
+// rollbackTopology deletes the given queues and exchanges in the reverse
+// order of their creation.  It attempts every deletion and returns the first
+// error.
+func rollbackTopology(conn ChannelSource, exchanges, queues []string) error {
+	if len(exchanges) == 0 && len(queues) == 0 {
+		return nil
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	var first error
+	record := func(err error) {
+		if err == nil {
+			return
+		}
+		if first == nil {
+			first = err
+		}
+		// Any error closes the channel, keep going on a new one.
+		if ch.IsClosed() {
+			if ch, err = conn.Channel(); err != nil {
+				ch = nil
+			}
+		}
+	}
+
+	for i := len(queues) - 1; i >= 0 && ch != nil; i-- {
+		_, err := ch.QueueDelete(queues[i], false, false, false)
+		record(err)
+	}
+
+	for i := len(exchanges) - 1; i >= 0 && ch != nil; i-- {
+		record(ch.ExchangeDelete(exchanges[i], false, false))
+	}
+
+	if ch != nil {
+		_ = ch.Close()
+	}
+
+	return first
+}
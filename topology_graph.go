@@ -0,0 +1,188 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Kinds of the edges of a TopologyGraph.
+const (
+	EdgeBinding           = "binding"            // a queue or exchange binding
+	EdgeAlternateExchange = "alternate-exchange" // the "alternate-exchange" argument of an exchange
+	EdgeDeadLetter        = "dead-letter"        // the "x-dead-letter-exchange" argument of a queue
+)
+
+// TopologyNode is an exchange or a queue of a TopologyGraph.
+type TopologyNode struct {
+	ID         string       `json:"id"`   // unique, like "exchange:orders" or "queue:jobs"
+	Kind       string       `json:"kind"` // "exchange" or "queue"
+	Name       string       `json:"name"`
+	Type       ExchangeType `json:"type,omitempty"`
+	Durable    bool         `json:"durable"`
+	AutoDelete bool         `json:"autoDelete"`
+	Internal   bool         `json:"internal,omitempty"`
+	Exclusive  bool         `json:"exclusive,omitempty"`
+
+	// Declared is false for the exchanges referenced by the topology without
+	// being part of it, like amq.topic.
+	Declared bool `json:"declared"`
+}
+
+// TopologyEdge is a route between two nodes of a TopologyGraph, from the
+// source exchange to the destination exchange or queue.
+type TopologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"` // one of EdgeBinding, EdgeAlternateExchange and EdgeDeadLetter
+	Key  string `json:"key,omitempty"`
+	Args Table  `json:"args,omitempty"`
+}
+
+// TopologyGraph is the graph of the exchanges, queues and bindings of a
+// Topology.  It can be marshalled to JSON, see also Topology.WriteDOT.
+type TopologyGraph struct {
+	Nodes []TopologyNode `json:"nodes"`
+	Edges []TopologyEdge `json:"edges"`
+}
+
+func exchangeNodeID(name string) string {
+	return "exchange:" + name
+}
+
+/*
+Graph returns the exchanges and queues of the topology as nodes, and its
+bindings as edges, in the order of the topology.  The exchanges that are bound
+to without being declared by the topology are added as nodes that are not
+Declared.  The "alternate-exchange" argument of exchanges and the
+"x-dead-letter-exchange" argument of queues are edges as well, so that
+visualizing the graph shows where unroutable and dead lettered messages go.
+
+Server-named queues, declared with an empty name, are identified by their
+position in Topology.Queues.
+*/
+func (t Topology) Graph() TopologyGraph {
+	var g TopologyGraph
+	known := make(map[string]bool)
+
+	addExchange := func(name string) string {
+		id := exchangeNodeID(name)
+		if !known[id] {
+			known[id] = true
+			g.Nodes = append(g.Nodes, TopologyNode{ID: id, Kind: "exchange", Name: name})
+		}
+		return id
+	}
+
+	for _, e := range t.Exchanges {
+		id := exchangeNodeID(e.Name)
+		known[id] = true
+		g.Nodes = append(g.Nodes, TopologyNode{
+			ID:         id,
+			Kind:       "exchange",
+			Name:       e.Name,
+			Type:       e.Kind,
+			Durable:    e.Durable,
+			AutoDelete: e.AutoDelete,
+			Internal:   e.Internal,
+			Declared:   true,
+		})
+	}
+
+	for i, q := range t.Queues {
+		id := "queue:" + q.Name
+		if q.Name == "" {
+			id = fmt.Sprintf("queue:#%d", i)
+		}
+		g.Nodes = append(g.Nodes, TopologyNode{
+			ID:         id,
+			Kind:       "queue",
+			Name:       q.Name,
+			Durable:    q.Durable,
+			AutoDelete: q.AutoDelete,
+			Exclusive:  q.Exclusive,
+			Declared:   true,
+		})
+
+		for _, b := range q.Bindings {
+			g.Edges = append(g.Edges, TopologyEdge{From: addExchange(b.Exchange), To: id, Kind: EdgeBinding, Key: b.Key, Args: b.Args})
+		}
+		if dlx, ok := q.Args["x-dead-letter-exchange"].(string); ok {
+			key, _ := q.Args["x-dead-letter-routing-key"].(string)
+			g.Edges = append(g.Edges, TopologyEdge{From: id, To: addExchange(dlx), Kind: EdgeDeadLetter, Key: key})
+		}
+	}
+
+	for _, e := range t.Exchanges {
+		id := exchangeNodeID(e.Name)
+		for _, b := range e.Bindings {
+			g.Edges = append(g.Edges, TopologyEdge{From: addExchange(b.Exchange), To: id, Kind: EdgeBinding, Key: b.Key, Args: b.Args})
+		}
+		if alternate, ok := e.Args["alternate-exchange"].(string); ok {
+			g.Edges = append(g.Edges, TopologyEdge{From: id, To: addExchange(alternate), Kind: EdgeAlternateExchange})
+		}
+	}
+
+	return g
+}
+
+/*
+WriteDOT writes the graph of the topology in the DOT language of Graphviz.
+Exchanges are boxes labelled with their type, queues are ellipses, and
+bindings are labelled with their routing key.  Alternate exchanges are
+dashed edges and dead letter exchanges dotted ones.
+
+	dot -Tsvg topology.dot > topology.svg
+*/
+func (t Topology) WriteDOT(w io.Writer) error {
+	g := t.Graph()
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "digraph topology {")
+	fmt.Fprintln(bw, "\trankdir=LR;")
+
+	for _, n := range g.Nodes {
+		label, shape := n.Name, "ellipse"
+		if n.Kind == "exchange" {
+			shape = "box"
+			if label == "" {
+				label = "(default)"
+			}
+			if n.Type != "" {
+				label += "\n" + string(n.Type)
+			}
+		} else if label == "" {
+			label = "(server-named)"
+		}
+
+		style := ""
+		if !n.Declared {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(bw, "\t%s [label=%s, shape=%s%s];\n", dotQuote(n.ID), dotQuote(label), shape, style)
+	}
+
+	for _, e := range g.Edges {
+		attrs := "label=" + dotQuote(e.Key)
+		switch e.Kind {
+		case EdgeAlternateExchange:
+			attrs = `label="alternate", style=dashed`
+		case EdgeDeadLetter:
+			attrs = "label=" + dotQuote(strings.TrimSpace("dead letter "+e.Key)) + ", style=dotted"
+		}
+		fmt.Fprintf(bw, "\t%s -> %s [%s];\n", dotQuote(e.From), dotQuote(e.To), attrs)
+	}
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
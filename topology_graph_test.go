@@ -0,0 +1,79 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func graphTopology() Topology {
+	return Topology{
+		Exchanges: []ExchangeSpec{
+			{Name: "orders", Kind: Topic, Durable: true, Args: Table{"alternate-exchange": "orders.unroutable"}},
+			{Name: "orders.unroutable", Kind: Fanout, Durable: true},
+		},
+		Queues: []QueueSpec{
+			{
+				Name:     "orders.created",
+				Durable:  true,
+				Args:     Table{"x-dead-letter-exchange": "dlx"},
+				Bindings: []Binding{{Exchange: "orders", Key: "order.created"}, {Exchange: "amq.topic", Key: "legacy.#"}},
+			},
+		},
+	}
+}
+
+func TestTopologyGraph(t *testing.T) {
+	g := graphTopology().Graph()
+
+	var ids []string
+	for _, n := range g.Nodes {
+		ids = append(ids, n.ID)
+	}
+	if want, got := "exchange:orders exchange:orders.unroutable queue:orders.created exchange:amq.topic exchange:dlx", strings.Join(ids, " "); want != got {
+		t.Fatalf("expected nodes %q, got %q", want, got)
+	}
+	if g.Nodes[3].Declared {
+		t.Fatalf("expected the exchanges bound to without being declared to be marked as such")
+	}
+
+	var edges []string
+	for _, e := range g.Edges {
+		edges = append(edges, e.From+" -"+e.Kind+"-> "+e.To)
+	}
+	want := []string{
+		"exchange:orders -binding-> queue:orders.created",
+		"exchange:amq.topic -binding-> queue:orders.created",
+		"queue:orders.created -dead-letter-> exchange:dlx",
+		"exchange:orders -alternate-exchange-> exchange:orders.unroutable",
+	}
+	if strings.Join(want, "\n") != strings.Join(edges, "\n") {
+		t.Fatalf("expected edges:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(edges, "\n"))
+	}
+
+	if _, err := json.Marshal(g); err != nil {
+		t.Fatalf("could not marshal the graph: %v", err)
+	}
+}
+
+func TestTopologyWriteDOT(t *testing.T) {
+	var dot strings.Builder
+	if err := graphTopology().WriteDOT(&dot); err != nil {
+		t.Fatalf("could not write DOT: %v", err)
+	}
+
+	for _, line := range []string{
+		`"exchange:orders" [label="orders\ntopic", shape=box];`,
+		`"exchange:amq.topic" [label="amq.topic", shape=box, style=dashed];`,
+		`"exchange:orders" -> "queue:orders.created" [label="order.created"];`,
+		`"exchange:orders" -> "exchange:orders.unroutable" [label="alternate", style=dashed];`,
+	} {
+		if !strings.Contains(dot.String(), "\t"+line+"\n") {
+			t.Errorf("expected the line %s in:\n%s", line, dot.String())
+		}
+	}
+}
@@ -0,0 +1,543 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+/*
+TopologyRecovery configures the recovery of the topology declared on the
+channels of a connection, see RecoveryPolicy.Topology.
+
+The exchanges, queues and bindings declared, and the consumers started, on the
+channels of the connection are recorded, and forgotten when deleted or
+cancelled.  Once the connection is recovered, before the NotifyReconnect
+listeners are notified, they are declared again in that order, like the
+topology recovery of the Java client.  Server-named queues are declared again
+with a new name, which their bindings and consumers follow.
+
+A recovered consumer goes on delivering to the chan returned by
+Channel.Consume.  Its deliveries then come from a new channel, with the same
+prefetch as the channel it was started on, so they must be acknowledged with
+Delivery.Ack rather than with the methods of the original channel.  Deliveries
+received before the connection was lost cannot be acknowledged anymore, the
+server requeues them.  The consumer is cancelled with Channel.Cancel on the
+original channel, and its chan is closed when the connection is closed for
+good or the consumer could not be recovered.
+
+The Exclude functions leave out declarations from the recovery, they are called
+when the declaration is recorded.  Failures of the recovery are logged and do
+not stop the recovery of the rest of the topology.
+*/
+type TopologyRecovery struct {
+	ExcludeExchange func(ExchangeSpec) bool
+	ExcludeQueue    func(QueueSpec) bool
+	ExcludeBinding  func(RecordedBinding) bool
+	ExcludeConsumer func(RecordedConsumer) bool
+}
+
+// RecordedBinding is a binding recorded for the topology recovery.
+type RecordedBinding struct {
+	Source      string // source exchange
+	Destination string // destination queue, or exchange when ToExchange is true
+	ToExchange  bool
+	Key         string
+	Args        Table
+}
+
+func (b RecordedBinding) same(other RecordedBinding) bool {
+	// Tables print with sorted keys, which compares them without reflection.
+	return b.Source == other.Source && b.Destination == other.Destination &&
+		b.ToExchange == other.ToExchange && b.Key == other.Key &&
+		fmt.Sprint(b.Args) == fmt.Sprint(other.Args)
+}
+
+// RecordedConsumer is a consumer recorded for the topology recovery.
+type RecordedConsumer struct {
+	Queue     string
+	Consumer  string
+	AutoAck   bool
+	Exclusive bool
+	NoLocal   bool
+	Args      Table
+}
+
+// topologyRecorder records the topology declared on the channels of a
+// connection and declares it again after a recovery.
+type topologyRecorder struct {
+	filter TopologyRecovery
+
+	m         sync.Mutex
+	exchanges []ExchangeSpec
+	queues    []recordedQueue
+	bindings  []RecordedBinding
+	consumers map[string]*recordedConsumer // by consumer tag
+}
+
+type recordedQueue struct {
+	spec        QueueSpec
+	serverNamed bool
+}
+
+// recordedConsumer is a recovered consumer and its forwarder.
+type recordedConsumer struct {
+	RecordedConsumer
+	limit overflow
+
+	origin  *Channel // channel the consumer was started on
+	current *Channel // channel the consumer delivers from
+
+	suspended bool                   // true while the connection recovers
+	resume    chan (<-chan Delivery) // deliveries of the recovered consumer, nil when given up
+}
+
+func newTopologyRecorder(filter TopologyRecovery) *topologyRecorder {
+	return &topologyRecorder{
+		filter:    filter,
+		consumers: make(map[string]*recordedConsumer),
+	}
+}
+
+// record updates the topology after req succeeded on ch.
+func (t *topologyRecorder) record(ch *Channel, req message, res ...message) {
+	if ch.unrecorded {
+		return
+	}
+
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	switch req := req.(type) {
+	case *exchangeDeclare:
+		if req.Passive {
+			return
+		}
+		spec := ExchangeSpec{
+			Name:       req.Exchange,
+			Kind:       ExchangeType(req.Type),
+			Durable:    req.Durable,
+			AutoDelete: req.AutoDelete,
+			Internal:   req.Internal,
+			Args:       req.Arguments,
+		}
+		if t.filter.ExcludeExchange != nil && t.filter.ExcludeExchange(spec) {
+			return
+		}
+		t.forgetExchange(spec.Name)
+		t.exchanges = append(t.exchanges, spec)
+
+	case *exchangeDelete:
+		t.forgetExchange(req.Exchange)
+		t.forgetBindings(func(b RecordedBinding) bool {
+			return b.Source == req.Exchange || (b.ToExchange && b.Destination == req.Exchange)
+		})
+
+	case *queueDeclare:
+		if req.Passive {
+			return
+		}
+		name := req.Queue
+		if ok, isOk := res[0].(*queueDeclareOk); isOk && req.wait() {
+			name = ok.Queue
+		}
+		if name == "" {
+			return
+		}
+		spec := QueueSpec{
+			Name:       name,
+			Durable:    req.Durable,
+			AutoDelete: req.AutoDelete,
+			Exclusive:  req.Exclusive,
+			Args:       req.Arguments,
+		}
+		if t.filter.ExcludeQueue != nil && t.filter.ExcludeQueue(spec) {
+			return
+		}
+		t.forgetQueue(name)
+		t.queues = append(t.queues, recordedQueue{spec: spec, serverNamed: req.Queue == ""})
+
+	case *queueDelete:
+		t.forgetQueue(req.Queue)
+		t.forgetBindings(func(b RecordedBinding) bool {
+			return !b.ToExchange && b.Destination == req.Queue
+		})
+
+	case *queueBind:
+		t.recordBinding(RecordedBinding{Source: req.Exchange, Destination: req.Queue, Key: req.RoutingKey, Args: req.Arguments})
+
+	case *queueUnbind:
+		binding := RecordedBinding{Source: req.Exchange, Destination: req.Queue, Key: req.RoutingKey, Args: req.Arguments}
+		t.forgetBindings(binding.same)
+
+	case *exchangeBind:
+		t.recordBinding(RecordedBinding{Source: req.Source, Destination: req.Destination, ToExchange: true, Key: req.RoutingKey, Args: req.Arguments})
+
+	case *exchangeUnbind:
+		binding := RecordedBinding{Source: req.Source, Destination: req.Destination, ToExchange: true, Key: req.RoutingKey, Args: req.Arguments}
+		t.forgetBindings(binding.same)
+
+	}
+}
+
+func (t *topologyRecorder) forgetExchange(name string) {
+	for i, e := range t.exchanges {
+		if e.Name == name {
+			t.exchanges = append(t.exchanges[:i], t.exchanges[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *topologyRecorder) forgetQueue(name string) {
+	for i, q := range t.queues {
+		if q.spec.Name == name {
+			t.queues = append(t.queues[:i], t.queues[i+1:]...)
+			return
+		}
+	}
+}
+
+func (t *topologyRecorder) recordBinding(binding RecordedBinding) {
+	if t.filter.ExcludeBinding != nil && t.filter.ExcludeBinding(binding) {
+		return
+	}
+	t.forgetBindings(binding.same)
+	t.bindings = append(t.bindings, binding)
+}
+
+func (t *topologyRecorder) forgetBindings(match func(RecordedBinding) bool) {
+	kept := t.bindings[:0]
+	for _, b := range t.bindings {
+		if !match(b) {
+			kept = append(kept, b)
+		}
+	}
+	t.bindings = kept
+}
+
+// consume records the consumer started by req on ch and returns the chan of
+// its deliveries, which outlives the recoveries of the connection.  It returns
+// deliveries as is when the consumer is excluded.
+func (t *topologyRecorder) consume(ctx context.Context, ch *Channel, req *basicConsume, deliveries chan Delivery, limit overflow) <-chan Delivery {
+	if ch.unrecorded {
+		return deliveries
+	}
+
+	consumer := RecordedConsumer{
+		Queue:     req.Queue,
+		Consumer:  req.ConsumerTag,
+		AutoAck:   req.NoAck,
+		Exclusive: req.Exclusive,
+		NoLocal:   req.NoLocal,
+		Args:      req.Arguments,
+	}
+	if t.filter.ExcludeConsumer != nil && t.filter.ExcludeConsumer(consumer) {
+		return deliveries
+	}
+
+	rc := &recordedConsumer{
+		RecordedConsumer: consumer,
+		limit:            limit,
+		origin:           ch,
+		current:          ch,
+		resume:           make(chan (<-chan Delivery), 1),
+	}
+
+	t.m.Lock()
+	t.consumers[consumer.Consumer] = rc
+	t.m.Unlock()
+
+	out := make(chan Delivery)
+	go t.forward(ctx, rc, deliveries, out)
+	return out
+}
+
+// forward sends the deliveries of rc to out, across the recoveries of the
+// connection, until the consumer ends.
+func (t *topologyRecorder) forward(ctx context.Context, rc *recordedConsumer, in <-chan Delivery, out chan Delivery) {
+	defer close(out)
+
+	done := ctx.Done()
+	for {
+		select {
+		case d, ok := <-in:
+			if ok {
+				out <- d
+				continue
+			}
+
+			t.m.Lock()
+			waiting := t.consumers[rc.Consumer] == rc && (rc.suspended || len(rc.resume) > 0)
+			var idle *Channel
+			if !waiting && t.consumers[rc.Consumer] == rc {
+				idle = t.forgetConsumer(rc)
+			}
+			t.m.Unlock()
+
+			if !waiting {
+				if idle != nil {
+					_ = idle.Close()
+				}
+				return
+			}
+			if in = <-rc.resume; in == nil {
+				return
+			}
+
+		case <-done:
+			// Until recovered, the consumer is cancelled like any other.
+			done = nil
+			if ch := t.channelOf(rc.origin, rc.Consumer); ch != nil && ch != rc.origin {
+				_ = ch.Cancel(rc.Consumer, false)
+			}
+		}
+	}
+}
+
+// forgetConsumer drops rc.  It returns the channel opened by a recovery for
+// rc when no other consumer delivers from it anymore, to be closed by the
+// caller without t.m held.
+func (t *topologyRecorder) forgetConsumer(rc *recordedConsumer) *Channel {
+	delete(t.consumers, rc.Consumer)
+	if rc.current == rc.origin || rc.current.IsClosed() {
+		return nil
+	}
+	for _, other := range t.consumers {
+		if other.current == rc.current {
+			return nil
+		}
+	}
+	return rc.current
+}
+
+// channelOf returns the channel the consumer started on origin delivers from,
+// nil when there is none.
+func (t *topologyRecorder) channelOf(origin *Channel, consumer string) *Channel {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	if rc, ok := t.consumers[consumer]; ok && rc.origin == origin {
+		return rc.current
+	}
+	return nil
+}
+
+// suspend keeps the consumers waiting for the recovery of the connection,
+// before their channels are closed.
+func (t *topologyRecorder) suspend() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for _, rc := range t.consumers {
+		rc.suspended = true
+	}
+}
+
+// abandon ends the consumers after the recovery of the connection gave up.
+func (t *topologyRecorder) abandon() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for _, rc := range t.consumers {
+		t.resumeConsumer(rc, nil)
+		delete(t.consumers, rc.Consumer)
+	}
+}
+
+// resumeConsumer hands the deliveries of the recovered consumer to its
+// forwarder, replacing those of a previous recovery the forwarder did not
+// take.  It is called with t.m held.
+func (t *topologyRecorder) resumeConsumer(rc *recordedConsumer, deliveries <-chan Delivery) {
+	select {
+	case <-rc.resume:
+	default:
+	}
+	rc.suspended = false
+	rc.resume <- deliveries
+}
+
+// recover declares the recorded topology on the recovered connection c.
+func (t *topologyRecorder) recover(c *Connection) {
+	t.m.Lock()
+	exchanges := append([]ExchangeSpec(nil), t.exchanges...)
+	queues := append([]recordedQueue(nil), t.queues...)
+	t.m.Unlock()
+
+	var ch *Channel
+	defer func() {
+		if ch != nil {
+			_ = ch.Close()
+		}
+	}()
+
+	// declare runs step on the channel of the recovery, opening another one
+	// after the server closed it on a failure.
+	declare := func(what string, step func(*Channel) error) bool {
+		if ch == nil || ch.IsClosed() {
+			var err error
+			if ch, err = c.Channel(); err != nil {
+				Logger.Printf("topology recovery: %s: %v", what, err)
+				ch = nil
+				return false
+			}
+			ch.unrecorded = true
+		}
+		if err := step(ch); err != nil {
+			Logger.Printf("topology recovery: %s: %v", what, err)
+			return false
+		}
+		return true
+	}
+
+	for _, e := range exchanges {
+		declare(fmt.Sprintf("declare exchange %q", e.Name), func(ch *Channel) error {
+			return ch.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, false, e.Args)
+		})
+	}
+
+	for _, q := range queues {
+		name := q.spec.Name
+		if q.serverNamed {
+			name = ""
+		}
+
+		var declared Queue
+		if !declare(fmt.Sprintf("declare queue %q", q.spec.Name), func(ch *Channel) (err error) {
+			declared, err = ch.QueueDeclare(name, q.spec.Durable, q.spec.AutoDelete, q.spec.Exclusive, false, q.spec.Args)
+			return err
+		}) {
+			continue
+		}
+
+		if declared.Name != q.spec.Name {
+			t.renameQueue(q.spec.Name, declared.Name)
+		}
+	}
+
+	t.m.Lock()
+	bindings := append([]RecordedBinding(nil), t.bindings...)
+	t.m.Unlock()
+
+	for _, b := range bindings {
+		declare(fmt.Sprintf("bind %q to %q with key %q", b.Destination, b.Source, b.Key), func(ch *Channel) error {
+			if b.ToExchange {
+				return ch.ExchangeBind(b.Destination, b.Key, b.Source, false, b.Args)
+			}
+			return ch.QueueBind(b.Destination, b.Key, b.Source, false, b.Args)
+		})
+	}
+
+	t.recoverConsumers(c)
+}
+
+// renameQueue follows the new name of a server-named queue declared again.
+func (t *topologyRecorder) renameQueue(from, to string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	for i := range t.queues {
+		if t.queues[i].spec.Name == from {
+			t.queues[i].spec.Name = to
+		}
+	}
+	for i := range t.bindings {
+		if !t.bindings[i].ToExchange && t.bindings[i].Destination == from {
+			t.bindings[i].Destination = to
+		}
+	}
+	for _, rc := range t.consumers {
+		if rc.Queue == from {
+			rc.Queue = to
+		}
+	}
+}
+
+// recoverConsumers starts the suspended consumers again, on a new channel for
+// each channel they were started on.
+func (t *topologyRecorder) recoverConsumers(c *Connection) {
+	t.m.Lock()
+	byOrigin := make(map[*Channel][]*recordedConsumer)
+	var origins []*Channel
+	for _, rc := range t.consumers {
+		if !rc.suspended {
+			continue
+		}
+		if byOrigin[rc.origin] == nil {
+			origins = append(origins, rc.origin)
+		}
+		byOrigin[rc.origin] = append(byOrigin[rc.origin], rc)
+	}
+	t.m.Unlock()
+
+	for _, origin := range origins {
+		t.recoverChannel(c, origin, byOrigin[origin])
+	}
+}
+
+func (t *topologyRecorder) recoverChannel(c *Connection, origin *Channel, consumers []*recordedConsumer) {
+	giveUp := func(rcs []*recordedConsumer, err error) {
+		t.m.Lock()
+		defer t.m.Unlock()
+
+		for _, rc := range rcs {
+			Logger.Printf("topology recovery: consume %q from %q: %v", rc.Consumer, rc.Queue, err)
+			if t.consumers[rc.Consumer] == rc {
+				t.resumeConsumer(rc, nil)
+				delete(t.consumers, rc.Consumer)
+			}
+		}
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		giveUp(consumers, err)
+		return
+	}
+	ch.unrecorded = true
+
+	origin.m.Lock()
+	qos := append([]basicQos(nil), origin.recordedQos...)
+	origin.m.Unlock()
+
+	for _, q := range qos {
+		if err := ch.setQos(int(q.PrefetchCount), int(q.PrefetchSize), q.Global); err != nil {
+			giveUp(consumers, err)
+			_ = ch.Close()
+			return
+		}
+	}
+
+	recovered := 0
+	for i, rc := range consumers {
+		t.m.Lock()
+		queue := rc.Queue
+		t.m.Unlock()
+
+		deliveries, err := ch.consume(context.Background(), queue, rc.Consumer, rc.AutoAck, rc.Exclusive, rc.NoLocal, false, rc.Args, rc.limit)
+		if err != nil {
+			giveUp(consumers[i:i+1], err)
+			if ch.IsClosed() {
+				giveUp(consumers[i+1:], err)
+				return
+			}
+			continue
+		}
+
+		t.m.Lock()
+		if t.consumers[rc.Consumer] == rc {
+			rc.current = ch
+			t.resumeConsumer(rc, deliveries)
+			recovered++
+		}
+		t.m.Unlock()
+	}
+
+	if recovered == 0 {
+		_ = ch.Close()
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTopologyRecoveryDeclaresAgainAfterReconnect(t *testing.T) {
+	lose := make(chan struct{})
+	recovered := struct {
+		queue   *queueDeclare
+		bind    *queueBind
+		qos     *basicQos
+		consume *basicConsume
+	}{&queueDeclare{}, &queueBind{}, &basicQos{}, &basicConsume{}}
+
+	dial, served := recoveryDialer(t,
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+
+			srv.recv(1, &exchangeDeclare{})
+			srv.send(1, &exchangeDeclareOk{})
+			srv.recv(1, &queueDeclare{})
+			srv.send(1, &queueDeclareOk{Queue: "amq.gen-1"})
+			srv.recv(1, &queueBind{})
+			srv.send(1, &queueBindOk{})
+			srv.recv(1, &queueDeclare{})
+			srv.send(1, &queueDeclareOk{Queue: "scratch"})
+
+			srv.recv(1, &basicQos{})
+			srv.send(1, &basicQosOk{})
+			consume := &basicConsume{}
+			srv.recv(1, consume)
+			srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+			srv.send(1, &basicDeliver{ConsumerTag: consume.ConsumerTag, DeliveryTag: 1})
+
+			<-lose
+			srv.S.Close()
+		},
+		func(srv *server) {
+			srv.connectionOpen()
+			srv.channelOpen(1)
+
+			srv.recv(1, &exchangeDeclare{})
+			srv.send(1, &exchangeDeclareOk{})
+			srv.recv(1, recovered.queue)
+			srv.send(1, &queueDeclareOk{Queue: "amq.gen-2"})
+			srv.recv(1, recovered.bind)
+			srv.send(1, &queueBindOk{})
+
+			srv.channelOpen(2)
+			srv.recv(2, recovered.qos)
+			srv.send(2, &basicQosOk{})
+			srv.recv(2, recovered.consume)
+			srv.send(2, &basicConsumeOk{ConsumerTag: recovered.consume.ConsumerTag})
+
+			srv.recv(1, &channelClose{})
+			srv.send(1, &channelCloseOk{})
+
+			srv.send(2, &basicDeliver{ConsumerTag: recovered.consume.ConsumerTag, DeliveryTag: 1})
+			srv.recv(2, &basicAck{})
+
+			srv.recv(2, &basicCancel{})
+			srv.send(2, &basicCancelOk{ConsumerTag: recovered.consume.ConsumerTag})
+			srv.recv(2, &channelClose{})
+			srv.send(2, &channelCloseOk{})
+			srv.connectionClose()
+		},
+	)
+
+	c, err := DialConfig("amqp://localhost", Config{
+		RecoveryPolicy: &RecoveryPolicy{
+			BaseDelay: time.Millisecond,
+			Topology: &TopologyRecovery{
+				ExcludeQueue: func(q QueueSpec) bool { return q.Name == "scratch" },
+			},
+		},
+		Dial: dial,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	reconnects := c.NotifyReconnect(make(chan struct{}, 1))
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if err := ch.ExchangeDeclare("jobs", Direct, true, false, false, false, nil); err != nil {
+		t.Fatalf("could not declare exchange: %v", err)
+	}
+	queue, err := ch.QueueDeclare("", false, true, true, false, nil)
+	if err != nil {
+		t.Fatalf("could not declare queue: %v", err)
+	}
+	if err := ch.QueueBind(queue.Name, "resize", "jobs", false, nil); err != nil {
+		t.Fatalf("could not bind queue: %v", err)
+	}
+	if _, err := ch.QueueDeclare("scratch", false, false, false, false, nil); err != nil {
+		t.Fatalf("could not declare queue: %v", err)
+	}
+	if err := ch.Qos(3, 0, false); err != nil {
+		t.Fatalf("could not set qos: %v", err)
+	}
+	deliveries, err := ch.Consume(queue.Name, "worker", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+	<-deliveries
+
+	close(lose)
+	select {
+	case <-reconnects:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the connection to recover")
+	}
+
+	if recovered.queue.Queue != "" || !recovered.queue.Exclusive {
+		t.Fatalf("expected the server-named queue to be declared again, got %+v", recovered.queue)
+	}
+	if recovered.bind.Queue != "amq.gen-2" || recovered.bind.Exchange != "jobs" || recovered.bind.RoutingKey != "resize" {
+		t.Fatalf("expected the binding to follow the new queue name, got %+v", recovered.bind)
+	}
+	if recovered.qos.PrefetchCount != 3 {
+		t.Fatalf("expected the prefetch of the consumer channel, got %+v", recovered.qos)
+	}
+	if recovered.consume.Queue != "amq.gen-2" || recovered.consume.ConsumerTag != "worker" {
+		t.Fatalf("expected the consumer to be started again, got %+v", recovered.consume)
+	}
+
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatalf("expected the consumer chan to stay open")
+		}
+		if err := d.Ack(false); err != nil {
+			t.Fatalf("could not ack on the recovered channel: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a delivery of the recovered consumer")
+	}
+
+	if err := ch.Cancel("worker", false); err != nil {
+		t.Fatalf("could not cancel the recovered consumer: %v", err)
+	}
+	if _, ok := <-deliveries; ok {
+		t.Fatalf("expected the consumer chan to be closed once cancelled")
+	}
+
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	served.Wait()
+}
+
+func TestTopologyRecoveryClosesConsumersWhenGivingUp(t *testing.T) {
+	lose := make(chan struct{})
+	dial, served := recoveryDialer(t, func(srv *server) {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		consume := &basicConsume{}
+		srv.recv(1, consume)
+		srv.send(1, &basicConsumeOk{ConsumerTag: consume.ConsumerTag})
+		<-lose
+		srv.S.Close()
+	})
+
+	c, err := DialConfig("amqp://localhost", Config{
+		RecoveryPolicy: &RecoveryPolicy{MaxAttempts: 1, BaseDelay: time.Millisecond, Topology: &TopologyRecovery{}},
+		Dial:           dial,
+	})
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	deliveries, err := ch.Consume("jobs", "", false, false, false, false, nil)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	close(lose)
+	served.Wait()
+
+	select {
+	case _, ok := <-deliveries:
+		if ok {
+			t.Fatalf("unexpected delivery")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the consumer chan to be closed once the recovery gave up")
+	}
+}
+
+func TestTopologyRecoveryForgetsDeletedDeclarations(t *testing.T) {
+	rec := newTopologyRecorder(TopologyRecovery{
+		ExcludeBinding: func(b RecordedBinding) bool { return b.Key == "private" },
+	})
+	ch := &Channel{}
+
+	rec.record(ch, &exchangeDeclare{Exchange: "jobs", Type: "direct"}, &exchangeDeclareOk{})
+	rec.record(ch, &exchangeDeclare{Exchange: "jobs", Type: "direct", Passive: true}, &exchangeDeclareOk{})
+	rec.record(ch, &queueDeclare{Queue: "resize"}, &queueDeclareOk{Queue: "resize"})
+	rec.record(ch, &queueBind{Queue: "resize", Exchange: "jobs", RoutingKey: "a"}, &queueBindOk{})
+	rec.record(ch, &queueBind{Queue: "resize", Exchange: "jobs", RoutingKey: "a"}, &queueBindOk{})
+	rec.record(ch, &queueBind{Queue: "resize", Exchange: "jobs", RoutingKey: "private"}, &queueBindOk{})
+	rec.record(ch, &exchangeBind{Destination: "jobs", Source: "amq.topic", RoutingKey: "#"}, &exchangeBindOk{})
+
+	if len(rec.exchanges) != 1 || len(rec.queues) != 1 || len(rec.bindings) != 2 {
+		t.Fatalf("unexpected recorded topology %+v %+v %+v", rec.exchanges, rec.queues, rec.bindings)
+	}
+
+	rec.record(ch, &queueDelete{Queue: "resize"}, &queueDeleteOk{})
+	rec.record(ch, &exchangeUnbind{Destination: "jobs", Source: "amq.topic", RoutingKey: "#"}, &exchangeUnbindOk{})
+
+	if len(rec.queues) != 0 || len(rec.bindings) != 0 {
+		t.Fatalf("expected the deleted declarations to be forgotten, got %+v %+v", rec.queues, rec.bindings)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestTopologyApplyRollsBackCreatedObjects(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	deleted := make(chan string, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		// exchange "events" does not exist yet
+		srv.channelOpen(2)
+		srv.recv(2, &exchangeDeclare{})
+		srv.send(2, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no exchange 'events'"})
+		srv.recv(2, &channelCloseOk{})
+
+		srv.recv(1, &exchangeDeclare{})
+		srv.send(1, &exchangeDeclareOk{})
+
+		// queue "jobs" does not exist yet, and cannot be declared
+		srv.channelOpen(3)
+		srv.recv(3, &queueDeclare{})
+		srv.send(3, &channelClose{ReplyCode: NotFound, ReplyText: "NOT_FOUND - no queue 'jobs'"})
+		srv.recv(3, &channelCloseOk{})
+
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &channelClose{ReplyCode: PreconditionFailed, ReplyText: "PRECONDITION_FAILED - invalid arg"})
+		srv.recv(1, &channelCloseOk{})
+
+		// rollback
+		srv.channelOpen(4)
+		del := &exchangeDelete{}
+		srv.recv(4, del)
+		srv.send(4, &exchangeDeleteOk{})
+		deleted <- del.Exchange
+		srv.recv(4, &channelClose{})
+		srv.send(4, &channelCloseOk{})
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	topology := Topology{
+		Exchanges: []ExchangeSpec{{Name: "events", Kind: Topic, Durable: true}},
+		Queues:    []QueueSpec{{Name: "jobs", Durable: true, Args: Table{"x-queue-type": "bogus"}}},
+	}
+
+	err = topology.Apply(context.Background(), c, ApplyOptions{Rollback: true})
+
+	var terr *TopologyError
+	if !errors.As(err, &terr) {
+		t.Fatalf("expected a *TopologyError, got %v", err)
+	}
+	if want, got := `declare queue "jobs"`, terr.Step; want != got {
+		t.Errorf("expected failing step %s, got %s", want, got)
+	}
+	if len(terr.CreatedExchanges) != 1 || terr.CreatedExchanges[0] != "events" {
+		t.Errorf("expected exchange events to be recorded as created, got %v", terr.CreatedExchanges)
+	}
+	if !terr.RolledBack {
+		t.Errorf("expected the topology to be rolled back, rollback error: %v", terr.RollbackErr)
+	}
+	if want, got := "events", <-deleted; want != got {
+		t.Errorf("expected exchange %q to be deleted, got %q", want, got)
+	}
+}
+
+func TestTopologyDiffEmpty(t *testing.T) {
+	if !(TopologyDiff{}).Empty() {
+		t.Errorf("expected a diff without missing objects to be empty")
+	}
+	if (TopologyDiff{MissingQueues: []string{"jobs"}}).Empty() {
+		t.Errorf("expected a diff with a missing queue not to be empty")
+	}
+}
@@ -6,6 +6,7 @@
 package amqp091
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"time"
@@ -47,6 +48,10 @@ var (
 	// access the requested Vhost.
 	ErrVhost = &Error{Code: AccessRefused, Reason: "no access to this vhost"}
 
+	// ErrLocale is returned from Dial when the server does not offer the
+	// locale requested in Config.Locale.
+	ErrLocale = &Error{Code: NotImplemented, Reason: "locale not offered by the server"}
+
 	// ErrSyntax is hard protocol error, indicating an unsupported protocol,
 	// implementation or encoding.
 	ErrSyntax = &Error{Code: SyntaxError, Reason: "invalid field or value inside of a frame"}
@@ -82,6 +87,8 @@ type Error struct {
 	Reason  string // description of the error
 	Server  bool   // true when initiated from the server, false when from this library
 	Recover bool   // true when this error can be recovered by retrying later or with different parameters
+
+	op *OpError // method that raised the exception, see OpError
 }
 
 func newError(code uint16, text string) *Error {
@@ -491,7 +498,10 @@ type reader struct {
 }
 
 type writer struct {
-	w io.Writer
+	w          io.Writer
+	conn       io.Writer    // transport under w, for vectored and unbuffered writes
+	unbuffered bool         // write each frame and message to conn at once, see Config.LowLatencyWrites
+	frames     bytes.Buffer // frames encoded for an unbuffered write
 }
 
 // Implements the frame interface for Connection RPC
@@ -0,0 +1,100 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import "context"
+
+// PossibleDuplicateHeader is the header set to true on messages published
+// again by Channel.RepublishUnconfirmed.  The server may have received the
+// original publishing before the connection dropped, so consumers should treat
+// messages carrying this header as possible duplicates.
+const PossibleDuplicateHeader = "x-possible-duplicate"
+
+// UnconfirmedPublishing is a copy of a message published on a channel in
+// confirm mode for which no confirmation was received.
+type UnconfirmedPublishing struct {
+	DeliveryTag uint64 // delivery tag of the original publishing
+	Exchange    string
+	RoutingKey  string
+	Mandatory   bool
+	Immediate   bool
+	Publishing
+}
+
+/*
+RetainUnconfirmed makes the channel keep an in-memory copy of every message
+published in confirm mode until the server confirms it.  The copies of
+publishings that were still unconfirmed when the channel or connection closed
+are returned by Channel.Unconfirmed, and can be published again on a new
+channel with Channel.RepublishUnconfirmed.  This gives at-least-once delivery
+across reconnects without external storage:
+
+	ch.Confirm(false)
+	ch.RetainUnconfirmed()
+	// ... publish until the connection drops, then reconnect ...
+	next.Confirm(false)
+	next.RetainUnconfirmed()
+	next.RepublishUnconfirmed(ctx, ch.Unconfirmed())
+
+A channel reopened after a soft error publishes its unconfirmed messages again
+by itself, see Channel.SetAutoReopen.  With RecoveryPolicy.RepublishUnconfirmed,
+channels in confirm mode retain their unconfirmed messages without this call,
+and the recovery of the connection publishes them again.
+
+Retention only applies to messages published after this call while the channel
+is in confirm mode.  The body and headers of each message are copied, so memory
+use grows with the number of outstanding confirmations.
+*/
+func (ch *Channel) RetainUnconfirmed() {
+	ch.confirms.retain()
+}
+
+// Unconfirmed returns the copies of the messages that have not been confirmed
+// by the server, ordered by delivery tag.  It is meant to be called after the
+// channel has been closed, but can be called at any time.  It returns nil
+// unless Channel.RetainUnconfirmed was called.
+func (ch *Channel) Unconfirmed() []UnconfirmedPublishing {
+	pending := ch.confirms.unconfirmed()
+	if len(pending) == 0 {
+		return nil
+	}
+	return pending
+}
+
+/*
+RepublishUnconfirmed publishes the given messages again on this channel, in
+order, with the PossibleDuplicateHeader header set to true.  The messages are
+typically the result of Channel.Unconfirmed on a channel that was closed.
+
+The returned DeferredConfirmations correspond to the republished messages and
+are nil if this channel is not in confirm mode.  Publishing stops at the first
+error, or when ctx is done, and the confirmations of the messages published so
+far are returned along with the error.
+*/
+func (ch *Channel) RepublishUnconfirmed(ctx context.Context, pending []UnconfirmedPublishing) ([]*DeferredConfirmation, error) {
+	confirmations := make([]*DeferredConfirmation, 0, len(pending))
+
+	for _, p := range pending {
+		if err := ctx.Err(); err != nil {
+			return confirmations, err
+		}
+
+		msg := p.Publishing
+		headers := make(Table, len(msg.Headers)+1)
+		for k, v := range msg.Headers {
+			headers[k] = v
+		}
+		headers[PossibleDuplicateHeader] = true
+		msg.Headers = headers
+
+		dc, err := ch.PublishWithDeferredConfirmWithContext(ctx, p.Exchange, p.RoutingKey, p.Mandatory, p.Immediate, msg)
+		if err != nil {
+			return confirmations, err
+		}
+		confirmations = append(confirmations, dc)
+	}
+
+	return confirmations, nil
+}
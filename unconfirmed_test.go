@@ -0,0 +1,120 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRepublishUnconfirmedAfterClose(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	republished := make(chan *basicPublish, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		srv.recv(1, &confirmSelect{})
+		srv.send(1, &confirmSelectOk{})
+
+		for i := 0; i < 3; i++ {
+			srv.recv(1, &basicPublish{})
+		}
+		srv.send(1, &basicAck{DeliveryTag: 1})
+
+		srv.send(1, &channelClose{ReplyCode: InternalError, ReplyText: "gone"})
+		srv.recv(1, &channelCloseOk{})
+
+		srv.channelOpen(2)
+
+		srv.recv(2, &confirmSelect{})
+		srv.send(2, &confirmSelectOk{})
+
+		for i := 0; i < 2; i++ {
+			pub := &basicPublish{}
+			srv.recv(2, pub)
+			if i == 0 {
+				republished <- pub
+			}
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		t.Fatalf("unexpected error putting channel in confirm mode: %v", err)
+	}
+	ch.RetainUnconfirmed()
+
+	closed := ch.NotifyClose(make(chan *Error, 1))
+
+	for _, b := range []string{"zero", "one", "two"} {
+		if err := ch.Publish("", "jobs", false, false, Publishing{Body: []byte(b), Headers: Table{"n": b}}); err != nil {
+			t.Fatalf("unexpected error during publish: %v", err)
+		}
+	}
+
+	<-closed
+
+	pending := ch.Unconfirmed()
+	if want, got := 2, len(pending); want != got {
+		t.Fatalf("expected %d unconfirmed publishings, got %d", want, got)
+	}
+	if want, got := uint64(2), pending[0].DeliveryTag; want != got {
+		t.Errorf("expected first unconfirmed delivery tag %d, got %d", want, got)
+	}
+	if want, got := "one", string(pending[0].Body); want != got {
+		t.Errorf("expected first unconfirmed body %q, got %q", want, got)
+	}
+
+	next, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", next, err)
+	}
+
+	if err := next.Confirm(false); err != nil {
+		t.Fatalf("unexpected error putting channel in confirm mode: %v", err)
+	}
+
+	dcs, err := next.RepublishUnconfirmed(context.Background(), pending)
+	if err != nil {
+		t.Fatalf("unexpected error during republish: %v", err)
+	}
+	if want, got := 2, len(dcs); want != got {
+		t.Fatalf("expected %d deferred confirmations, got %d", want, got)
+	}
+
+	pub := <-republished
+	if want, got := true, pub.Properties.Headers[PossibleDuplicateHeader]; want != got {
+		t.Errorf("expected %s header to be %v, got %v", PossibleDuplicateHeader, want, got)
+	}
+	if want, got := "one", pub.Properties.Headers["n"]; want != got {
+		t.Errorf("expected original headers to be kept, got %v", pub.Properties.Headers)
+	}
+	if _, found := pending[0].Headers[PossibleDuplicateHeader]; found {
+		t.Errorf("expected the retained headers not to be modified")
+	}
+}
+
+func TestUnconfirmedWithoutRetention(t *testing.T) {
+	c := newConfirms()
+	c.publish()
+	c.keep(UnconfirmedPublishing{DeliveryTag: 1})
+
+	if pending := c.unconfirmed(); len(pending) != 0 {
+		t.Fatalf("expected nothing to be retained, got %v", pending)
+	}
+}
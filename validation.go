@@ -0,0 +1,182 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// ValidationErrorHeader is the header holding the validation error of an
+// invalid delivery published to a ParkingLot.
+const ValidationErrorHeader = "x-validation-error"
+
+// Validator checks that a message body honours a schema, for example a JSON
+// Schema or a protobuf descriptor.
+type Validator interface {
+	Validate(body []byte) error
+}
+
+// ValidatorFunc adapts an ordinary function to the Validator interface.
+type ValidatorFunc func(body []byte) error
+
+// Validate calls f(body).
+func (f ValidatorFunc) Validate(body []byte) error {
+	return f(body)
+}
+
+// ValidationError is returned when a message body is rejected by a Validator.
+type ValidationError struct {
+	Type        string // Type property of the message
+	ContentType string // ContentType property of the message
+	Err         error  // error returned by the Validator
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid message (type %q, content type %q): %v", e.Type, e.ContentType, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+/*
+SchemaRegistry holds the validators of the messages exchanged by an
+application, keyed by the Type or the ContentType property of the messages.
+When both match, the validator registered for the Type is used.  Messages
+matching no validator are considered valid.
+
+Use SchemaRegistry.PublishHook to reject invalid messages before they are
+published, and ConsumeValidate to keep invalid deliveries away from consumers:
+
+	registry := amqp.NewSchemaRegistry()
+	registry.Register("order.created", orderCreatedSchema)
+
+	ch.AddPublishHook(registry.PublishHook())
+	deliveries, err := ch.ConsumeWithOptions(ctx, "orders", "", false,
+		amqp.ConsumeValidate(registry, &amqp.ParkingLot{Channel: ch, RoutingKey: "orders.invalid"}),
+	)
+
+A SchemaRegistry is safe for concurrent use.
+*/
+type SchemaRegistry struct {
+	m             sync.RWMutex
+	byType        map[string]Validator
+	byContentType map[string]Validator
+}
+
+// NewSchemaRegistry returns an empty SchemaRegistry.
+func NewSchemaRegistry() *SchemaRegistry {
+	return &SchemaRegistry{
+		byType:        make(map[string]Validator),
+		byContentType: make(map[string]Validator),
+	}
+}
+
+// Register sets the validator of the messages whose Type property is msgType.
+func (r *SchemaRegistry) Register(msgType string, v Validator) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.byType[msgType] = v
+}
+
+// RegisterContentType sets the validator of the messages whose ContentType
+// property is contentType and whose Type has no validator.
+func (r *SchemaRegistry) RegisterContentType(contentType string, v Validator) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.byContentType[contentType] = v
+}
+
+// Validate checks body with the validator registered for msgType or, when
+// there is none, for contentType.  It returns a *ValidationError when the
+// body is invalid, and nil when it is valid or no validator matches.
+func (r *SchemaRegistry) Validate(msgType, contentType string, body []byte) error {
+	r.m.RLock()
+	v, found := r.byType[msgType]
+	if !found {
+		v, found = r.byContentType[contentType]
+	}
+	r.m.RUnlock()
+
+	if !found {
+		return nil
+	}
+
+	if err := v.Validate(body); err != nil {
+		return &ValidationError{Type: msgType, ContentType: contentType, Err: err}
+	}
+
+	return nil
+}
+
+// PublishHook returns a hook for Channel.AddPublishHook that rejects invalid
+// messages with a *ValidationError before they are sent.
+func (r *SchemaRegistry) PublishHook() PublishHook {
+	return func(_, _ string, msg *Publishing) error {
+		return r.Validate(msg.Type, msg.ContentType, msg.Body)
+	}
+}
+
+// ParkingLot is the destination of invalid deliveries dropped by
+// ConsumeValidate.  The deliveries are published on Channel to Exchange with
+// RoutingKey, with the ValidationErrorHeader header describing the error.
+type ParkingLot struct {
+	Channel    *Channel
+	Exchange   string
+	RoutingKey string
+}
+
+/*
+ConsumeValidate validates every delivery of the consumer against the registry
+and drops the invalid ones before they reach the application.
+
+When parking is nil, invalid deliveries are rejected without requeue, so the
+server dead-letters them when the queue has a dead letter exchange.  Otherwise
+they are published to the parking lot and acknowledged; if publishing fails,
+they are rejected with requeue so that they are not lost.  Invalid deliveries
+of a consumer that automatically acknowledges deliveries cannot be rejected:
+they are only published to the parking lot, if any.
+*/
+func ConsumeValidate(r *SchemaRegistry, parking *ParkingLot) ConsumeOption {
+	return func(o *consumeOptions) error {
+		o.hooks = append(o.hooks, func(autoAck bool) DeliveryHook {
+			return func(d *Delivery) bool {
+				err := r.Validate(d.Type, d.ContentType, d.Body)
+				if err == nil {
+					return true
+				}
+
+				if parking == nil {
+					if !autoAck {
+						_ = d.Reject(false)
+					}
+					return false
+				}
+
+				msg := deliveryPublishing(*d)
+				msg.Headers = make(Table, len(d.Headers)+1)
+				for k, v := range d.Headers {
+					msg.Headers[k] = v
+				}
+				msg.Headers[ValidationErrorHeader] = err.Error()
+
+				perr := parking.Channel.PublishWithContext(context.Background(), parking.Exchange, parking.RoutingKey, false, false, msg)
+				if !autoAck {
+					if perr != nil {
+						_ = d.Reject(true)
+					} else {
+						_ = d.Ack(false)
+					}
+				}
+				return false
+			}
+		})
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+)
+
+func jsonObjectValidator() Validator {
+	return ValidatorFunc(func(body []byte) error {
+		if !bytes.HasPrefix(body, []byte("{")) {
+			return errors.New("not a JSON object")
+		}
+		return nil
+	})
+}
+
+func TestSchemaRegistryLookup(t *testing.T) {
+	r := NewSchemaRegistry()
+	r.RegisterContentType("application/json", jsonObjectValidator())
+	r.Register("raw", ValidatorFunc(func([]byte) error { return nil }))
+
+	var verr *ValidationError
+	if err := r.Validate("", "application/json", []byte("[]")); !errors.As(err, &verr) {
+		t.Errorf("expected a *ValidationError by content type, got %v", err)
+	}
+	if err := r.Validate("raw", "application/json", []byte("[]")); err != nil {
+		t.Errorf("expected the Type validator to take precedence, got %v", err)
+	}
+	if err := r.Validate("", "text/plain", []byte("[]")); err != nil {
+		t.Errorf("expected messages without validator to be valid, got %v", err)
+	}
+}
+
+func TestPublishHookRejectsInvalidMessage(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		pub := &basicPublish{}
+		srv.recv(1, pub)
+		if want, got := "{}", string(pub.Body); want != got {
+			t.Errorf("expected only the valid message to be published, got %q", got)
+		}
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	r := NewSchemaRegistry()
+	r.RegisterContentType("application/json", jsonObjectValidator())
+	ch.AddPublishHook(r.PublishHook())
+
+	var verr *ValidationError
+	err = ch.Publish("", "q", false, false, Publishing{ContentType: "application/json", Body: []byte("nope")})
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a *ValidationError, got %v", err)
+	}
+
+	if err := ch.Publish("", "q", false, false, Publishing{ContentType: "application/json", Body: []byte("{}")}); err != nil {
+		t.Fatalf("unexpected error during publish: %v", err)
+	}
+}
+
+func TestConsumeValidateParksInvalidDeliveries(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	parked := make(chan *basicPublish, 1)
+	acked := make(chan *basicAck, 1)
+
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		req := &basicConsume{}
+		srv.recv(1, req)
+		srv.send(1, &basicConsumeOk{ConsumerTag: req.ConsumerTag})
+
+		srv.send(1, &basicDeliver{
+			ConsumerTag: req.ConsumerTag,
+			DeliveryTag: 1,
+			Properties:  properties{ContentType: "application/json"},
+			Body:        []byte("nope"),
+		})
+		srv.send(1, &basicDeliver{
+			ConsumerTag: req.ConsumerTag,
+			DeliveryTag: 2,
+			Properties:  properties{ContentType: "application/json"},
+			Body:        []byte("{}"),
+		})
+
+		pub := &basicPublish{}
+		srv.recv(1, pub)
+		parked <- pub
+
+		ack := &basicAck{}
+		srv.recv(1, ack)
+		acked <- ack
+	}()
+
+	c, err := Open(rwc, defaultConfig())
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	r := NewSchemaRegistry()
+	r.RegisterContentType("application/json", jsonObjectValidator())
+
+	deliveries, err := ch.ConsumeWithOptions(context.Background(), "orders", "", false,
+		ConsumeValidate(r, &ParkingLot{Channel: ch, RoutingKey: "orders.invalid"}),
+	)
+	if err != nil {
+		t.Fatalf("could not consume: %v", err)
+	}
+
+	d := <-deliveries
+	if want, got := uint64(2), d.DeliveryTag; want != got {
+		t.Fatalf("expected only the valid delivery %d, got %d", want, got)
+	}
+
+	pub := <-parked
+	if want, got := "orders.invalid", pub.RoutingKey; want != got {
+		t.Errorf("expected invalid delivery to be parked with key %q, got %q", want, got)
+	}
+	if _, found := pub.Properties.Headers[ValidationErrorHeader]; !found {
+		t.Errorf("expected the %s header on the parked message", ValidationErrorHeader)
+	}
+
+	if ack := <-acked; ack.DeliveryTag != 1 {
+		t.Errorf("expected the invalid delivery to be acknowledged, got %+v", ack)
+	}
+}
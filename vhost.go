@@ -0,0 +1,261 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// vhostConnection is the cached connection to one virtual host.  ready is
+// closed once the dial completed, after which conn or err is set.
+type vhostConnection struct {
+	ready    chan struct{}
+	conn     *Connection
+	err      error
+	lastUsed time.Time
+}
+
+/*
+VhostManager lazily opens and caches one connection per virtual host, all to
+the same server and with the same credentials.  It is meant for multi-tenant
+services that talk to many virtual hosts, so that each of them gets exactly one
+connection that is reused by all callers.
+
+A connection is opened on the first call to VhostManager.Channel or
+VhostManager.Connection for its virtual host, and replaced by a new one when it
+has been closed.  When an idle timeout is set, connections without any open
+channel that were not requested for that long are closed.
+
+A VhostManager is safe for concurrent use.
+*/
+type VhostManager struct {
+	url    string
+	config Config
+	idle   time.Duration
+
+	// dial opens the connection to a virtual host, replaced in tests.
+	dial func(url string, config Config) (*Connection, error)
+
+	m      sync.Mutex
+	conns  map[string]*vhostConnection
+	closed bool
+	done   chan struct{}
+}
+
+// NewVhostManager returns a VhostManager that opens its connections with
+// DialConfig, using url for the server address and credentials and config for
+// everything else.  The Vhost of config and of url are ignored.  An idleTimeout
+// of zero keeps connections open until VhostManager.Close is called.
+func NewVhostManager(url string, config Config, idleTimeout time.Duration) (*VhostManager, error) {
+	if _, err := ParseURI(url); err != nil {
+		return nil, err
+	}
+
+	m := &VhostManager{
+		url:    url,
+		config: config,
+		idle:   idleTimeout,
+		dial:   DialConfig,
+		conns:  make(map[string]*vhostConnection),
+		done:   make(chan struct{}),
+	}
+
+	if idleTimeout > 0 {
+		go m.expire()
+	}
+
+	return m, nil
+}
+
+/*
+Connection returns the connection to vhost, opening it when there is no open
+connection to that virtual host yet.  Concurrent callers for the same virtual
+host share a single dial.  The connection is owned by the manager and must not
+be closed by the caller.
+
+When ctx is done before the connection is open, ctx.Err() is returned and the
+connection is still cached once the dial completes.
+*/
+func (m *VhostManager) Connection(ctx context.Context, vhost string) (*Connection, error) {
+	m.m.Lock()
+	if m.closed {
+		m.m.Unlock()
+		return nil, ErrClosed
+	}
+
+	vc, found := m.conns[vhost]
+	if found {
+		select {
+		case <-vc.ready:
+			if vc.err != nil || vc.conn.IsClosed() {
+				found = false
+			}
+		default:
+		}
+	}
+
+	if !found {
+		vc = &vhostConnection{ready: make(chan struct{})}
+		m.conns[vhost] = vc
+		go m.open(vhost, vc)
+	}
+	vc.lastUsed = time.Now()
+	m.m.Unlock()
+
+	select {
+	case <-vc.ready:
+		return vc.conn, vc.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (m *VhostManager) open(vhost string, vc *vhostConnection) {
+	config := m.config
+	config.Vhost = vhost
+
+	// The properties are amended during the handshake, do not share them
+	// between concurrent dials.
+	if config.Properties != nil {
+		config.Properties = make(Table, len(m.config.Properties))
+		for k, v := range m.config.Properties {
+			config.Properties[k] = v
+		}
+	}
+
+	conn, err := m.dial(m.url, config)
+
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	vc.conn, vc.err = conn, err
+	close(vc.ready)
+
+	if err != nil {
+		// Let the next caller dial again.
+		if m.conns[vhost] == vc {
+			delete(m.conns, vhost)
+		}
+		return
+	}
+
+	if m.closed {
+		_ = conn.Close()
+	}
+}
+
+/*
+Channel opens a new channel on the connection to vhost, opening the connection
+first when needed.  The caller owns the channel and should close it when done.
+
+When ctx is done before the channel is open, ctx.Err() is returned.
+*/
+func (m *VhostManager) Channel(ctx context.Context, vhost string) (*Channel, error) {
+	conn, err := m.Connection(ctx, vhost)
+	if err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		ch  *Channel
+		err error
+	}
+	opened := make(chan result, 1)
+
+	go func() {
+		ch, err := conn.Channel()
+		opened <- result{ch, err}
+	}()
+
+	select {
+	case res := <-opened:
+		return res.ch, res.err
+	case <-ctx.Done():
+		go func() {
+			if res := <-opened; res.err == nil {
+				_ = res.ch.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes every connection of the manager and returns the first error.
+// Calls to VhostManager.Channel and VhostManager.Connection fail with ErrClosed
+// afterwards.
+func (m *VhostManager) Close() error {
+	m.m.Lock()
+	if m.closed {
+		m.m.Unlock()
+		return ErrClosed
+	}
+	m.closed = true
+	close(m.done)
+
+	var conns []*Connection
+	for vhost, vc := range m.conns {
+		select {
+		case <-vc.ready:
+			if vc.err == nil {
+				conns = append(conns, vc.conn)
+			}
+		default:
+			// closed by open once the dial completes
+		}
+		delete(m.conns, vhost)
+	}
+	m.m.Unlock()
+
+	var first error
+	for _, conn := range conns {
+		if err := conn.Close(); err != nil && err != ErrClosed && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// expire periodically closes the connections that have been idle for longer
+// than the idle timeout.
+func (m *VhostManager) expire() {
+	ticker := time.NewTicker(m.idle / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case now := <-ticker.C:
+			for _, conn := range m.idleConnections(now) {
+				_ = conn.Close()
+			}
+		}
+	}
+}
+
+func (m *VhostManager) idleConnections(now time.Time) []*Connection {
+	m.m.Lock()
+	defer m.m.Unlock()
+
+	var idle []*Connection
+	for vhost, vc := range m.conns {
+		select {
+		case <-vc.ready:
+		default:
+			continue
+		}
+
+		if now.Sub(vc.lastUsed) < m.idle || vc.conn.openChannels() > 0 {
+			continue
+		}
+
+		delete(m.conns, vhost)
+		idle = append(idle, vc.conn)
+	}
+
+	return idle
+}
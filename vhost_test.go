@@ -0,0 +1,115 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockVhostDial returns a dial function that opens a connection to a mock
+// server, which opens the given number of channels and then waits for
+// connection.close.
+func mockVhostDial(t *testing.T, channels int, dialed chan<- string) func(string, Config) (*Connection, error) {
+	return func(_ string, config Config) (*Connection, error) {
+		rwc, srv := newSession(t)
+		t.Cleanup(func() { rwc.Close() })
+
+		go func() {
+			srv.connectionOpen()
+			for id := 1; id <= channels; id++ {
+				srv.channelOpen(id)
+			}
+			srv.connectionClose()
+		}()
+
+		dialed <- config.Vhost
+		return Open(rwc, config)
+	}
+}
+
+func TestVhostManagerCachesConnectionPerVhost(t *testing.T) {
+	m, err := NewVhostManager("amqp://", defaultConfig(), 0)
+	if err != nil {
+		t.Fatalf("could not create manager: %v", err)
+	}
+
+	dialed := make(chan string, 4)
+	m.dial = mockVhostDial(t, 0, dialed)
+
+	var wg sync.WaitGroup
+	conns := make([]*Connection, 3)
+	for i, vhost := range []string{"tenant-a", "tenant-a", "tenant-b"} {
+		wg.Add(1)
+		go func(i int, vhost string) {
+			defer wg.Done()
+			conn, err := m.Connection(context.Background(), vhost)
+			if err != nil {
+				t.Errorf("could not get connection for %q: %v", vhost, err)
+			}
+			conns[i] = conn
+		}(i, vhost)
+	}
+	wg.Wait()
+
+	if conns[0] != conns[1] {
+		t.Errorf("expected the connection to tenant-a to be shared")
+	}
+	if conns[0] == conns[2] {
+		t.Errorf("expected different connections for different vhosts")
+	}
+	if want, got := 2, len(dialed); want != got {
+		t.Errorf("expected %d dials, got %d", want, got)
+	}
+
+	if err := m.Close(); err != nil {
+		t.Fatalf("unexpected error closing manager: %v", err)
+	}
+
+	if _, err := m.Channel(context.Background(), "tenant-a"); err != ErrClosed {
+		t.Fatalf("expected ErrClosed after Close, got %v", err)
+	}
+}
+
+func TestVhostManagerExpiresIdleConnections(t *testing.T) {
+	m, err := NewVhostManager("amqp://", defaultConfig(), 20*time.Millisecond)
+	if err != nil {
+		t.Fatalf("could not create manager: %v", err)
+	}
+	defer m.Close()
+
+	dialed := make(chan string, 2)
+	m.dial = mockVhostDial(t, 1, dialed)
+
+	ch, err := m.Channel(context.Background(), "tenant-a")
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	conn := ch.connection
+
+	// A connection with an open channel is never idle.
+	time.Sleep(50 * time.Millisecond)
+	if conn.IsClosed() {
+		t.Fatalf("expected connection with an open channel to be kept")
+	}
+
+	conn.releaseChannel(ch)
+
+	deadline := time.Now().Add(time.Second)
+	for !conn.IsClosed() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected idle connection to be closed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestNewVhostManagerInvalidURL(t *testing.T) {
+	if _, err := NewVhostManager("http://example.com", Config{}, 0); err != errURIScheme {
+		t.Fatalf("expected errURIScheme, got %v", err)
+	}
+}
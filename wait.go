@@ -0,0 +1,72 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	waitForMinDelay = 100 * time.Millisecond
+	waitForMaxDelay = 5 * time.Second
+)
+
+// WaitForQueue blocks until a queue with the given name exists on the server,
+// or until ctx is done.  It is meant for consumers that may start before the
+// producer that declares the topology they depend on.
+//
+// Existence is checked with a passive declare on a throwaway channel, since a
+// NOT_FOUND reply closes the channel it was issued on.  The check is retried
+// with an exponential backoff, starting at 100ms and capped at 5s, for as long
+// as the server replies with NOT_FOUND.  Any other error, such as a closed
+// connection or ACCESS_REFUSED, is returned immediately.
+func WaitForQueue(ctx context.Context, conn *Connection, name string) error {
+	return waitFor(ctx, conn, func(ch *Channel) error {
+		_, err := ch.QueueDeclarePassive(name, false, false, false, false, nil)
+		return err
+	})
+}
+
+// WaitForExchange blocks until an exchange with the given name exists on the
+// server, or until ctx is done.  It follows the same retry rules as
+// WaitForQueue.
+func WaitForExchange(ctx context.Context, conn *Connection, name string) error {
+	return waitFor(ctx, conn, func(ch *Channel) error {
+		return ch.ExchangeDeclarePassive(name, "", false, false, false, false, nil)
+	})
+}
+
+func waitFor(ctx context.Context, conn *Connection, check func(*Channel) error) error {
+	b := newBackoff(waitForMinDelay, waitForMaxDelay)
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ch, err := conn.Channel()
+		if err != nil {
+			return err
+		}
+
+		err = check(ch)
+		if err == nil {
+			return ch.Close()
+		}
+
+		var amqpErr *Error
+		if !errors.As(err, &amqpErr) || amqpErr.Code != NotFound {
+			_ = ch.Close()
+			return err
+		}
+
+		// The server has already closed the channel after NOT_FOUND.
+		if err := sleepContext(ctx, b.next()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// webSocketGateway accepts one WebSocket connection on a local listener,
+// pings the client, and serves it with serve.
+func webSocketGateway(t *testing.T, serve func(srv *server)) (addr string, requests <-chan *http.Request) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			t.Errorf("could not read the upgrade request: %v", err)
+			return
+		}
+		reqs <- req
+
+		_, _ = io.WriteString(conn, "HTTP/1.1 101 Switching Protocols\r\n"+
+			"Upgrade: websocket\r\n"+
+			"Connection: Upgrade\r\n"+
+			"Sec-WebSocket-Protocol: amqp\r\n"+
+			"Sec-WebSocket-Accept: "+webSocketAccept(req.Header.Get("Sec-WebSocket-Key"))+"\r\n\r\n")
+
+		ws := &webSocketConn{Conn: conn, r: br}
+		if err := ws.writeFrame(wsPing, []byte("ping")); err != nil {
+			t.Errorf("could not ping: %v", err)
+			return
+		}
+		serve(newServer(t, ws, ws))
+	}()
+
+	return l.Addr().String(), reqs
+}
+
+func TestDialWebSocket(t *testing.T) {
+	served := make(chan struct{})
+	addr, requests := webSocketGateway(t, func(srv *server) {
+		defer close(served)
+		srv.connectionOpen()
+		srv.channelOpen(1)
+		srv.recv(1, &queueDeclare{})
+		srv.send(1, &queueDeclareOk{Queue: "jobs"})
+		srv.connectionClose()
+	})
+
+	c, err := Dial("ws://" + addr + "/amqp")
+	if err != nil {
+		t.Fatalf("could not dial through the gateway: %v", err)
+	}
+
+	req := <-requests
+	if req.URL.Path != "/amqp" || req.Header.Get("Upgrade") != "websocket" || req.Header.Get("Sec-WebSocket-Protocol") != "amqp" {
+		t.Fatalf("unexpected upgrade request %s %+v", req.URL, req.Header)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v", err)
+	}
+	if _, err := ch.QueueDeclare("jobs", true, false, false, false, nil); err != nil {
+		t.Fatalf("could not declare queue: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+	<-served
+}
+
+func TestWebSocketHandshakeRejected(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	go func() {
+		if _, err := http.ReadRequest(bufio.NewReader(server)); err != nil {
+			return
+		}
+		_, _ = io.WriteString(server, "HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	if _, err := webSocketHandshake(client, "gateway:80", "/missing"); err == nil {
+		t.Fatalf("expected the refused upgrade to fail the handshake")
+	}
+}
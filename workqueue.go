@@ -0,0 +1,199 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+)
+
+// WorkQueueOptions configures a WorkQueue.
+type WorkQueueOptions struct {
+	// Queue is the name of the durable queue holding the jobs.
+	Queue string
+
+	// Store records the processed message ids.  When nil, jobs are not
+	// deduplicated.
+	Store DedupStore
+
+	// Publisher configures how jobs are enqueued.
+	Publisher PublisherOptions
+
+	// Consumer configures how jobs are processed.  Its Queue and Handler are
+	// set by WorkQueue.Process.
+	Consumer ConsumerOptions
+}
+
+/*
+WorkQueue distributes jobs between workers through a durable queue, with
+effectively-once processing.
+
+Jobs are published as persistent messages with publisher confirms, so Enqueue
+returns once the server is responsible for the job.  Each job gets a unique
+MessageId when it has none.
+
+Messages are redelivered when a worker dies or loses its connection before
+acknowledging them, so a job can be delivered more than once.  Before calling
+the handler, Process checks the message id against the DedupStore and skips the
+jobs already processed.  The id is marked once the handler returns nil, before
+the acknowledgement.  For the side effects of a job to happen exactly once,
+Mark must commit together with them, for example in the same database
+transaction; otherwise a crash between the side effects and Mark still
+executes the job twice.
+
+	q := amqp.NewWorkQueue(conn, amqp.WorkQueueOptions{Queue: "emails", Store: store})
+	if err := q.Declare(ctx); err != nil {
+		return err
+	}
+	err := q.Process(func(d amqp.Delivery) error {
+		return send(d.Body)
+	})
+*/
+type WorkQueue struct {
+	source ChannelSource
+	opts   WorkQueueOptions
+
+	publisher *Publisher
+
+	m        sync.Mutex
+	consumer *Consumer
+}
+
+// NewWorkQueue returns a WorkQueue using channels of source.
+func NewWorkQueue(source ChannelSource, opts WorkQueueOptions) *WorkQueue {
+	return &WorkQueue{
+		source:    source,
+		opts:      opts,
+		publisher: NewPublisher(source, opts.Publisher),
+	}
+}
+
+// Declare declares the durable queue of the work queue.
+func (q *WorkQueue) Declare(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ch, err := q.source.Channel()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = ch.Close() }()
+
+	_, err = ch.QueueDeclare(q.opts.Queue, true, false, false, false, nil)
+	return err
+}
+
+// Enqueue publishes a job and waits for the server to confirm it.  The job is
+// made persistent and gets a unique MessageId when it has none.
+func (q *WorkQueue) Enqueue(ctx context.Context, job Publishing) error {
+	if job.MessageId == "" {
+		id, err := newMessageID()
+		if err != nil {
+			return err
+		}
+		job.MessageId = id
+	}
+	job.DeliveryMode = Persistent
+
+	return q.publisher.Publish(ctx, "", q.opts.Queue, job)
+}
+
+// newMessageID returns 128 random bits, hex encoded.
+func newMessageID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// Process starts consuming the jobs in the background, calling handler once
+// per job that has not been processed yet.
+func (q *WorkQueue) Process(handler Handler) error {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.consumer != nil {
+		return errors.New("work queue already processing")
+	}
+
+	opts := q.opts.Consumer
+	opts.Queue = q.opts.Queue
+	opts.Handler = q.dedup(handler)
+
+	consumer := NewConsumer(q.source, opts)
+	if err := consumer.Start(); err != nil {
+		return err
+	}
+
+	q.consumer = consumer
+	return nil
+}
+
+// dedup wraps handler to skip the jobs recorded by the store.
+func (q *WorkQueue) dedup(handler Handler) Handler {
+	store := q.opts.Store
+	if store == nil {
+		return handler
+	}
+
+	return func(d Delivery) error {
+		if d.MessageId == "" {
+			return handler(d)
+		}
+
+		// Not the context of the delivery, which is cancelled by Stop while
+		// the running handlers are allowed to finish.
+		ctx := context.Background()
+
+		seen, err := store.Seen(ctx, d.MessageId)
+		if err != nil {
+			return err
+		}
+		if seen {
+			return nil
+		}
+
+		if err := handler(d); err != nil {
+			return err
+		}
+
+		return store.Mark(ctx, d.MessageId)
+	}
+}
+
+// Stats returns the counters of the consumer processing the jobs.
+func (q *WorkQueue) Stats() ConsumerStats {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.consumer == nil {
+		return ConsumerStats{}
+	}
+	return q.consumer.Stats()
+}
+
+// Stop stops processing jobs, as Consumer.Stop, and closes the publisher.
+func (q *WorkQueue) Stop(ctx context.Context) error {
+	q.m.Lock()
+	consumer := q.consumer
+	q.m.Unlock()
+
+	var errs []error
+	if consumer != nil {
+		if err := consumer.Stop(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := q.publisher.Close(); err != nil && !errors.Is(err, ErrClosed) {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
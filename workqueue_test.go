@@ -0,0 +1,84 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueSkipsProcessedJobs(t *testing.T) {
+	store := NewMemoryDedupStore()
+	q := NewWorkQueue(nil, WorkQueueOptions{Queue: "jobs", Store: store})
+
+	runs := 0
+	fail := true
+	handler := q.dedup(func(Delivery) error {
+		runs++
+		if fail {
+			return errors.New("failed")
+		}
+		return nil
+	})
+
+	job := Delivery{MessageId: "job-1"}
+
+	if err := handler(job); err == nil {
+		t.Fatalf("expected the handler error")
+	}
+	if seen, _ := store.Seen(context.Background(), "job-1"); seen {
+		t.Fatalf("expected a failed job not to be marked")
+	}
+
+	fail = false
+	if err := handler(job); err != nil {
+		t.Fatalf("expected the redelivered job to be processed, got: %v", err)
+	}
+	if err := handler(job); err != nil {
+		t.Fatalf("expected the processed job to be skipped, got: %v", err)
+	}
+	if runs != 2 {
+		t.Fatalf("expected the handler to run twice, ran %d times", runs)
+	}
+
+	if err := handler(Delivery{}); err != nil || runs != 3 {
+		t.Fatalf("expected jobs without id to be processed, got: %v after %d runs", err, runs)
+	}
+}
+
+func TestWorkQueueEnqueue(t *testing.T) {
+	published := make(chan *basicPublish, 1)
+	c := openPublisherConnection(t, func(srv *server) {
+		srv.confirmedChannelOpen(1)
+		published <- srv.recv(1, &basicPublish{}).(*basicPublish)
+		srv.send(1, &basicAck{DeliveryTag: 1})
+		srv.recv(1, &channelClose{})
+		srv.send(1, &channelCloseOk{})
+		srv.connectionClose()
+	})
+
+	q := NewWorkQueue(c, WorkQueueOptions{Queue: "jobs"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := q.Enqueue(ctx, Publishing{Body: []byte("job")}); err != nil {
+		t.Fatalf("could not enqueue job: %v", err)
+	}
+
+	p := <-published
+	if p.RoutingKey != "jobs" || p.Properties.DeliveryMode != Persistent || len(p.Properties.MessageId) != 32 {
+		t.Fatalf("expected a persistent job with a message id on the jobs queue, got: %+v", p)
+	}
+
+	if err := q.Stop(ctx); err != nil {
+		t.Fatalf("could not stop work queue: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("could not close connection: %v", err)
+	}
+}
@@ -12,15 +12,22 @@ import (
 	"errors"
 	"io"
 	"math"
+	"net"
 	"time"
 )
 
 func (w *writer) WriteFrameNoFlush(frame frame) (err error) {
+	if w.unbuffered {
+		return w.writeUnbuffered(frame)
+	}
 	err = frame.write(w.w)
 	return
 }
 
 func (w *writer) WriteFrame(frame frame) (err error) {
+	if w.unbuffered {
+		return w.writeUnbuffered(frame)
+	}
 	if err = frame.write(w.w); err != nil {
 		return
 	}
@@ -32,6 +39,110 @@ func (w *writer) WriteFrame(frame frame) (err error) {
 	return
 }
 
+// vectoredBodyMin is the smallest body written by WriteFrames without
+// copying it into the buffer of the writer.
+const vectoredBodyMin = 16 * 1024
+
+// WriteFrames writes the frames of a message, its method, header and body
+// frames, then flushes them.  When the bodies are large and the transport is
+// a TCP or Unix socket, the frames are written with net.Buffers in a single
+// writev system call, and the bodies are not copied into the buffer.  When the
+// writer is unbuffered, the frames of the other messages are written at once
+// with a single write.
+func (w *writer) WriteFrames(frames []frame) error {
+	vectored := w.vectored(frames)
+	if !vectored && w.unbuffered {
+		return w.writeUnbuffered(frames...)
+	}
+	if !vectored {
+		for _, f := range frames {
+			if err := f.write(w.w); err != nil {
+				return err
+			}
+		}
+		return w.flush()
+	}
+
+	// Keep the frames in order with the ones buffered by WriteFrameNoFlush.
+	if err := w.flush(); err != nil {
+		return err
+	}
+
+	bufs := make(net.Buffers, 0, 2*len(frames)+1)
+	var pending bytes.Buffer // encoded frames preceding the next body
+	for _, f := range frames {
+		body, ok := f.(*bodyFrame)
+		if !ok {
+			if err := f.write(&pending); err != nil {
+				return err
+			}
+			continue
+		}
+
+		size := uint32(len(body.Body))
+		pending.Write([]byte{
+			frameBody,
+			byte(body.ChannelId >> 8),
+			byte(body.ChannelId),
+			byte(size >> 24),
+			byte(size >> 16),
+			byte(size >> 8),
+			byte(size),
+		})
+		bufs = append(bufs, pending.Bytes(), body.Body)
+		pending = bytes.Buffer{}
+		pending.WriteByte(frameEnd)
+	}
+	bufs = append(bufs, pending.Bytes())
+
+	_, err := bufs.WriteTo(w.conn)
+	return err
+}
+
+// vectored returns true when WriteFrames writes frames with net.Buffers.
+func (w *writer) vectored(frames []frame) bool {
+	switch w.conn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+	default:
+		return false
+	}
+
+	var size int
+	for _, f := range frames {
+		if body, ok := f.(*bodyFrame); ok {
+			size += len(body.Body)
+		}
+	}
+	return size >= vectoredBodyMin
+}
+
+// unbufferedRetainMax is the largest buffer an unbuffered writer keeps to
+// encode the next frames, so that a large message does not pin its memory.
+const unbufferedRetainMax = 64 * 1024
+
+// writeUnbuffered writes frames to the transport with a single write.
+func (w *writer) writeUnbuffered(frames ...frame) error {
+	w.frames.Reset()
+	for _, f := range frames {
+		if err := f.write(&w.frames); err != nil {
+			return err
+		}
+	}
+	_, err := w.conn.Write(w.frames.Bytes())
+
+	if w.frames.Cap() > unbufferedRetainMax {
+		w.frames = bytes.Buffer{}
+	}
+	return err
+}
+
+func (w *writer) flush() error {
+	if buf, ok := w.w.(*bufio.Writer); ok {
+		return buf.Flush()
+	}
+	return nil
+}
+
 func (f *methodFrame) write(w io.Writer) (err error) {
 	var payload bytes.Buffer
 
@@ -0,0 +1,188 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+// writeFramesOverTCP writes frames with WriteFrames on a loopback TCP
+// connection, after a heartbeat left in the buffer, and returns the frames
+// read by the other end.
+func writeFramesOverTCP(t *testing.T, frames []frame) []frame {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+	defer l.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	w := &writer{w: bufio.NewWriter(conn), conn: conn}
+	if err := w.WriteFrameNoFlush(&heartbeatFrame{}); err != nil {
+		t.Fatalf("could not write heartbeat: %v", err)
+	}
+	if err := w.WriteFrames(frames); err != nil {
+		t.Fatalf("could not write frames: %v", err)
+	}
+	conn.Close()
+
+	r := reader{bytes.NewReader(<-received)}
+	var read []frame
+	for {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			return read
+		}
+		if err != nil {
+			t.Fatalf("could not read frame %d: %v", len(read), err)
+		}
+		read = append(read, f)
+	}
+}
+
+func TestWriteFrames(t *testing.T) {
+	for name, size := range map[string]int{"buffered": 10, "vectored": 3*vectoredBodyMin + 1} {
+		t.Run(name, func(t *testing.T) {
+			body := bytes.Repeat([]byte("0123456789"), size/10+1)[:size]
+			chunk := vectoredBodyMin
+			frames := []frame{
+				&methodFrame{ChannelId: 7, Method: &basicPublish{Exchange: "ex", RoutingKey: "key"}},
+				&headerFrame{ChannelId: 7, ClassId: 60, Size: uint64(size)},
+			}
+			for i, j := 0, chunk; i < size; i, j = j, j+chunk {
+				if j > size {
+					j = size
+				}
+				frames = append(frames, &bodyFrame{ChannelId: 7, Body: body[i:j]})
+			}
+
+			read := writeFramesOverTCP(t, frames)
+			if len(read) != 1+len(frames) {
+				t.Fatalf("expected %d frames, got %d", 1+len(frames), len(read))
+			}
+			if _, ok := read[0].(*heartbeatFrame); !ok {
+				t.Fatalf("expected the buffered heartbeat first, got %#v", read[0])
+			}
+			if publish, ok := read[1].(*methodFrame).Method.(*basicPublish); !ok || publish.RoutingKey != "key" {
+				t.Fatalf("expected basic.publish, got %#v", read[1])
+			}
+			if header, ok := read[2].(*headerFrame); !ok || header.Size != uint64(size) {
+				t.Fatalf("expected the content header, got %#v", read[2])
+			}
+
+			var got []byte
+			for _, f := range read[3:] {
+				b, ok := f.(*bodyFrame)
+				if !ok || b.ChannelId != 7 {
+					t.Fatalf("expected a body frame on channel 7, got %#v", f)
+				}
+				got = append(got, b.Body...)
+			}
+			if !bytes.Equal(got, body) {
+				t.Fatalf("expected the body to be written unchanged")
+			}
+		})
+	}
+}
+
+// recordedWrites keeps the buffer of every write.
+type recordedWrites [][]byte
+
+func (r *recordedWrites) Write(b []byte) (int, error) {
+	*r = append(*r, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// readFrameTypes reads the frames of the writes and returns their types.
+func readFrameTypes(t *testing.T, writes recordedWrites) (types []string) {
+	t.Helper()
+
+	r := reader{bytes.NewReader(bytes.Join(writes, nil))}
+	for {
+		f, err := r.ReadFrame()
+		if err == io.EOF {
+			return types
+		}
+		if err != nil {
+			t.Fatalf("could not read frame: %v", err)
+		}
+		types = append(types, fmt.Sprintf("%T", f))
+	}
+}
+
+func TestWriteFrameUnbuffered(t *testing.T) {
+	var writes recordedWrites
+	w := &writer{w: bufio.NewWriter(&writes), conn: &writes, unbuffered: true}
+
+	if err := w.WriteFrameNoFlush(&methodFrame{ChannelId: 1, Method: &queueBind{Queue: "q", Exchange: "logs"}}); err != nil {
+		t.Fatalf("could not write frame: %v", err)
+	}
+	if len(writes) != 1 {
+		t.Fatalf("expected the frame to be written without waiting for a flush, got %d writes", len(writes))
+	}
+
+	if err := w.WriteFrame(&heartbeatFrame{}); err != nil {
+		t.Fatalf("could not write frame: %v", err)
+	}
+	if len(writes) != 2 {
+		t.Fatalf("expected a write per frame, got %d writes", len(writes))
+	}
+
+	if got := readFrameTypes(t, writes); fmt.Sprint(got) != "[*amqp091.methodFrame *amqp091.heartbeatFrame]" {
+		t.Fatalf("unexpected frames %v", got)
+	}
+}
+
+func TestWriteFramesUnbuffered(t *testing.T) {
+	var writes recordedWrites
+	w := &writer{w: bufio.NewWriter(&writes), conn: &writes, unbuffered: true}
+
+	body := bytes.Repeat([]byte("x"), 10000)
+	frames := []frame{
+		&methodFrame{ChannelId: 1, Method: &basicPublish{RoutingKey: "rpc"}},
+		&headerFrame{ChannelId: 1, ClassId: 60, Size: uint64(len(body))},
+		&bodyFrame{ChannelId: 1, Body: body},
+	}
+	if err := w.WriteFrames(frames); err != nil {
+		t.Fatalf("could not write frames: %v", err)
+	}
+	if len(writes) != 1 {
+		t.Fatalf("expected the publishing to be written at once, got %d writes", len(writes))
+	}
+	if got := readFrameTypes(t, writes); fmt.Sprint(got) != "[*amqp091.methodFrame *amqp091.headerFrame *amqp091.bodyFrame]" {
+		t.Fatalf("unexpected frames %v", got)
+	}
+
+	// Only large bodies are worth a writev on sockets, unbuffered or not.
+	w.conn = &net.TCPConn{}
+	if w.vectored(frames) {
+		t.Fatalf("expected a small publishing to be written with a single write")
+	}
+	if !w.vectored(append(frames, &bodyFrame{ChannelId: 1, Body: make([]byte, vectoredBodyMin)})) {
+		t.Fatalf("expected a large publishing to be written with net.Buffers")
+	}
+}
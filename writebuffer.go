@@ -0,0 +1,100 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrWriteBufferFull is returned by the publishing methods when
+// Config.WriteBufferLimit is reached and Config.WriteBufferFailFast is set.
+var ErrWriteBufferFull = errors.New("connection write buffer is full")
+
+/*
+writeBudget bounds the bytes of the publishings waiting to be written to a
+connection.  Frames are written synchronously, so when the server applies TCP
+backpressure every publishing goroutine blocks on the writer while holding its
+body, and the memory of the client grows with the number of publishers.
+
+A publishing larger than the limit is admitted alone, otherwise it could
+never be sent.
+*/
+type writeBudget struct {
+	limit    int
+	failFast bool
+
+	m       sync.Mutex
+	pending int
+	freed   chan struct{} // closed and replaced when bytes are released
+}
+
+func newWriteBudget(limit int, failFast bool) *writeBudget {
+	if limit <= 0 {
+		return nil
+	}
+	return &writeBudget{limit: limit, failFast: failFast, freed: make(chan struct{})}
+}
+
+// reserve waits until size bytes fit in the budget, ctx is done or closed is
+// closed.  The returned function releases the bytes once written.
+func (b *writeBudget) reserve(ctx context.Context, size int, closed <-chan struct{}) (func(), error) {
+	if b == nil {
+		return func() {}, nil
+	}
+	if size > b.limit {
+		size = b.limit
+	}
+
+	for {
+		b.m.Lock()
+		if b.pending+size <= b.limit {
+			b.pending += size
+			b.m.Unlock()
+			return func() { b.release(size) }, nil
+		}
+		freed := b.freed
+		b.m.Unlock()
+
+		if b.failFast {
+			return nil, ErrWriteBufferFull
+		}
+
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-closed:
+			return nil, ErrClosed
+		}
+	}
+}
+
+func (b *writeBudget) release(size int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.pending -= size
+	close(b.freed)
+	b.freed = make(chan struct{})
+}
+
+func (b *writeBudget) len() int {
+	if b == nil {
+		return 0
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.pending
+}
+
+// PendingWriteBytes returns the bytes of the publishings waiting to be
+// written to the server, as bounded by Config.WriteBufferLimit.  It is always
+// zero when no limit is configured.
+func (c *Connection) PendingWriteBytes() int {
+	return c.writeBudget.len()
+}
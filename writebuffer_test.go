@@ -0,0 +1,99 @@
+// Copyright (c) 2024 VMware, Inc. or its affiliates. All Rights Reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package amqp091
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestWriteBufferLimitBlocksPublishings(t *testing.T) {
+	rwc, srv := newSession(t)
+	t.Cleanup(func() { rwc.Close() })
+
+	unblock := make(chan struct{})
+	go func() {
+		srv.connectionOpen()
+		srv.channelOpen(1)
+
+		// The server stops reading, so the first publishing stays in the
+		// write buffer.
+		<-unblock
+		for _, want := range []string{"first", "second"} {
+			pub := &basicPublish{}
+			srv.recv(1, pub)
+			if got := string(pub.Body); got != want {
+				t.Errorf("expected %q to be published, got %q", want, got)
+			}
+		}
+	}()
+
+	config := defaultConfig()
+	config.WriteBufferLimit = 8
+	c, err := Open(rwc, config)
+	if err != nil {
+		t.Fatalf("could not create connection: %v (%s)", c, err)
+	}
+
+	ch, err := c.Channel()
+	if err != nil {
+		t.Fatalf("could not open channel: %v (%s)", ch, err)
+	}
+
+	first := make(chan error, 1)
+	go func() {
+		first <- ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("first")})
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for c.PendingWriteBytes() != len("first") {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the first publishing to be pending, got %d bytes", c.PendingWriteBytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ch.PublishWithContext(ctx, "", "q", false, false, Publishing{Body: []byte("second")}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the publishing over the limit to wait for the context, got: %v", err)
+	}
+
+	close(unblock)
+	if err := ch.PublishWithContext(context.Background(), "", "q", false, false, Publishing{Body: []byte("second")}); err != nil {
+		t.Fatalf("expected the publishing to proceed once the buffer drained, got: %v", err)
+	}
+	if err := <-first; err != nil {
+		t.Fatalf("could not publish: %v", err)
+	}
+	if pending := c.PendingWriteBytes(); pending != 0 {
+		t.Fatalf("expected no pending bytes, got %d", pending)
+	}
+}
+
+func TestWriteBudgetFailFast(t *testing.T) {
+	b := newWriteBudget(10, true)
+
+	release, err := b.reserve(context.Background(), 6, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.reserve(context.Background(), 6, nil); !errors.Is(err, ErrWriteBufferFull) {
+		t.Fatalf("expected ErrWriteBufferFull, got: %v", err)
+	}
+	release()
+
+	// A publishing larger than the limit is admitted when nothing is pending.
+	release, err = b.reserve(context.Background(), 100, nil)
+	if err != nil {
+		t.Fatalf("expected an oversized publishing to be admitted alone, got: %v", err)
+	}
+	release()
+	if b.len() != 0 {
+		t.Fatalf("expected no pending bytes, got %d", b.len())
+	}
+}